@@ -17,6 +17,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -34,18 +35,68 @@ type AddrManager struct {
 	peersFile      string
 	lookupFunc     func(string) ([]net.IP, error)
 	rand           *rand.Rand
+	keySource      io.Reader
 	key            [32]byte
 	addrIndex      map[string]*KnownAddress // address key to ka for all addrs.
-	addrNew        [newBucketCount]map[string]*KnownAddress
-	addrTried      [triedBucketCount]*list.List
+	pools          map[NetworkID]*addrPool
+	networkPrefs   []NetworkPreference
 	started        int32
 	shutdown       int32
 	wg             sync.WaitGroup
 	quit           chan struct{}
-	nTried         int
-	nNew           int
 	lamtx          sync.Mutex
 	localAddresses map[string]*localAddress
+	maxAddresses   int
+	maxAddressAge  time.Duration
+	metrics        Metrics
+
+	// unsupportedAddrs holds the serialized addresses of networks which
+	// can't be represented by a wire.NetAddress, such as Tor v3 and I2P,
+	// keyed by address.  They aren't handed out, but are written back to
+	// the peers file so they aren't lost.
+	unsupportedAddrs map[string]*serializedKnownAddress
+}
+
+// addrPool holds the new and tried buckets of the addresses of a network, so
+// that the addresses of one network can never crowd out or be selected in place
+// of the ones of another.
+type addrPool struct {
+	addrNew   [newBucketCount]map[string]*KnownAddress
+	addrTried [triedBucketCount]*list.List
+	nTried    int
+	nNew      int
+}
+
+// newAddrPool returns a new address pool with empty buckets.
+func newAddrPool() *addrPool {
+	p := new(addrPool)
+	for i := range p.addrNew {
+		p.addrNew[i] = make(map[string]*KnownAddress)
+	}
+	for i := range p.addrTried {
+		p.addrTried[i] = list.New()
+	}
+	return p
+}
+
+// NetworkPreference is the preference for the addresses of a network in the
+// selection of outbound peers.
+type NetworkPreference struct {
+	// Network is the network preferred.
+	Network NetworkID
+
+	// Weight is the share of the selections from the network, relative to
+	// the weights of the other preferred networks.  A weight of one is used
+	// when it is zero.
+	Weight uint32
+}
+
+// weight returns the weight of the preference, with the default applied.
+func (p *NetworkPreference) weight() int {
+	if p.Weight == 0 {
+		return 1
+	}
+	return int(p.Weight)
 }
 
 type serializedKnownAddress struct {
@@ -55,6 +106,21 @@ type serializedKnownAddress struct {
 	TimeStamp   int64
 	LastAttempt int64
 	LastSuccess int64
+
+	// Network, Services and SrcServices were added in version 2.  The
+	// network of the addresses of version 1 is derived from their host, and
+	// their services default to SFNodeNetwork.
+	Network     NetworkID
+	Services    wire.ServiceFlag
+	SrcServices wire.ServiceFlag
+
+	// The connection outcomes the quality of the address is scored by,
+	// with the uptime and latency in seconds and milliseconds.  They're
+	// zero when absent.
+	TotalAttempts int
+	Successes     int
+	Uptime        int64
+	Latency       int64
 	// no refcount or tried, that is available from context.
 }
 
@@ -133,6 +199,11 @@ const (
 	// address has vanished if we have not seen it announced  in that long.
 	numMissingDays = 30
 
+	// defaultMaxAddressAge is the default time after which an address
+	// which has neither been seen nor successfully connected to is
+	// garbage collected.
+	defaultMaxAddressAge = numMissingDays * time.Hour * 24
+
 	// numRetries is the number of tried without a single success before
 	// we assume an address is bad.
 	numRetries = 3
@@ -154,8 +225,18 @@ const (
 	// will share with a call to AddressCache.
 	getAddrPercent = 23
 
+	// qualityUptimeMax is the cumulative uptime over which the quality
+	// score of an address doesn't increase any further.
+	qualityUptimeMax = time.Hour * 24
+
+	// latencyWeight is the weight of a new latency sample in the moving
+	// average of the latency of an address.
+	latencyWeight = 8
+
 	// serialisationVersion is the current version of the on-disk format.
-	serialisationVersion = 1
+	// Files of older versions are migrated when they're loaded, and written
+	// in the current version when the peers are next saved.
+	serialisationVersion = 2
 )
 
 // updateAddress is a helper function to either update an address already known
@@ -203,61 +284,273 @@ func (a *AddrManager) updateAddress(netAddr, srcAddr *wire.NetAddress) {
 			return
 		}
 	} else {
+		// Make room for the address when the address manager holds the
+		// maximum number of addresses, or drop it.
+		if a.maxAddresses > 0 && a.numAddresses() >= a.maxAddresses &&
+			!a.evictForCap(netAddr, srcAddr) {
+
+			log.Tracef("Address manager is full, dropping %s", addr)
+			return
+		}
+
 		// Make a copy of the net address to avoid races since it is
 		// updated elsewhere in the addrmanager code and would otherwise
 		// change the actual netaddress on the peer.
 		netAddrCopy := *netAddr
 		ka = &KnownAddress{na: &netAddrCopy, srcAddr: srcAddr}
 		a.addrIndex[addr] = ka
-		a.nNew++
+		a.pool(netAddr).nNew++
 		// XXX time penalty?
 	}
 
+	pool := a.pool(netAddr)
 	bucket := a.getNewBucket(netAddr, srcAddr)
 
 	// Already exists?
-	if _, ok := a.addrNew[bucket][addr]; ok {
+	if _, ok := pool.addrNew[bucket][addr]; ok {
 		return
 	}
 
 	// Enforce max addresses.
-	if len(a.addrNew[bucket]) > newBucketSize {
+	if len(pool.addrNew[bucket]) > newBucketSize {
 		log.Tracef("new bucket is full, expiring old")
-		a.expireNew(bucket)
+		a.expireNew(pool, bucket)
 	}
 
 	// Add to new bucket.
 	ka.refs++
-	a.addrNew[bucket][addr] = ka
+	pool.addrNew[bucket][addr] = ka
+	a.reportCount()
 
 	log.Tracef("Added new address %s for a total of %d addresses", addr,
-		a.nTried+a.nNew)
+		a.numAddresses())
+}
+
+// evictForCap makes room for the passed address when the address manager holds
+// the maximum number of addresses, by evicting the oldest address of the new
+// bucket it would be placed in.  Evicting only from that bucket means a flood
+// of addresses can't displace the addresses of the buckets it isn't placed
+// in.  When that bucket is empty, the oldest address of a network which isn't
+// supported is evicted instead, since those are never handed out.  It returns
+// false when there is nothing to evict, in which case the address should be
+// dropped.
+func (a *AddrManager) evictForCap(netAddr, srcAddr *wire.NetAddress) bool {
+	pool := a.pool(netAddr)
+	bucket := a.getNewBucket(netAddr, srcAddr)
+
+	var oldest *KnownAddress
+	var oldestKey string
+	for k, v := range pool.addrNew[bucket] {
+		if oldest == nil || v.na.Timestamp.Before(oldest.na.Timestamp) ||
+			(v.na.Timestamp.Equal(oldest.na.Timestamp) && k < oldestKey) {
+
+			oldest = v
+			oldestKey = k
+		}
+	}
+	if oldest == nil {
+		return a.evictUnsupportedForCap()
+	}
+
+	log.Tracef("Evicting %s to stay within %d addresses", oldestKey,
+		a.maxAddresses)
+	a.removeAddress(oldest)
+	a.evicted(EvictionCap)
+	return true
+}
+
+// evictUnsupportedForCap makes room for an address when the address manager
+// holds the maximum number of addresses, by evicting the oldest address of a
+// network which isn't supported.  It returns false when there is none.
+func (a *AddrManager) evictUnsupportedForCap() bool {
+	var oldest *serializedKnownAddress
+	for _, v := range a.unsupportedAddrs {
+		if oldest == nil || v.TimeStamp < oldest.TimeStamp ||
+			(v.TimeStamp == oldest.TimeStamp && v.Addr < oldest.Addr) {
+
+			oldest = v
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+
+	log.Tracef("Evicting %s to stay within %d addresses", oldest.Addr,
+		a.maxAddresses)
+	delete(a.unsupportedAddrs, oldest.Addr)
+	a.evicted(EvictionCap)
+	return true
+}
+
+// removeAddress removes the passed known address from all of the buckets of
+// its pool and from the index.
+func (a *AddrManager) removeAddress(ka *KnownAddress) {
+	key := NetAddressKey(ka.na)
+	pool := a.pool(ka.na)
+
+	if ka.tried {
+		// The address is in the tried bucket it was placed in, unless
+		// the peers file was written with another key.
+		removed := false
+		buckets := []int{a.getTriedBucket(ka.na)}
+		for i := range pool.addrTried {
+			buckets = append(buckets, i)
+		}
+		for _, i := range buckets {
+			for e := pool.addrTried[i].Front(); e != nil; e = e.Next() {
+				if e.Value.(*KnownAddress) == ka {
+					pool.addrTried[i].Remove(e)
+					removed = true
+					break
+				}
+			}
+			if removed {
+				break
+			}
+		}
+		ka.tried = false
+		pool.nTried--
+	} else {
+		for i := range pool.addrNew {
+			if _, ok := pool.addrNew[i][key]; ok {
+				delete(pool.addrNew[i], key)
+				ka.refs--
+			}
+		}
+		pool.nNew--
+	}
+
+	delete(a.addrIndex, key)
+	a.reportCount()
+}
+
+// collectStale garbage collects the addresses which have neither been seen nor
+// successfully connected to for longer than the maximum address age, and then
+// evicts the oldest addresses of unsupported networks followed by the oldest
+// new addresses while there are more than the maximum number of addresses,
+// such as after the maximum was lowered.
+func (a *AddrManager) collectStale() {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	cutoff := time.Now().Add(-a.maxAddressAge)
+	var numStale int
+	for _, ka := range a.addrIndex {
+		if !ka.connectedSince.IsZero() || ka.na.Timestamp.After(cutoff) ||
+			ka.lastsuccess.After(cutoff) {
+
+			continue
+		}
+		a.removeAddress(ka)
+		a.evicted(EvictionStale)
+		numStale++
+	}
+	for k, v := range a.unsupportedAddrs {
+		if time.Unix(v.TimeStamp, 0).Before(cutoff) {
+			delete(a.unsupportedAddrs, k)
+			a.evicted(EvictionStale)
+			numStale++
+		}
+	}
+
+	// The addresses of networks which aren't supported are evicted first,
+	// since they are never handed out.
+	var numCapped int
+	for a.maxAddresses > 0 && a.numAddresses() > a.maxAddresses &&
+		a.evictUnsupportedForCap() {
+
+		numCapped++
+	}
+	if a.maxAddresses > 0 && a.numAddresses() > a.maxAddresses {
+		// Only new addresses are evicted, since the tried ones are
+		// known to work.
+		var candidates []*KnownAddress
+		for _, ka := range a.addrIndex {
+			if !ka.tried {
+				candidates = append(candidates, ka)
+			}
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			ti, tj := candidates[i].na.Timestamp, candidates[j].na.Timestamp
+			if ti.Equal(tj) {
+				return NetAddressKey(candidates[i].na) <
+					NetAddressKey(candidates[j].na)
+			}
+			return ti.Before(tj)
+		})
+		for _, ka := range candidates {
+			if a.numAddresses() <= a.maxAddresses {
+				break
+			}
+			a.removeAddress(ka)
+			a.evicted(EvictionCap)
+			numCapped++
+		}
+	}
+
+	if numStale > 0 || numCapped > 0 {
+		log.Debugf("Collected %d stale addresses and evicted %d over the "+
+			"maximum, %d addresses remain", numStale, numCapped,
+			a.numAddresses())
+	}
+}
+
+// evicted reports the eviction of an address for the passed reason to the
+// metrics, if any.
+func (a *AddrManager) evicted(reason EvictionReason) {
+	if a.metrics != nil {
+		a.metrics.AddressEvicted(reason)
+	}
+}
+
+// reportCount reports the number of addresses to the metrics, if any.
+func (a *AddrManager) reportCount() {
+	if a.metrics != nil {
+		a.metrics.SetAddressCount(a.numAddresses())
+	}
+}
+
+// pool returns the address pool of the network of the passed address, creating
+// it when the network has no addresses yet.
+func (a *AddrManager) pool(na *wire.NetAddress) *addrPool {
+	network := NetAddressNetwork(na)
+	p, ok := a.pools[network]
+	if !ok {
+		p = newAddrPool()
+		a.pools[network] = p
+	}
+	return p
 }
 
 // expireNew makes space in the new buckets by expiring the really bad entries.
 // If no bad entries are available we look at a few and remove the oldest.
-func (a *AddrManager) expireNew(bucket int) {
+func (a *AddrManager) expireNew(pool *addrPool, bucket int) {
 	// First see if there are any entries that are so bad we can just throw
 	// them away. otherwise we throw away the oldest entry in the cache.
 	// Bitcoind here chooses four random and just throws the oldest of
 	// those away, but we keep track of oldest in the initial traversal and
 	// use that information instead.
 	var oldest *KnownAddress
-	for k, v := range a.addrNew[bucket] {
+	var oldestKey string
+	for k, v := range pool.addrNew[bucket] {
 		if v.isBad() {
 			log.Tracef("expiring bad address %v", k)
-			delete(a.addrNew[bucket], k)
+			delete(pool.addrNew[bucket], k)
 			v.refs--
 			if v.refs == 0 {
-				a.nNew--
+				pool.nNew--
 				delete(a.addrIndex, k)
+				a.evicted(EvictionBad)
 			}
 			continue
 		}
-		if oldest == nil {
-			oldest = v
-		} else if !v.na.Timestamp.After(oldest.na.Timestamp) {
+		// Ties are broken by key, so the entry expired doesn't depend
+		// on the iteration order.
+		if oldest == nil || v.na.Timestamp.Before(oldest.na.Timestamp) ||
+			(v.na.Timestamp.Equal(oldest.na.Timestamp) && k < oldestKey) {
+
 			oldest = v
+			oldestKey = k
 		}
 	}
 
@@ -265,22 +558,24 @@ func (a *AddrManager) expireNew(bucket int) {
 		key := NetAddressKey(oldest.na)
 		log.Tracef("expiring oldest address %v", key)
 
-		delete(a.addrNew[bucket], key)
+		delete(pool.addrNew[bucket], key)
 		oldest.refs--
 		if oldest.refs == 0 {
-			a.nNew--
+			pool.nNew--
 			delete(a.addrIndex, key)
+			a.evicted(EvictionOldest)
 		}
+		a.reportCount()
 	}
 }
 
 // pickTried selects an address from the tried bucket to be evicted.
 // We just choose the eldest. Bitcoind selects 4 random entries and throws away
 // the older of them.
-func (a *AddrManager) pickTried(bucket int) *list.Element {
+func (p *addrPool) pickTried(bucket int) *list.Element {
 	var oldest *KnownAddress
 	var oldestElem *list.Element
-	for e := a.addrTried[bucket].Front(); e != nil; e = e.Next() {
+	for e := p.addrTried[bucket].Front(); e != nil; e = e.Next() {
 		ka := e.Value.(*KnownAddress)
 		if oldest == nil || oldest.na.Timestamp.After(ka.na.Timestamp) {
 			oldestElem = e
@@ -342,6 +637,7 @@ out:
 	for {
 		select {
 		case <-dumpAddressTicker.C:
+			a.collectStale()
 			a.savePeers()
 
 		case <-a.quit:
@@ -365,8 +661,8 @@ func (a *AddrManager) savePeers() {
 	sam.Version = serialisationVersion
 	copy(sam.Key[:], a.key[:])
 
-	sam.Addresses = make([]*serializedKnownAddress, len(a.addrIndex))
-	i := 0
+	sam.Addresses = make([]*serializedKnownAddress, 0,
+		len(a.addrIndex)+len(a.unsupportedAddrs))
 	for k, v := range a.addrIndex {
 		ska := new(serializedKnownAddress)
 		ska.Addr = k
@@ -375,26 +671,34 @@ func (a *AddrManager) savePeers() {
 		ska.Attempts = v.attempts
 		ska.LastAttempt = v.lastattempt.Unix()
 		ska.LastSuccess = v.lastsuccess.Unix()
+		ska.Network = NetAddressNetwork(v.na)
+		ska.Services = v.na.Services
+		ska.SrcServices = v.srcAddr.Services
+		ska.TotalAttempts = v.totalAttempts
+		ska.Successes = v.successes
+		ska.Uptime = int64(v.currentUptime() / time.Second)
+		ska.Latency = int64(v.latency / time.Millisecond)
 		// Tried and refs are implicit in the rest of the structure
 		// and will be worked out from context on unserialisation.
-		sam.Addresses[i] = ska
-		i++
-	}
-	for i := range a.addrNew {
-		sam.NewBuckets[i] = make([]string, len(a.addrNew[i]))
-		j := 0
-		for k := range a.addrNew[i] {
-			sam.NewBuckets[i][j] = k
-			j++
-		}
+		sam.Addresses = append(sam.Addresses, ska)
 	}
-	for i := range a.addrTried {
-		sam.TriedBuckets[i] = make([]string, a.addrTried[i].Len())
-		j := 0
-		for e := a.addrTried[i].Front(); e != nil; e = e.Next() {
-			ka := e.Value.(*KnownAddress)
-			sam.TriedBuckets[i][j] = NetAddressKey(ka.na)
-			j++
+	for _, ska := range a.unsupportedAddrs {
+		sam.Addresses = append(sam.Addresses, ska)
+	}
+	// The buckets of the pools are merged, since the network of each
+	// address decides the pool it's loaded back into.
+	for _, pool := range a.pools {
+		for i := range pool.addrNew {
+			for k := range pool.addrNew[i] {
+				sam.NewBuckets[i] = append(sam.NewBuckets[i], k)
+			}
+		}
+		for i := range pool.addrTried {
+			for e := pool.addrTried[i].Front(); e != nil; e = e.Next() {
+				ka := e.Value.(*KnownAddress)
+				sam.TriedBuckets[i] = append(sam.TriedBuckets[i],
+					NetAddressKey(ka.na))
+			}
 		}
 	}
 
@@ -429,6 +733,7 @@ func (a *AddrManager) loadPeers() {
 		a.reset()
 		return
 	}
+	a.reportCount()
 	log.Infof("Loaded %d addresses from file '%s'", a.numAddresses(), a.peersFile)
 }
 
@@ -451,56 +756,82 @@ func (a *AddrManager) deserializePeers(filePath string) error {
 		return fmt.Errorf("error reading %s: %v", filePath, err)
 	}
 
-	if sam.Version != serialisationVersion {
+	// Decoding JSON only decodes the fields it understands, so the files of
+	// all of the versions up to the current one can be decoded and migrated.
+	if sam.Version < 1 || sam.Version > serialisationVersion {
 		return fmt.Errorf("unknown version %v in serialized "+
 			"addrmanager", sam.Version)
 	}
 	copy(a.key[:], sam.Key[:])
 
 	for _, v := range sam.Addresses {
+		if err := migrateKnownAddress(v, sam.Version); err != nil {
+			return err
+		}
+
+		// Addresses of networks a wire.NetAddress can't represent are
+		// set aside, along with their references in the buckets.
+		if !supportedNetwork(v.Network) {
+			a.unsupportedAddrs[v.Addr] = v
+			continue
+		}
+
 		ka := new(KnownAddress)
-		ka.na, err = a.DeserializeNetAddress(v.Addr)
+		ka.na, err = a.deserializeNetAddress(v.Addr, v.Services)
 		if err != nil {
 			return fmt.Errorf("failed to deserialize netaddress "+
 				"%s: %v", v.Addr, err)
 		}
-		ka.srcAddr, err = a.DeserializeNetAddress(v.Src)
+		ka.srcAddr, err = a.deserializeNetAddress(v.Src, v.SrcServices)
 		if err != nil {
 			return fmt.Errorf("failed to deserialize netaddress "+
 				"%s: %v", v.Src, err)
 		}
+		ka.na.Timestamp = time.Unix(v.TimeStamp, 0)
 		ka.attempts = v.Attempts
 		ka.lastattempt = time.Unix(v.LastAttempt, 0)
 		ka.lastsuccess = time.Unix(v.LastSuccess, 0)
+		ka.totalAttempts = v.TotalAttempts
+		ka.successes = v.Successes
+		ka.uptime = time.Duration(v.Uptime) * time.Second
+		ka.latency = time.Duration(v.Latency) * time.Millisecond
 		a.addrIndex[NetAddressKey(ka.na)] = ka
 	}
 
 	for i := range sam.NewBuckets {
 		for _, val := range sam.NewBuckets[i] {
+			if _, ok := a.unsupportedAddrs[val]; ok {
+				continue
+			}
 			ka, ok := a.addrIndex[val]
 			if !ok {
 				return fmt.Errorf("newbucket contains %s but "+
 					"none in address list", val)
 			}
 
+			pool := a.pool(ka.na)
 			if ka.refs == 0 {
-				a.nNew++
+				pool.nNew++
 			}
 			ka.refs++
-			a.addrNew[i][val] = ka
+			pool.addrNew[i][val] = ka
 		}
 	}
 	for i := range sam.TriedBuckets {
 		for _, val := range sam.TriedBuckets[i] {
+			if _, ok := a.unsupportedAddrs[val]; ok {
+				continue
+			}
 			ka, ok := a.addrIndex[val]
 			if !ok {
 				return fmt.Errorf("Newbucket contains %s but "+
 					"none in address list", val)
 			}
 
+			pool := a.pool(ka.na)
 			ka.tried = true
-			a.nTried++
-			a.addrTried[i].PushBack(ka)
+			pool.nTried++
+			pool.addrTried[i].PushBack(ka)
 		}
 	}
 
@@ -520,8 +851,43 @@ func (a *AddrManager) deserializePeers(filePath string) error {
 	return nil
 }
 
+// migrateKnownAddress migrates the passed serialized address of the passed
+// version of the on-disk format to the current version.
+func migrateKnownAddress(ska *serializedKnownAddress, version int) error {
+	if version >= 2 {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(ska.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to migrate netaddress %s: %v",
+			ska.Addr, err)
+	}
+	ska.Network = HostNetwork(host)
+	ska.Services = wire.SFNodeNetwork
+	ska.SrcServices = wire.SFNodeNetwork
+	return nil
+}
+
+// supportedNetwork returns whether the addresses of the passed network can be
+// represented by a wire.NetAddress.  Those of NetUnknown are deserialized as
+// they always were, resolving their host.
+func supportedNetwork(network NetworkID) bool {
+	switch network {
+	case NetUnknown, NetIPv4, NetIPv6, NetTorV2:
+		return true
+	}
+	return false
+}
+
 // DeserializeNetAddress converts a given address string to a *wire.NetAddress
 func (a *AddrManager) DeserializeNetAddress(addr string) (*wire.NetAddress, error) {
+	return a.deserializeNetAddress(addr, wire.SFNodeNetwork)
+}
+
+// deserializeNetAddress converts a given address string to a *wire.NetAddress
+// with the passed services.
+func (a *AddrManager) deserializeNetAddress(addr string, services wire.ServiceFlag) (*wire.NetAddress, error) {
 	host, portStr, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, err
@@ -531,7 +897,7 @@ func (a *AddrManager) DeserializeNetAddress(addr string) (*wire.NetAddress, erro
 		return nil, err
 	}
 
-	return a.HostToNetAddress(host, uint16(port), wire.SFNodeNetwork)
+	return a.HostToNetAddress(host, uint16(port), services)
 }
 
 // Start begins the core address handler which manages a pool of known
@@ -544,8 +910,10 @@ func (a *AddrManager) Start() {
 
 	log.Trace("Starting address manager")
 
-	// Load peers we already know about from file.
+	// Load peers we already know about from file, and collect the ones
+	// which went stale since they were saved.
 	a.loadPeers()
+	a.collectStale()
 
 	// Start the address ticker to save addresses periodically.
 	a.wg.Add(1)
@@ -610,9 +978,16 @@ func (a *AddrManager) AddAddressByIP(addrIP string) error {
 	return nil
 }
 
-// NumAddresses returns the number of addresses known to the address manager.
+// numAddresses returns the number of addresses known to the address manager,
+// including the ones of networks which aren't supported.
+//
+// This function MUST be called with the address manager lock held.
 func (a *AddrManager) numAddresses() int {
-	return a.nTried + a.nNew
+	n := len(a.unsupportedAddrs)
+	for _, pool := range a.pools {
+		n += pool.nTried + pool.nNew
+	}
+	return n
 }
 
 // NumAddresses returns the number of addresses known to the address manager.
@@ -629,7 +1004,9 @@ func (a *AddrManager) NeedMoreAddresses() bool {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
-	return a.numAddresses() < needAddressThreshold
+	// The addresses of networks which aren't supported don't count, since
+	// they can't be connected to.
+	return a.numAddresses()-len(a.unsupportedAddrs) < needAddressThreshold
 }
 
 // AddressCache returns the current address cache.  It must be treated as
@@ -658,7 +1035,7 @@ func (a *AddrManager) AddressCache() []*wire.NetAddress {
 	// `numAddresses' since we are throwing the rest.
 	for i := 0; i < numAddresses; i++ {
 		// pick a number between current index and the end
-		j := rand.Intn(addrIndexLen-i) + i
+		j := a.rand.Intn(addrIndexLen-i) + i
 		allAddr[i], allAddr[j] = allAddr[j], allAddr[i]
 	}
 
@@ -689,15 +1066,11 @@ func (a *AddrManager) EntireAddressCache() []*wire.NetAddress {
 func (a *AddrManager) reset() {
 
 	a.addrIndex = make(map[string]*KnownAddress)
+	a.unsupportedAddrs = make(map[string]*serializedKnownAddress)
 
 	// fill key with bytes from a good random source.
-	io.ReadFull(crand.Reader, a.key[:])
-	for i := range a.addrNew {
-		a.addrNew[i] = make(map[string]*KnownAddress)
-	}
-	for i := range a.addrTried {
-		a.addrTried[i] = list.New()
-	}
+	io.ReadFull(a.keySource, a.key[:])
+	a.pools = make(map[NetworkID]*addrPool)
 }
 
 // HostToNetAddress returns a netaddress given a host address.  If the address
@@ -754,38 +1127,40 @@ func NetAddressKey(na *wire.NetAddress) string {
 
 // GetAddress returns a single address that should be routable.  It picks a
 // random one from the possible addresses with preference given to ones that
-// have not been used recently and should not pick 'close' addresses
+// have not been used recently and whose past connections were successful,
+// long-lived and responsive, and should not pick 'close' addresses
 // consecutively.
 func (a *AddrManager) GetAddress() *KnownAddress {
 	// Protect concurrent access.
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
-	if a.numAddresses() == 0 {
+	pool := a.selectPool()
+	if pool == nil {
 		return nil
 	}
 
 	// Use a 50% chance for choosing between tried and new table entries.
-	if a.nTried > 0 && (a.nNew == 0 || a.rand.Intn(2) == 0) {
+	if pool.nTried > 0 && (pool.nNew == 0 || a.rand.Intn(2) == 0) {
 		// Tried entry.
 		large := 1 << 30
 		factor := 1.0
 		for {
 			// pick a random bucket.
-			bucket := a.rand.Intn(len(a.addrTried))
-			if a.addrTried[bucket].Len() == 0 {
+			bucket := a.rand.Intn(len(pool.addrTried))
+			if pool.addrTried[bucket].Len() == 0 {
 				continue
 			}
 
 			// Pick a random entry in the list
-			e := a.addrTried[bucket].Front()
+			e := pool.addrTried[bucket].Front()
 			for i :=
-				a.rand.Int63n(int64(a.addrTried[bucket].Len())); i > 0; i-- {
+				a.rand.Int63n(int64(pool.addrTried[bucket].Len())); i > 0; i-- {
 				e = e.Next()
 			}
 			ka := e.Value.(*KnownAddress)
 			randval := a.rand.Intn(large)
-			if float64(randval) < (factor * ka.chance() * float64(large)) {
+			if float64(randval) < (factor * ka.score() * float64(large)) {
 				log.Tracef("Selected %v from tried bucket",
 					NetAddressKey(ka.na))
 				return ka
@@ -799,21 +1174,20 @@ func (a *AddrManager) GetAddress() *KnownAddress {
 		factor := 1.0
 		for {
 			// Pick a random bucket.
-			bucket := a.rand.Intn(len(a.addrNew))
-			if len(a.addrNew[bucket]) == 0 {
+			bucket := a.rand.Intn(len(pool.addrNew))
+			if len(pool.addrNew[bucket]) == 0 {
 				continue
 			}
-			// Then, a random entry in it.
-			var ka *KnownAddress
-			nth := a.rand.Intn(len(a.addrNew[bucket]))
-			for _, value := range a.addrNew[bucket] {
-				if nth == 0 {
-					ka = value
-				}
-				nth--
+			// Then, a random entry in it.  The entries are sorted,
+			// since the iteration order of the bucket is undefined.
+			keys := make([]string, 0, len(pool.addrNew[bucket]))
+			for k := range pool.addrNew[bucket] {
+				keys = append(keys, k)
 			}
+			sort.Strings(keys)
+			ka := pool.addrNew[bucket][keys[a.rand.Intn(len(keys))]]
 			randval := a.rand.Intn(large)
-			if float64(randval) < (factor * ka.chance() * float64(large)) {
+			if float64(randval) < (factor * ka.score() * float64(large)) {
 				log.Tracef("Selected %v from new bucket",
 					NetAddressKey(ka.na))
 				return ka
@@ -823,6 +1197,87 @@ func (a *AddrManager) GetAddress() *KnownAddress {
 	}
 }
 
+// selectPool returns the pool of the network GetAddress selects an address
+// from, or nil when none of the allowed networks has any addresses.  Without
+// network preferences, the pools are selected in proportion to their number of
+// addresses.  Otherwise only the pools of the preferred networks are selected,
+// in proportion to their weights.
+func (a *AddrManager) selectPool() *addrPool {
+	var pools []*addrPool
+	var weights []int
+	var total int
+	if len(a.networkPrefs) == 0 {
+		// The pools are visited by network, since the iteration order
+		// of the map is undefined.
+		networks := make([]int, 0, len(a.pools))
+		for network := range a.pools {
+			networks = append(networks, int(network))
+		}
+		sort.Ints(networks)
+		for _, network := range networks {
+			pool := a.pools[NetworkID(network)]
+			if n := pool.nTried + pool.nNew; n > 0 {
+				pools = append(pools, pool)
+				weights = append(weights, n)
+				total += n
+			}
+		}
+	} else {
+		for i := range a.networkPrefs {
+			pool, ok := a.pools[a.networkPrefs[i].Network]
+			if !ok || pool.nTried+pool.nNew == 0 {
+				continue
+			}
+			pools = append(pools, pool)
+			weights = append(weights, a.networkPrefs[i].weight())
+			total += a.networkPrefs[i].weight()
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+
+	n := a.rand.Intn(total)
+	for i, weight := range weights {
+		if n < weight {
+			return pools[i]
+		}
+		n -= weight
+	}
+	return nil
+}
+
+// SetNetworkPreferences restricts the selection of addresses by GetAddress to
+// the networks of the passed preferences, selecting from each of them in
+// proportion to its weight.  For example, a node running over Tor only
+// prefers NetTorV2 alone, so it never selects a clearnet address.  Passing no
+// preferences allows all of the networks again, selected in proportion to
+// their number of addresses.  The addresses of all of the networks are still
+// stored, each network in a pool of its own.
+//
+// This function is safe for concurrent access.
+func (a *AddrManager) SetNetworkPreferences(prefs []NetworkPreference) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	a.networkPrefs = append([]NetworkPreference(nil), prefs...)
+}
+
+// NumNetworkAddresses returns the number of addresses of the passed network
+// known to the address manager.
+//
+// This function is safe for concurrent access.
+func (a *AddrManager) NumNetworkAddresses(network NetworkID) int {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	pool, ok := a.pools[network]
+	if !ok {
+		return 0
+	}
+	return pool.nTried + pool.nNew
+}
+
 func (a *AddrManager) find(addr *wire.NetAddress) *KnownAddress {
 	return a.addrIndex[NetAddressKey(addr)]
 }
@@ -841,6 +1296,7 @@ func (a *AddrManager) Attempt(addr *wire.NetAddress) {
 	}
 	// set last tried time to now
 	ka.attempts++
+	ka.totalAttempts++
 	ka.lastattempt = time.Now()
 }
 
@@ -867,6 +1323,42 @@ func (a *AddrManager) Connected(addr *wire.NetAddress) {
 	}
 }
 
+// Disconnected marks the connection to the given address, which was marked
+// good when it was established, as closed, adding its duration to the uptime
+// of the address.  The address must already be known to AddrManager else it
+// will be ignored.
+func (a *AddrManager) Disconnected(addr *wire.NetAddress) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	ka := a.find(addr)
+	if ka == nil || ka.connectedSince.IsZero() {
+		return
+	}
+
+	ka.uptime = ka.currentUptime()
+	ka.connectedSince = time.Time{}
+}
+
+// UpdateLatency records the passed round trip time of a ping of the given
+// address in a moving average of its latency.  The address must already be
+// known to AddrManager else it will be ignored.
+func (a *AddrManager) UpdateLatency(addr *wire.NetAddress, latency time.Duration) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	ka := a.find(addr)
+	if ka == nil || latency <= 0 {
+		return
+	}
+
+	if ka.latency == 0 {
+		ka.latency = latency
+		return
+	}
+	ka.latency += (latency - ka.latency) / latencyWeight
+}
+
 // Good marks the given address as good.  To be called after a successful
 // connection and version exchange.  If the address is unknown to the address
 // manager it will be ignored.
@@ -885,6 +1377,10 @@ func (a *AddrManager) Good(addr *wire.NetAddress) {
 	ka.lastsuccess = now
 	ka.lastattempt = now
 	ka.attempts = 0
+	ka.successes++
+	if ka.connectedSince.IsZero() {
+		ka.connectedSince = now
+	}
 
 	// move to tried set, optionally evicting other addresses if neeed.
 	if ka.tried {
@@ -892,22 +1388,23 @@ func (a *AddrManager) Good(addr *wire.NetAddress) {
 	}
 
 	// ok, need to move it to tried.
+	pool := a.pool(ka.na)
 
 	// remove from all new buckets.
 	// record one of the buckets in question and call it the `first'
 	addrKey := NetAddressKey(addr)
 	oldBucket := -1
-	for i := range a.addrNew {
+	for i := range pool.addrNew {
 		// we check for existence so we can record the first one
-		if _, ok := a.addrNew[i][addrKey]; ok {
-			delete(a.addrNew[i], addrKey)
+		if _, ok := pool.addrNew[i][addrKey]; ok {
+			delete(pool.addrNew[i], addrKey)
 			ka.refs--
 			if oldBucket == -1 {
 				oldBucket = i
 			}
 		}
 	}
-	a.nNew--
+	pool.nNew--
 
 	if oldBucket == -1 {
 		// What? wasn't in a bucket after all.... Panic?
@@ -917,15 +1414,15 @@ func (a *AddrManager) Good(addr *wire.NetAddress) {
 	bucket := a.getTriedBucket(ka.na)
 
 	// Room in this tried bucket?
-	if a.addrTried[bucket].Len() < triedBucketSize {
+	if pool.addrTried[bucket].Len() < triedBucketSize {
 		ka.tried = true
-		a.addrTried[bucket].PushBack(ka)
-		a.nTried++
+		pool.addrTried[bucket].PushBack(ka)
+		pool.nTried++
 		return
 	}
 
 	// No room, we have to evict something else.
-	entry := a.pickTried(bucket)
+	entry := pool.pickTried(bucket)
 	rmka := entry.Value.(*KnownAddress)
 
 	// First bucket it would have been put in.
@@ -933,7 +1430,7 @@ func (a *AddrManager) Good(addr *wire.NetAddress) {
 
 	// If no room in the original bucket, we put it in a bucket we just
 	// freed up a space in.
-	if len(a.addrNew[newBucket]) >= newBucketSize {
+	if len(pool.addrNew[newBucket]) >= newBucketSize {
 		newBucket = oldBucket
 	}
 
@@ -944,16 +1441,16 @@ func (a *AddrManager) Good(addr *wire.NetAddress) {
 	rmka.tried = false
 	rmka.refs++
 
-	// We don't touch a.nTried here since the number of tried stays the same
-	// but we decemented new above, raise it again since we're putting
+	// We don't touch pool.nTried here since the number of tried stays the
+	// same but we decemented new above, raise it again since we're putting
 	// something back.
-	a.nNew++
+	pool.nNew++
 
 	rmkey := NetAddressKey(rmka.na)
 	log.Tracef("Replacing %s with %s in tried", rmkey, addrKey)
 
 	// We made sure there is space here just above.
-	a.addrNew[newBucket][rmkey] = rmka
+	pool.addrNew[newBucket][rmkey] = rmka
 }
 
 // AddLocalAddress adds na to the list of known local addresses to advertise
@@ -1099,15 +1596,73 @@ func (a *AddrManager) GetBestLocalAddress(remoteAddr *wire.NetAddress) *wire.Net
 	return bestAddress
 }
 
+// Config is the configuration of an address manager.
+type Config struct {
+	// DataDir is the directory the peers file is kept in.
+	DataDir string
+
+	// LookupFunc resolves the hosts of addresses which aren't IP
+	// addresses.
+	LookupFunc func(string) ([]net.IP, error)
+
+	// Rand is the source of randomness of the address manager, which
+	// places the addresses in the buckets, selects and evicts them, and
+	// generates the key the buckets are keyed by.  Passing a source with a
+	// fixed seed makes all of these reproducible, such as for tests and
+	// simulations of eclipse attacks, but must never be done on a live
+	// node, since an attacker could then predict the placement of the
+	// addresses.  A source seeded from the time, with a key from
+	// crypto/rand, is used when it is nil.
+	//
+	// This field can be nil.
+	Rand *rand.Rand
+
+	// MaxAddresses is the maximum number of addresses kept, including the
+	// ones of networks which aren't supported.  Once it's reached, a new
+	// address evicts the oldest address of the new bucket it's placed in,
+	// or the oldest address of an unsupported network when that bucket is
+	// empty, and is dropped when there is neither.  No maximum
+	// other than the capacity of the buckets applies when it is zero.
+	MaxAddresses int
+
+	// MaxAddressAge is the time after which addresses which have neither
+	// been seen nor successfully connected to are garbage collected.  A
+	// default of 30 days is used when it is zero.
+	MaxAddressAge time.Duration
+
+	// Metrics receives the number of addresses and the evictions.
+	// This field can be nil.
+	Metrics Metrics
+}
+
 // New returns a new soter address manager.
 // Use Start to begin processing asynchronous address updates.
 func New(dataDir string, lookupFunc func(string) ([]net.IP, error)) *AddrManager {
+	return NewWithConfig(&Config{DataDir: dataDir, LookupFunc: lookupFunc})
+}
+
+// NewWithConfig returns a new soter address manager with the passed
+// configuration.
+// Use Start to begin processing asynchronous address updates.
+func NewWithConfig(cfg *Config) *AddrManager {
 	am := AddrManager{
-		peersFile:      filepath.Join(dataDir, "peers.json"),
-		lookupFunc:     lookupFunc,
-		rand:           rand.New(rand.NewSource(time.Now().UnixNano())),
+		peersFile:      filepath.Join(cfg.DataDir, "peers.json"),
+		lookupFunc:     cfg.LookupFunc,
+		rand:           cfg.Rand,
+		keySource:      crand.Reader,
 		quit:           make(chan struct{}),
 		localAddresses: make(map[string]*localAddress),
+		maxAddresses:   cfg.MaxAddresses,
+		maxAddressAge:  cfg.MaxAddressAge,
+		metrics:        cfg.Metrics,
+	}
+	if am.maxAddressAge == 0 {
+		am.maxAddressAge = defaultMaxAddressAge
+	}
+	if am.rand == nil {
+		am.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	} else {
+		am.keySource = am.rand
 	}
 	am.reset()
 	return &am
@@ -6,10 +6,16 @@
 package addrmgr_test
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"math/rand"
 	"net"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -471,3 +477,311 @@ func TestNetAddressKey(t *testing.T) {
 	}
 
 }
+
+// TestPeersFileMigration ensures peers files of the first version of the
+// on-disk format are migrated, and that addresses of networks the manager can't
+// represent yet are kept in the file.
+func TestPeersFileMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testpeersfilemigration")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	peersFile := filepath.Join(dir, "peers.json")
+
+	// The addresses were seen recently, so they aren't collected as stale.
+	torV3 := strings.Repeat("a", 56) + ".onion:8333"
+	seen := time.Now().Add(-time.Hour).Unix()
+	v1 := fmt.Sprintf(`{"Version": 1, "Addresses": [
+		{"Addr": "%s:8333", "Src": "173.144.173.111:8333",
+		 "TimeStamp": %d},
+		{"Addr": "%s", "Src": "173.144.173.111:8333",
+		 "TimeStamp": %d}],
+		"NewBuckets": [["%s:8333", "%s"]]}`, someIP, seen, torV3, seen,
+		someIP, torV3)
+	if err := ioutil.WriteFile(peersFile, []byte(v1), 0600); err != nil {
+		t.Fatalf("Failed to write peers file: %v", err)
+	}
+
+	n := addrmgr.New(dir, lookupFunc)
+	n.Start()
+	if numAddrs := n.NumAddresses(); numAddrs != 1 {
+		t.Fatalf("Wrong number of addresses: got %d, want 1", numAddrs)
+	}
+	ka := n.GetAddress()
+	if ka.NetAddress().IP.String() != someIP {
+		t.Fatalf("Wrong IP: got %v, want %v", ka.NetAddress().IP, someIP)
+	}
+	if ka.NetAddress().Services != wire.SFNodeNetwork ||
+		ka.NetAddress().Timestamp.Unix() != seen {
+
+		t.Fatalf("Address not migrated: %+v", ka.NetAddress())
+	}
+	if err := n.Stop(); err != nil {
+		t.Fatalf("Address Manager failed to stop: %v", err)
+	}
+
+	// The file is written in the current version when the manager stops,
+	// still holding the Tor v3 address.
+	data, err := ioutil.ReadFile(peersFile)
+	if err != nil {
+		t.Fatalf("Failed to read peers file: %v", err)
+	}
+	var saved struct {
+		Version   int
+		Addresses []struct {
+			Addr    string
+			Network addrmgr.NetworkID
+		}
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("Failed to decode peers file: %v", err)
+	}
+	if saved.Version != 2 || len(saved.Addresses) != 2 {
+		t.Fatalf("Unexpected peers file version %d with %d addresses",
+			saved.Version, len(saved.Addresses))
+	}
+	networks := make(map[string]addrmgr.NetworkID)
+	for _, addr := range saved.Addresses {
+		networks[addr.Addr] = addr.Network
+	}
+	if networks[someIP+":8333"] != addrmgr.NetIPv4 ||
+		networks[torV3] != addrmgr.NetTorV3 {
+
+		t.Fatalf("Unexpected networks %v", networks)
+	}
+
+	// The migrated file loads again.
+	n = addrmgr.New(dir, lookupFunc)
+	n.Start()
+	defer n.Stop()
+	if numAddrs := n.NumAddresses(); numAddrs != 1 {
+		t.Fatalf("Wrong number of addresses after reloading: got %d, "+
+			"want 1", numAddrs)
+	}
+}
+
+// TestNetworkPreferences ensures the addresses of each network are kept in a
+// pool of their own, and that GetAddress only selects the addresses of the
+// preferred networks.
+func TestNetworkPreferences(t *testing.T) {
+	n := addrmgr.New("testnetworkpreferences", lookupFunc)
+	for i := 0; i < 10; i++ {
+		na, err := n.DeserializeNetAddress(fmt.Sprintf("173.%d.147.1:8333", i))
+		if err != nil {
+			t.Fatalf("Failed to deserialize address: %v", err)
+		}
+		n.AddAddress(na, na)
+
+		host := fmt.Sprintf("aaaaaaaaaaaaaaa%c.onion", 'a'+i)
+		na, err = n.HostToNetAddress(host, 8333, wire.SFNodeNetwork)
+		if err != nil {
+			t.Fatalf("Failed to deserialize address: %v", err)
+		}
+		n.AddAddress(na, na)
+	}
+
+	if got := n.NumNetworkAddresses(addrmgr.NetIPv4); got != 10 {
+		t.Fatalf("Wrong number of IPv4 addresses: got %d, want 10", got)
+	}
+	if got := n.NumNetworkAddresses(addrmgr.NetTorV2); got != 10 {
+		t.Fatalf("Wrong number of Tor addresses: got %d, want 10", got)
+	}
+
+	tests := []struct {
+		network addrmgr.NetworkID
+		isTor   bool
+	}{
+		{addrmgr.NetTorV2, true},
+		{addrmgr.NetIPv4, false},
+	}
+	for _, test := range tests {
+		n.SetNetworkPreferences([]addrmgr.NetworkPreference{
+			{Network: test.network},
+		})
+		for i := 0; i < 50; i++ {
+			ka := n.GetAddress()
+			if addrmgr.IsOnionCatTor(ka.NetAddress()) != test.isTor {
+				t.Fatalf("Selected %s while preferring %v",
+					addrmgr.NetAddressKey(ka.NetAddress()),
+					test.network)
+			}
+		}
+	}
+
+	// Nothing is selected when the preferred networks have no addresses.
+	n.SetNetworkPreferences([]addrmgr.NetworkPreference{
+		{Network: addrmgr.NetIPv6},
+	})
+	if ka := n.GetAddress(); ka != nil {
+		t.Fatalf("Selected %s while preferring IPv6",
+			addrmgr.NetAddressKey(ka.NetAddress()))
+	}
+}
+
+// newSeededAddrManager returns an address manager whose randomness comes from a
+// source with the passed seed.
+func newSeededAddrManager(dataDir string, seed int64) *addrmgr.AddrManager {
+	return addrmgr.NewWithConfig(&addrmgr.Config{
+		DataDir:    dataDir,
+		LookupFunc: lookupFunc,
+		Rand:       rand.New(rand.NewSource(seed)),
+	})
+}
+
+// TestDeterministicRand ensures address managers with sources with the same
+// seed place, evict and select the same addresses.
+func TestDeterministicRand(t *testing.T) {
+	run := func() []string {
+		n := newSeededAddrManager("testdeterministicrand", 1)
+		srcAddr := wire.NewNetAddressIPPort(net.IPv4(173, 144, 173, 111),
+			8333, 0)
+		for i := 0; i < 4096; i++ {
+			ip := net.IPv4(byte(60+i%64), byte(i/64), 147, 1)
+			na := wire.NewNetAddressIPPort(ip, 8333, wire.SFNodeNetwork)
+			na.Timestamp = time.Unix(1500000000, 0)
+			n.AddAddress(na, srcAddr)
+			if i%4 == 0 {
+				n.Good(na)
+			}
+		}
+
+		selected := []string{fmt.Sprint(n.NumAddresses())}
+		for i := 0; i < 100; i++ {
+			ka := n.GetAddress()
+			selected = append(selected, addrmgr.NetAddressKey(ka.NetAddress()))
+		}
+		return selected
+	}
+
+	first, second := run(), run()
+	if !reflect.DeepEqual(first, second) {
+		t.Fatal("address managers with the same seed diverged")
+	}
+}
+
+// TestEclipseResistance simulates an attacker flooding a node with addresses
+// from a single source, and ensures the attacker's addresses only ever fill a
+// bounded part of the new table while most of the honest addresses are kept.
+func TestEclipseResistance(t *testing.T) {
+	n := newSeededAddrManager("testeclipseresistance", 2)
+
+	// The honest addresses are learned from many sources, a while ago.
+	const numHonest = 1000
+	for i := 0; i < numHonest; i++ {
+		ip := net.IPv4(173, byte(i/8), byte(i%8), 1)
+		na := wire.NewNetAddressIPPort(ip, 8333, wire.SFNodeNetwork)
+		na.Timestamp = time.Now().Add(-time.Hour)
+		srcAddr := wire.NewNetAddressIPPort(net.IPv4(80, byte(i%250), 0, 1),
+			8333, 0)
+		n.AddAddress(na, srcAddr)
+	}
+
+	// The attacker announces many more, and more recent, addresses in many
+	// groups from a source it controls.
+	attacker := wire.NewNetAddressIPPort(net.IPv4(44, 0, 0, 1), 8333, 0)
+	for i := 0; i < 20000; i++ {
+		ip := net.IPv4(44, byte(i/256), byte(i), 1)
+		na := wire.NewNetAddressIPPort(ip, 8333, wire.SFNodeNetwork)
+		n.AddAddress(na, attacker)
+	}
+
+	var numHonestKept, numAttacker int
+	for _, na := range n.EntireAddressCache() {
+		if na.IP.To4()[0] == 44 {
+			numAttacker++
+		} else {
+			numHonestKept++
+		}
+	}
+
+	// A single source group is limited to newBucketsPerGroup buckets of
+	// newBucketSize addresses each, plus one entry per bucket before it is
+	// expired.
+	if max := 64 * (64 + 1); numAttacker > max {
+		t.Errorf("Attacker has %d addresses, want at most %d", numAttacker,
+			max)
+	}
+	if numHonestKept < numHonest*8/10 {
+		t.Errorf("Only %d of %d honest addresses kept", numHonestKept,
+			numHonest)
+	}
+}
+
+// testMetrics records the metrics reported by an address manager.
+type testMetrics struct {
+	evicted map[addrmgr.EvictionReason]int
+	count   int
+}
+
+func (m *testMetrics) AddressEvicted(reason addrmgr.EvictionReason) {
+	m.evicted[reason]++
+}
+
+func (m *testMetrics) SetAddressCount(count int) {
+	m.count = count
+}
+
+// TestStaleAndCap ensures addresses which went stale are garbage collected
+// when the address manager starts, that the number of addresses stays within
+// the maximum, and that the evictions are reported.
+func TestStaleAndCap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "teststaleandcap")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	metrics := &testMetrics{evicted: make(map[addrmgr.EvictionReason]int)}
+	n := addrmgr.NewWithConfig(&addrmgr.Config{
+		DataDir:       dir,
+		LookupFunc:    lookupFunc,
+		Rand:          rand.New(rand.NewSource(3)),
+		MaxAddresses:  50,
+		MaxAddressAge: 24 * time.Hour,
+		Metrics:       metrics,
+	})
+
+	// Half of the addresses were last seen two days ago.
+	srcAddr := wire.NewNetAddressIPPort(net.IPv4(173, 144, 173, 111), 8333, 0)
+	for i := 0; i < 40; i++ {
+		na := wire.NewNetAddressIPPort(net.IPv4(173, byte(i), 147, 1), 8333,
+			wire.SFNodeNetwork)
+		if i%2 == 0 {
+			na.Timestamp = time.Now().Add(-48 * time.Hour)
+		}
+		n.AddAddress(na, srcAddr)
+	}
+	if metrics.count != 40 {
+		t.Fatalf("Reported %d addresses, want 40", metrics.count)
+	}
+
+	n.Start()
+	if numAddrs := n.NumAddresses(); numAddrs != 20 {
+		t.Fatalf("Wrong number of addresses after collecting the stale "+
+			"ones: got %d, want 20", numAddrs)
+	}
+	if metrics.evicted[addrmgr.EvictionStale] != 20 || metrics.count != 20 {
+		t.Fatalf("Reported %d stale evictions and %d addresses, want 20 "+
+			"and 20", metrics.evicted[addrmgr.EvictionStale],
+			metrics.count)
+	}
+
+	// A flood of addresses from a single source never takes the address
+	// manager over its maximum.
+	for i := 0; i < 1000; i++ {
+		na := wire.NewNetAddressIPPort(net.IPv4(44, byte(i/256), byte(i), 1),
+			8333, wire.SFNodeNetwork)
+		n.AddAddress(na, srcAddr)
+		if numAddrs := n.NumAddresses(); numAddrs > 50 {
+			t.Fatalf("Address manager holds %d addresses, want at most "+
+				"50", numAddrs)
+		}
+	}
+	if metrics.evicted[addrmgr.EvictionCap] == 0 {
+		t.Fatal("No evictions to stay within the maximum reported")
+	}
+	if err := n.Stop(); err != nil {
+		t.Fatalf("Address Manager failed to stop: %v", err)
+	}
+}
@@ -30,10 +30,18 @@ drastically reduces the chances an attacker is able to coerce your peer into
 only connecting to nodes they control.
 
 The address manager also understands routability and Tor addresses and tries
-hard to only return routable addresses.  In addition, it uses the information
+hard to only return routable addresses.  The addresses of each network are kept in
+pools of their own, and SetNetworkPreferences restricts the selection to the
+preferred networks, such as Tor alone for a node running over Tor only.  In addition, it uses the information
 provided by the caller about connected, known good, and attempted addresses to
 periodically purge peers which no longer appear to be good peers as well as
-bias the selection toward known good peers.  The general idea is to make a best
-effort at only providing usable addresses.
+bias the selection toward known good peers.  The quality of a peer is scored by
+the success rate, cumulative uptime and latency of the connections to it, as
+reported through Attempt, Good, Disconnected and UpdateLatency, and whether it
+serves the full block DAG.  Addresses which have neither been seen nor
+connected to for a configurable number of days are garbage collected, and the
+total number of addresses can be capped, with the evictions reported through
+the Metrics interface.  The general idea is to make a best effort at only
+providing usable addresses.
 */
 package addrmgr
@@ -0,0 +1,190 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package addrmgr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// exportVersion is the current version of the format of address exports.
+const exportVersion = 1
+
+// ExportedAddress is a known address in an address export.
+type ExportedAddress struct {
+	// Addr is the address in the form of NetAddressKey.
+	Addr string
+
+	// Network is the network the address belongs to.
+	Network NetworkID
+
+	// Services are the services the address was last known to offer.
+	Services wire.ServiceFlag
+
+	// TimeStamp is the last time the address was seen, in seconds since
+	// the epoch.
+	TimeStamp int64
+}
+
+// AddressExport is a portable set of known addresses, which another node can
+// import to bootstrap without the DNS seeds.  Unlike the peers file, it holds
+// none of the state private to the node, such as the key the addresses are
+// bucketed with or the outcomes of the connections to them.
+type AddressExport struct {
+	Version   int
+	Addresses []ExportedAddress
+}
+
+// Export returns an export of all of the addresses known to the address
+// manager.
+//
+// This function is safe for concurrent access.
+func (a *AddrManager) Export() *AddressExport {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	exp := &AddressExport{
+		Version: exportVersion,
+		Addresses: make([]ExportedAddress, 0,
+			len(a.addrIndex)+len(a.unsupportedAddrs)),
+	}
+	for k, v := range a.addrIndex {
+		exp.Addresses = append(exp.Addresses, ExportedAddress{
+			Addr:      k,
+			Network:   NetAddressNetwork(v.na),
+			Services:  v.na.Services,
+			TimeStamp: v.na.Timestamp.Unix(),
+		})
+	}
+	for k, v := range a.unsupportedAddrs {
+		exp.Addresses = append(exp.Addresses, ExportedAddress{
+			Addr:      k,
+			Network:   v.Network,
+			Services:  v.Services,
+			TimeStamp: v.TimeStamp,
+		})
+	}
+	return exp
+}
+
+// WriteExport writes an export of all of the addresses known to the address
+// manager to the passed writer, encoded as JSON.
+//
+// This function is safe for concurrent access.
+func (a *AddrManager) WriteExport(w io.Writer) error {
+	return json.NewEncoder(w).Encode(a.Export())
+}
+
+// ReadExport reads an address export encoded as JSON from the passed reader.
+func ReadExport(r io.Reader) (*AddressExport, error) {
+	var exp AddressExport
+	if err := json.NewDecoder(r).Decode(&exp); err != nil {
+		return nil, fmt.Errorf("error reading address export: %v", err)
+	}
+	if exp.Version < 1 || exp.Version > exportVersion {
+		return nil, fmt.Errorf("unknown version %v of address export",
+			exp.Version)
+	}
+	return &exp, nil
+}
+
+// Merge adds the addresses of the passed export to the address manager, and
+// returns the number of addresses which were not known yet.  Addresses which
+// are already known are deduplicated, with their last seen time and services
+// updated as when they're announced again.
+//
+// The addresses are placed in the new buckets as if they were announced by
+// the passed source.  When the source is nil, each address is treated as its
+// own source, spreading the addresses over the new buckets by their groups
+// rather than crowding the few buckets of a single source group.  This suits
+// exports from trusted nodes, such as the ones of a private deployment.
+//
+// This function is safe for concurrent access.
+func (a *AddrManager) Merge(exp *AddressExport, srcAddr *wire.NetAddress) (int, error) {
+	if exp.Version < 1 || exp.Version > exportVersion {
+		return 0, fmt.Errorf("unknown version %v of address export",
+			exp.Version)
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	var added int
+	for _, ea := range exp.Addresses {
+		network := ea.Network
+		if network == NetUnknown {
+			host, _, err := net.SplitHostPort(ea.Addr)
+			if err != nil {
+				return added, fmt.Errorf("invalid address %s: %v",
+					ea.Addr, err)
+			}
+			network = HostNetwork(host)
+		}
+
+		// Addresses of networks a wire.NetAddress can't represent are
+		// kept with the ones of the peers file.
+		if !supportedNetwork(network) {
+			if _, ok := a.unsupportedAddrs[ea.Addr]; ok {
+				continue
+			}
+			if a.maxAddresses > 0 &&
+				a.numAddresses() >= a.maxAddresses &&
+				!a.evictUnsupportedForCap() {
+
+				log.Tracef("Address manager is full, dropping %s",
+					ea.Addr)
+				continue
+			}
+			a.unsupportedAddrs[ea.Addr] = &serializedKnownAddress{
+				Addr:      ea.Addr,
+				Src:       ea.Addr,
+				TimeStamp: ea.TimeStamp,
+				Network:   network,
+				Services:  ea.Services,
+			}
+			a.reportCount()
+			added++
+			continue
+		}
+
+		na, err := a.deserializeNetAddress(ea.Addr, ea.Services)
+		if err != nil {
+			return added, fmt.Errorf("failed to deserialize netaddress "+
+				"%s: %v", ea.Addr, err)
+		}
+		na.Timestamp = time.Unix(ea.TimeStamp, 0)
+
+		src := srcAddr
+		if src == nil {
+			src = na
+		}
+		known := a.find(na) != nil
+		a.updateAddress(na, src)
+		if !known && a.find(na) != nil {
+			added++
+		}
+	}
+
+	log.Infof("Merged %d new addresses from an export of %d addresses",
+		added, len(exp.Addresses))
+	return added, nil
+}
+
+// Import reads an address export encoded as JSON from the passed reader and
+// merges it into the address manager as Merge does.
+//
+// This function is safe for concurrent access.
+func (a *AddrManager) Import(r io.Reader, srcAddr *wire.NetAddress) (int, error) {
+	exp, err := ReadExport(r)
+	if err != nil {
+		return 0, err
+	}
+	return a.Merge(exp, srcAddr)
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package addrmgr_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/soteria-dag/soterd/addrmgr"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// TestExportImport ensures the addresses exported by one address manager are
+// imported by another, and that importing them again adds none.
+func TestExportImport(t *testing.T) {
+	src := addrmgr.New("testexport", lookupFunc)
+	for i := 0; i < 100; i++ {
+		na, err := src.DeserializeNetAddress(fmt.Sprintf("173.%d.147.1:8333",
+			i))
+		if err != nil {
+			t.Fatalf("Failed to deserialize address: %v", err)
+		}
+		na.Services = wire.SFNodeNetwork
+		src.AddAddress(na, na)
+	}
+
+	var buf bytes.Buffer
+	if err := src.WriteExport(&buf); err != nil {
+		t.Fatalf("WriteExport: %v", err)
+	}
+	data := buf.String()
+
+	dst := addrmgr.New("testimport", lookupFunc)
+	added, err := dst.Import(strings.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if added != 100 || dst.NumAddresses() != 100 {
+		t.Fatalf("Imported %d addresses for a total of %d, want 100",
+			added, dst.NumAddresses())
+	}
+
+	added, err = dst.Import(strings.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if added != 0 || dst.NumAddresses() != 100 {
+		t.Fatalf("Importing again added %d addresses for a total of %d",
+			added, dst.NumAddresses())
+	}
+
+	// Addresses of networks which can't be represented yet are kept, and
+	// exported again.
+	torV3 := strings.Repeat("a", 56) + ".onion:8333"
+	added, err = dst.Merge(&addrmgr.AddressExport{
+		Version:   1,
+		Addresses: []addrmgr.ExportedAddress{{Addr: torV3}},
+	}, nil)
+	if err != nil || added != 1 {
+		t.Fatalf("Merging a Tor v3 address added %d: %v", added, err)
+	}
+	exp := dst.Export()
+	if len(exp.Addresses) != 101 {
+		t.Fatalf("Exported %d addresses, want 101", len(exp.Addresses))
+	}
+
+	if _, err := addrmgr.ReadExport(strings.NewReader(`{"Version": 2}`)); err == nil {
+		t.Fatal("ReadExport accepted an unknown version")
+	}
+}
+
+// TestMergeUnsupportedCap ensures the addresses of networks which can't be
+// represented count towards the maximum number of addresses, and are evicted
+// to stay within it.
+func TestMergeUnsupportedCap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testmergeunsupportedcap")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	n := addrmgr.NewWithConfig(&addrmgr.Config{
+		DataDir:      dir,
+		LookupFunc:   lookupFunc,
+		MaxAddresses: 10,
+	})
+
+	exp := &addrmgr.AddressExport{Version: 1}
+	for i := 0; i < 20; i++ {
+		torV3 := fmt.Sprintf("%s%02d.onion:8333", strings.Repeat("a", 54),
+			i)
+		exp.Addresses = append(exp.Addresses, addrmgr.ExportedAddress{
+			Addr:      torV3,
+			TimeStamp: int64(i),
+		})
+	}
+	if _, err := n.Merge(exp, nil); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if numAddrs := n.NumAddresses(); numAddrs != 10 {
+		t.Fatalf("Address manager holds %d addresses, want 10", numAddrs)
+	}
+
+	// A supported address evicts an unsupported one rather than being
+	// dropped.
+	na := wire.NewNetAddressIPPort(net.IPv4(173, 144, 173, 111), 8333,
+		wire.SFNodeNetwork)
+	n.AddAddress(na, na)
+	if numAddrs := n.NumAddresses(); numAddrs != 10 {
+		t.Fatalf("Address manager holds %d addresses, want 10", numAddrs)
+	}
+	if n.GetAddress() == nil {
+		t.Fatal("Supported address was dropped")
+	}
+}
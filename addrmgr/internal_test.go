@@ -24,3 +24,15 @@ func TstNewKnownAddress(na *wire.NetAddress, attempts int,
 	return &KnownAddress{na: na, attempts: attempts, lastattempt: lastattempt,
 		lastsuccess: lastsuccess, tried: tried, refs: refs}
 }
+
+func TstKnownAddressQuality(ka *KnownAddress) float64 {
+	return ka.quality()
+}
+
+func TstSetKnownAddressOutcomes(ka *KnownAddress, totalAttempts, successes int,
+	uptime, latency time.Duration) {
+	ka.totalAttempts = totalAttempts
+	ka.successes = successes
+	ka.uptime = uptime
+	ka.latency = latency
+}
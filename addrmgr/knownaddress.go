@@ -21,6 +21,14 @@ type KnownAddress struct {
 	lastsuccess time.Time
 	tried       bool
 	refs        int // reference count of new buckets
+
+	// The connection outcomes of the address, which its quality is scored
+	// by.  Unlike attempts, they aren't reset by a success.
+	totalAttempts  int
+	successes      int
+	uptime         time.Duration
+	connectedSince time.Time
+	latency        time.Duration
 }
 
 // NetAddress returns the underlying wire.NetAddress associated with the
@@ -60,6 +68,56 @@ func (ka *KnownAddress) chance() float64 {
 	return c
 }
 
+// currentUptime returns the cumulative uptime of the connections to a known
+// address, including the current one.
+func (ka *KnownAddress) currentUptime() time.Duration {
+	if ka.connectedSince.IsZero() {
+		return ka.uptime
+	}
+	return ka.uptime + time.Since(ka.connectedSince)
+}
+
+// quality returns the quality score of a known address, between zero and one,
+// from the outcomes of the connections to it.  Addresses which tend to lead to
+// working connections that stay up, are quick to respond and serve the full
+// block DAG score higher.  Addresses without any recorded outcomes get a
+// neutral score, so new addresses are still explored.
+func (ka *KnownAddress) quality() float64 {
+	// The success rate is smoothed so that a single outcome doesn't decide
+	// it.
+	attempts := ka.totalAttempts
+	if attempts < ka.successes {
+		attempts = ka.successes
+	}
+	q := float64(ka.successes+1) / float64(attempts+2)
+
+	// A longer cumulative uptime raises the score, up to a day.
+	uptime := ka.currentUptime()
+	if uptime > qualityUptimeMax {
+		uptime = qualityUptimeMax
+	}
+	q *= 0.5 + 0.5*float64(uptime)/float64(qualityUptimeMax)
+
+	// A higher latency lowers the score, halving it at one second.
+	if ka.latency > 0 {
+		q /= 1 + float64(ka.latency)/float64(time.Second)
+	}
+
+	// Peers which don't serve the full block DAG are of little use to sync
+	// from.
+	if !ka.na.HasService(wire.SFNodeNetwork) {
+		q *= 0.5
+	}
+
+	return q
+}
+
+// score returns the selection score of a known address, which combines the
+// selection probability from chance with its quality.
+func (ka *KnownAddress) score() float64 {
+	return ka.chance() * ka.quality()
+}
+
 // isBad returns true if the address in question has not been tried in the last
 // minute and meets one of the following criteria:
 // 1) It claims to be from the future
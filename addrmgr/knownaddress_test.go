@@ -113,3 +113,54 @@ func TestIsBad(t *testing.T) {
 		t.Errorf("test case 10: This should be a valid address.")
 	}
 }
+
+func TestQuality(t *testing.T) {
+	newAddr := func(services wire.ServiceFlag, totalAttempts, successes int,
+		uptime, latency time.Duration) *addrmgr.KnownAddress {
+
+		ka := addrmgr.TstNewKnownAddress(&wire.NetAddress{Services: services},
+			0, time.Time{}, time.Time{}, false, 0)
+		addrmgr.TstSetKnownAddressOutcomes(ka, totalAttempts, successes,
+			uptime, latency)
+		return ka
+	}
+
+	var tests = []struct {
+		addr     *addrmgr.KnownAddress
+		expected float64
+	}{
+		{
+			// Test an address without any outcomes.
+			newAddr(wire.SFNodeNetwork, 0, 0, 0, 0),
+			0.5 * 0.5,
+		}, {
+			// Test an address which doesn't serve the block DAG.
+			newAddr(0, 0, 0, 0, 0),
+			0.5 * 0.5 * 0.5,
+		}, {
+			// Test an address which always worked and stayed up a day.
+			newAddr(wire.SFNodeNetwork, 3, 3, 24*time.Hour, 0),
+			4.0 / 5.0,
+		}, {
+			// Test the uptime being capped at a day.
+			newAddr(wire.SFNodeNetwork, 3, 3, 72*time.Hour, 0),
+			4.0 / 5.0,
+		}, {
+			// Test an address which mostly failed.
+			newAddr(wire.SFNodeNetwork, 8, 1, 12*time.Hour, 0),
+			2.0 / 10.0 * 0.75,
+		}, {
+			// Test an address with a latency of a second.
+			newAddr(wire.SFNodeNetwork, 3, 3, 24*time.Hour, time.Second),
+			4.0 / 5.0 / 2,
+		},
+	}
+
+	err := .0001
+	for i, test := range tests {
+		quality := addrmgr.TstKnownAddressQuality(test.addr)
+		if math.Abs(test.expected-quality) >= err {
+			t.Errorf("case %d: got %f, expected %f", i, quality, test.expected)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package addrmgr
+
+import "fmt"
+
+// EvictionReason describes why an address was evicted from the address
+// manager.
+type EvictionReason int
+
+const (
+	// EvictionBad indicates the address was bad, as decided by isBad, and
+	// was expired to make room in a full new bucket.
+	EvictionBad EvictionReason = iota
+
+	// EvictionOldest indicates the address was the oldest one of a full new
+	// bucket, and was expired to make room in it.
+	EvictionOldest
+
+	// EvictionStale indicates the address had not been seen or connected
+	// to for longer than the maximum address age, and was garbage
+	// collected.
+	EvictionStale
+
+	// EvictionCap indicates the address was evicted to keep the number of
+	// addresses within the maximum.
+	EvictionCap
+)
+
+// Map of EvictionReason values back to their constant names for pretty
+// printing.
+var evictionReasonStrings = map[EvictionReason]string{
+	EvictionBad:    "EvictionBad",
+	EvictionOldest: "EvictionOldest",
+	EvictionStale:  "EvictionStale",
+	EvictionCap:    "EvictionCap",
+}
+
+// String returns the EvictionReason as a human-readable name.
+func (r EvictionReason) String() string {
+	if s := evictionReasonStrings[r]; s != "" {
+		return s
+	}
+	return fmt.Sprintf("Unknown EvictionReason (%d)", int(r))
+}
+
+// Metrics defines the interface the address manager uses to report the size
+// of its address set and its evictions, such as to export them to a
+// monitoring system, without depending on any particular metrics library.
+//
+// The methods are called with the address manager lock held, so they MUST NOT
+// call back into the address manager and should return quickly.
+type Metrics interface {
+	// AddressEvicted is called when an address is evicted from the address
+	// manager, with the reason for its eviction.
+	AddressEvicted(reason EvictionReason)
+
+	// SetAddressCount is called when the number of addresses known to the
+	// address manager changes.
+	SetAddressCount(count int)
+}
@@ -8,6 +8,7 @@ package addrmgr
 import (
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/soteria-dag/soterd/wire"
 )
@@ -93,6 +94,102 @@ var (
 	heNet = ipNet("2001:470::", 32, 128)
 )
 
+// NetworkID identifies the network an address belongs to.  The values match
+// the network IDs of the addrv2 message (BIP155), so they remain stable on disk.
+type NetworkID uint8
+
+const (
+	// NetUnknown is the network of addresses which can't be classified.
+	NetUnknown NetworkID = 0
+
+	// NetIPv4 is the network of IPv4 addresses.
+	NetIPv4 NetworkID = 1
+
+	// NetIPv6 is the network of IPv6 addresses.
+	NetIPv6 NetworkID = 2
+
+	// NetTorV2 is the network of Tor v2 onion services, which are carried in
+	// the OnionCat range of IPv6.
+	NetTorV2 NetworkID = 3
+
+	// NetTorV3 is the network of Tor v3 onion services.
+	NetTorV3 NetworkID = 4
+
+	// NetI2P is the network of I2P destinations.
+	NetI2P NetworkID = 5
+
+	// NetCJDNS is the network of CJDNS addresses.
+	NetCJDNS NetworkID = 6
+)
+
+// Map of network IDs back to their constant names for pretty printing.
+var networkIDStrings = map[NetworkID]string{
+	NetUnknown: "NetUnknown",
+	NetIPv4:    "NetIPv4",
+	NetIPv6:    "NetIPv6",
+	NetTorV2:   "NetTorV2",
+	NetTorV3:   "NetTorV3",
+	NetI2P:     "NetI2P",
+	NetCJDNS:   "NetCJDNS",
+}
+
+// String returns the NetworkID in human-readable form.
+func (n NetworkID) String() string {
+	if s, ok := networkIDStrings[n]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown NetworkID (%d)", uint8(n))
+}
+
+const (
+	// torV2HostLen is the length of the host of a Tor v2 onion service,
+	// which is the base32 encoding of ten bytes followed by ".onion".
+	torV2HostLen = 16 + len(".onion")
+
+	// torV3HostLen is the length of the host of a Tor v3 onion service,
+	// which is the base32 encoding of 35 bytes followed by ".onion".
+	torV3HostLen = 56 + len(".onion")
+
+	// i2pHostLen is the length of the host of an I2P destination, which is
+	// the base32 encoding of 32 bytes without padding followed by
+	// ".b32.i2p".
+	i2pHostLen = 52 + len(".b32.i2p")
+)
+
+// NetAddressNetwork returns the network the passed address belongs to.
+func NetAddressNetwork(na *wire.NetAddress) NetworkID {
+	switch {
+	case na.IP == nil:
+		return NetUnknown
+	case IsIPv4(na):
+		return NetIPv4
+	case IsOnionCatTor(na):
+		return NetTorV2
+	}
+	return NetIPv6
+}
+
+// HostNetwork returns the network the passed host of an address belongs to,
+// from its form.  Hosts which are neither an IP address, nor a Tor or I2P name
+// belong to NetUnknown, since they name the host of an address of any network.
+func HostNetwork(host string) NetworkID {
+	host = strings.ToLower(host)
+	switch {
+	case len(host) == torV2HostLen && strings.HasSuffix(host, ".onion"):
+		return NetTorV2
+	case len(host) == torV3HostLen && strings.HasSuffix(host, ".onion"):
+		return NetTorV3
+	case len(host) == i2pHostLen && strings.HasSuffix(host, ".b32.i2p"):
+		return NetI2P
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return NetUnknown
+	}
+	return NetAddressNetwork(&wire.NetAddress{IP: ip})
+}
+
 // ipNet returns a net.IPNet struct given the passed IP address string, number
 // of one bits to include at the start of the mask, and the total number of bits
 // for the mask.
@@ -7,6 +7,7 @@ package addrmgr_test
 
 import (
 	"net"
+	"strings"
 	"testing"
 
 	"github.com/soteria-dag/soterd/addrmgr"
@@ -201,3 +202,28 @@ func TestGroupKey(t *testing.T) {
 		}
 	}
 }
+
+// TestHostNetwork ensures the network of the hosts of addresses is recognized
+// from their form.
+func TestHostNetwork(t *testing.T) {
+	tests := []struct {
+		host string
+		want addrmgr.NetworkID
+	}{
+		{"173.194.115.66", addrmgr.NetIPv4},
+		{"2001:470::1", addrmgr.NetIPv6},
+		{"fd87:d87e:eb43::1", addrmgr.NetTorV2},
+		{"aaaaaaaaaaaaaaaa.onion", addrmgr.NetTorV2},
+		{strings.Repeat("a", 56) + ".onion", addrmgr.NetTorV3},
+		{strings.Repeat("A", 56) + ".ONION", addrmgr.NetTorV3},
+		{strings.Repeat("a", 52) + ".b32.i2p", addrmgr.NetI2P},
+		{"seed.example.com", addrmgr.NetUnknown},
+	}
+
+	for i, test := range tests {
+		if got := addrmgr.HostNetwork(test.host); got != test.want {
+			t.Errorf("HostNetwork #%d (%s): got %v, want %v", i,
+				test.host, got, test.want)
+		}
+	}
+}
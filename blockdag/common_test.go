@@ -291,6 +291,9 @@ func newFakeChain(params *chaincfg.Params) *BlockDAG {
 		graph:               phantom.NewGraph(),
 		blueSet:             phantom.NewBlueSetCache(),
 		nodeOrder:           make([]*chainhash.Hash, 0),
+		orderAudit:          newOrderAudit(DefaultOrderAuditSize),
+		rejectedReorders:    make(map[chainhash.Hash]*blockNode),
+		reorderOverrides:    make(map[chainhash.Hash]struct{}),
 		warningCaches:       newThresholdCaches(vbNumBits),
 		deploymentCaches:    newThresholdCaches(chaincfg.DefinedDeployments),
 	}
@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/soteria-dag/soterd/blockdag/phantom"
+	"github.com/soteria-dag/soterd/blockdag/pow"
 	"github.com/soteria-dag/soterd/chaincfg"
 	"github.com/soteria-dag/soterd/chaincfg/chainhash"
 	"github.com/soteria-dag/soterd/database"
@@ -35,8 +36,11 @@ const (
 	coloringK = 3
 )
 
-// BlockLocator is used to help locate specific blocks. The locator
-// is the height at which we expect to find the block(s) (the first element in the array)
+// BlockLocator is used to help locate specific blocks. The locator is a list of
+// heights in descending order, starting with the height of the sender's
+// selected tip, and the recipient returns the blocks after the first height it
+// has blocks at.  A locator with a single element is the height at which we
+// expect to find the block(s).
 //
 // It's represented as an array of *int32, so that functions that attempt to create
 // a locator can return nil (which is valid for an uninitialized array) to represent
@@ -146,6 +150,7 @@ type BlockDAG struct {
 	minRetargetTimespan int64 // target timespan / adjustment factor
 	maxRetargetTimespan int64 // target timespan * adjustment factor
 	blocksPerRetarget   int64 // target timespan / target time per block
+	powBackend          pow.Backend
 
 	// chainLock protects concurrent access to the vast majority of the
 	// fields in this struct below this point.
@@ -163,6 +168,24 @@ type BlockDAG struct {
 	nodeOrder         []*chainhash.Hash
 	orderCache        *phantom.OrderCache
 
+	// orderAudit keeps a record of recent re-orderings of the DAG, so that
+	// deep re-ordering events can be investigated after the fact.
+	orderAudit *orderAudit
+
+	// These fields are related to the MaxReorderDepth policy.  They are
+	// protected by the chain lock.
+	//
+	// rejectedReorders houses the blocks that were not connected because
+	// they would re-order the DAG deeper than allowed, so that they can be
+	// manually approved via OverrideDeepReorder.  It holds at most
+	// maxRejectedReorders blocks.
+	//
+	// reorderOverrides houses the hashes of the blocks whose re-ordering
+	// has been manually approved.
+	maxReorderDepth  int
+	rejectedReorders map[chainhash.Hash]*blockNode
+	reorderOverrides map[chainhash.Hash]struct{}
+
 	// These fields are related to handling of orphan blocks.  They are
 	// protected by a combination of the chain lock and the orphan lock.
 	orphanLock   sync.RWMutex
@@ -939,6 +962,14 @@ func (b *BlockDAG) connectBlock(node *blockNode, block *soterutil.Block,
 
 	dagState := newDAGState(dagTips, curTotalBlks + 1)
 	newView := NewUtxoViewpoint()
+	var rejection *DeepReorderRejection
+
+	// The blue score depth of a re-ordering is measured against the
+	// coloring of the DAG before the block is added to the graph.
+	var oldBlueSet map[chainhash.Hash]struct{}
+	if b.maxReorderDepth > 0 {
+		oldBlueSet = b.blueSetOfTip()
+	}
 
 	// Atomically insert info into the database.
 	err = b.db.Update(func(dbTx database.Tx) error {
@@ -1012,6 +1043,23 @@ func (b *BlockDAG) connectBlock(node *blockNode, block *soterutil.Block,
 			}
 		}
 
+		// Refuse re-orderings deeper than allowed by the policy, unless
+		// they have been manually approved.
+		rejection = b.checkReorderDepth(block, b.nodeOrder, sortedHashes,
+			oldBlueSet)
+		if rejection != nil {
+			str := fmt.Sprintf("block %v re-orders the DAG to a blue "+
+				"score depth of %d, which exceeds the max of %d",
+				block.Hash(), rejection.Depth, rejection.MaxDepth)
+			return ruleError(ErrDeepReorder, str)
+		}
+
+		change := b.orderAudit.record(block.Hash(), b.nodeOrder, sortedHashes)
+		if change != nil {
+			log.Infof("REORDER: Block %v re-ordered %d blocks starting at "+
+				"order position %d", block.Hash(), change.Depth(),
+				change.Position)
+		}
 		b.nodeOrder = sortedHashes
 
 		//err = dbPutUtxoView(dbTx, view)
@@ -1048,6 +1096,17 @@ func (b *BlockDAG) connectBlock(node *blockNode, block *soterutil.Block,
 	if err != nil {
 		// Remove the block from the graph
 		b.graph.RemoveTipById(block.Hash().String())
+
+		// Keep track of blocks rejected for deep re-orderings so they
+		// can be approved later, and notify the caller about them.
+		if rejection != nil {
+			log.Warnf("REORDER: %v", err)
+			b.addRejectedReorder(node)
+
+			b.chainLock.Unlock()
+			b.sendNotification(NTDeepReorderRejected, rejection)
+			b.chainLock.Lock()
+		}
 		return err
 	}
 
@@ -1555,7 +1614,9 @@ func (b *BlockDAG) connectBestChain(node *blockNode, block *soterutil.Block, fla
 		// If we got hit with a rule error, then we'll mark
 		// that status of the block as invalid and flush the
 		// index state to disk before returning with the error.
-		if _, ok := err.(RuleError); ok {
+		// Blocks refused by the re-ordering policy aren't invalid,
+		// since they may still be approved manually.
+		if rerr, ok := err.(RuleError); ok && rerr.ErrorCode != ErrDeepReorder {
 			b.index.SetStatusFlags(
 				node, statusValidateFailed,
 			)
@@ -1688,6 +1749,28 @@ func (b *BlockDAG) DAGSnapshot() *DAGState {
 	return snapshot
 }
 
+// PowBackend returns the proof of work backend of the network, which blocks
+// are validated with.
+//
+// This function is safe for concurrent access.
+func (b *BlockDAG) PowBackend() pow.Backend {
+	return b.powBackend
+}
+
+// TipHashes returns the hashes of the current tips of the DAG, sorted the same
+// way as the tips of the DAG view.
+//
+// This function is safe for concurrent access.
+func (b *BlockDAG) TipHashes() []chainhash.Hash {
+	tips := b.dView.Tips()
+	hashes := make([]chainhash.Hash, len(tips))
+	for i, tip := range tips {
+		hashes[i] = tip.hash
+	}
+
+	return hashes
+}
+
 // DAGColoring returns the blue set of blocks after coloring is run on the DAG
 // Based on the last block added
 func (b *BlockDAG) DAGColoring() []*chainhash.Hash {
@@ -1769,8 +1852,32 @@ func (b *BlockDAG) BlockLocatorFromHeight(height int32) BlockLocator {
 	return locator
 }
 
-// LatestBlockLocator returns a block locator for the latest known tip of the
-// main (best) chain.
+// LocatorFromTips returns a DAG-aware block locator for the passed tip hashes.
+// Hashes for unknown blocks are ignored, and the locator for the genesis block
+// is returned when none of the hashes are known.
+//
+// This function is safe for concurrent access.
+func (b *BlockDAG) LocatorFromTips(tips []chainhash.Hash) BlockLocator {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	nodes := make([]*blockNode, 0, len(tips))
+	for i := range tips {
+		node := b.index.LookupNode(&tips[i])
+		if node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+
+	if len(nodes) == 0 {
+		return b.BlockLocatorFromHeight(b.dView.Genesis().height)
+	}
+
+	return locatorFromNodes(nodes)
+}
+
+// LatestBlockLocator returns a DAG-aware block locator for the current tips of
+// the DAG.
 //
 // This function is safe for concurrent access.
 func (b *BlockDAG) LatestBlockLocator() (BlockLocator, error) {
@@ -2005,15 +2112,22 @@ func (b *BlockDAG) locateInventory(locator BlockLocator, hashStop *chainhash.Has
 		}
 	}
 
+	// Inventory starts after the highest height in the locator we have
+	// blocks at, so the blocks the sender already has aren't sent again.
+	// The genesis height is used when none of the heights are known.
 	genesisHeight := b.dView.Genesis().height
-	var startHeight int32
-	if *locator[0] == genesisHeight {
-		// We don't count the genesis block as inventory; It's already hard-coded into the client.
-		startHeight = genesisHeight + 1
-	} else {
-		startHeight = *locator[0] + 1
+	known := genesisHeight
+	for _, height := range locator {
+		if *height > known && len(b.dView.NodesByHeight(*height)) > 0 {
+			known = *height
+			break
+		}
 	}
 
+	// We don't count the genesis block as inventory; It's already hard-coded
+	// into the client.
+	startHeight := known + 1
+
 	inventory := make([]*blockNode, 0)
 	MAXREACHED:
 	for h := startHeight; h <= stopNode.height; h++ {
@@ -2216,6 +2330,25 @@ type Config struct {
 	// This field can be nil if the caller is not interested in using a
 	// signature cache.
 	HashCache *txscript.HashCache
+
+	// OrderAuditSize defines the number of DAG re-ordering events to retain
+	// for later inspection via OrderChanges.
+	//
+	// This field can be zero to use DefaultOrderAuditSize, or negative to
+	// disable the order audit trail.
+	OrderAuditSize int
+
+	// MaxReorderDepth defines the maximum depth, in blue score, of the
+	// re-orderings that connecting a new block may cause.  The depth is
+	// the number of blocks that were blue before the block, from the
+	// first position of the order that changes to the end of the order,
+	// so re-ordered red blocks don't count towards it.
+	// Blocks exceeding it are not connected, and an NTDeepReorderRejected
+	// notification is sent so that the re-ordering can be reviewed and
+	// approved via OverrideDeepReorder.
+	//
+	// This field can be zero to allow re-orderings of any depth.
+	MaxReorderDepth int
 }
 
 // New returns a BlockChain instance using the provided configuration details.
@@ -2249,7 +2382,17 @@ func New(config *Config) (*BlockDAG, error) {
 		}
 	}*/
 
+	orderAuditSize := config.OrderAuditSize
+	if orderAuditSize == 0 {
+		orderAuditSize = DefaultOrderAuditSize
+	}
+
 	params := config.ChainParams
+	powBackend, err := pow.Lookup(params.PowBackend)
+	if err != nil {
+		return nil, AssertError(fmt.Sprintf("blockchain.New %v", err))
+	}
+
 	targetTimespan := int64(params.TargetTimespan / time.Millisecond)
 	targetTimePerBlock := int64(params.TargetTimePerBlock / time.Millisecond)
 	adjustmentFactor := params.RetargetAdjustmentFactor
@@ -2264,6 +2407,7 @@ func New(config *Config) (*BlockDAG, error) {
 		minRetargetTimespan: targetTimespan / adjustmentFactor,
 		maxRetargetTimespan: targetTimespan * adjustmentFactor,
 		blocksPerRetarget:   int64(targetTimespan / targetTimePerBlock),
+		powBackend:          powBackend,
 		index:               newBlockIndex(config.DB, params),
 		hashCache:           config.HashCache,
 		dView:               newDAGView(nil),
@@ -2271,6 +2415,10 @@ func New(config *Config) (*BlockDAG, error) {
 		nodeOrder:           make([]*chainhash.Hash, 0),
 		blueSet:             phantom.NewBlueSetCache(),
 		orderCache:          phantom.NewOrderCache(),
+		orderAudit:          newOrderAudit(orderAuditSize),
+		maxReorderDepth:     config.MaxReorderDepth,
+		rejectedReorders:    make(map[chainhash.Hash]*blockNode),
+		reorderOverrides:    make(map[chainhash.Hash]struct{}),
 		orphans:             make(map[chainhash.Hash]*orphanBlock),
 		prevOrphans:         make(map[chainhash.Hash][]*orphanBlock),
 		warningCaches:       newThresholdCaches(vbNumBits),
@@ -471,6 +471,11 @@ func TestDAGSnapshot(t *testing.T) {
 		t.Errorf("DAGSnapshot expecting one tip %s: got %s\n", block3Hash, tipHashes[0])
 	}
 
+	// TipHashes should agree with the snapshot
+	tipHashes = dag.TipHashes()
+	if len(tipHashes) != 1 || !tipHashes[0].IsEqual(&block3Hash) {
+		t.Errorf("TipHashes expecting one tip %s: got %v\n", block3Hash, tipHashes)
+	}
 }
 
 func TestGetOrphanRoot(t *testing.T) {
@@ -274,29 +274,29 @@ func TestFullBlocks(t *testing.T) {
 		}
 	}
 
-	// testExpectedTip ensures the current tip of the blockchain is the
-	// block specified in the provided test instance.
-	//TODO
-	/*
+	// testExpectedTips ensures the current tips of the DAG are exactly the
+	// blocks specified in the provided test instance.
 	testExpectedTips := func(item dagfullblocktests.ExpectedTips) {
-		blockHeight := item.Height
-		block := soterutil.NewBlock(item.Block)
-		block.SetHeight(blockHeight)
-		t.Logf("Testing tip for block %s (hash %s, height %d)",
-			item.Name, block.Hash(), blockHeight)
+		expected := make(map[chainhash.Hash]string, len(item.Tips))
+		for _, tip := range item.Tips {
+			t.Logf("Testing tip for block %s (hash %s, height %d)",
+				tip.Name, tip.Block.BlockHash(), tip.Height)
+			expected[tip.Block.BlockHash()] = tip.Name
+		}
 
-		// Ensure hash and height match.
-		best := chain.BestSnapshot()
-		if best.Hash != item.Block.BlockHash() ||
-			best.Height != blockHeight {
+		tipHashes := chain.TipHashes()
+		if len(tipHashes) != len(expected) {
+			t.Fatalf("expected %d tips, got %d: %v", len(expected),
+				len(tipHashes), tipHashes)
+		}
 
-			t.Fatalf("block %q (hash %s, height %d) should be "+
-				"the current tip -- got (hash %s, height %d)",
-				item.Name, block.Hash(), blockHeight, best.Hash,
-				best.Height)
+		for _, hash := range tipHashes {
+			if _, ok := expected[hash]; !ok {
+				t.Fatalf("block %s is a tip, but is not one of "+
+					"the expected tips", hash)
+			}
 		}
 	}
-   */
 
 	for testNum, test := range tests {
 		for itemNum, item := range test {
@@ -309,8 +309,8 @@ func TestFullBlocks(t *testing.T) {
 				testRejectedNonCanonicalBlock(item)
 			case dagfullblocktests.OrphanOrRejectedBlock:
 				testOrphanOrRejectedBlock(item)
-			//case dagfullblocktests.ExpectedTips:
-			//	testExpectedTips(item)
+			case dagfullblocktests.ExpectedTips:
+				testExpectedTips(item)
 			default:
 				t.Fatalf("test #%d, item #%d is not one of "+
 					"the supported test instance types -- "+
@@ -484,47 +484,89 @@ func (c *chainView) FindFork(node *blockNode) *blockNode {
 }
 */
 
+// locatorFromNodes returns a DAG-aware block locator for the passed tip nodes.
+//
+// The locator starts with the height of the selected tip (the highest of the
+// passed nodes), followed by the heights of the remaining tips, and then
+// samples heights below the lowest tip with an exponentially increasing step,
+// down to maxGenerationDifference generations below the lowest tip.  Blocks
+// from other branches of the DAG can only reference parents within that many
+// generations, so this is enough to cover regions of the DAG that were missed
+// while partitioned from the rest of the network.
+//
+// The heights are unique and sorted in descending order, so the recipient
+// starts returning inventory after the first entry it has blocks at.
+func locatorFromNodes(nodes []*blockNode) BlockLocator {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	maxHeight := nodes[0].height
+	minHeight := nodes[0].height
+	for _, node := range nodes {
+		if node.height > maxHeight {
+			maxHeight = node.height
+		}
+		if node.height < minHeight {
+			minHeight = node.height
+		}
+	}
+
+	floor := minHeight - maxGenerationDifference
+	if floor < 0 {
+		floor = 0
+	}
+
+	heights := make(map[int32]struct{})
+	for _, node := range nodes {
+		heights[node.height] = struct{}{}
+	}
+	for step := int32(1); minHeight-step > floor; step *= 2 {
+		heights[minHeight-step] = struct{}{}
+	}
+	heights[floor] = struct{}{}
+
+	sorted := make([]int32, 0, len(heights))
+	for height := range heights {
+		sorted = append(sorted, height)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] > sorted[j]
+	})
+
+	locator := make(BlockLocator, len(sorted))
+	for i := range sorted {
+		locator[i] = &sorted[i]
+	}
+
+	return locator
+}
+
 // blockLocator returns a block locator for the passed block node.  The passed
-// node can be nil in which case the block locator for the current tip
-// associated with the view will be returned.  This only differs from the
+// node can be nil in which case the DAG-aware block locator for the current
+// tips associated with the view will be returned.  This only differs from the
 // exported version in that it is up to the caller to ensure the lock is held.
 //
 // See the exported BlockLocator function comments for more details.
 //
 // This function MUST be called with the view mutex locked (for reads).
 func (c *dagView) blockLocator(node *blockNode) BlockLocator {
-	// Use the current tip if requested.
-	var maxHeight int32
+	// Use the current tips if requested.
 	if node == nil {
-		tips := c.tips()
-		maxHeight = tips[0].height
-		node = tips[0]
-		for _, tip := range tips {
-			if tip.height > maxHeight {
-				maxHeight = tip.height
-				node = tip
-			}
-		}
-	}
-	if node == nil {
-		// We weren't able to locate any block
-		return nil
+		return locatorFromNodes(c.tips())
 	}
 
-	if node.height > maxHeight {
-		maxHeight = node.height
-	}
-	locator := make(BlockLocator, 0, 1)
-	locator = append(locator, &maxHeight)
-
-	return locator
+	// A specific block is being located, so there is no need to look for
+	// missing regions of the DAG.
+	height := node.height
+	return BlockLocator{&height}
 }
 
 // BlockLocator returns a block locator for the passed block node.  The passed
-// node can be nil in which case the block locator for the current tip
+// node can be nil in which case the block locator for the current tips
 // associated with the view will be returned.
 //
-// See the BlockLocator type for details on the algorithm used to create a block
+// See locatorFromNodes for details on the algorithm used to create a block
 // locator.
 //
 // This function is safe for concurrent access.
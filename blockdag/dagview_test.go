@@ -297,3 +297,33 @@ func TestDagViewRemoveTip(t *testing.T) {
 		t.Errorf("Expecting one tip, got %d", len(tips))
 	}
 }
+
+// TestDagViewBlockLocator ensures that block locators for the tips of the view
+// include every tip, and sample heights below the lowest tip.
+func TestDagViewBlockLocator(t *testing.T) {
+	chain := make([]*blockNode, 0, 100)
+	chain = append(chain, createBlock(nil))
+	for i := 1; i < 100; i++ {
+		chain = append(chain, createBlock([]*blockNode{ chain[i-1] }))
+	}
+	side := createBlock([]*blockNode{ chain[90] })
+
+	dagView := newDAGView([]*blockNode{ chain[99], side })
+
+	expected := []int32{99, 91, 90, 89, 87, 83, 75, 59, 27, 21}
+	locator := dagView.BlockLocator(nil)
+	if len(locator) != len(expected) {
+		t.Fatalf("Expecting locator of length %d, got %d", len(expected), len(locator))
+	}
+	for i, height := range locator {
+		if *height != expected[i] {
+			t.Errorf("Expecting locator entry %d to be height %d, got %d", i, expected[i], *height)
+		}
+	}
+
+	// A locator for a specific block only contains the block's height
+	locator = dagView.BlockLocator(chain[50])
+	if len(locator) != 1 || *locator[0] != 50 {
+		t.Errorf("Expecting locator for height 50, got %v", locator)
+	}
+}
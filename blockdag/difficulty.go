@@ -12,7 +12,7 @@ import (
 	"sort"
 	"time"
 
-	qithash "github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/soteria-dag/soterd/blockdag/pow"
 	"github.com/soteria-dag/soterd/chaincfg/chainhash"
 	"github.com/soteria-dag/soterd/wire"
 )
@@ -346,25 +346,13 @@ func (b *BlockDAG) CalcNextRequiredDifficulty(timestamp time.Time) (uint32, erro
 	return difficulty, err
 }
 
-// scaleDifficulty scales the difficulty value
-func scaleDifficulty(d *big.Int) *big.Int {
-	scaled := big.NewInt(0).Div(oneLsh256, d)
-	if scaled.Cmp(bigOne) < 0 {
-		// Scaled difficulty can't be less than 1
-		return bigOne
-	}
-	return scaled
-}
-
 // ProofDifficulty returns the difficulty value of the cuckoo cycle proof.
 // The proof difficulty is defined as the maximum difficulty of 2^256 divided by
-// the double-sha256 digest of the cycle nonces.
+// the double-sha256 digest of the cycle nonces.  It is the proof difficulty of
+// the default proof of work backend; see BlockDAG.PowBackend for the one of
+// the network.
 func ProofDifficulty(cycleNonces []uint32) *big.Int {
-	var hashInt big.Int
-	cycleNoncesHash := qithash.DoubleHashB(Uint32ToBytes(cycleNonces))
-	hashInt.SetBytes(cycleNoncesHash[:])
-
-	return scaleDifficulty(&hashInt)
+	return pow.Default().ProofDifficulty(cycleNonces)
 }
 
 // TargetDifficulty returns
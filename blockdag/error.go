@@ -224,6 +224,14 @@ const (
 	// current chain tip. This is not a block validation rule, but is required
 	// for block proposals submitted via getblocktemplate RPC.
 	ErrPrevBlockNotBest
+
+	// ErrDuplicateParent indicates a block references the same parent more
+	// than once.
+	ErrDuplicateParent
+
+	// ErrDeepReorder indicates connecting a block would re-order the DAG
+	// deeper, in blue score, than allowed by the MaxReorderDepth policy.
+	ErrDeepReorder
 )
 
 // Map of ErrorCode values back to their constant names for pretty printing.
@@ -272,6 +280,8 @@ var errorCodeStrings = map[ErrorCode]string{
 	ErrPreviousBlockUnknown:      "ErrPreviousBlockUnknown",
 	ErrInvalidAncestorBlock:      "ErrInvalidAncestorBlock",
 	ErrPrevBlockNotBest:          "ErrPrevBlockNotBest",
+	ErrDuplicateParent:           "ErrDuplicateParent",
+	ErrDeepReorder:               "ErrDeepReorder",
 }
 
 // String returns the ErrorCode as a human-readable name.
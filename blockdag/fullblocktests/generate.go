@@ -129,14 +129,16 @@ type ExpectedTip struct {
 	Height int32
 }
 
-type ExpectedTips struct { // FOR DAG
-	tips []*ExpectedTip
+// ExpectedTips defines a test instance that expects the provided blocks to be
+// the exact set of tips of the DAG.
+type ExpectedTips struct {
+	Tips []ExpectedTip
 }
 
-// Ensure ExpectedTip implements the TestInstance interface.
+// Ensure ExpectedTips implements the TestInstance interface.
 var _ TestInstance = ExpectedTips{}
 
-// FullBlockTestInstance only exists to allow ExpectedTip to be treated as a
+// FullBlockTestInstance only exists to allow ExpectedTips to be treated as a
 // TestInstance.
 //
 // This implements the TestInstance interface.
@@ -480,7 +482,9 @@ func (g *testGenerator) nextBlock(blockName string, spend *spendableOut, parentN
 		parentNames = g.tipNames
 	}
 
-	maxHeight := g.lastHeight
+	// The height of the block is one more than its highest parent, which
+	// isn't necessarily the last generated block.
+	maxHeight := int32(0)
 	for _, tip := range parentNames {
 		tipHeight := g.blockHeights[tip]
 		if tipHeight > maxHeight {
@@ -919,8 +923,8 @@ func Generate(includeLargeReorg bool) (tests [][]TestInstance, err error) {
 	// provided block to either by accepted as an orphan or rejected by the
 	// consensus rules.
 	//
-	// expectTipBlock creates a test instance that expects the provided
-	// block to be the current tip of the block chain.
+	// expectTipBlocks creates a test instance that expects the provided
+	// blocks to be the exact set of tips of the DAG.
 	acceptBlock := func(blockName string, block *wire.MsgBlock, isMainChain, isOrphan bool) TestInstance {
 		blockHeight := g.blockHeights[blockName]
 		return AcceptedBlock{blockName, block, blockHeight, isMainChain,
@@ -939,13 +943,13 @@ func Generate(includeLargeReorg bool) (tests [][]TestInstance, err error) {
 		blockHeight := g.blockHeights[blockName]
 		return OrphanOrRejectedBlock{blockName, block, blockHeight}
 	}
-	//expectTipBlocks := func(tipNames []string) TestInstance {
-	//	ets := make([]*ExpectedTip, len(tipNames))
-	//	for i, tipName := range tipNames {
-	//		ets[i] = &ExpectedTip{tipName, g.blocksByName[tipName], g.blockHeights[tipName]}
-	//	}
-	//	return ExpectedTips{ ets}
-	//}
+	expectTipBlocks := func(tipNames []string) TestInstance {
+		ets := make([]ExpectedTip, len(tipNames))
+		for i, tipName := range tipNames {
+			ets[i] = ExpectedTip{tipName, g.blocksByName[tipName], g.blockHeights[tipName]}
+		}
+		return ExpectedTips{ets}
+	}
 
 	// Define some convenience helper functions to populate the tests slice
 	// with test instances that have the described characteristics.
@@ -954,6 +958,9 @@ func Generate(includeLargeReorg bool) (tests [][]TestInstance, err error) {
 	// the current tip which expects the block to be accepted to the main
 	// chain.
 	//
+	// expectedTips creates and appends a single expectTipBlocks test
+	// instance for the provided tips.
+	//
 	// acceptedToSideChainWithExpectedTip creates an appends a two-instance
 	// test.  The first instance is an acceptBlock test instance for the
 	// current tip which expects the block to be accepted to a side chain.
@@ -973,11 +980,11 @@ func Generate(includeLargeReorg bool) (tests [][]TestInstance, err error) {
 			acceptBlock(g.lastName, g.last, true, false),
 		})
 	}
-	//expectedTips := func(tips []string) {
-	//	tests = append(tests, []TestInstance{
-	//		expectTipBlocks(tips),
-	//	})
-	//}
+	expectedTips := func(tips []string) {
+		tests = append(tests, []TestInstance{
+			expectTipBlocks(tips),
+		})
+	}
 	//acceptedToSideChainWithExpectedTip := func(tipName string) {
 	//	tests = append(tests, []TestInstance{
 	//		acceptBlock(g.tipName, g.tip, false, false),
@@ -1144,6 +1151,106 @@ func Generate(includeLargeReorg bool) (tests [][]TestInstance, err error) {
 	// outright rejected due to an invalid parent.
 	g.nextBlock("b9", outs[5], []string{"b8"})
 	orphanedOrRejected()
+
+	// ---------------------------------------------------------------------
+	// Multi-parent block tests.
+	// ---------------------------------------------------------------------
+
+	// Create two sibling blocks that share a parent, and then a block that
+	// merges them back together.
+	//
+	//   ... -> b7(3) -> b10(4) -> b12(5)
+	//               \-> b11    -/
+	g.setLast("b7")
+	g.setTips([]string{"b7"})
+	g.nextBlock("b10", outs[4], []string{"b7"})
+	accepted()
+
+	g.nextBlock("b11", nil, []string{"b7"})
+	accepted()
+	expectedTips([]string{"b10", "b11"})
+
+	g.nextBlock("b12", outs[5], []string{"b10", "b11"})
+	accepted()
+	expectedTips([]string{"b12"})
+
+	// ---------------------------------------------------------------------
+	// Non-tip parent tests.
+	// ---------------------------------------------------------------------
+
+	// Create a block whose parent is no longer a tip of the DAG.  It is
+	// valid, and becomes a new tip alongside the existing one.
+	//
+	//   ... -> b7(3) -> b10(4) -> b12(5)
+	//               \-> b11    -/
+	//                      \-> b13
+	g.nextBlock("b13", nil, []string{"b10"})
+	accepted()
+	expectedTips([]string{"b12", "b13"})
+
+	// ---------------------------------------------------------------------
+	// Duplicate parent tests.
+	// ---------------------------------------------------------------------
+
+	// Create a block that references the same parent twice.
+	//
+	//   ... -> b12(5) -> b14
+	//                \--/
+	g.nextBlock("b14", nil, []string{"b12", "b12"})
+	rejected(blockdag.ErrDuplicateParent)
+	g.setTips([]string{"b12", "b13"})
+	expectedTips([]string{"b12", "b13"})
+
+	// ---------------------------------------------------------------------
+	// Divergent parent height tests.
+	// ---------------------------------------------------------------------
+
+	// Create a block whose parents are at different heights.  The height of
+	// the block is one more than its highest parent.
+	//
+	//   ... -> b12(5) -> b15(6) -> b16(7) -> b17(8)
+	//      \-> b13                        -/
+	g.nextBlock("b15", outs[6], []string{"b12"})
+	accepted()
+
+	g.nextBlock("b16", outs[7], []string{"b15"})
+	accepted()
+	expectedTips([]string{"b13", "b16"})
+
+	g.nextBlock("b17", outs[8], []string{"b16", "b13"})
+	accepted()
+	expectedTips([]string{"b17"})
+
+	// ---------------------------------------------------------------------
+	// Sibling double spend tests.
+	// ---------------------------------------------------------------------
+
+	// Create two sibling blocks that spend the same output.  The sibling
+	// that arrives first is accepted, and the other is rejected because
+	// the output has already been spent in the DAG's utxo set.
+	//
+	//   ... -> b17(8) -> b18(9)
+	//                \-> b19(9)
+	g.nextBlock("b18", outs[9], []string{"b17"})
+	accepted()
+
+	g.nextBlock("b19", outs[9], []string{"b17"})
+	rejected(blockdag.ErrMissingTxOut)
+	g.setTips([]string{"b18"})
+	expectedTips([]string{"b18"})
+
+	// A sibling block that doesn't conflict is valid, and the siblings can
+	// be merged by a later block.
+	//
+	//   ... -> b17(8) -> b18(9) -> b21
+	//                \-> b20    -/
+	g.nextBlock("b20", nil, []string{"b17"})
+	accepted()
+	expectedTips([]string{"b18", "b20"})
+
+	g.nextBlock("b21", nil, []string{"b18", "b20"})
+	accepted()
+	expectedTips([]string{"b21"})
 /*
 	// ---------------------------------------------------------------------
 	// Coinbase script length limits tests.
@@ -30,14 +30,20 @@ const (
 	// NTBlockDisconnected indicates the associated block was disconnected
 	// from the main chain.
 	NTBlockDisconnected
+
+	// NTDeepReorderRejected indicates the associated block was not
+	// connected because it would have re-ordered the DAG deeper, in blue
+	// score, than allowed by the MaxReorderDepth policy.
+	NTDeepReorderRejected
 )
 
 // notificationTypeStrings is a map of notification types back to their constant
 // names for pretty printing.
 var notificationTypeStrings = map[NotificationType]string{
-	NTBlockAccepted:     "NTBlockAccepted",
-	NTBlockConnected:    "NTBlockConnected",
-	NTBlockDisconnected: "NTBlockDisconnected",
+	NTBlockAccepted:       "NTBlockAccepted",
+	NTBlockConnected:      "NTBlockConnected",
+	NTBlockDisconnected:   "NTBlockDisconnected",
+	NTDeepReorderRejected: "NTDeepReorderRejected",
 }
 
 // String returns the NotificationType in human-readable form.
@@ -51,9 +57,10 @@ func (n NotificationType) String() string {
 // Notification defines notification that is sent to the caller via the callback
 // function provided during the call to New and consists of a notification type
 // as well as associated data that depends on the type as follows:
-// 	- NTBlockAccepted:     *soterutil.Block
-// 	- NTBlockConnected:    *soterutil.Block
-// 	- NTBlockDisconnected: *soterutil.Block
+// 	- NTBlockAccepted:       *soterutil.Block
+// 	- NTBlockConnected:      *soterutil.Block
+// 	- NTBlockDisconnected:   *soterutil.Block
+// 	- NTDeepReorderRejected: *DeepReorderRejection
 type Notification struct {
 	Type NotificationType
 	Data interface{}
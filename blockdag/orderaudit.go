@@ -0,0 +1,158 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockdag
+
+import (
+	"sync"
+	"time"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+)
+
+const (
+	// DefaultOrderAuditSize is the default number of re-ordering events
+	// retained by the order audit trail.
+	DefaultOrderAuditSize = 100
+)
+
+// OrderChange describes a re-ordering of the DAG.  A re-ordering happens when
+// connecting a block changes the position of blocks that were already part of
+// the order, as opposed to simply appending to it (for example when tips merge
+// and a previous sibling becomes blue).
+type OrderChange struct {
+	// Trigger is the hash of the block whose connection caused the
+	// re-ordering.
+	Trigger chainhash.Hash
+
+	// Position is the index in the order where the old and new order
+	// first diverge.
+	Position int
+
+	// OldSegment is the old order starting from Position.
+	OldSegment []chainhash.Hash
+
+	// NewSegment is the new order starting from Position.
+	NewSegment []chainhash.Hash
+
+	// Timestamp is the time the re-ordering was recorded.
+	Timestamp time.Time
+}
+
+// Depth returns the number of previously ordered blocks whose position was
+// changed by the re-ordering.
+func (c *OrderChange) Depth() int {
+	return len(c.OldSegment)
+}
+
+// orderAudit is a fixed-size ring buffer of OrderChange entries.  Once the
+// buffer is full, the oldest entry is replaced by the newest one.
+type orderAudit struct {
+	mtx     sync.Mutex
+	entries []OrderChange
+	next    int
+	full    bool
+}
+
+// newOrderAudit returns an order audit trail that retains up to size entries.
+// A size less than or equal to zero disables the audit trail.
+func newOrderAudit(size int) *orderAudit {
+	if size < 0 {
+		size = 0
+	}
+	return &orderAudit{
+		entries: make([]OrderChange, size),
+	}
+}
+
+// orderDivergence returns the first index where the old and new orders differ.
+// When the new order only appends to the old order, the length of the old
+// order is returned.
+func orderDivergence(oldOrder, newOrder []*chainhash.Hash) int {
+	for i, hash := range oldOrder {
+		if i >= len(newOrder) || !hash.IsEqual(newOrder[i]) {
+			return i
+		}
+	}
+
+	return len(oldOrder)
+}
+
+// copyOrderSegment returns a copy of the passed order, dereferencing the hashes
+// so that the segment is unaffected by later changes to the order.
+func copyOrderSegment(order []*chainhash.Hash) []chainhash.Hash {
+	segment := make([]chainhash.Hash, len(order))
+	for i, hash := range order {
+		segment[i] = *hash
+	}
+
+	return segment
+}
+
+// record compares the old and new orders, and adds an entry to the audit trail
+// if the new order changed the position of any previously ordered block.  It
+// returns the recorded entry, or nil if no re-ordering took place.
+//
+// This function is safe for concurrent access.
+func (a *orderAudit) record(trigger *chainhash.Hash, oldOrder, newOrder []*chainhash.Hash) *OrderChange {
+	pos := orderDivergence(oldOrder, newOrder)
+	if pos == len(oldOrder) {
+		// The old order is a prefix of the new order, so nothing was
+		// re-ordered.
+		return nil
+	}
+
+	change := OrderChange{
+		Trigger:    *trigger,
+		Position:   pos,
+		OldSegment: copyOrderSegment(oldOrder[pos:]),
+		NewSegment: copyOrderSegment(newOrder[pos:]),
+		Timestamp:  time.Now(),
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if len(a.entries) == 0 {
+		return &change
+	}
+
+	a.entries[a.next] = change
+	a.next++
+	if a.next == len(a.entries) {
+		a.next = 0
+		a.full = true
+	}
+
+	return &change
+}
+
+// changes returns the entries in the audit trail, ordered from oldest to
+// newest.
+//
+// This function is safe for concurrent access.
+func (a *orderAudit) changes() []OrderChange {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if !a.full {
+		changes := make([]OrderChange, a.next)
+		copy(changes, a.entries[:a.next])
+		return changes
+	}
+
+	changes := make([]OrderChange, 0, len(a.entries))
+	changes = append(changes, a.entries[a.next:]...)
+	changes = append(changes, a.entries[:a.next]...)
+	return changes
+}
+
+// OrderChanges returns the most recent re-orderings of the DAG, ordered from
+// oldest to newest.  The number of entries retained is controlled by the
+// OrderAuditSize field of Config.
+//
+// This function is safe for concurrent access.
+func (b *BlockDAG) OrderChanges() []OrderChange {
+	return b.orderAudit.changes()
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockdag
+
+import (
+	"testing"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+)
+
+// makeOrderForTest returns an order made up of hashes whose first byte is set
+// to each of the passed values.
+func makeOrderForTest(ids ...byte) []*chainhash.Hash {
+	order := make([]*chainhash.Hash, len(ids))
+	for i, id := range ids {
+		order[i] = &chainhash.Hash{id}
+	}
+	return order
+}
+
+// TestOrderAuditAppend ensures that appending to the order doesn't record a
+// re-ordering.
+func TestOrderAuditAppend(t *testing.T) {
+	audit := newOrderAudit(2)
+	trigger := chainhash.Hash{3}
+
+	change := audit.record(&trigger, makeOrderForTest(0, 1, 2), makeOrderForTest(0, 1, 2, 3))
+	if change != nil {
+		t.Errorf("Expecting no re-ordering, got %+v", change)
+	}
+
+	if len(audit.changes()) != 0 {
+		t.Errorf("Expecting no audit entries, got %d", len(audit.changes()))
+	}
+}
+
+// TestOrderAuditRecord ensures that re-orderings are recorded with the correct
+// segments, and that the ring buffer drops the oldest entries when full.
+func TestOrderAuditRecord(t *testing.T) {
+	audit := newOrderAudit(2)
+
+	for i := byte(0); i < 3; i++ {
+		trigger := chainhash.Hash{10 + i}
+		change := audit.record(&trigger, makeOrderForTest(0, 1, 2), makeOrderForTest(0, 2, 1, 10+i))
+		if change == nil {
+			t.Fatalf("Expecting re-ordering for trigger %v", trigger)
+		}
+
+		if change.Position != 1 {
+			t.Errorf("Expecting divergence at position 1, got %d", change.Position)
+		}
+
+		if change.Depth() != 2 {
+			t.Errorf("Expecting depth of 2, got %d", change.Depth())
+		}
+
+		if len(change.NewSegment) != 3 || change.NewSegment[2] != trigger {
+			t.Errorf("Unexpected new segment %v", change.NewSegment)
+		}
+	}
+
+	changes := audit.changes()
+	if len(changes) != 2 {
+		t.Fatalf("Expecting 2 audit entries, got %d", len(changes))
+	}
+
+	// The first entry should have been replaced, leaving the second and
+	// third ordered from oldest to newest.
+	for i, change := range changes {
+		expected := chainhash.Hash{11 + byte(i)}
+		if change.Trigger != expected {
+			t.Errorf("Expecting entry %d trigger to be %v, got %v", i, expected, change.Trigger)
+		}
+	}
+}
+
+// TestOrderAuditDisabled ensures that a zero-sized audit trail still reports
+// re-orderings to the caller without retaining them.
+func TestOrderAuditDisabled(t *testing.T) {
+	audit := newOrderAudit(-1)
+	trigger := chainhash.Hash{3}
+
+	change := audit.record(&trigger, makeOrderForTest(0, 1, 2), makeOrderForTest(0, 2, 1, 3))
+	if change == nil {
+		t.Errorf("Expecting re-ordering to be reported")
+	}
+
+	if len(audit.changes()) != 0 {
+		t.Errorf("Expecting no audit entries, got %d", len(audit.changes()))
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package pow
+
+import (
+	"encoding/binary"
+	"math/big"
+
+	qithash "github.com/Qitmeer/qitmeer-lib/common/hash"
+	"github.com/Qitmeer/qitmeer-lib/crypto/cuckoo"
+)
+
+var (
+	// bigOne is 1 represented as a big.Int.  It is defined here to avoid
+	// the overhead of creating it multiple times.
+	bigOne = big.NewInt(1)
+
+	// oneLsh256 is 1 shifted left 256 bits.  It is defined here to avoid
+	// the overhead of creating it multiple times.
+	oneLsh256 = new(big.Int).Lsh(bigOne, 256)
+)
+
+// cuckooBackend is the cuckoo cycle backend.  It implements the Backend
+// interface.
+type cuckooBackend struct{}
+
+// cuckooSolver searches for cuckoo cycles.  It implements the Solver
+// interface.
+type cuckooSolver struct {
+	pow func(hash []byte) ([]uint32, bool)
+}
+
+// Solve searches for a cuckoo cycle in the graph generated from the passed
+// block header hash.  It is part of the Solver interface.
+func (s *cuckooSolver) Solve(hash []byte) ([]uint32, bool) {
+	return s.pow(hash)
+}
+
+// Name returns the name of the backend.  It is part of the Backend interface.
+func (cuckooBackend) Name() string {
+	return CuckooBackend
+}
+
+// ProofSize returns the length of the cycles.  It is part of the Backend
+// interface.
+func (cuckooBackend) ProofSize() int {
+	return cuckoo.ProofSize
+}
+
+// NewSolver returns a new cuckoo cycle solver.  It is part of the Backend
+// interface.
+func (cuckooBackend) NewSolver() Solver {
+	return &cuckooSolver{pow: cuckoo.NewCuckoo().PoW}
+}
+
+// Verify verifies the passed cycle nonces form a cuckoo cycle in the graph
+// generated from the passed block header hash.  It is part of the Backend
+// interface.
+func (cuckooBackend) Verify(hash []byte, proof []uint32) error {
+	return cuckoo.Verify(hash, proof)
+}
+
+// ProofDifficulty returns the maximum difficulty of 2^256 divided by the
+// double-sha256 digest of the cycle nonces, and at least one.  It is part of
+// the Backend interface.
+func (cuckooBackend) ProofDifficulty(proof []uint32) *big.Int {
+	buf := make([]byte, 4*len(proof))
+	for i, x := range proof {
+		binary.LittleEndian.PutUint32(buf[4*i:], x)
+	}
+
+	var hashInt big.Int
+	digest := qithash.DoubleHashB(buf)
+	hashInt.SetBytes(digest[:])
+
+	difficulty := new(big.Int).Div(oneLsh256, &hashInt)
+	if difficulty.Cmp(bigOne) < 0 {
+		return bigOne
+	}
+	return difficulty
+}
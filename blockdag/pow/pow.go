@@ -0,0 +1,126 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package pow abstracts the proof of work of soter blocks.
+//
+// A block is solved by a proof whose verification against the hash of the
+// block header succeeds, and whose proof difficulty is at least the target
+// difficulty of the block.  The default backend finds and verifies the proofs
+// as cuckoo cycles, whose difficulty derives from the double-sha256 digest of
+// the cycle nonces.  Research networks may register other backends, which are
+// selected through the PowBackend field of the chain parameters, so the
+// validation and the miners don't need patching.
+package pow
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+)
+
+// CuckooBackend is the name of the cuckoo cycle backend, which is the default
+// backend.
+const CuckooBackend = "cuckoo"
+
+// Solver searches for proofs of work.  A solver may keep state between
+// searches, so it isn't safe for concurrent access; each miner uses its own.
+type Solver interface {
+	// Solve searches for a proof for the passed block header hash, and
+	// returns whether one was found.  Its verification may still fail,
+	// and its difficulty may not meet the target.
+	Solve(hash []byte) (proof []uint32, found bool)
+}
+
+// Backend defines the proof of work of a network.
+//
+// The interface contract requires that all of these methods are safe for
+// concurrent access.
+type Backend interface {
+	// Name returns the name the backend is registered under.
+	Name() string
+
+	// ProofSize returns the number of nonces of a proof.
+	ProofSize() int
+
+	// NewSolver returns a new solver searching for proofs.
+	NewSolver() Solver
+
+	// Verify returns an error when the passed proof isn't valid for the
+	// passed block header hash.
+	Verify(hash []byte, proof []uint32) error
+
+	// ProofDifficulty returns the difficulty of the passed proof, which
+	// solves a block when it's at least the target difficulty of the
+	// block.
+	ProofDifficulty(proof []uint32) *big.Int
+}
+
+var (
+	backendsMtx sync.RWMutex
+	backends    = map[string]Backend{
+		CuckooBackend: cuckooBackend{},
+	}
+)
+
+// Register registers the passed backend under its name, so it can be selected
+// through the PowBackend field of the chain parameters.  Registering a backend
+// under the name of an existing one replaces it.
+//
+// This function is safe for concurrent access.
+func Register(backend Backend) {
+	backendsMtx.Lock()
+	backends[backend.Name()] = backend
+	backendsMtx.Unlock()
+}
+
+// Names returns the sorted names of the registered backends.
+//
+// This function is safe for concurrent access.
+func Names() []string {
+	backendsMtx.RLock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	backendsMtx.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the backend registered under the passed name.  The cuckoo
+// cycle backend is returned when the name is empty.
+//
+// This function is safe for concurrent access.
+func Lookup(name string) (Backend, error) {
+	if name == "" {
+		name = CuckooBackend
+	}
+
+	backendsMtx.RLock()
+	backend, ok := backends[name]
+	backendsMtx.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown proof of work backend %q "+
+			"(registered: %v)", name, Names())
+	}
+	return backend, nil
+}
+
+// Default returns the cuckoo cycle backend.
+func Default() Backend {
+	return cuckooBackend{}
+}
+
+// Solved returns the difficulty of the passed proof for the passed block
+// header hash, and whether it solves a block of the passed target difficulty.
+// An error is returned when the proof isn't valid.
+func Solved(backend Backend, hash []byte, proof []uint32, target *big.Int) (*big.Int, bool, error) {
+	if err := backend.Verify(hash, proof); err != nil {
+		return nil, false, err
+	}
+	difficulty := backend.ProofDifficulty(proof)
+	return difficulty, difficulty.Cmp(target) >= 0, nil
+}
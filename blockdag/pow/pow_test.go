@@ -0,0 +1,91 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package pow
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// testBackend is a backend whose proofs are valid when their first nonce is
+// the first byte of the hash, and whose difficulty is their second nonce.
+type testBackend struct{}
+
+func (testBackend) Name() string      { return "test" }
+func (testBackend) ProofSize() int    { return 2 }
+func (testBackend) NewSolver() Solver { return testSolver{} }
+
+func (testBackend) Verify(hash []byte, proof []uint32) error {
+	if len(proof) != 2 || proof[0] != uint32(hash[0]) {
+		return errors.New("invalid proof")
+	}
+	return nil
+}
+
+func (testBackend) ProofDifficulty(proof []uint32) *big.Int {
+	return big.NewInt(int64(proof[1]))
+}
+
+type testSolver struct{}
+
+func (testSolver) Solve(hash []byte) ([]uint32, bool) {
+	return []uint32{uint32(hash[0]), 10}, true
+}
+
+// TestLookup ensures the cuckoo cycle backend is the default, and that
+// registered backends can be looked up by name.
+func TestLookup(t *testing.T) {
+	backend, err := Lookup("")
+	if err != nil || backend.Name() != CuckooBackend {
+		t.Fatalf("Lookup: got (%v, %v) for the default backend", backend,
+			err)
+	}
+	if Default().Name() != CuckooBackend {
+		t.Fatalf("Default: got backend %s", Default().Name())
+	}
+	if _, err := Lookup("test"); err == nil {
+		t.Fatal("Lookup: unregistered backend found")
+	}
+
+	Register(testBackend{})
+	backend, err = Lookup("test")
+	if err != nil || backend.Name() != "test" {
+		t.Fatalf("Lookup: got (%v, %v) for the registered backend",
+			backend, err)
+	}
+	if names := Names(); len(names) != 2 || names[0] != CuckooBackend {
+		t.Fatalf("Names: got %v", names)
+	}
+}
+
+// TestSolved ensures proofs only solve a block when they're valid and meet the
+// target difficulty.
+func TestSolved(t *testing.T) {
+	backend := testBackend{}
+	hash := []byte{7}
+	proof, found := backend.NewSolver().Solve(hash)
+	if !found {
+		t.Fatal("Solve: no proof found")
+	}
+
+	difficulty, solved, err := Solved(backend, hash, proof, big.NewInt(10))
+	if err != nil || !solved || difficulty.Int64() != 10 {
+		t.Fatalf("Solved: got (%v, %v, %v) at the target", difficulty,
+			solved, err)
+	}
+	_, solved, err = Solved(backend, hash, proof, big.NewInt(11))
+	if err != nil || solved {
+		t.Fatalf("Solved: got (%v, %v) below the target", solved, err)
+	}
+	if _, _, err := Solved(backend, []byte{8}, proof, bigOne); err == nil {
+		t.Fatal("Solved: invalid proof accepted")
+	}
+
+	// The difficulty of the cuckoo cycle proofs is at least one.
+	if Default().ProofDifficulty(make([]uint32, 42)).Cmp(bigOne) < 0 {
+		t.Fatal("ProofDifficulty: cuckoo cycle difficulty below one")
+	}
+}
@@ -200,7 +200,8 @@ func (b *BlockDAG) ProcessBlock(block *soterutil.Block, flags BehaviorFlags) (bo
 	}
 
 	// Perform preliminary sanity checks on the block and its transactions.
-	err = checkBlockSanity(block, b.chainParams.PowLimit, b.timeSource, flags)
+	err = checkBlockSanity(block, b.chainParams.PowLimit, b.powBackend,
+		b.timeSource, flags)
 	if err != nil {
 		return false, false, err
 	}
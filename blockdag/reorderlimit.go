@@ -0,0 +1,206 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockdag
+
+import (
+	"fmt"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/database"
+	"github.com/soteria-dag/soterd/soterutil"
+)
+
+// maxRejectedReorders is the maximum number of blocks rejected for deep
+// re-orderings that are kept for manual approval.  Once it's reached, the
+// lowest rejected block is evicted to make room for a new one.
+const maxRejectedReorders = 100
+
+// DeepReorderRejection describes a block that was not connected to the DAG
+// because it would have re-ordered the DAG deeper, in blue score, than allowed
+// by the MaxReorderDepth policy.  It is the data of NTDeepReorderRejected
+// notifications.
+type DeepReorderRejection struct {
+	// Block is the block that was rejected.
+	Block *soterutil.Block
+
+	// Change describes the re-ordering the block would have caused.
+	Change OrderChange
+
+	// Depth is the blue score depth of the re-ordering, which is the
+	// number of blue blocks in the old segment of Change.
+	Depth int
+
+	// MaxDepth is the maximum blue score depth allowed by the policy at
+	// the time the block was rejected.
+	MaxDepth int
+}
+
+// blueSetOfTip returns the hashes of the blue blocks in the coloring of the
+// best tip of the DAG.  Nil is returned when the coloring isn't known.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockDAG) blueSetOfTip() map[chainhash.Hash]struct{} {
+	tip := b.graph.GetNodeById(b.BestSnapshot().Hash.String())
+	if tip == nil {
+		return nil
+	}
+	blueNodes := b.blueSet.GetBlueNodes(tip)
+	if blueNodes == nil {
+		return nil
+	}
+
+	blueSet := make(map[chainhash.Hash]struct{}, len(blueNodes)+1)
+	for _, node := range append(blueNodes, tip) {
+		hash, err := chainhash.NewHashFromStr(node.GetId())
+		if err != nil {
+			continue
+		}
+		blueSet[*hash] = struct{}{}
+	}
+
+	return blueSet
+}
+
+// reorderDepth returns the blue score depth of a re-ordering that replaces the
+// passed segment of the old order, which is the number of blocks of the
+// segment that are in the passed blue set.  Every block is counted when the
+// blue set is nil, so that an unknown coloring errs on the side of rejecting.
+func reorderDepth(segment []*chainhash.Hash, blueSet map[chainhash.Hash]struct{}) int {
+	if blueSet == nil {
+		return len(segment)
+	}
+
+	var depth int
+	for _, hash := range segment {
+		if _, ok := blueSet[*hash]; ok {
+			depth++
+		}
+	}
+
+	return depth
+}
+
+// checkReorderDepth returns a rejection when replacing the old order with the
+// new one would re-order the DAG deeper, in blue score, than allowed by the
+// MaxReorderDepth policy, and the block hasn't been approved via
+// OverrideDeepReorder.  The depth of a re-ordering is the blue score the DAG
+// loses back to the first position of the order that changes, counted against
+// the passed blue set of the DAG before the block.  Nil is returned when the
+// re-ordering is allowed.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockDAG) checkReorderDepth(block *soterutil.Block, oldOrder, newOrder []*chainhash.Hash, oldBlueSet map[chainhash.Hash]struct{}) *DeepReorderRejection {
+	if b.maxReorderDepth <= 0 {
+		return nil
+	}
+
+	pos := orderDivergence(oldOrder, newOrder)
+	depth := reorderDepth(oldOrder[pos:], oldBlueSet)
+	if depth <= b.maxReorderDepth {
+		return nil
+	}
+
+	if _, ok := b.reorderOverrides[*block.Hash()]; ok {
+		log.Warnf("REORDER: Accepting manually approved re-ordering of "+
+			"blue score depth %d by block %v (max %d)", depth,
+			block.Hash(), b.maxReorderDepth)
+		return nil
+	}
+
+	return &DeepReorderRejection{
+		Block: block,
+		Change: OrderChange{
+			Trigger:    *block.Hash(),
+			Position:   pos,
+			OldSegment: copyOrderSegment(oldOrder[pos:]),
+			NewSegment: copyOrderSegment(newOrder[pos:]),
+		},
+		Depth:    depth,
+		MaxDepth: b.maxReorderDepth,
+	}
+}
+
+// addRejectedReorder keeps track of the passed block, which was rejected for
+// re-ordering the DAG deeper than allowed, so it can be approved later.  When
+// maxRejectedReorders blocks are already kept, the one with the lowest height
+// is evicted, since it's the most likely to be buried by the DAG.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockDAG) addRejectedReorder(node *blockNode) {
+	if _, ok := b.rejectedReorders[node.hash]; !ok &&
+		len(b.rejectedReorders) >= maxRejectedReorders {
+
+		var lowest *blockNode
+		for _, n := range b.rejectedReorders {
+			if lowest == nil || n.height < lowest.height ||
+				(n.height == lowest.height &&
+					n.hash.String() < lowest.hash.String()) {
+
+				lowest = n
+			}
+		}
+		log.Debugf("REORDER: Evicting rejected block %v to make room "+
+			"for %v", lowest.hash, node.hash)
+		delete(b.rejectedReorders, lowest.hash)
+	}
+
+	b.rejectedReorders[node.hash] = node
+}
+
+// RejectedReorders returns the hashes of the blocks that are currently held
+// back because they would re-order the DAG deeper than allowed by the
+// MaxReorderDepth policy.
+//
+// This function is safe for concurrent access.
+func (b *BlockDAG) RejectedReorders() []chainhash.Hash {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	hashes := make([]chainhash.Hash, 0, len(b.rejectedReorders))
+	for hash := range b.rejectedReorders {
+		hashes = append(hashes, hash)
+	}
+
+	return hashes
+}
+
+// OverrideDeepReorder manually approves the re-ordering caused by a block that
+// was previously rejected by the MaxReorderDepth policy, and attempts to
+// connect it to the DAG again.
+//
+// This function is safe for concurrent access.
+func (b *BlockDAG) OverrideDeepReorder(hash *chainhash.Hash) error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	node, ok := b.rejectedReorders[*hash]
+	if !ok {
+		return fmt.Errorf("block %v was not rejected for re-ordering "+
+			"the DAG", hash)
+	}
+
+	var block *soterutil.Block
+	err := b.db.View(func(dbTx database.Tx) error {
+		var err error
+		block, err = dbFetchBlockByNode(dbTx, node)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Infof("REORDER: Retrying block %v with manual re-ordering "+
+		"override", hash)
+
+	b.reorderOverrides[*hash] = struct{}{}
+	_, err = b.connectBestChain(node, block, BFNone)
+	delete(b.reorderOverrides, *hash)
+	if err != nil {
+		return err
+	}
+
+	delete(b.rejectedReorders, *hash)
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockdag
+
+import (
+	"testing"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// TestCheckReorderDepth ensures that re-orderings deeper, in blue score, than
+// the configured max depth are rejected unless they have been manually
+// approved.
+func TestCheckReorderDepth(t *testing.T) {
+	block := soterutil.NewBlock(&wire.MsgBlock{})
+	oldOrder := makeOrderForTest(0, 1, 2, 3)
+	shallow := makeOrderForTest(0, 1, 3, 2, 4)
+	deep := makeOrderForTest(0, 3, 2, 1, 4)
+
+	// Block 2 is red, so it doesn't count towards the depth.
+	blueSet := map[chainhash.Hash]struct{}{
+		{0}: {},
+		{1}: {},
+		{3}: {},
+	}
+
+	b := &BlockDAG{
+		reorderOverrides: make(map[chainhash.Hash]struct{}),
+	}
+
+	// A max depth of zero disables the policy.
+	if rejection := b.checkReorderDepth(block, oldOrder, deep, blueSet); rejection != nil {
+		t.Errorf("Expecting re-ordering to be allowed without a max depth")
+	}
+
+	b.maxReorderDepth = 1
+	if rejection := b.checkReorderDepth(block, oldOrder, shallow, blueSet); rejection != nil {
+		t.Errorf("Expecting re-ordering of blue score depth 1 to be allowed")
+	}
+
+	rejection := b.checkReorderDepth(block, oldOrder, deep, blueSet)
+	if rejection == nil {
+		t.Fatalf("Expecting re-ordering of blue score depth 2 to be rejected")
+	}
+	if rejection.Change.Depth() != 3 || rejection.Change.Position != 1 {
+		t.Errorf("Unexpected rejected re-ordering %+v", rejection.Change)
+	}
+	if rejection.Depth != 2 {
+		t.Errorf("Expecting blue score depth of 2, got %d", rejection.Depth)
+	}
+	if rejection.MaxDepth != 1 {
+		t.Errorf("Expecting max depth of 1, got %d", rejection.MaxDepth)
+	}
+
+	// Every block counts when the coloring isn't known.
+	rejection = b.checkReorderDepth(block, oldOrder, shallow, nil)
+	if rejection == nil {
+		t.Fatalf("Expecting re-ordering without a coloring to be rejected")
+	}
+	if rejection.Depth != 2 {
+		t.Errorf("Expecting depth of 2, got %d", rejection.Depth)
+	}
+
+	b.reorderOverrides[*block.Hash()] = struct{}{}
+	if rejection := b.checkReorderDepth(block, oldOrder, deep, blueSet); rejection != nil {
+		t.Errorf("Expecting approved re-ordering to be allowed")
+	}
+}
+
+// TestAddRejectedReorder ensures the blocks kept for manual approval of their
+// re-orderings are capped, evicting the lowest ones first.
+func TestAddRejectedReorder(t *testing.T) {
+	b := &BlockDAG{
+		rejectedReorders: make(map[chainhash.Hash]*blockNode),
+	}
+
+	nodes := make([]*blockNode, maxRejectedReorders+1)
+	for i := range nodes {
+		nodes[i] = &blockNode{
+			hash:   chainhash.Hash{byte(i), byte(i >> 8)},
+			height: int32(i),
+		}
+	}
+	for _, node := range nodes[1:] {
+		b.addRejectedReorder(node)
+	}
+	if len(b.rejectedReorders) != maxRejectedReorders {
+		t.Fatalf("Expecting %d rejected blocks, got %d",
+			maxRejectedReorders, len(b.rejectedReorders))
+	}
+
+	// Adding a block that is already kept evicts nothing.
+	b.addRejectedReorder(nodes[1])
+	if _, ok := b.rejectedReorders[nodes[1].hash]; !ok {
+		t.Fatalf("Expecting block at height 1 to be kept")
+	}
+
+	// Adding a new block evicts the lowest one.
+	b.addRejectedReorder(nodes[0])
+	if len(b.rejectedReorders) != maxRejectedReorders {
+		t.Fatalf("Expecting %d rejected blocks, got %d",
+			maxRejectedReorders, len(b.rejectedReorders))
+	}
+	if _, ok := b.rejectedReorders[nodes[1].hash]; ok {
+		t.Errorf("Expecting block at height 1 to be evicted")
+	}
+	if _, ok := b.rejectedReorders[nodes[0].hash]; !ok {
+		t.Errorf("Expecting the new block to be kept")
+	}
+}
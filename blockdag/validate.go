@@ -8,11 +8,11 @@ package blockdag
 import (
 	"encoding/binary"
 	"fmt"
-	"github.com/Qitmeer/qitmeer-lib/crypto/cuckoo"
 	"math"
 	"math/big"
 	"time"
 
+	"github.com/soteria-dag/soterd/blockdag/pow"
 	"github.com/soteria-dag/soterd/chaincfg"
 	"github.com/soteria-dag/soterd/chaincfg/chainhash"
 	"github.com/soteria-dag/soterd/soterutil"
@@ -302,13 +302,13 @@ func CheckTransactionSanity(tx *soterutil.Tx) error {
 }
 
 // checkProofOfWork ensures the block header bits which indicate the target
-// difficulty is in min/max range and that the block hash is less than the
-// target difficulty as claimed.
+// difficulty is in min/max range and that the proof of work of the block is
+// valid for the passed backend.
 //
 // The flags modify the behavior of this function as follows:
-//  - BFNoPoWCheck: The check to ensure the block hash is less than the target
-//    difficulty is not performed.
-func checkProofOfWork(block *wire.MsgBlock, powLimit *big.Int, flags BehaviorFlags) error {
+//  - BFNoPoWCheck: The check to ensure the proof of work is valid is not
+//    performed.
+func checkProofOfWork(block *wire.MsgBlock, powLimit *big.Int, backend pow.Backend, flags BehaviorFlags) error {
 	var header = block.Header
 	// The target difficulty must be larger than zero.
 	target := CompactToBig(header.Bits)
@@ -325,12 +325,13 @@ func checkProofOfWork(block *wire.MsgBlock, powLimit *big.Int, flags BehaviorFla
 		return ruleError(ErrUnexpectedDifficulty, str)
 	}
 
-	// Cuckoo cycle verification must pass, unless the flag to avoid proof of work checks is set.
+	// Proof of work verification must pass, unless the flag to avoid proof of work checks is set.
 	if flags&BFNoPoWCheck != BFNoPoWCheck {
 		hash := header.BlockHash()
-		err := cuckoo.Verify(hash.CloneBytes(), block.Verification.CycleNonces)
+		err := backend.Verify(hash.CloneBytes(), block.Verification.CycleNonces)
 		if err != nil {
-			str := fmt.Sprintf("cuckoo cycle verification failed: %s", err)
+			str := fmt.Sprintf("%s proof of work verification failed: %s",
+				backend.Name(), err)
 			return ruleError(ErrCuckooFail, str)
 		}
 	}
@@ -339,10 +340,10 @@ func checkProofOfWork(block *wire.MsgBlock, powLimit *big.Int, flags BehaviorFla
 }
 
 // CheckProofOfWork ensures the block header bits which indicate the target
-// difficulty is in min/max range and that the block hash is less than the
-// target difficulty as claimed.
+// difficulty is in min/max range and that the proof of work of the block is
+// valid for the default cuckoo cycle backend.
 func CheckProofOfWork(block *soterutil.Block, powLimit *big.Int) error {
-	return checkProofOfWork(block.MsgBlock(), powLimit, BFNone)
+	return checkProofOfWork(block.MsgBlock(), powLimit, pow.Default(), BFNone)
 }
 
 // CountSigOps returns the number of signature operations for all transaction
@@ -429,12 +430,12 @@ func CountP2SHSigOps(tx *soterutil.Tx, isCoinBaseTx bool, utxoView *UtxoViewpoin
 //
 // The flags do not modify the behavior of this function directly, however they
 // are needed to pass along to checkProofOfWork.
-func checkBlockHeaderSanity(block *wire.MsgBlock, powLimit *big.Int, timeSource MedianTimeSource, flags BehaviorFlags) error {
+func checkBlockHeaderSanity(block *wire.MsgBlock, powLimit *big.Int, backend pow.Backend, timeSource MedianTimeSource, flags BehaviorFlags) error {
 	var header = block.Header
 	// Ensure the proof of work bits in the block header is in min/max range
 	// and the block hash is less than the target value described by the
 	// bits.
-	err := checkProofOfWork(block, powLimit, flags)
+	err := checkProofOfWork(block, powLimit, backend, flags)
 	if err != nil {
 		return err
 	}
@@ -459,6 +460,17 @@ func checkBlockHeaderSanity(block *wire.MsgBlock, powLimit *big.Int, timeSource
 		return ruleError(ErrTimeTooNew, str)
 	}
 
+	// A block must not reference the same parent more than once.
+	parents := make(map[chainhash.Hash]struct{})
+	for _, parentHash := range block.Parents.ParentHashes() {
+		if _, exists := parents[parentHash]; exists {
+			str := fmt.Sprintf("block references parent %v more "+
+				"than once", parentHash)
+			return ruleError(ErrDuplicateParent, str)
+		}
+		parents[parentHash] = struct{}{}
+	}
+
 	return nil
 }
 
@@ -467,10 +479,10 @@ func checkBlockHeaderSanity(block *wire.MsgBlock, powLimit *big.Int, timeSource
 //
 // The flags do not modify the behavior of this function directly, however they
 // are needed to pass along to checkBlockHeaderSanity.
-func checkBlockSanity(block *soterutil.Block, powLimit *big.Int, timeSource MedianTimeSource, flags BehaviorFlags) error {
+func checkBlockSanity(block *soterutil.Block, powLimit *big.Int, backend pow.Backend, timeSource MedianTimeSource, flags BehaviorFlags) error {
 	msgBlock := block.MsgBlock()
 	header := &msgBlock.Header
-	err := checkBlockHeaderSanity(block.MsgBlock(), powLimit, timeSource, flags)
+	err := checkBlockHeaderSanity(block.MsgBlock(), powLimit, backend, timeSource, flags)
 	if err != nil {
 		return err
 	}
@@ -574,8 +586,9 @@ func checkBlockSanity(block *soterutil.Block, powLimit *big.Int, timeSource Medi
 
 // CheckBlockSanity performs some preliminary checks on a block to ensure it is
 // sane before continuing with block processing.  These checks are context free.
+// The proof of work is checked with the default cuckoo cycle backend.
 func CheckBlockSanity(block *soterutil.Block, powLimit *big.Int, timeSource MedianTimeSource) error {
-	return checkBlockSanity(block, powLimit, timeSource, BFNone)
+	return checkBlockSanity(block, powLimit, pow.Default(), timeSource, BFNone)
 }
 
 // ExtractCoinbaseHeight attempts to extract the height of the block from the
@@ -1295,7 +1308,8 @@ func (b *BlockDAG) CheckConnectBlockTemplate(block *soterutil.Block) error {
 		return ruleError(ErrPrevBlockNotBest, str)
 	}
 
-	err := checkBlockSanity(block, b.chainParams.PowLimit, b.timeSource, flags)
+	err := checkBlockSanity(block, b.chainParams.PowLimit, b.powBackend,
+		b.timeSource, flags)
 	if err != nil {
 		return err
 	}
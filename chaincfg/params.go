@@ -66,6 +66,17 @@ type DNSSeed struct {
 	HasFiltering bool
 }
 
+// HTTPSeed identifies a seed service serving a signed list of peers over
+// HTTPS, for environments where DNS seeding is blocked or untrusted.
+type HTTPSeed struct {
+	// URL defines the https URL of the peer list.
+	URL string
+
+	// PubKey defines the hex-encoded public key the peer list must be
+	// signed with.
+	PubKey string
+}
+
 // ConsensusDeployment defines details related to a specific consensus rule
 // change that is voted in.  This is part of BIP0009.
 type ConsensusDeployment struct {
@@ -124,6 +135,11 @@ type Params struct {
 	// as one method to discover peers.
 	DNSSeeds []DNSSeed
 
+	// HTTPSeeds defines a list of seed services for the network serving
+	// signed peer lists over HTTPS, which are used as an alternative to
+	// the DNS seeds.
+	HTTPSeeds []HTTPSeed
+
 	// GenesisBlock defines the first block of the chain.
 	GenesisBlock *wire.MsgBlock
 
@@ -138,6 +154,12 @@ type Params struct {
 	// block in compact form.
 	PowLimitBits uint32
 
+	// PowBackend is the name of the proof of work backend blocks are solved
+	// and validated with.  The cuckoo cycle backend is used when it is
+	// empty.  Other backends must be registered with the blockdag/pow
+	// package beforehand.
+	PowBackend string
+
 	// These fields define the block heights at which the specified softfork
 	// BIP became active.
 	BIP0034Height int32
@@ -206,8 +228,20 @@ type Params struct {
 	Deployments                   [DefinedDeployments]ConsensusDeployment
 
 	// Mempool parameters
+	//
+	// RelayNonStdTxs defines whether or not non-standard transactions are
+	// accepted and relayed by default.
 	RelayNonStdTxs bool
 
+	// DustRelayFee is the fee rate in nanoSoter/kB used by default to
+	// determine whether transaction outputs are dust, so that networks with
+	// different economic parameters can use different dust limits.  The
+	// minimum relay fee of the mempool policy is used when it is zero.
+	// Dust thresholds per script class are only set by the mempool policy,
+	// since the script classes are defined by txscript, which depends on
+	// this package.
+	DustRelayFee int64
+
 	// Human-readable part for Bech32 encoded segwit addresses, as defined
 	// in BIP 173.
 	Bech32HRPSegwit string
@@ -310,6 +344,7 @@ var MainNetParams = Params{
 
 	// Mempool parameters
 	RelayNonStdTxs: false,
+	DustRelayFee:   0,
 
 	// Human-readable part for Bech32 encoded segwit addresses, as defined in
 	// BIP 173.
@@ -389,6 +424,7 @@ var RegressionNetParams = Params{
 
 	// Mempool parameters
 	RelayNonStdTxs: true,
+	DustRelayFee:   0,
 
 	// Human-readable part for Bech32 encoded segwit addresses, as defined in
 	// BIP 173.
@@ -481,6 +517,7 @@ var TestNet1Params = Params{
 
 	// Mempool parameters
 	RelayNonStdTxs: true,
+	DustRelayFee:   0,
 
 	// Human-readable part for Bech32 encoded segwit addresses, as defined in
 	// BIP 173.
@@ -564,6 +601,7 @@ var SimNetParams = Params{
 
 	// Mempool parameters
 	RelayNonStdTxs: true,
+	DustRelayFee:   0,
 
 	// Human-readable part for Bech32 encoded segwit addresses, as defined in
 	// BIP 173.
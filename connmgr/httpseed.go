@@ -0,0 +1,265 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package connmgr
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	mrand "math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soteria-dag/soterd/chaincfg"
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/soterec"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+const (
+	// peerListMagic is the first line of a signed peer list, identifying
+	// the format and its version.
+	peerListMagic = "soterd-peers 1"
+
+	// maxPeerListSize is the maximum size of a signed peer list, in bytes.
+	maxPeerListSize = 1 << 20
+
+	// maxPeerListAge is the maximum age of a signed peer list, past which
+	// it's considered stale and rejected, so an old list can't be
+	// replayed.
+	maxPeerListAge = time.Hour * 24 * 7
+
+	// maxPeerListSkew is how far in the future the timestamp of a signed
+	// peer list may be, to allow for clock skew.
+	maxPeerListSkew = time.Hour * 2
+
+	// httpSeedTimeout is the timeout of the requests for peer lists when
+	// no HTTP client is passed.
+	httpSeedTimeout = time.Second * 30
+
+	// maxPeerListRedirects is the maximum number of redirects followed
+	// when fetching a peer list, matching the default of net/http.
+	maxPeerListRedirects = 10
+)
+
+// SignPeerList returns a signed peer list of the passed addresses for the
+// passed network, in the format served by seed services and verified by
+// VerifyPeerList.  The list holds one address per line, after a header naming
+// the network and the time it was signed at, and is followed by the signature
+// of the double SHA-256 hash of everything before it.
+func SignPeerList(addrs []*net.TCPAddr, network string, timestamp time.Time,
+	privKey *soterec.PrivateKey) ([]byte, error) {
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\nnetwork %s\ntimestamp %d\n", peerListMagic,
+		network, timestamp.Unix())
+	for _, addr := range addrs {
+		fmt.Fprintf(&buf, "%s\n", addr)
+	}
+
+	sig, err := privKey.Sign(chainhash.DoubleHashB(buf.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(&buf, "signature %x\n", sig.Serialize())
+	return buf.Bytes(), nil
+}
+
+// VerifyPeerList verifies the passed signed peer list was signed with the
+// passed public key for the passed network, and isn't stale as of the passed
+// time, and returns its addresses.
+func VerifyPeerList(data []byte, pubKey *soterec.PublicKey, network string,
+	now time.Time) ([]*net.TCPAddr, error) {
+
+	// The signature is on the last line, and covers everything before it.
+	trimmed := bytes.TrimSuffix(data, []byte("\n"))
+	i := bytes.LastIndexByte(trimmed, '\n')
+	if i < 0 {
+		return nil, errors.New("peer list is not signed")
+	}
+	payload, sigLine := data[:i+1], string(trimmed[i+1:])
+	if !strings.HasPrefix(sigLine, "signature ") {
+		return nil, errors.New("peer list is not signed")
+	}
+	sigBytes, err := hex.DecodeString(strings.TrimPrefix(sigLine,
+		"signature "))
+	if err != nil {
+		return nil, fmt.Errorf("malformed peer list signature: %v", err)
+	}
+	sig, err := soterec.ParseDERSignature(sigBytes, soterec.S256())
+	if err != nil {
+		return nil, fmt.Errorf("malformed peer list signature: %v", err)
+	}
+	if !sig.Verify(chainhash.DoubleHashB(payload), pubKey) {
+		return nil, errors.New("invalid peer list signature")
+	}
+
+	// Only the signed payload is parsed from here on.
+	lines := strings.Split(strings.TrimSuffix(string(payload), "\n"), "\n")
+	if len(lines) < 3 || lines[0] != peerListMagic {
+		return nil, errors.New("unknown peer list format")
+	}
+	if lines[1] != "network "+network {
+		return nil, fmt.Errorf("peer list is for %q, not network %s",
+			lines[1], network)
+	}
+	if !strings.HasPrefix(lines[2], "timestamp ") {
+		return nil, errors.New("peer list has no timestamp")
+	}
+	unix, err := strconv.ParseInt(strings.TrimPrefix(lines[2], "timestamp "),
+		10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed peer list timestamp: %v", err)
+	}
+	timestamp := time.Unix(unix, 0)
+	if timestamp.Before(now.Add(-maxPeerListAge)) ||
+		timestamp.After(now.Add(maxPeerListSkew)) {
+
+		return nil, fmt.Errorf("peer list signed at %v is not current",
+			timestamp)
+	}
+
+	addrs := make([]*net.TCPAddr, 0, len(lines)-3)
+	for _, line := range lines[3:] {
+		host, portStr, err := net.SplitHostPort(line)
+		if err != nil {
+			return nil, fmt.Errorf("malformed peer %q: %v", line, err)
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return nil, fmt.Errorf("malformed peer %q: not an IP "+
+				"address", line)
+		}
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("malformed peer %q: %v", line, err)
+		}
+		addrs = append(addrs, &net.TCPAddr{IP: ip, Port: int(port)})
+	}
+	return addrs, nil
+}
+
+// httpsOnlyClient returns a copy of the passed client which refuses to follow
+// redirects to URLs which aren't https, so a seed service can't downgrade the
+// request to plain http.  The redirect policy of the passed client, if any, is
+// applied as well.
+func httpsOnlyClient(client *http.Client) *http.Client {
+	checkRedirect := client.CheckRedirect
+	httpsClient := *client
+	httpsClient.CheckRedirect = func(req *http.Request,
+		via []*http.Request) error {
+
+		if req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to %s is not https",
+				req.URL)
+		}
+		if checkRedirect != nil {
+			return checkRedirect(req, via)
+		}
+		if len(via) >= maxPeerListRedirects {
+			return fmt.Errorf("stopped after %d redirects",
+				maxPeerListRedirects)
+		}
+		return nil
+	}
+	return &httpsClient
+}
+
+// fetchPeerList fetches the signed peer list of the passed seed service with
+// the passed client, and returns its verified addresses.
+func fetchPeerList(client *http.Client, seed chaincfg.HTTPSeed,
+	network string) ([]*net.TCPAddr, error) {
+
+	// The list is trusted by its signature, but it's still only fetched
+	// over TLS, so the peers a node bootstraps from aren't observable.
+	u, err := url.Parse(seed.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("seed URL %s is not https", seed.URL)
+	}
+	keyBytes, err := hex.DecodeString(seed.PubKey)
+	if err != nil {
+		return nil, fmt.Errorf("malformed seed public key: %v", err)
+	}
+	pubKey, err := soterec.ParsePubKey(keyBytes, soterec.S256())
+	if err != nil {
+		return nil, fmt.Errorf("malformed seed public key: %v", err)
+	}
+
+	resp, err := client.Get(seed.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxPeerListSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxPeerListSize {
+		return nil, fmt.Errorf("peer list exceeds %d bytes",
+			maxPeerListSize)
+	}
+
+	return VerifyPeerList(data, pubKey, network, time.Now())
+}
+
+// SeedFromHTTPS uses the seed services of the network serving signed peer
+// lists over HTTPS to populate the address manager with peers, as an
+// alternative to DNS seeding.  A client with a default timeout is used when
+// the passed client is nil; passing one allows routing the requests through a
+// proxy, such as Tor.  Either way, redirects are only followed to https URLs.
+func SeedFromHTTPS(chainParams *chaincfg.Params, client *http.Client,
+	seedFn OnSeed) {
+
+	if client == nil {
+		client = &http.Client{Timeout: httpSeedTimeout}
+	}
+	client = httpsOnlyClient(client)
+
+	for _, httpSeed := range chainParams.HTTPSeeds {
+		go func(seed chaincfg.HTTPSeed) {
+			randSource := mrand.New(mrand.NewSource(time.Now().UnixNano()))
+
+			seedpeers, err := fetchPeerList(client, seed,
+				chainParams.Name)
+			if err != nil {
+				log.Infof("HTTPS discovery failed on seed %s: %v",
+					seed.URL, err)
+				return
+			}
+			numPeers := len(seedpeers)
+
+			log.Infof("%d addresses found from HTTPS seed %s", numPeers,
+				seed.URL)
+
+			if numPeers == 0 {
+				return
+			}
+			addresses := make([]*wire.NetAddress, len(seedpeers))
+			for i, peer := range seedpeers {
+				// The addresses are timestamped between 3 and
+				// 7 days ago, as the ones from the DNS seeds.
+				addresses[i] = wire.NewNetAddressTimestamp(
+					time.Now().Add(-1*time.Second*time.Duration(secondsIn3Days+
+						randSource.Int31n(secondsIn4Days))),
+					0, peer.IP, uint16(peer.Port))
+			}
+
+			seedFn(addresses)
+		}(httpSeed)
+	}
+}
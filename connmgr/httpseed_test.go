@@ -0,0 +1,143 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package connmgr
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/soteria-dag/soterd/chaincfg"
+	"github.com/soteria-dag/soterd/soterec"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// TestVerifyPeerList ensures signed peer lists are verified, and that lists
+// which were tampered with, signed with another key, for another network, or
+// stale are rejected.
+func TestVerifyPeerList(t *testing.T) {
+	privKey, err := soterec.NewPrivateKey(soterec.S256())
+	if err != nil {
+		t.Fatalf("NewPrivateKey: %v", err)
+	}
+	otherKey, err := soterec.NewPrivateKey(soterec.S256())
+	if err != nil {
+		t.Fatalf("NewPrivateKey: %v", err)
+	}
+
+	now := time.Unix(1560000000, 0)
+	addrs := []*net.TCPAddr{
+		{IP: net.ParseIP("173.194.115.66"), Port: 18555},
+		{IP: net.ParseIP("2001:470::1"), Port: 18555},
+	}
+	list, err := SignPeerList(addrs, "simnet", now, privKey)
+	if err != nil {
+		t.Fatalf("SignPeerList: %v", err)
+	}
+
+	got, err := VerifyPeerList(list, privKey.PubKey(), "simnet", now)
+	if err != nil {
+		t.Fatalf("VerifyPeerList: %v", err)
+	}
+	if len(got) != len(addrs) {
+		t.Fatalf("got %d addresses, want %d", len(got), len(addrs))
+	}
+	for i := range addrs {
+		if got[i].String() != addrs[i].String() {
+			t.Fatalf("address %d: got %v, want %v", i, got[i], addrs[i])
+		}
+	}
+
+	tampered := bytes.Replace(list, []byte("173.194.115.66"),
+		[]byte("173.194.115.67"), 1)
+	tests := []struct {
+		name    string
+		list    []byte
+		pubKey  *soterec.PublicKey
+		network string
+		now     time.Time
+	}{
+		{"tampered", tampered, privKey.PubKey(), "simnet", now},
+		{"other key", list, otherKey.PubKey(), "simnet", now},
+		{"other network", list, privKey.PubKey(), "mainnet", now},
+		{"stale", list, privKey.PubKey(), "simnet",
+			now.Add(maxPeerListAge + time.Second)},
+		{"future", list, privKey.PubKey(), "simnet",
+			now.Add(-maxPeerListSkew - time.Second)},
+		{"unsigned", list[:bytes.LastIndex(list, []byte("signature"))],
+			privKey.PubKey(), "simnet", now},
+	}
+	for _, test := range tests {
+		if _, err := VerifyPeerList(test.list, test.pubKey, test.network,
+			test.now); err == nil {
+
+			t.Errorf("%s: peer list not rejected", test.name)
+		}
+	}
+}
+
+// TestSeedFromHTTPS ensures the addresses of the signed peer lists of the seed
+// services are delivered, and that lists from services which aren't served
+// over https, or redirect to plain http, are not.
+func TestSeedFromHTTPS(t *testing.T) {
+	privKey, err := soterec.NewPrivateKey(soterec.S256())
+	if err != nil {
+		t.Fatalf("NewPrivateKey: %v", err)
+	}
+	addrs := []*net.TCPAddr{{IP: net.ParseIP("173.194.115.66"), Port: 18555}}
+	list, err := SignPeerList(addrs, "simnet", time.Now(), privKey)
+	if err != nil {
+		t.Fatalf("SignPeerList: %v", err)
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(list)
+	})
+
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, plain.URL, http.StatusFound)
+			return
+		}
+		handler(w, r)
+	})
+	server := httptest.NewTLSServer(redirect)
+	defer server.Close()
+
+	pubKey := hex.EncodeToString(privKey.PubKey().SerializeCompressed())
+	params := chaincfg.SimNetParams
+	params.HTTPSeeds = []chaincfg.HTTPSeed{
+		{URL: plain.URL, PubKey: pubKey},
+		{URL: server.URL + "/redirect", PubKey: pubKey},
+		{URL: server.URL, PubKey: pubKey},
+	}
+
+	seeded := make(chan []*wire.NetAddress, 3)
+	SeedFromHTTPS(&params, server.Client(), func(addrs []*wire.NetAddress) {
+		seeded <- addrs
+	})
+
+	select {
+	case got := <-seeded:
+		if len(got) != 1 || !got[0].IP.Equal(addrs[0].IP) ||
+			got[0].Port != uint16(addrs[0].Port) {
+
+			t.Fatalf("unexpected seeded addresses %v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the seeded addresses")
+	}
+
+	select {
+	case got := <-seeded:
+		t.Fatalf("addresses %v seeded over plain http", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -151,7 +151,8 @@ type blockStore struct {
 	//   1) obfMutex
 	//   2) lruMutex
 	//   3) writeCursor mutex
-	//   4) specific file mutexes
+	//   4) mmap cache mutex
+	//   5) specific file and mapping mutexes
 	//
 	// None of the mutexes are required to be locked at the same time, and
 	// often aren't.  However, if they are to be locked simultaneously, they
@@ -169,6 +170,10 @@ type blockStore struct {
 	// new blocks are written to.
 	writeCursor *writeCursor
 
+	// mmaps houses the memory-mapped views of block files used to serve
+	// reads.  It is nil when the memory-mapped read path is disabled.
+	mmaps *mmapCache
+
 	// These functions are set to openFile, openWriteFile, and deleteFile by
 	// default, but are exposed here to allow the whitebox tests to replace
 	// them when working with mock files.
@@ -510,19 +515,27 @@ func (s *blockStore) readBlock(hash *chainhash.Hash, loc blockLocation) ([]byte,
 	// Get the referenced block file handle opening the file as needed.  The
 	// function also handles closing files as needed to avoid going over the
 	// max allowed open files.
-	blockFile, err := s.blockFile(loc.blockFileNum)
-	if err != nil {
-		return nil, err
-	}
-
+	//
+	// The read is served from the memory-mapped view of the file instead
+	// when the memory-mapped read path is enabled and the file is mapped.
 	serializedData := make([]byte, loc.blockLen)
-	n, err := blockFile.file.ReadAt(serializedData, int64(loc.fileOffset))
-	blockFile.RUnlock()
-	if err != nil {
-		str := fmt.Sprintf("failed to read block %s from file %d, "+
-			"offset %d: %v", hash, loc.blockFileNum, loc.fileOffset,
-			err)
-		return nil, makeDbErr(database.ErrDriverSpecific, str, err)
+	n := len(serializedData)
+	if !s.readMapped(loc.blockFileNum, serializedData, loc.fileOffset) {
+		blockFile, err := s.blockFile(loc.blockFileNum)
+		if err != nil {
+			return nil, err
+		}
+
+		n, err = blockFile.file.ReadAt(serializedData,
+			int64(loc.fileOffset))
+		blockFile.RUnlock()
+		if err != nil {
+			str := fmt.Sprintf("failed to read block %s from file "+
+				"%d, offset %d: %v", hash, loc.blockFileNum,
+				loc.fileOffset, err)
+			return nil, makeDbErr(database.ErrDriverSpecific, str,
+				err)
+		}
 	}
 
 	// Calculate the checksum of the read data and ensure it matches the
@@ -566,16 +579,21 @@ func (s *blockStore) readBlockRegion(loc blockLocation, offset, numBytes uint32)
 	// Get the referenced block file handle opening the file as needed.  The
 	// function also handles closing files as needed to avoid going over the
 	// max allowed open files.
-	blockFile, err := s.blockFile(loc.blockFileNum)
-	if err != nil {
-		return nil, err
-	}
-
+	//
 	// Regions are offsets into the actual block, however the serialized
 	// data for a block includes an initial 4 bytes for network + 4 bytes
 	// for block length.  Thus, add 8 bytes to adjust.
 	readOffset := loc.fileOffset + 8 + offset
 	serializedData := make([]byte, numBytes)
+	if s.readMapped(loc.blockFileNum, serializedData, readOffset) {
+		return serializedData, nil
+	}
+
+	blockFile, err := s.blockFile(loc.blockFileNum)
+	if err != nil {
+		return nil, err
+	}
+
 	_, err = blockFile.file.ReadAt(serializedData, int64(readOffset))
 	blockFile.RUnlock()
 	if err != nil {
@@ -664,6 +682,12 @@ func (s *blockStore) handleRollback(oldBlockFileNum, oldBlockOffset uint32) {
 	log.Debugf("ROLLBACK: Rolling back to file %d, offset %d",
 		oldBlockFileNum, oldBlockOffset)
 
+	// Release the mappings of any files which are about to be deleted or
+	// truncated.
+	if s.mmaps != nil {
+		s.mmaps.invalidate(oldBlockFileNum)
+	}
+
 	// Close the current write file if it needs to be deleted.  Then delete
 	// all files that are newer than the provided rollback file while
 	// also moving the write cursor file backwards accordingly.
@@ -739,7 +763,11 @@ func scanBlockFiles(dbPath string) (int, uint32) {
 
 // newBlockStore returns a new block store with the current block file number
 // and offset set and all fields initialized.
-func newBlockStore(basePath string, network wire.SoterNet) *blockStore {
+//
+// The maxMappedFiles parameter is the maximum number of block files to keep
+// memory-mapped for serving reads.  A value of zero disables the memory-mapped
+// read path.
+func newBlockStore(basePath string, network wire.SoterNet, maxMappedFiles int) *blockStore {
 	// Look for the end of the latest block to file to determine what the
 	// write cursor position is from the viewpoing of the block files on
 	// disk.
@@ -762,6 +790,7 @@ func newBlockStore(basePath string, network wire.SoterNet) *blockStore {
 			curFileNum: uint32(fileNum),
 			curOffset:  fileOff,
 		},
+		mmaps: newMmapCache(maxMappedFiles),
 	}
 	store.openFileFunc = store.openFile
 	store.openWriteFileFunc = store.openWriteFile
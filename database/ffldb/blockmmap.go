@@ -0,0 +1,200 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// This file contains the memory-mapped read path for the flat files that house
+// the actual blocks.
+
+package ffldb
+
+import (
+	"container/list"
+	"os"
+	"sync"
+)
+
+// mappedFile represents a block file that has been memory-mapped read-only.  It
+// also contains a read-write mutex to support multiple concurrent readers while
+// preventing the mapping from being released out from under them.
+type mappedFile struct {
+	sync.RWMutex
+	data []byte
+}
+
+// mmapCache houses the memory-mapped views of block files that are no longer
+// being written to.  The number of mappings is limited by maxMappings, and the
+// least recently used mapping is released when a new file needs to be mapped.
+//
+// Only files older than the current write file are mapped, since they are not
+// appended to anymore.  Reads for the current write file, along with any reads
+// which fall outside of a mapping, are served by the regular file handles.
+//
+// NOTE: The mmap cache mutex comes after the write cursor mutex and before the
+// specific file mutexes in the locking order described by blockStore.
+type mmapCache struct {
+	mtx              sync.Mutex
+	maxMappings      int
+	mappedLRU        *list.List // Contains uint32 block file numbers.
+	fileNumToLRUElem map[uint32]*list.Element
+	mappedFiles      map[uint32]*mappedFile
+}
+
+// newMmapCache returns a new cache which keeps up to maxMappings block files
+// memory-mapped.  Nil is returned when maxMappings is not positive or memory
+// mapping isn't supported on the current platform, which disables the
+// memory-mapped read path.
+func newMmapCache(maxMappings int) *mmapCache {
+	if maxMappings <= 0 || !mmapSupported {
+		return nil
+	}
+
+	return &mmapCache{
+		maxMappings:      maxMappings,
+		mappedLRU:        list.New(),
+		fileNumToLRUElem: make(map[uint32]*list.Element),
+		mappedFiles:      make(map[uint32]*mappedFile),
+	}
+}
+
+// mapFile memory-maps the block file at the provided path.  The file handle is
+// only needed while creating the mapping, so it is closed before returning.
+func mapFile(filePath string) (*mappedFile, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	st, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Empty files can't be mapped, and there is nothing to read from them
+	// anyways.
+	if st.Size() == 0 {
+		return &mappedFile{}, nil
+	}
+
+	data, err := mmapFile(file, int(st.Size()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &mappedFile{data: data}, nil
+}
+
+// release unmaps the file under the write lock for the mapping in case any
+// readers are currently reading from it.
+func (mf *mappedFile) release() {
+	mf.Lock()
+	if mf.data != nil {
+		_ = munmapFile(mf.data)
+		mf.data = nil
+	}
+	mf.Unlock()
+}
+
+// mappedFile returns the mapping for the passed block file number, mapping the
+// file as needed while releasing the least recently used mapping when the max
+// number of mappings would be exceeded.  Nil is returned if the file can't be
+// mapped, in which case the caller should fall back to reading from the file.
+//
+// NOTE: The returned mapping will already have the read lock acquired and the
+// caller MUST call .RUnlock() to release it once it has finished all read
+// operations.
+func (c *mmapCache) mappedFile(s *blockStore, fileNum uint32) *mappedFile {
+	// The write cursor read lock is held for the duration so the file can't
+	// become the current write file while it is being mapped.
+	wc := s.writeCursor
+	wc.RLock()
+	defer wc.RUnlock()
+	if fileNum >= wc.curFileNum {
+		return nil
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if mf, ok := c.mappedFiles[fileNum]; ok {
+		c.mappedLRU.MoveToFront(c.fileNumToLRUElem[fileNum])
+		mf.RLock()
+		return mf
+	}
+
+	filePath := blockFilePath(s.basePath, fileNum)
+	mf, err := mapFile(filePath)
+	if err != nil {
+		log.Debugf("Unable to memory-map block file %d: %v", fileNum,
+			err)
+		return nil
+	}
+
+	if c.mappedLRU.Len() >= c.maxMappings {
+		lruFileNum := c.mappedLRU.Remove(c.mappedLRU.Back()).(uint32)
+		c.mappedFiles[lruFileNum].release()
+		delete(c.mappedFiles, lruFileNum)
+		delete(c.fileNumToLRUElem, lruFileNum)
+	}
+	c.fileNumToLRUElem[fileNum] = c.mappedLRU.PushFront(fileNum)
+	c.mappedFiles[fileNum] = mf
+
+	mf.RLock()
+	return mf
+}
+
+// readAt copies the mapped data at the provided offset into buf.  It returns
+// false when the requested region isn't fully covered by the mapping.
+//
+// This function MUST be called with the mapping read lock held.
+func (mf *mappedFile) readAt(buf []byte, offset uint32) bool {
+	end := uint64(offset) + uint64(len(buf))
+	if end > uint64(len(mf.data)) {
+		return false
+	}
+
+	copy(buf, mf.data[offset:end])
+	return true
+}
+
+// readMapped copies the data at the provided offset of the passed block file
+// number into buf using the memory-mapped view of the file.  It returns false
+// when the read could not be served from a mapping, in which case the caller
+// should fall back to reading from the file handle.
+func (s *blockStore) readMapped(fileNum uint32, buf []byte, offset uint32) bool {
+	if s.mmaps == nil {
+		return false
+	}
+
+	mf := s.mmaps.mappedFile(s, fileNum)
+	if mf == nil {
+		return false
+	}
+	ok := mf.readAt(buf, offset)
+	mf.RUnlock()
+	return ok
+}
+
+// invalidate releases the mappings for all block files with a file number
+// greater than or equal to the passed one.  It is used when files are about to
+// be truncated or deleted.
+func (c *mmapCache) invalidate(fromFileNum uint32) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for fileNum, mf := range c.mappedFiles {
+		if fileNum < fromFileNum {
+			continue
+		}
+
+		mf.release()
+		c.mappedLRU.Remove(c.fileNumToLRUElem[fileNum])
+		delete(c.mappedFiles, fileNum)
+		delete(c.fileNumToLRUElem, fileNum)
+	}
+}
+
+// close releases all mappings held by the cache.
+func (c *mmapCache) close() {
+	c.invalidate(0)
+}
@@ -1939,6 +1939,11 @@ func (db *db) Close() error {
 	db.store.openBlocksLRU.Init()
 	db.store.fileNumToLRUElem = nil
 
+	// Release any memory-mapped views of the flat files.
+	if db.store.mmaps != nil {
+		db.store.mmaps.close()
+	}
+
 	return closeErr
 }
 
@@ -2017,7 +2022,7 @@ func openDB(dbPath string, network wire.SoterNet, create bool) (database.DB, err
 	// according to the data that is actually on disk.  Also create the
 	// database cache which wraps the underlying leveldb database to provide
 	// write caching.
-	store := newBlockStore(dbPath, network)
+	store := newBlockStore(dbPath, network, maxMappedFiles)
 	cache := newDbCache(ldb, store, defaultCacheSize, defaultFlushSecs)
 	pdb := &db{store: store, cache: cache}
 
@@ -15,6 +15,11 @@ import (
 
 var log = soterlog.Disabled
 
+// maxMappedFiles is the maximum number of flat block files databases opened by
+// the driver keep memory-mapped for serving block reads.  See
+// SetMaxMappedFiles.
+var maxMappedFiles int
+
 const (
 	dbType = "ffldb"
 )
@@ -64,6 +69,22 @@ func createDBDriver(args ...interface{}) (database.DB, error) {
 	return openDB(dbPath, network, true)
 }
 
+// SetMaxMappedFiles enables serving block reads from memory-mapped views of the
+// flat block files for databases that are opened or created after the call,
+// instead of issuing a read for every request.  Up to n files are kept mapped,
+// with the least recently used mapping being released as needed.  This mostly
+// benefits workloads that read many historical blocks, such as serving blocks
+// to syncing peers and indexers doing full scans.
+//
+// A value of zero, which is the default, disables the memory-mapped read path.
+// It is also disabled on platforms that don't support memory-mapped files.
+func SetMaxMappedFiles(n int) {
+	if n < 0 {
+		n = 0
+	}
+	maxMappedFiles = n
+}
+
 // useLogger is the callback provided during driver registration that sets the
 // current logger to the provided one.
 func useLogger(logger soterlog.Logger) {
@@ -287,3 +287,32 @@ func TestInterface(t *testing.T) {
 		testInterface(t, db)
 	})
 }
+
+// TestInterfaceMmap performs all interfaces tests for this database driver with
+// the memory-mapped read path enabled.
+func TestInterfaceMmap(t *testing.T) {
+	t.Parallel()
+
+	// Create a new database to run tests against.
+	dbPath := filepath.Join(os.TempDir(), "ffldb-interfacetest-mmap")
+	_ = os.RemoveAll(dbPath)
+	db, err := database.Create(dbType, dbPath, blockDataNet)
+	if err != nil {
+		t.Errorf("Failed to create test database (%s) %v", dbType, err)
+		return
+	}
+	defer os.RemoveAll(dbPath)
+	defer db.Close()
+
+	// Run all of the interface tests against the database.
+	runtime.GOMAXPROCS(runtime.NumCPU())
+
+	// Change the maximum file size to a small value to force multiple flat
+	// files with the test data set, and keep fewer files mapped than there
+	// are files so mappings are released and recreated.
+	ffldb.TstRunWithMaxBlockFileSize(db, 2048, func() {
+		ffldb.TstRunWithMaxMappedFiles(db, 2, func() {
+			testInterface(t, db)
+		})
+	})
+}
@@ -25,3 +25,19 @@ func TstRunWithMaxBlockFileSize(idb database.DB, size uint32, fn func()) {
 	fn()
 	ffldb.store.maxBlockFileSize = origSize
 }
+
+// TstRunWithMaxMappedFiles runs the passed function with the memory-mapped read
+// path for the database enabled and limited to the provided number of mapped
+// files.  The read path will be set back to the original state upon
+// completion.
+func TstRunWithMaxMappedFiles(idb database.DB, n int, fn func()) {
+	ffldb := idb.(*db)
+	origMmaps := ffldb.store.mmaps
+
+	ffldb.store.mmaps = newMmapCache(n)
+	fn()
+	if ffldb.store.mmaps != nil {
+		ffldb.store.mmaps.close()
+	}
+	ffldb.store.mmaps = origMmaps
+}
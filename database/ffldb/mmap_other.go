@@ -0,0 +1,32 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package ffldb
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapSupported indicates whether block files can be memory-mapped on the
+// current platform.
+const mmapSupported = false
+
+// errMmapUnsupported is returned when attempting to memory-map a file on a
+// platform where the memory-mapped read path is not supported.
+var errMmapUnsupported = errors.New("memory-mapped block files are not " +
+	"supported on this platform")
+
+// mmapFile is not supported on this platform.
+func mmapFile(file *os.File, size int) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+// munmapFile is not supported on this platform.
+func munmapFile(data []byte) error {
+	return errMmapUnsupported
+}
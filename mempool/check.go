@@ -0,0 +1,175 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"fmt"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// TxCheckResult is the verdict of checking whether a transaction would be
+// accepted into the pool via CheckTransaction or CheckPackage.
+type TxCheckResult struct {
+	// Tx is the transaction that was checked.
+	Tx *soterutil.Tx
+
+	// Accepted is whether the transaction would be accepted into the pool.
+	Accepted bool
+
+	// Fee is the fee the transaction pays, and FeePerKB the resulting fee
+	// rate in nanoSoter per 1000 bytes.  They are only set once the inputs
+	// of the transaction are known.
+	Fee      int64
+	FeePerKB int64
+
+	// VirtualSize is the virtual size of the transaction.
+	VirtualSize int64
+
+	// MissingParents are the hashes of the unknown transactions referenced
+	// by the inputs when the transaction is an orphan.
+	MissingParents []*chainhash.Hash
+
+	// Replaces are the hashes of the transactions in the pool, including
+	// descendants, which would be replaced by the transaction.
+	Replaces []*chainhash.Hash
+
+	// RejectCode and Reason describe why the transaction was rejected in
+	// the form suitable for a wire.MsgReject message.  Err is the
+	// underlying error.  They are only set when the transaction isn't
+	// accepted.
+	RejectCode wire.RejectCode
+	Reason     string
+	Err        error
+}
+
+// reject marks the result as rejected due to the passed error.
+func (r *TxCheckResult) reject(err error) {
+	r.Accepted = false
+	r.Err = err
+	r.RejectCode, r.Reason = ErrToRejectErr(err)
+}
+
+// checkTransaction is the internal function which implements the public
+// CheckTransaction and CheckPackage.  The outputs of the pending transactions
+// are treated as if the transactions were in the pool.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) checkTransaction(tx *soterutil.Tx,
+	pending map[chainhash.Hash]*soterutil.Tx) *TxCheckResult {
+
+	result := &TxCheckResult{
+		Tx:          tx,
+		VirtualSize: GetTxVirtualSize(tx),
+	}
+
+	v, err := mp.validateTransaction(tx, true, false, true, pending, false)
+	if err != nil {
+		result.reject(err)
+		return result
+	}
+
+	if len(v.missingParents) > 0 {
+		// NOTE: RejectDuplicate matches the code used by
+		// ProcessTransaction for orphans which aren't allowed.
+		result.MissingParents = v.missingParents
+		str := fmt.Sprintf("orphan transaction %v references "+
+			"outputs of unknown or fully-spent transaction %v",
+			tx.Hash(), v.missingParents[0])
+		result.reject(txRuleError(wire.RejectDuplicate, str))
+		return result
+	}
+
+	result.Accepted = true
+	result.Fee = v.fee
+	result.FeePerKB = v.fee * 1000 / result.VirtualSize
+	for hash := range v.conflicts {
+		hashCopy := hash
+		result.Replaces = append(result.Replaces, &hashCopy)
+	}
+
+	return result
+}
+
+// CheckTransaction runs all of the policy and consensus checks used to accept
+// the passed transaction into the pool, and returns whether it would be
+// accepted along with its fee or the reason it would be rejected.  The pool is
+// NOT modified, which allows wallets to validate transactions before
+// broadcasting them.
+//
+// The checks are performed as if the transaction was submitted locally, so the
+// free transaction rate limiter is not applied.  A transaction which would be
+// accepted can still be evicted right away when the pool is full and it pays
+// the lowest fee rate.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) CheckTransaction(tx *soterutil.Tx) *TxCheckResult {
+	mp.mtx.Lock()
+	result := mp.checkTransaction(tx, nil)
+	mp.mtx.Unlock()
+
+	return result
+}
+
+// CheckPackage is the same as CheckTransaction except that it checks a package
+// of transactions which may depend on each other.  The transactions must be
+// ordered such that parents come before the transactions spending them, and
+// each transaction is checked as if the accepted transactions preceding it
+// were in the pool.  A result is returned for each transaction in the same
+// order, and transactions spending a rejected transaction are rejected as
+// orphans.  The pool is NOT modified.
+//
+// The ancestor and descendant limits are evaluated against the transactions
+// already in the pool only.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) CheckPackage(txns []*soterutil.Tx) []*TxCheckResult {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	results := make([]*TxCheckResult, 0, len(txns))
+	pending := make(map[chainhash.Hash]*soterutil.Tx, len(txns))
+	spent := make(map[wire.OutPoint]*soterutil.Tx)
+	for _, tx := range txns {
+		// Transactions in the package may not spend the same outputs as
+		// each other, as at most one of them could be accepted.
+		var doubleSpend error
+		for _, txIn := range tx.MsgTx().TxIn {
+			spender, ok := spent[txIn.PreviousOutPoint]
+			if !ok {
+				continue
+			}
+			str := fmt.Sprintf("output %v already spent by "+
+				"transaction %v in the package",
+				txIn.PreviousOutPoint, spender.Hash())
+			doubleSpend = txRuleError(wire.RejectDuplicate, str)
+			break
+		}
+		if doubleSpend != nil {
+			result := &TxCheckResult{
+				Tx:          tx,
+				VirtualSize: GetTxVirtualSize(tx),
+			}
+			result.reject(doubleSpend)
+			results = append(results, result)
+			continue
+		}
+
+		result := mp.checkTransaction(tx, pending)
+		results = append(results, result)
+		if !result.Accepted {
+			continue
+		}
+
+		pending[*tx.Hash()] = tx
+		for _, txIn := range tx.MsgTx().TxIn {
+			spent[txIn.PreviousOutPoint] = tx
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,341 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"fmt"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/soterutil"
+)
+
+// DefaultConflictResolutionDepth is the default number of blocks which must be
+// ordered after a block confirming transactions in the DAG ordering before the
+// conflicts it causes are considered resolved.
+const DefaultConflictResolutionDepth = 6
+
+// TxState describes the state of a transaction with respect to the blocks of
+// the DAG.
+type TxState int
+
+const (
+	// TxStateUnknown indicates the transaction is neither in the pool nor
+	// recently confirmed.
+	TxStateUnknown TxState = iota
+
+	// TxStatePending indicates the transaction is in the pool and doesn't
+	// conflict with any transaction confirmed by a block.
+	TxStatePending
+
+	// TxStateConflicted indicates the transaction is in the pool, but it or
+	// one of its unconfirmed ancestors double spends a transaction
+	// confirmed by a block on a tip of the DAG.  It can still be confirmed
+	// should that block lose the ordering of the DAG.
+	TxStateConflicted
+
+	// TxStateConfirmed indicates the transaction was confirmed by a block
+	// whose conflicts aren't resolved yet.
+	TxStateConfirmed
+)
+
+// Map of TxState values back to their constant names for pretty printing.
+var txStateStrings = map[TxState]string{
+	TxStateUnknown:    "TxStateUnknown",
+	TxStatePending:    "TxStatePending",
+	TxStateConflicted: "TxStateConflicted",
+	TxStateConfirmed:  "TxStateConfirmed",
+}
+
+// String returns the TxState as a human-readable name.
+func (s TxState) String() string {
+	if str := txStateStrings[s]; str != "" {
+		return str
+	}
+	return fmt.Sprintf("Unknown TxState (%d)", int(s))
+}
+
+// TxStateInfo describes the state of a transaction as returned by TxState.
+type TxStateInfo struct {
+	// State is the state of the transaction.
+	State TxState
+
+	// Blocks are the hashes of the blocks which confirmed the transaction
+	// when it is confirmed, or the blocks confirming the conflicting
+	// transactions when it is conflicted.
+	Blocks []chainhash.Hash
+
+	// ConflictsWith are the hashes of the confirmed transactions that the
+	// transaction, or one of its unconfirmed ancestors, double spends when
+	// it is conflicted.
+	ConflictsWith []chainhash.Hash
+}
+
+// blockConfirmations tracks the blocks confirming a transaction.
+type blockConfirmations map[chainhash.Hash]struct{}
+
+// txConflict tracks the confirmed transactions and blocks a transaction in the
+// pool conflicts with.
+type txConflict struct {
+	txns   map[chainhash.Hash]struct{}
+	blocks blockConfirmations
+}
+
+// conflictResolutionDepth returns the depth at which conflicts are resolved,
+// using the default when the policy doesn't set it.
+func (p *Policy) conflictResolutionDepth() int32 {
+	if p.ConflictResolutionDepth > 0 {
+		return p.ConflictResolutionDepth
+	}
+	return DefaultConflictResolutionDepth
+}
+
+// ConfirmTransaction removes the passed transaction from the pool since it was
+// confirmed by the passed block.  Transactions in the pool which double spend
+// it aren't removed, since the block might lose the ordering of the DAG to a
+// block on another tip.  They are marked as conflicted instead, and are only
+// removed once the block is ordered deep enough in the DAG for the conflict to
+// be resolved by a call to Maintain.  Conflicted transactions aren't handed out
+// for mining or re-announced meanwhile.  Transactions that redeem outputs from
+// the confirmed transaction are NOT removed since they are still valid.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) ConfirmTransaction(tx *soterutil.Tx, block *chainhash.Hash) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	mp.removeTransaction(tx, false, RemovalBlock)
+
+	txHash := *tx.Hash()
+	confirmed, ok := mp.confirmedTxns[txHash]
+	if !ok {
+		confirmed = make(blockConfirmations)
+		mp.confirmedTxns[txHash] = confirmed
+	}
+	confirmed[*block] = struct{}{}
+
+	for _, txIn := range tx.MsgTx().TxIn {
+		txRedeemer, ok := mp.outpoints[txIn.PreviousOutPoint]
+		if !ok || txRedeemer.Hash().IsEqual(&txHash) {
+			continue
+		}
+
+		redeemerHash := *txRedeemer.Hash()
+		conflict, ok := mp.conflicted[redeemerHash]
+		if !ok {
+			conflict = &txConflict{
+				txns:   make(map[chainhash.Hash]struct{}),
+				blocks: make(blockConfirmations),
+			}
+			mp.conflicted[redeemerHash] = conflict
+		}
+		conflict.txns[txHash] = struct{}{}
+		conflict.blocks[*block] = struct{}{}
+		mp.bumpGeneration()
+
+		log.Debugf("Transaction %v conflicts with transaction %v "+
+			"confirmed by block %v", redeemerHash, txHash, block)
+	}
+}
+
+// DisconnectBlock forgets about the transactions confirmed by the passed block
+// since it was disconnected from the DAG.  Transactions in the pool which were
+// only conflicted because of the block become pending again.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) DisconnectBlock(block *chainhash.Hash) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	for txHash, confirmed := range mp.confirmedTxns {
+		delete(confirmed, *block)
+		if len(confirmed) == 0 {
+			delete(mp.confirmedTxns, txHash)
+		}
+	}
+
+	for txHash, conflict := range mp.conflicted {
+		if _, ok := conflict.blocks[*block]; !ok {
+			continue
+		}
+		delete(conflict.blocks, *block)
+
+		// Only keep the conflicting transactions that are still
+		// confirmed by another block.
+		for conflictHash := range conflict.txns {
+			if _, ok := mp.confirmedTxns[conflictHash]; !ok {
+				delete(conflict.txns, conflictHash)
+			}
+		}
+		if len(conflict.blocks) == 0 || len(conflict.txns) == 0 {
+			delete(mp.conflicted, txHash)
+			mp.bumpGeneration()
+		}
+	}
+}
+
+// resolveConflicts removes the transactions in the pool which conflict with
+// transactions confirmed by blocks ordered deep enough in the DAG for the
+// conflicts to be resolved, along with their descendants.  The records of the
+// transactions confirmed by such blocks are dropped as well.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) resolveConflicts() []*TxDesc {
+	if mp.cfg.DAGOrdering == nil {
+		return nil
+	}
+
+	// A block is ordered deep enough once it's no longer among the last
+	// blocks of the ordering.  The blocks confirming transactions are
+	// connected to the DAG, so they are part of the ordering until they
+	// are disconnected.
+	depth := int(mp.cfg.Policy.conflictResolutionDepth())
+	order := mp.cfg.DAGOrdering()
+	if len(order) <= depth {
+		return nil
+	}
+	unresolved := make(map[chainhash.Hash]struct{}, depth)
+	for _, hash := range order[len(order)-depth:] {
+		unresolved[*hash] = struct{}{}
+	}
+
+	var removed []*TxDesc
+	for txHash, conflict := range mp.conflicted {
+		for block := range conflict.blocks {
+			if _, ok := unresolved[block]; ok {
+				continue
+			}
+
+			delete(mp.conflicted, txHash)
+			if txD, ok := mp.pool[txHash]; ok {
+				removed = append(removed,
+					mp.removeTransactionWithReason(txD,
+						RemovalConflict)...)
+			}
+			break
+		}
+	}
+
+	for txHash, confirmed := range mp.confirmedTxns {
+		for block := range confirmed {
+			if _, ok := unresolved[block]; !ok {
+				delete(confirmed, block)
+			}
+		}
+		if len(confirmed) == 0 {
+			delete(mp.confirmedTxns, txHash)
+		}
+	}
+
+	if len(removed) > 0 {
+		log.Debugf("Removed %d conflicted %s (remaining: %d)",
+			len(removed), pickNoun(len(removed), "transaction",
+				"transactions"), len(mp.pool))
+	}
+
+	return removed
+}
+
+// isConflicted returns whether the passed transaction in the pool, or one of its
+// unconfirmed ancestors, is conflicted.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) isConflicted(tx *soterutil.Tx) bool {
+	if _, ok := mp.conflicted[*tx.Hash()]; ok {
+		return true
+	}
+	for hash := range mp.txAncestors(tx, nil) {
+		if _, ok := mp.conflicted[hash]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// conflictedTxns returns the hashes of the conflicted transactions in the
+// pool, which are the transactions double spending a confirmed transaction
+// along with their descendants.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) conflictedTxns() map[chainhash.Hash]struct{} {
+	txns := make(map[chainhash.Hash]struct{})
+	cache := make(map[chainhash.Hash]map[chainhash.Hash]*soterutil.Tx)
+	for txHash := range mp.conflicted {
+		txD, ok := mp.pool[txHash]
+		if !ok {
+			continue
+		}
+		txns[txHash] = struct{}{}
+		for hash := range mp.txDescendants(txD.Tx, cache) {
+			txns[hash] = struct{}{}
+		}
+	}
+	return txns
+}
+
+// IsConflicted returns whether the passed transaction in the pool, or one of
+// its unconfirmed ancestors, is conflicted.  Conflicted transactions shouldn't
+// be relayed, since they may never be confirmed.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) IsConflicted(hash *chainhash.Hash) bool {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	txD, ok := mp.pool[*hash]
+	return ok && mp.isConflicted(txD.Tx)
+}
+
+// TxState returns the state of the passed transaction with respect to the
+// blocks of the DAG, so that wallets can tell whether a transaction that is
+// still in the pool conflicts with a transaction confirmed on a tip of the DAG.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) TxState(hash *chainhash.Hash) *TxStateInfo {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	if confirmed, ok := mp.confirmedTxns[*hash]; ok {
+		info := &TxStateInfo{State: TxStateConfirmed}
+		for block := range confirmed {
+			info.Blocks = append(info.Blocks, block)
+		}
+		return info
+	}
+
+	txD, ok := mp.pool[*hash]
+	if !ok {
+		return &TxStateInfo{State: TxStateUnknown}
+	}
+	if !mp.isConflicted(txD.Tx) {
+		return &TxStateInfo{State: TxStatePending}
+	}
+
+	// Gather the conflicts of the transaction and its ancestors.
+	blocks := make(map[chainhash.Hash]struct{})
+	txns := make(map[chainhash.Hash]struct{})
+	related := mp.txAncestors(txD.Tx, nil)
+	related[*hash] = txD.Tx
+	for relatedHash := range related {
+		conflict, ok := mp.conflicted[relatedHash]
+		if !ok {
+			continue
+		}
+		for block := range conflict.blocks {
+			blocks[block] = struct{}{}
+		}
+		for conflictHash := range conflict.txns {
+			txns[conflictHash] = struct{}{}
+		}
+	}
+
+	info := &TxStateInfo{State: TxStateConflicted}
+	for block := range blocks {
+		info.Blocks = append(info.Blocks, block)
+	}
+	for conflictHash := range txns {
+		info.ConflictsWith = append(info.ConflictsWith, conflictHash)
+	}
+	return info
+}
@@ -44,6 +44,8 @@ be an exhaustive list.
    - Reject invalid transactions according to the network consensus rules
    - Full script execution and validation with signature cache support
    - Individual transaction query support
+   - Dry-run acceptance checks of individual transactions and packages of
+     dependent transactions which leave the pool untouched
  - Orphan transaction support (transactions that spend from unknown outputs)
    - Configurable limits (see transaction acceptance policy)
    - Automatic addition of orphan transactions that are no longer orphans as new
@@ -51,19 +53,64 @@ be an exhaustive list.
    - Individual orphan transaction query support
  - Configurable transaction acceptance policy
    - Option to accept or reject standard transactions
-   - Option to accept or reject transactions based on priority calculations
-   - Rate limiting of low-fee and free transactions
-   - Non-zero fee threshold
+   - Fee rate based admission with a minimum relay fee, along with a hook
+     for exempting transactions from it
+   - Deprecated option to accept free transactions based on priority
+     calculations, with rate limiting of low-fee and free transactions
    - Max signature operations per transaction
    - Max orphan transaction size
    - Max number of orphan transactions allowed
+   - Max total size of orphan transactions, overall and per tag (typically
+     the relaying peer)
+   - Opt-in Replace-By-Fee (RBF) support, with an option for full replacement
+   - Per-peer rate limiting of low-fee transactions, with counters exposed
+     for each peer
+   - Limits on the number and size of unconfirmed ancestors and descendants
+   - Optional pool size limit in terms of the estimated memory usage of the
+     transactions, evicting the lowest fee rate transactions and raising a
+     decaying minimum fee when exceeded
+   - Optional expiry of transactions which stay in the pool for too long
+   - Periodic re-checks of the transactions against the current DAG state
+   - Configurable standardness limits, including the max standard transaction
+     weight, pay-to-script-hash signature operations, dust relay fee, data
+     carrier size, and script verification flags
+   - Dust thresholds for each output script class, with the dust relay fee
+     defaulting to the one of the network
  - Additional metadata tracking for each transaction
    - Timestamp when the transaction was added to the pool
    - Most recent block height when the transaction was added to the pool
    - The fee the transaction pays
    - The starting priority for the transaction
+ - Immutable snapshots of the pool which can be iterated without holding the
+   mempool lock
  - Manual control of transaction removal
    - Recursive removal of all dependent transactions
+ - Virtual fee deltas for prioritising transactions, which affect the fee
+   policy, eviction, and mining selection without changing their actual fees
+ - Dumping the pool along with the fee deltas for restoring it across restarts
+ - Rebroadcasting of locally submitted transactions with exponential backoff
+   until they leave the pool
+ - Cache of the outputs of the DAG fetched for validating transactions, which
+   is invalidated as blocks are connected and disconnected
+ - Bounded cache of recently rejected transactions along with the reason for
+   each rejection, avoiding validating them again until the DAG changes
+ - Atomic acceptance of packages of dependent transactions, allowing the fee
+   of a child to pay for a parent below the minimum fee
+ - Mining descriptors ordered by package fee rate, allowing transactions to be
+   mined along with the descendants paying for them (child-pays-for-parent)
+ - Fee estimation based on the time transactions take to be mined, including
+   by parallel blocks of the DAG
+   - Smart fee estimates with configurable confidence levels
+   - Persistence of the estimator state in the database across restarts
+ - Structured debug logging of the decisions about processed transactions,
+   along with an optional trace of the most recent decisions for analyzing
+   why transactions weren't relayed
+ - Metrics interface for reporting the accepted, rejected, orphaned and
+   removed transactions along with the size of the pool to a monitoring system
+ - Channel-based subscriptions to the transactions added to and removed from
+   the pool, including the reason for each removal
+ - Tracking of the transactions conflicting with transactions confirmed on
+   parallel tips of the DAG until the conflicts are resolved
 
 Errors
 
@@ -0,0 +1,155 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// mempoolDumpVersion is the version of the serialization format produced by
+// Dump.
+const mempoolDumpVersion = 1
+
+// Dump serializes the transactions in the main pool, ordered such that parents
+// come before the transactions spending them, along with the virtual fee
+// deltas applied via PrioritiseTransaction to the passed writer.  It is
+// intended to be called on shutdown, so that the pool can be restored with
+// LoadDump on the next start.  The orphan pool is not included.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Dump(w io.Writer) error {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	err := binary.Write(w, binary.BigEndian, uint32(mempoolDumpVersion))
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(w, binary.BigEndian, uint32(len(mp.feeDeltas)))
+	if err != nil {
+		return err
+	}
+	for hash, feeDelta := range mp.feeDeltas {
+		if err := binary.Write(w, binary.BigEndian, hash); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, feeDelta); err != nil {
+			return err
+		}
+	}
+
+	// A transaction always has fewer unconfirmed ancestors than the
+	// transactions spending it, so ordering by the number of ancestors
+	// ensures parents come first.
+	descs := make([]*TxDesc, 0, len(mp.pool))
+	for _, txD := range mp.pool {
+		descs = append(descs, txD)
+	}
+	sort.Slice(descs, func(i, j int) bool {
+		return descs[i].ancestors.Count < descs[j].ancestors.Count
+	})
+
+	err = binary.Write(w, binary.BigEndian, uint32(len(descs)))
+	if err != nil {
+		return err
+	}
+	for _, txD := range descs {
+		err := binary.Write(w, binary.BigEndian, txD.Added.Unix())
+		if err != nil {
+			return err
+		}
+		if err := txD.Tx.MsgTx().Serialize(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoadDump restores the transactions and fee deltas serialized by Dump from
+// the passed reader.  The fee deltas are added to the ones already applied.
+// Each transaction is validated again as if it was submitted locally, and the
+// ones that are no longer valid, such as those mined in the meantime, are
+// skipped.  The time the accepted transactions were originally added to the
+// pool is retained so that they expire as expected.  The number of accepted
+// transactions is returned.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) LoadDump(r io.Reader) (int, error) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	var version uint32
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return 0, err
+	}
+	if version != mempoolDumpVersion {
+		return 0, fmt.Errorf("incorrect mempool dump version: expected "+
+			"%d found %d", mempoolDumpVersion, version)
+	}
+
+	var numDeltas uint32
+	if err := binary.Read(r, binary.BigEndian, &numDeltas); err != nil {
+		return 0, err
+	}
+	for i := uint32(0); i < numDeltas; i++ {
+		var hash chainhash.Hash
+		var feeDelta int64
+		if err := binary.Read(r, binary.BigEndian, &hash); err != nil {
+			return 0, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &feeDelta); err != nil {
+			return 0, err
+		}
+		mp.prioritiseTransaction(&hash, feeDelta)
+	}
+
+	var numTxns uint32
+	if err := binary.Read(r, binary.BigEndian, &numTxns); err != nil {
+		return 0, err
+	}
+	accepted := 0
+	for i := uint32(0); i < numTxns; i++ {
+		var added int64
+		if err := binary.Read(r, binary.BigEndian, &added); err != nil {
+			return accepted, err
+		}
+		var msgTx wire.MsgTx
+		if err := msgTx.Deserialize(r); err != nil {
+			return accepted, err
+		}
+
+		tx := soterutil.NewTx(&msgTx)
+		missing, txD, err := mp.maybeAcceptTransaction(tx, true, false,
+			true, nil)
+		if err != nil {
+			log.Debugf("Skipping transaction %v from the mempool "+
+				"dump: %v", tx.Hash(), err)
+			continue
+		}
+		if len(missing) > 0 {
+			log.Debugf("Skipping orphan transaction %v from the "+
+				"mempool dump", tx.Hash())
+			continue
+		}
+		txD.Added = time.Unix(added, 0)
+		mp.bumpGeneration()
+		accepted++
+	}
+
+	log.Infof("Loaded %d of %d %s from the mempool dump", accepted,
+		numTxns, pickNoun(int(numTxns), "transaction", "transactions"))
+
+	return accepted, nil
+}
@@ -18,6 +18,7 @@ import (
 	"sync"
 
 	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/database"
 	"github.com/soteria-dag/soterd/miningdag"
 	"github.com/soteria-dag/soterd/soterutil"
 )
@@ -47,6 +48,17 @@ const (
 	DefaultEstimateFeeMinRegisteredBlocks = 3
 
 	bytePerKb = 1000
+
+	// estimateSmartFeeMinSamples is the minimum number of observed
+	// transactions a smart fee estimate must be based on.
+	estimateSmartFeeMinSamples = 3
+
+	// EstimateConfidenceLow, EstimateConfidenceMedium and
+	// EstimateConfidenceHigh are typical confidence levels for
+	// EstimateSmartFee.
+	EstimateConfidenceLow    = 0.60
+	EstimateConfidenceMedium = 0.85
+	EstimateConfidenceHigh   = 0.95
 )
 
 var (
@@ -133,14 +145,37 @@ func deserializeObservedTransaction(r io.Reader) (*observedTransaction, error) {
 // it mined which had been previously observed by the FeeEstimator. It
 // is used if Rollback is called to reverse the effect of registering
 // a block.
+//
+// The hashes of the parallel blocks registered at the same height on other
+// tips of the DAG are also kept, since they are rolled back along with it.
 type registeredBlock struct {
 	hash         chainhash.Hash
+	parallel     []chainhash.Hash
 	transactions []*observedTransaction
 }
 
+// hasHash returns whether the passed hash is the one of the registered block
+// or of one of its parallel blocks.
+func (rb *registeredBlock) hasHash(hash *chainhash.Hash) bool {
+	if rb.hash.IsEqual(hash) {
+		return true
+	}
+	for i := range rb.parallel {
+		if rb.parallel[i].IsEqual(hash) {
+			return true
+		}
+	}
+	return false
+}
+
 func (rb *registeredBlock) serialize(w io.Writer, txs map[*observedTransaction]uint32) {
 	binary.Write(w, binary.BigEndian, rb.hash)
 
+	binary.Write(w, binary.BigEndian, uint32(len(rb.parallel)))
+	for _, hash := range rb.parallel {
+		binary.Write(w, binary.BigEndian, hash)
+	}
+
 	binary.Write(w, binary.BigEndian, uint32(len(rb.transactions)))
 	for _, o := range rb.transactions {
 		binary.Write(w, binary.BigEndian, txs[o])
@@ -194,6 +229,18 @@ func NewFeeEstimator(maxRollback, minRegisteredBlocks uint32) *FeeEstimator {
 	}
 }
 
+// binIndex returns the index of the bin an observed transaction which was
+// mined belongs to, based on the number of blocks it took to be mined.
+// Transactions mined by a parallel block at the height they were observed at
+// are put in the first bin.
+func (o *observedTransaction) binIndex() int32 {
+	blocksToConfirm := o.mined - o.observed - 1
+	if blocksToConfirm < 0 {
+		blocksToConfirm = 0
+	}
+	return blocksToConfirm
+}
+
 // ObserveTransaction is called when a new transaction is observed in the mempool.
 func (ef *FeeEstimator) ObserveTransaction(t *TxDesc) {
 	ef.mtx.Lock()
@@ -219,6 +266,12 @@ func (ef *FeeEstimator) ObserveTransaction(t *TxDesc) {
 }
 
 // RegisterBlock informs the fee estimator of a new block to take into account.
+//
+// Blocks at or below the last known height are parallel blocks on other tips
+// of the DAG.  The transactions they mine are treated as mined at the last
+// known height, and they are rolled back along with the block registered at
+// that height.  Parallel blocks registered once that block was rolled back
+// are ignored.
 func (ef *FeeEstimator) RegisterBlock(block *soterutil.Block) error {
 	ef.mtx.Lock()
 	defer ef.mtx.Unlock()
@@ -227,14 +280,29 @@ func (ef *FeeEstimator) RegisterBlock(block *soterutil.Block) error {
 	ef.cached = nil
 
 	height := block.Height()
-	if height != ef.lastKnownHeight+1 && ef.lastKnownHeight != miningdag.UnminedHeight {
+	parallel := ef.lastKnownHeight != miningdag.UnminedHeight &&
+		height <= ef.lastKnownHeight
+	if !parallel && height != ef.lastKnownHeight+1 && ef.lastKnownHeight != miningdag.UnminedHeight {
 		return fmt.Errorf("intermediate block not recorded; current height is %d; new height is %d",
 			ef.lastKnownHeight, height)
 	}
 
-	// Update the last known height.
-	ef.lastKnownHeight = height
-	ef.numBlocksRegistered++
+	if parallel {
+		// The transactions of a parallel block can't be rolled back
+		// when the block registered at the last known height was
+		// rolled back already, so they aren't taken into account.
+		if ef.maxRollback != 0 && len(ef.dropped) == 0 {
+			log.Debugf("Estimate fee: ignoring parallel block %v, "+
+				"since no block at height %d can be rolled back",
+				block.Hash(), ef.lastKnownHeight)
+			return nil
+		}
+		height = ef.lastKnownHeight
+	} else {
+		// Update the last known height.
+		ef.lastKnownHeight = height
+		ef.numBlocksRegistered++
+	}
 
 	// Randomly order txs in block.
 	transactions := make(map[*soterutil.Tx]struct{})
@@ -262,29 +330,31 @@ func (ef *FeeEstimator) RegisterBlock(block *soterutil.Block) error {
 			continue
 		}
 
-		// Put the observed tx in the oppropriate bin.
-		blocksToConfirm := height - o.observed - 1
-
-		// This shouldn't happen if the fee estimator works correctly,
-		// but return an error if it does.
+		// Parallel blocks of the DAG can include the same
+		// transaction, which is only taken into account once.
 		if o.mined != miningdag.UnminedHeight {
-			log.Error("Estimate fee: transaction ", hash.String(), " has already been mined")
-			return errors.New("Transaction has already been mined")
+			log.Debugf("Estimate fee: transaction %v has already "+
+				"been mined", hash)
+			continue
 		}
 
+		// Put the observed tx in the oppropriate bin.
+		o.mined = height
+		blocksToConfirm := o.binIndex()
+
 		// This shouldn't happen but check just in case to avoid
 		// an out-of-bounds array index later.
 		if blocksToConfirm >= estimateFeeDepth {
+			o.mined = miningdag.UnminedHeight
 			continue
 		}
 
 		// Make sure we do not replace too many transactions per min.
 		if replacementCounts[blocksToConfirm] == int(ef.maxReplacements) {
+			o.mined = miningdag.UnminedHeight
 			continue
 		}
 
-		o.mined = height
-
 		replacementCounts[blocksToConfirm]++
 
 		bin := ef.bin[blocksToConfirm]
@@ -316,6 +386,16 @@ func (ef *FeeEstimator) RegisterBlock(block *soterutil.Block) error {
 		return nil
 	}
 
+	// The transactions dropped by a parallel block are rolled back along
+	// with the block registered at the last known height.
+	if parallel {
+		last := ef.dropped[len(ef.dropped)-1]
+		last.parallel = append(last.parallel, *block.Hash())
+		last.transactions = append(last.transactions,
+			dropped.transactions...)
+		return nil
+	}
+
 	if uint32(len(ef.dropped)) == ef.maxRollback {
 		ef.dropped = append(ef.dropped[1:], dropped)
 	} else {
@@ -349,7 +429,7 @@ func (ef *FeeEstimator) Rollback(hash *chainhash.Hash) error {
 	// Find this block in the stack of recent registered blocks.
 	var n int
 	for n = 1; n <= len(ef.dropped); n++ {
-		if ef.dropped[len(ef.dropped)-n].hash.IsEqual(hash) {
+		if ef.dropped[len(ef.dropped)-n].hasHash(hash) {
 			break
 		}
 	}
@@ -388,7 +468,7 @@ func (ef *FeeEstimator) rollback() {
 	// Go through the txs in the dropped block.
 	for _, o := range dropped.transactions {
 		// Which bin was this tx in?
-		blocksToConfirm := o.mined - o.observed - 1
+		blocksToConfirm := o.binIndex()
 
 		bin := ef.bin[blocksToConfirm]
 
@@ -573,17 +653,156 @@ func (ef *FeeEstimator) EstimateFee(numBlocks uint32) (SotoPerKilobyte, error) {
 	return ef.cached[int(numBlocks)-1].ToSotoPerKb(), nil
 }
 
+// feeSample is the outcome of an observed transaction used by smart fee
+// estimation.
+type feeSample struct {
+	feeRate   nanoSoterPerByte
+	confirmed bool
+}
+
+// smartFeeSamples returns the outcomes of the observed transactions for the
+// given confirmation target, sorted by descending fee rate.  Transactions mined
+// within the target count as confirmed, while transactions mined later or still
+// waiting after the target count as not confirmed.  Transactions which haven't
+// waited for the target yet are left out since their outcome isn't known.
+func (ef *FeeEstimator) smartFeeSamples(target uint32) []feeSample {
+	var samples []feeSample
+	for i, bin := range ef.bin {
+		for _, o := range bin {
+			samples = append(samples, feeSample{
+				feeRate:   o.feeRate,
+				confirmed: uint32(i+1) <= target,
+			})
+		}
+	}
+	for _, o := range ef.observed {
+		if o.mined != miningdag.UnminedHeight {
+			continue
+		}
+		if ef.lastKnownHeight-o.observed >= int32(target) {
+			samples = append(samples, feeSample{feeRate: o.feeRate})
+		}
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].feeRate > samples[j].feeRate
+	})
+
+	return samples
+}
+
+// SmartFeeEstimate is the result of a smart fee estimation.
+type SmartFeeEstimate struct {
+	// FeeRate is the estimated fee rate.
+	FeeRate SotoPerKilobyte
+
+	// Blocks is the number of blocks the estimate is for.  It is higher
+	// than the requested target when there isn't enough data to provide
+	// an estimate with the requested confidence for the target.
+	Blocks uint32
+
+	// Confidence is the fraction of the observed transactions paying at
+	// least the estimated fee rate which were mined within Blocks blocks.
+	Confidence float64
+
+	// Samples is the number of observed transactions paying at least the
+	// estimated fee rate the confidence is based on.
+	Samples int
+}
+
+// EstimateSmartFee estimates the lowest fee rate for which at least the
+// requested fraction of the observed transactions paying that fee rate or more
+// were mined within target blocks of entering the mempool.  The confidence is
+// typically one of the EstimateConfidence levels.  When no fee rate meets the
+// confidence for the target, the target is increased until one does, and the
+// returned estimate reports the number of blocks it is for.
+func (ef *FeeEstimator) EstimateSmartFee(target uint32, confidence float64) (*SmartFeeEstimate, error) {
+	ef.mtx.Lock()
+	defer ef.mtx.Unlock()
+
+	// If the number of registered blocks is below the minimum, return
+	// an error.
+	if ef.numBlocksRegistered < ef.minRegisteredBlocks {
+		return nil, errors.New("not enough blocks have been observed")
+	}
+
+	if target == 0 {
+		return nil, errors.New("cannot confirm transaction in zero blocks")
+	}
+
+	if target > estimateFeeDepth {
+		return nil, fmt.Errorf("can only estimate fees for up to %d "+
+			"blocks from now", estimateFeeDepth)
+	}
+
+	if confidence <= 0 || confidence > 1 {
+		return nil, fmt.Errorf("confidence must be in the range (0, 1], "+
+			"got %v", confidence)
+	}
+
+	for blocks := target; blocks <= estimateFeeDepth; blocks++ {
+		samples := ef.smartFeeSamples(blocks)
+
+		// Find the lowest fee rate for which the fraction of samples
+		// paying at least that much that confirmed meets the required
+		// confidence, only considering fee rates once all of the
+		// samples paying the same fee rate were counted.
+		var estimate *SmartFeeEstimate
+		var confirmed int
+		for i, sample := range samples {
+			if sample.confirmed {
+				confirmed++
+			}
+			if i+1 < len(samples) && samples[i+1].feeRate == sample.feeRate {
+				continue
+			}
+
+			total := i + 1
+			if total < estimateSmartFeeMinSamples {
+				continue
+			}
+			fraction := float64(confirmed) / float64(total)
+			if fraction < confidence {
+				continue
+			}
+			estimate = &SmartFeeEstimate{
+				FeeRate:    sample.feeRate.ToSotoPerKb(),
+				Blocks:     blocks,
+				Confidence: fraction,
+				Samples:    total,
+			}
+		}
+
+		if estimate != nil {
+			return estimate, nil
+		}
+	}
+
+	return nil, fmt.Errorf("insufficient data to estimate a fee with %v "+
+		"confidence within %d blocks", confidence, estimateFeeDepth)
+}
+
 // In case the format for the serialized version of the FeeEstimator changes,
 // we use a version number. If the version number changes, it does not make
 // sense to try to upgrade a previous version to a new version. Instead, just
 // start fee estimation over.
-const estimateFeeSaveVersion = 1
+const estimateFeeSaveVersion = 2
 
 func deserializeRegisteredBlock(r io.Reader, txs map[uint32]*observedTransaction) (*registeredBlock, error) {
 	var lenTransactions uint32
 
 	rb := &registeredBlock{}
 	binary.Read(r, binary.BigEndian, &rb.hash)
+
+	var lenParallel uint32
+	binary.Read(r, binary.BigEndian, &lenParallel)
+	if lenParallel > 0 {
+		rb.parallel = make([]chainhash.Hash, lenParallel)
+		for i := uint32(0); i < lenParallel; i++ {
+			binary.Read(r, binary.BigEndian, &rb.parallel[i])
+		}
+	}
+
 	binary.Read(r, binary.BigEndian, &lenTransactions)
 
 	rb.transactions = make([]*observedTransaction, lenTransactions)
@@ -746,3 +965,46 @@ func RestoreFeeEstimator(data FeeEstimatorState) (*FeeEstimator, error) {
 
 	return ef, nil
 }
+
+// StoreFeeEstimator saves the state of the passed FeeEstimator in the metadata
+// of the database under EstimateFeeDatabaseKey, so that it can be restored by
+// LoadFeeEstimator when the program is restarted.
+func StoreFeeEstimator(db database.DB, ef *FeeEstimator) error {
+	state := ef.Save()
+	return db.Update(func(dbTx database.Tx) error {
+		return dbTx.Metadata().Put(EstimateFeeDatabaseKey, state)
+	})
+}
+
+// LoadFeeEstimator restores the FeeEstimator saved in the database by
+// StoreFeeEstimator.  The saved state is deleted once loaded, so that a stale
+// state isn't restored after an unclean shutdown.  A new FeeEstimator using the
+// passed parameters is returned when no state was saved or it can't be
+// restored, such as when it was saved by an incompatible version.
+func LoadFeeEstimator(db database.DB, maxRollback, minRegisteredBlocks uint32) (*FeeEstimator, error) {
+	var ef *FeeEstimator
+	err := db.Update(func(dbTx database.Tx) error {
+		metadata := dbTx.Metadata()
+		state := metadata.Get(EstimateFeeDatabaseKey)
+		if state == nil {
+			return nil
+		}
+
+		var err error
+		ef, err = RestoreFeeEstimator(state)
+		if err != nil {
+			log.Warnf("Failed to restore fee estimator state: %v", err)
+			ef = nil
+		}
+
+		return metadata.Delete(EstimateFeeDatabaseKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if ef == nil {
+		ef = NewFeeEstimator(maxRollback, minRegisteredBlocks)
+	}
+	return ef, nil
+}
@@ -423,3 +423,153 @@ func TestDatabase(t *testing.T) {
 		eft.checkSaveAndRestore(estimateHistory[len(estimateHistory)-round-1])
 	}
 }
+
+// TestEstimateFeeParallelBlocks ensures that parallel blocks of the DAG at or
+// below the last known height are registered, and rolled back along with the
+// block registered at the last known height.
+func TestEstimateFeeParallelBlocks(t *testing.T) {
+	ef := newTestFeeEstimator(5, 3, 2)
+	eft := estimateFeeTester{ef: ef, t: t}
+
+	txA := eft.testTx(1000000)
+	txB := eft.testTx(2000000)
+	ef.ObserveTransaction(txA)
+	ef.ObserveTransaction(txB)
+
+	// Mine the first transaction in a block, and both of them in a parallel
+	// block at the same height.
+	eft.newBlock([]*wire.MsgTx{txA.Tx.MsgTx()})
+	parallel := soterutil.NewBlock(&wire.MsgBlock{
+		Header:       wire.BlockHeader{Nonce: 1},
+		Transactions: []*wire.MsgTx{txA.Tx.MsgTx(), txB.Tx.MsgTx()},
+	})
+	parallel.SetHeight(eft.height)
+	if err := ef.RegisterBlock(parallel); err != nil {
+		t.Fatalf("RegisterBlock: unexpected error for parallel block: %v",
+			err)
+	}
+
+	if ef.LastKnownHeight() != eft.height {
+		t.Fatalf("unexpected last known height -- got %d, want %d",
+			ef.LastKnownHeight(), eft.height)
+	}
+	if n := len(ef.bin[0]); n != 2 {
+		t.Fatalf("unexpected number of binned transactions -- got %d, "+
+			"want 2", n)
+	}
+
+	// Rolling back the parallel block rolls back the whole height.
+	if err := ef.Rollback(parallel.Hash()); err != nil {
+		t.Fatalf("Rollback: unexpected error: %v", err)
+	}
+	if n := len(ef.bin[0]); n != 0 {
+		t.Fatalf("unexpected number of binned transactions after "+
+			"rollback -- got %d, want 0", n)
+	}
+	for _, tx := range []*TxDesc{txA, txB} {
+		if o := ef.observed[*tx.Tx.Hash()]; o.mined != miningdag.UnminedHeight {
+			t.Fatalf("transaction %v still mined after rollback",
+				tx.Tx.Hash())
+		}
+	}
+}
+
+// TestEstimateFeeParallelBlockAfterRollback ensures that parallel blocks
+// registered once the block at the last known height was rolled back are
+// ignored, since they couldn't be rolled back themselves.
+func TestEstimateFeeParallelBlockAfterRollback(t *testing.T) {
+	ef := newTestFeeEstimator(5, 3, 2)
+	eft := estimateFeeTester{ef: ef, t: t}
+
+	txA := eft.testTx(1000000)
+	ef.ObserveTransaction(txA)
+
+	eft.newBlock([]*wire.MsgTx{})
+	eft.rollback()
+
+	parallel := soterutil.NewBlock(&wire.MsgBlock{
+		Header:       wire.BlockHeader{Nonce: 1},
+		Transactions: []*wire.MsgTx{txA.Tx.MsgTx()},
+	})
+	parallel.SetHeight(eft.height)
+	if err := ef.RegisterBlock(parallel); err != nil {
+		t.Fatalf("RegisterBlock: unexpected error for parallel block: %v",
+			err)
+	}
+
+	if o := ef.observed[*txA.Tx.Hash()]; o.mined != miningdag.UnminedHeight {
+		t.Fatalf("transaction %v mined by an ignored parallel block",
+			txA.Tx.Hash())
+	}
+	if n := len(ef.bin[0]); n != 0 {
+		t.Fatalf("unexpected number of binned transactions -- got %d, "+
+			"want 0", n)
+	}
+	if err := ef.Rollback(parallel.Hash()); err == nil {
+		t.Fatal("Rollback: rolled back an ignored parallel block")
+	}
+}
+
+// TestEstimateSmartFee ensures that smart fee estimates meet the requested
+// confidence, and fall back to higher targets when they can't.
+func TestEstimateSmartFee(t *testing.T) {
+	ef := newTestFeeEstimator(100, 10, 1)
+	eft := estimateFeeTester{ef: ef, t: t}
+
+	// Observe low fee transactions which are mined after several blocks
+	// and high fee transactions which are mined in the next block.
+	var lowFee, highFee []*TxDesc
+	for i := 0; i < 4; i++ {
+		lowFee = append(lowFee, eft.testTx(100000))
+		highFee = append(highFee, eft.testTx(1000000))
+	}
+	for _, tx := range append(lowFee, highFee...) {
+		ef.ObserveTransaction(tx)
+	}
+
+	var highFeeTxns []*wire.MsgTx
+	for _, tx := range highFee {
+		highFeeTxns = append(highFeeTxns, tx.Tx.MsgTx())
+	}
+	eft.newBlock(highFeeTxns)
+	eft.newBlock([]*wire.MsgTx{})
+	eft.newBlock([]*wire.MsgTx{})
+
+	var lowFeeTxns []*wire.MsgTx
+	for _, tx := range lowFee {
+		lowFeeTxns = append(lowFeeTxns, tx.Tx.MsgTx())
+	}
+	eft.newBlock(lowFeeTxns)
+
+	// Only the high fee rate confirms within a block with high confidence.
+	estimate, err := ef.EstimateSmartFee(1, EstimateConfidenceHigh)
+	if err != nil {
+		t.Fatalf("EstimateSmartFee: unexpected error: %v", err)
+	}
+	if estimate.FeeRate != expectedFeePerKilobyte(highFee[0]) ||
+		estimate.Blocks != 1 || estimate.Confidence != 1 {
+
+		t.Fatalf("EstimateSmartFee: unexpected estimate for 1 block: "+
+			"%+v", estimate)
+	}
+
+	// Every transaction was mined within four blocks.
+	estimate, err = ef.EstimateSmartFee(4, EstimateConfidenceHigh)
+	if err != nil {
+		t.Fatalf("EstimateSmartFee: unexpected error: %v", err)
+	}
+	if estimate.FeeRate != expectedFeePerKilobyte(lowFee[0]) ||
+		estimate.Blocks != 4 || estimate.Samples != 8 {
+
+		t.Fatalf("EstimateSmartFee: unexpected estimate for 4 blocks: "+
+			"%+v", estimate)
+	}
+
+	// Invalid targets and confidence levels are rejected.
+	if _, err := ef.EstimateSmartFee(0, EstimateConfidenceLow); err == nil {
+		t.Fatalf("EstimateSmartFee: accepted target of 0 blocks")
+	}
+	if _, err := ef.EstimateSmartFee(1, 1.5); err == nil {
+		t.Fatalf("EstimateSmartFee: accepted confidence above 1")
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// RemovalReason describes why a transaction was removed from the pool.
+type RemovalReason int
+
+const (
+	// RemovalExpiry indicates the transaction stayed in the pool for longer
+	// than allowed by the expiry policy.
+	RemovalExpiry RemovalReason = iota
+
+	// RemovalConflict indicates the transaction spends outputs which are no
+	// longer available, such as outputs spent by a transaction in a block
+	// on another branch of the DAG.
+	RemovalConflict
+
+	// RemovalBlock indicates the transaction was included in a block.
+	RemovalBlock
+
+	// RemovalEviction indicates the transaction was evicted to keep the
+	// pool within its size limit.
+	RemovalEviction
+
+	// RemovalReplacement indicates the transaction was replaced according
+	// to the replacement policy.
+	RemovalReplacement
+
+	// RemovalManual indicates the transaction was removed by the caller via
+	// RemoveTransaction.
+	RemovalManual
+)
+
+// Map of RemovalReason values back to their constant names for pretty
+// printing.
+var removalReasonStrings = map[RemovalReason]string{
+	RemovalExpiry:      "RemovalExpiry",
+	RemovalConflict:    "RemovalConflict",
+	RemovalBlock:       "RemovalBlock",
+	RemovalEviction:    "RemovalEviction",
+	RemovalReplacement: "RemovalReplacement",
+	RemovalManual:      "RemovalManual",
+}
+
+// String returns the RemovalReason as a human-readable name.
+func (r RemovalReason) String() string {
+	if s := removalReasonStrings[r]; s != "" {
+		return s
+	}
+	return fmt.Sprintf("Unknown RemovalReason (%d)", int(r))
+}
+
+// TxEventType identifies the type of a transaction pool event.
+type TxEventType int
+
+const (
+	// TxAdded indicates a transaction was added to the main pool.
+	TxAdded TxEventType = iota
+
+	// TxRemoved indicates a transaction was removed from the main pool.
+	TxRemoved
+)
+
+// Map of TxEventType values back to their constant names for pretty printing.
+var txEventTypeStrings = map[TxEventType]string{
+	TxAdded:   "TxAdded",
+	TxRemoved: "TxRemoved",
+}
+
+// String returns the TxEventType as a human-readable name.
+func (t TxEventType) String() string {
+	if s := txEventTypeStrings[t]; s != "" {
+		return s
+	}
+	return fmt.Sprintf("Unknown TxEventType (%d)", int(t))
+}
+
+// TxEvent describes a change to the main pool which is delivered to the
+// subscribers of the pool.
+type TxEvent struct {
+	// Type is the type of the event.
+	Type TxEventType
+
+	// TxDesc is a copy of the descriptor of the transaction that was added
+	// or removed, as it was at the time of the event.
+	TxDesc *TxDesc
+
+	// Reason is why the transaction was removed.  It is only set for
+	// TxRemoved events.
+	Reason RemovalReason
+}
+
+// TxSubscription delivers the events of the pool it was created for via
+// Subscribe, in the order they happened.  Events are queued without bound, so
+// a slow subscriber never blocks the pool, and the subscription must be
+// cancelled with Unsubscribe once it is no longer needed.
+type TxSubscription struct {
+	pool   *TxPool
+	in     chan *TxEvent
+	out    chan *TxEvent
+	quit   chan struct{}
+	closed sync.Once
+}
+
+// Events returns the channel the events of the pool are delivered on.  It is
+// closed once the subscription is cancelled.
+func (s *TxSubscription) Events() <-chan *TxEvent {
+	return s.out
+}
+
+// Unsubscribe cancels the subscription.  Events that weren't received yet are
+// dropped.  It is safe to call more than once.
+//
+// This function is safe for concurrent access.
+func (s *TxSubscription) Unsubscribe() {
+	s.closed.Do(func() {
+		s.pool.mtx.Lock()
+		delete(s.pool.subscriptions, s)
+		s.pool.mtx.Unlock()
+
+		close(s.quit)
+	})
+}
+
+// send queues the passed event for delivery to the subscriber.
+func (s *TxSubscription) send(event *TxEvent) {
+	select {
+	case s.in <- event:
+	case <-s.quit:
+	}
+}
+
+// queueHandler queues the events sent to the subscription and delivers them to
+// the subscriber as it is ready to receive them.  It must be run as a
+// goroutine.
+func (s *TxSubscription) queueHandler() {
+	pending := list.New()
+	defer close(s.out)
+
+	for {
+		// Only attempt to deliver an event when there is one pending.
+		var out chan *TxEvent
+		var next *TxEvent
+		if front := pending.Front(); front != nil {
+			out = s.out
+			next = front.Value.(*TxEvent)
+		}
+
+		select {
+		case event := <-s.in:
+			pending.PushBack(event)
+
+		case out <- next:
+			pending.Remove(pending.Front())
+
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// Subscribe returns a new subscription to the events of the pool, which
+// reports every transaction added to or removed from the main pool along with
+// the reason for removals.  The orphan pool is not covered.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Subscribe() *TxSubscription {
+	s := &TxSubscription{
+		pool: mp,
+		in:   make(chan *TxEvent),
+		out:  make(chan *TxEvent),
+		quit: make(chan struct{}),
+	}
+	go s.queueHandler()
+
+	mp.mtx.Lock()
+	mp.subscriptions[s] = struct{}{}
+	mp.mtx.Unlock()
+
+	return s
+}
+
+// notifySubscribers delivers the passed event to all of the subscribers of the
+// pool.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) notifySubscribers(event *TxEvent) {
+	if len(mp.subscriptions) == 0 {
+		return
+	}
+
+	// The descriptor in the pool keeps being updated under the mempool
+	// lock, such as by fee deltas and package statistics, so subscribers
+	// are given their own copy of it.
+	txDCopy := *event.TxDesc
+	event.TxDesc = &txDCopy
+	for s := range mp.subscriptions {
+		s.send(event)
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+const (
+	// DefaultMaxPoolSize is the default maximum estimated memory usage in
+	// bytes of the transactions in the mempool when a limit is enabled.
+	DefaultMaxPoolSize = 300 * 1000 * 1000
+
+	// rollingFeeHalfLife is the amount of time it takes for the rolling
+	// minimum fee raised by evictions to decay by half.
+	rollingFeeHalfLife = time.Hour * 12
+)
+
+// descendantScore returns the fee rate used to rank the passed transaction for
+// eviction.  It is the higher of the fee rate of the transaction itself and the
+// fee rate of the package made up of the transaction along with all of its
+// unconfirmed descendants, so that a transaction isn't evicted while a
+// descendant is paying for it.
+func descendantScore(txD *TxDesc) int64 {
+	score := txD.descendants.FeePerKB()
+	if txD.FeePerKB > score {
+		score = txD.FeePerKB
+	}
+	return score
+}
+
+// decayRollingMinFee decays the rolling minimum fee according to the time that
+// passed since it was last updated.  The fee is dropped entirely once it falls
+// below half of the minimum relay fee.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) decayRollingMinFee(now time.Time) {
+	if mp.rollingMinFee == 0 {
+		mp.lastRollingFeeUpdate = now
+		return
+	}
+
+	elapsed := now.Sub(mp.lastRollingFeeUpdate)
+	if elapsed <= 0 {
+		return
+	}
+	mp.rollingMinFee /= math.Pow(2, float64(elapsed)/float64(rollingFeeHalfLife))
+	mp.lastRollingFeeUpdate = now
+
+	if mp.rollingMinFee < float64(mp.cfg.Policy.MinRelayTxFee)/2 {
+		mp.rollingMinFee = 0
+	}
+}
+
+// minFee returns the current minimum fee rate in nanoSoter/kB transactions
+// must pay to be accepted into the pool.  It is the higher of the minimum
+// relay fee and the rolling minimum fee raised by evictions.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) minFee() soterutil.Amount {
+	mp.decayRollingMinFee(time.Now())
+
+	rollingMinFee := soterutil.Amount(math.Ceil(mp.rollingMinFee))
+	if rollingMinFee > mp.cfg.Policy.MinRelayTxFee {
+		return rollingMinFee
+	}
+	return mp.cfg.Policy.MinRelayTxFee
+}
+
+// MinFee returns the current minimum fee rate in nanoSoter/kB transactions
+// must pay to be accepted into the pool.  It is the higher of the minimum
+// relay fee of the policy and a rolling minimum fee, which is raised when
+// transactions are evicted due to the pool exceeding its size limit and which
+// decays over time.  The relay layer can use it to avoid requesting or relaying
+// transactions which would be rejected.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) MinFee() soterutil.Amount {
+	mp.mtx.Lock()
+	minFee := mp.minFee()
+	mp.mtx.Unlock()
+
+	return minFee
+}
+
+// checkMinFee ensures the passed transaction pays at least the rolling minimum
+// fee while the pool is full.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) checkMinFee(tx *soterutil.Tx, txFee int64) error {
+	minFeeRate := mp.minFee()
+	if minFeeRate <= mp.cfg.Policy.MinRelayTxFee {
+		// The regular minimum relay fee rules apply.
+		return nil
+	}
+
+	minFee := calcMinRequiredTxRelayFee(GetTxVirtualSize(tx), minFeeRate)
+	if txFee < minFee {
+		str := fmt.Sprintf("transaction %v has %d fees which is under "+
+			"the mempool minimum fee of %d", tx.Hash(), txFee, minFee)
+		return txRuleError(wire.RejectInsufficientFee, str)
+	}
+
+	return nil
+}
+
+// trimToSize evicts the transactions with the lowest descendant scores, along
+// with their descendants, until the estimated memory usage of the pool fits
+// within the limit of the policy.  The rolling minimum fee is raised above the fee
+// rate of each evicted package, so that transactions paying less than what was
+// evicted aren't accepted right away.  The descriptors of the evicted
+// transactions are returned.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) trimToSize() []*TxDesc {
+	maxSize := mp.cfg.Policy.MaxPoolSize
+	if maxSize <= 0 {
+		return nil
+	}
+
+	var evicted []*TxDesc
+	for mp.memoryUsage > maxSize && len(mp.pool) > 0 {
+		// Find the transaction with the lowest descendant score.  This
+		// is a linear scan, but evictions only happen once the pool is
+		// full.
+		var worst *TxDesc
+		var worstScore int64
+		for _, txD := range mp.pool {
+			score := descendantScore(txD)
+			if worst == nil || score < worstScore {
+				worst = txD
+				worstScore = score
+			}
+		}
+
+		// Raise the rolling minimum fee so that it exceeds the fee rate
+		// of the package being evicted.
+		mp.decayRollingMinFee(time.Now())
+		newMinFee := float64(worstScore + int64(mp.cfg.Policy.MinRelayTxFee))
+		if newMinFee > mp.rollingMinFee {
+			mp.rollingMinFee = newMinFee
+		}
+
+		evicted = append(evicted, worst)
+		for hash := range mp.txDescendants(worst.Tx, nil) {
+			evicted = append(evicted, mp.pool[hash])
+		}
+		mp.removeTransaction(worst.Tx, true, RemovalEviction)
+	}
+
+	if len(evicted) > 0 {
+		log.Debugf("Evicted %d %s to stay within the pool size limit "+
+			"(rolling minimum fee: %.0f nanoSoter/kB)", len(evicted),
+			pickNoun(len(evicted), "transaction", "transactions"),
+			mp.rollingMinFee)
+	}
+
+	return evicted
+}
+
+// restoreTransactions adds the passed descriptors of transactions removed from
+// the pool back to it, such as when the transaction they were removed for is
+// rejected after all.  Parents are restored before their children, and a
+// transaction whose inputs can no longer be fetched isn't restored, along with
+// its descendants.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) restoreTransactions(descs []*TxDesc) {
+	pending := make(map[chainhash.Hash]struct{}, len(descs))
+	for _, txD := range descs {
+		pending[*txD.Tx.Hash()] = struct{}{}
+	}
+
+	for restored := true; restored && len(pending) > 0; {
+		restored = false
+		for _, txD := range descs {
+			txHash := txD.Tx.Hash()
+			if _, ok := pending[*txHash]; !ok ||
+				hasPendingParent(txD.Tx, pending) {
+
+				continue
+			}
+
+			utxoView, err := mp.fetchInputUtxos(txD.Tx, false)
+			if err != nil {
+				log.Warnf("Unable to restore transaction %v: %v",
+					txHash, err)
+				continue
+			}
+			mp.insertTransaction(utxoView, txD)
+			delete(pending, *txHash)
+			restored = true
+		}
+	}
+
+	if len(descs) > len(pending) {
+		log.Debugf("Restored %d %s to the pool", len(descs)-len(pending),
+			pickNoun(len(descs)-len(pending), "transaction",
+				"transactions"))
+	}
+}
+
+// hasPendingParent returns whether the passed transaction spends an output of
+// one of the passed pending transactions.
+func hasPendingParent(tx *soterutil.Tx, pending map[chainhash.Hash]struct{}) bool {
+	for _, txIn := range tx.MsgTx().TxIn {
+		if _, ok := pending[txIn.PreviousOutPoint.Hash]; ok {
+			return true
+		}
+	}
+	return false
+}
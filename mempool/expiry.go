@@ -0,0 +1,184 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"time"
+)
+
+const (
+	// DefaultExpiry is the default amount of time a transaction is allowed
+	// to stay in the pool before it expires and is removed.
+	DefaultExpiry = time.Hour * 336
+
+	// DefaultRevalidateInterval is the default minimum amount of time in
+	// between re-checks of the transactions in the pool against the
+	// current state of the DAG.
+	DefaultRevalidateInterval = time.Minute * 5
+)
+
+// removeTransactionWithReason removes the passed transaction along with all of
+// its descendants from the pool, and reports each removed transaction with the
+// passed reason to the OnTxRemoved callback.  The descriptors of the removed
+// transactions are returned.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) removeTransactionWithReason(txD *TxDesc, reason RemovalReason) []*TxDesc {
+	removed := []*TxDesc{txD}
+	for hash := range mp.txDescendants(txD.Tx, nil) {
+		removed = append(removed, mp.pool[hash])
+	}
+	mp.removeTransaction(txD.Tx, true, reason)
+
+	if mp.cfg.OnTxRemoved != nil {
+		for _, desc := range removed {
+			mp.cfg.OnTxRemoved(desc, reason)
+		}
+	}
+
+	return removed
+}
+
+// removeExpired removes the transactions which were added to the pool longer
+// ago than allowed by the expiry policy, along with their descendants.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) removeExpired(now time.Time) []*TxDesc {
+	expiry := mp.cfg.Policy.Expiry
+	if expiry <= 0 {
+		return nil
+	}
+
+	var removed []*TxDesc
+	cutoff := now.Add(-expiry)
+	for _, txD := range mp.pool {
+		// Skip transactions already removed as the descendant of an
+		// expired transaction.
+		if !mp.isTransactionInPool(txD.Tx.Hash()) {
+			continue
+		}
+
+		if txD.Added.Before(cutoff) {
+			removed = append(removed,
+				mp.removeTransactionWithReason(txD, RemovalExpiry)...)
+		}
+	}
+
+	if len(removed) > 0 {
+		log.Debugf("Expired %d %s (remaining: %d)", len(removed),
+			pickNoun(len(removed), "transaction", "transactions"),
+			len(mp.pool))
+	}
+
+	return removed
+}
+
+// RemoveExpired removes the transactions which were added to the pool longer
+// ago than allowed by the Expiry policy, along with their descendants.  The
+// descriptors of the removed transactions are returned.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) RemoveExpired() []*TxDesc {
+	mp.mtx.Lock()
+	removed := mp.removeExpired(time.Now())
+	mp.mtx.Unlock()
+
+	return removed
+}
+
+// revalidate re-checks the inputs of the transactions in the pool against the
+// current state of the DAG, and removes the transactions spending outputs that
+// are no longer available along with their descendants.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) revalidate() ([]*TxDesc, error) {
+	var removed []*TxDesc
+	for _, txD := range mp.pool {
+		// Skip transactions already removed as the descendant of a
+		// conflicted transaction.
+		if !mp.isTransactionInPool(txD.Tx.Hash()) {
+			continue
+		}
+
+		// Transactions conflicting with transactions confirmed by
+		// blocks are kept until the conflicts are resolved, since
+		// their inputs may only be spent on one tip of the DAG.
+		if mp.isConflicted(txD.Tx) {
+			continue
+		}
+
+		// Bypass the utxo cache since the point is to catch outputs
+		// which are no longer available.
+		utxoView, err := mp.fetchInputUtxos(txD.Tx, true)
+		if err != nil {
+			return removed, err
+		}
+
+		for _, txIn := range txD.Tx.MsgTx().TxIn {
+			entry := utxoView.LookupEntry(txIn.PreviousOutPoint)
+			if entry != nil && !entry.IsSpent() {
+				continue
+			}
+
+			log.Debugf("Removing transaction %v since input %v is "+
+				"no longer available", txD.Tx.Hash(),
+				txIn.PreviousOutPoint)
+			removed = append(removed,
+				mp.removeTransactionWithReason(txD, RemovalConflict)...)
+			break
+		}
+	}
+
+	return removed, nil
+}
+
+// Revalidate re-checks the inputs of all the transactions in the pool against
+// the current state of the DAG, and removes the transactions spending outputs
+// which are no longer available, such as outputs spent by a block on another
+// branch of the DAG, along with their descendants.  The descriptors of the
+// removed transactions are returned.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Revalidate() ([]*TxDesc, error) {
+	mp.mtx.Lock()
+	removed, err := mp.revalidate()
+	mp.nextRevalidation = time.Now().Add(mp.revalidateInterval())
+	mp.mtx.Unlock()
+
+	return removed, err
+}
+
+// revalidateInterval returns the minimum amount of time in between periodic
+// re-checks of the pool, using the default when the policy doesn't set it.
+func (mp *TxPool) revalidateInterval() time.Duration {
+	if mp.cfg.Policy.RevalidateInterval > 0 {
+		return mp.cfg.Policy.RevalidateInterval
+	}
+	return DefaultRevalidateInterval
+}
+
+// Maintain performs the periodic maintenance of the pool.  It removes expired
+// transactions and the conflicted transactions whose conflicts are resolved,
+// and re-checks the remaining transactions against the current state of the
+// DAG when the revalidation interval has elapsed since the last check.  It is
+// intended to be called whenever the DAG changes, such as when blocks are
+// connected.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Maintain() error {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	now := time.Now()
+	mp.removeExpired(now)
+	mp.resolveConflicts()
+	if now.Before(mp.nextRevalidation) {
+		return nil
+	}
+
+	mp.nextRevalidation = now.Add(mp.revalidateInterval())
+	_, err := mp.revalidate()
+	return err
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/miningdag"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// isFeeExempt returns whether the passed transaction is exempt from paying the
+// minimum relay fee.  Transactions which are being added back to the pool from
+// blocks that have been disconnected are always exempt, and the transactions
+// for which the IsFeeExempt callback of the configuration returns true are
+// exempt as well.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) isFeeExempt(tx *soterutil.Tx, isNew bool) bool {
+	if !isNew {
+		return true
+	}
+	return mp.cfg.IsFeeExempt != nil && mp.cfg.IsFeeExempt(tx)
+}
+
+// checkRelayFee ensures the passed transaction pays at least the passed
+// minimum relay fee unless it is exempt from it.  When the deprecated
+// LegacyFreeTxRelay policy is set, free and low-fee transactions are admitted
+// according to their size and priority instead.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) checkRelayFee(tx *soterutil.Tx, isNew bool,
+	utxoView *blockdag.UtxoViewpoint, nextBlockHeight int32, size,
+	fee, minFee int64) error {
+
+	if fee >= minFee {
+		return nil
+	}
+	if mp.cfg.Policy.LegacyFreeTxRelay {
+		return mp.checkLegacyFreeTx(tx, isNew, utxoView,
+			nextBlockHeight, size, fee, minFee)
+	}
+	if mp.isFeeExempt(tx, isNew) {
+		return nil
+	}
+
+	str := fmt.Sprintf("transaction %v has %d fees which is under the "+
+		"required amount of %d", tx.Hash(), fee, minFee)
+	return txRuleError(wire.RejectInsufficientFee, str)
+}
+
+// checkLegacyFreeTx implements the deprecated admission of free and low-fee
+// transactions which is used when the LegacyFreeTxRelay policy is set.
+//
+// Most miners allow a free transaction area in blocks they mine to go
+// alongside the area used for high-priority transactions as well as
+// transactions with fees.  A transaction size of up to 1000 bytes is
+// considered safe to go into this section.  Further, the minimum fee on its
+// own would encourage several small transactions to avoid fees rather than one
+// single larger transaction which is more desirable.  Therefore, as long as the
+// size of the transaction does not exceeed 1000 less than the reserved space
+// for high-priority transactions, don't require a fee for it.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) checkLegacyFreeTx(tx *soterutil.Tx, isNew bool,
+	utxoView *blockdag.UtxoViewpoint, nextBlockHeight int32, size,
+	fee, minFee int64) error {
+
+	if size >= (DefaultBlockPrioritySize - 1000) {
+		str := fmt.Sprintf("transaction %v has %d fees which is under "+
+			"the required amount of %d", tx.Hash(), fee, minFee)
+		return txRuleError(wire.RejectInsufficientFee, str)
+	}
+
+	// Require that free transactions have sufficient priority to be mined
+	// in the next block.  Transactions which are being added back to the
+	// memory pool from blocks that have been disconnected during a reorg
+	// are exempted.
+	if isNew && !mp.cfg.Policy.DisableRelayPriority {
+		currentPriority := miningdag.CalcPriority(tx.MsgTx(), utxoView,
+			nextBlockHeight)
+		if currentPriority <= miningdag.MinHighPriority {
+			str := fmt.Sprintf("transaction %v has insufficient "+
+				"priority (%g <= %g)", tx.Hash(),
+				currentPriority, miningdag.MinHighPriority)
+			return txRuleError(wire.RejectInsufficientFee, str)
+		}
+	}
+
+	return nil
+}
+
+// limitLegacyFreeTx rate limits the free-to-relay transactions admitted when
+// the deprecated LegacyFreeTxRelay policy is set, to prevent penny-flooding
+// with tiny transactions as a form of attack.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) limitLegacyFreeTx(tx *soterutil.Tx, size int64) error {
+	nowUnix := time.Now().Unix()
+	// Decay passed data with an exponentially decaying ~10 minute
+	// window - matches bitcoind handling.
+	mp.pennyTotal *= math.Pow(1.0-1.0/600.0,
+		float64(nowUnix-mp.lastPennyUnix))
+	mp.lastPennyUnix = nowUnix
+
+	// Are we still over the limit?
+	if mp.pennyTotal >= mp.cfg.Policy.FreeTxRelayLimit*10*1000 {
+		str := fmt.Sprintf("transaction %v has been rejected "+
+			"by the rate limiter due to low fees", tx.Hash())
+		return txRuleError(wire.RejectInsufficientFee, str)
+	}
+	oldTotal := mp.pennyTotal
+
+	mp.pennyTotal += float64(size)
+	log.Tracef("rate limit: curTotal %v, nextTotal: %v, "+
+		"limit %v", oldTotal, mp.pennyTotal,
+		mp.cfg.Policy.FreeTxRelayLimit*10*1000)
+
+	return nil
+}
@@ -6,6 +6,9 @@
 package mempool
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/soteria-dag/soterd/soterlog"
 )
 
@@ -40,3 +43,21 @@ func pickNoun(n int, singular, plural string) string {
 	}
 	return plural
 }
+
+// logFields formats the passed alternating keys and values as space separated
+// key=value fields, so that the log lines can be processed by tools expecting
+// structured logs.  Values containing spaces are quoted.
+func logFields(keyvals ...interface{}) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		value := fmt.Sprint(keyvals[i+1])
+		if strings.ContainsAny(value, " \t\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, "%v=%s", keyvals[i], value)
+	}
+	return b.String()
+}
@@ -7,7 +7,6 @@ package mempool
 import (
 	"container/list"
 	"fmt"
-	"math"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -38,6 +37,15 @@ const (
 	// orphanExpireScanInterval is the minimum amount of time in between
 	// scans of the orphan pool to evict expired transactions.
 	orphanExpireScanInterval = time.Minute * 5
+
+	// DefaultMaxOrphanBytes is the default maximum total serialized size in
+	// bytes of the transactions in the orphan pool.
+	DefaultMaxOrphanBytes = 5 * 1000 * 1000
+
+	// DefaultMaxOrphanBytesPerTag is the default maximum total serialized
+	// size in bytes of the orphan transactions sharing the same tag, which
+	// is typically the peer that relayed them.
+	DefaultMaxOrphanBytesPerTag = 1000 * 1000
 )
 
 // Tag represents an identifier to use for tagging orphan transactions.  The
@@ -63,6 +71,22 @@ type Config struct {
 	// the current best chain.
 	BestHeight func() int32
 
+	// DAGOrdering defines the function to use to access the current
+	// ordering of the blocks of the DAG, which the conflicts with
+	// transactions confirmed by the blocks are resolved by.  The returned
+	// slice is only read.  This field can be nil, in which case the
+	// conflicts are never resolved and conflicted transactions only leave
+	// the pool once their blocks are disconnected or they expire.
+	DAGOrdering func() []*chainhash.Hash
+
+	// SubscribeDAG defines the function to use to register the passed
+	// callback for the notifications of the DAG, such as the Subscribe
+	// method of the DAG.  The mempool uses them to invalidate the outputs
+	// of the DAG it caches for validating transactions as soon as blocks
+	// are connected or disconnected.  This field can be nil, in which case
+	// the outputs aren't cached.
+	SubscribeDAG func(callback blockdag.NotificationCallback)
+
 	// MedianTimePast defines the function to use in order to access the
 	// median time past calculated from the point-of-view of the current
 	// chain tip within the best chain.
@@ -93,6 +117,44 @@ type Config struct {
 	// FeeEstimatator provides a feeEstimator. If it is not nil, the mempool
 	// records all new transactions it observes into the feeEstimator.
 	FeeEstimator *FeeEstimator
+
+	// OnTxReplaced defines the function to call when a transaction replaces
+	// transactions in the pool according to the replacement policy.  The
+	// replaced transactions include any descendants that were evicted along
+	// with the ones spending the same outputs as the replacement.
+	//
+	// This function is called with the mempool lock held, so it MUST NOT
+	// call back into the mempool.  This field can be nil.
+	OnTxReplaced func(replaced []*TxDesc, replacement *TxDesc)
+
+	// OnTxRemoved defines the function to call when a transaction is
+	// removed from the pool by the pool maintenance, such as when it
+	// expires or spends outputs that are no longer available.
+	//
+	// This function is called with the mempool lock held, so it MUST NOT
+	// call back into the mempool.  This field can be nil.
+	OnTxRemoved func(txDesc *TxDesc, reason RemovalReason)
+
+	// IsFeeExempt defines the function to call to determine whether a
+	// transaction is exempt from paying the minimum relay fee, such as
+	// when it was submitted locally or by a whitelisted peer.  Exempt
+	// transactions must still pay the rolling minimum fee while the pool
+	// is full.  Transactions being added back to the pool from
+	// disconnected blocks are always exempt.
+	//
+	// This function is called with the mempool lock held, so it MUST NOT
+	// call back into the mempool.  This field can be nil.
+	IsFeeExempt func(tx *soterutil.Tx) bool
+
+	// DecisionTraceSize is the number of the most recent decisions about
+	// the transactions processed by the pool to keep for DecisionTrace.
+	// Decisions aren't traced when it is zero.
+	DecisionTraceSize int
+
+	// Metrics defines the interface to report the health of the pool to,
+	// such as the number of accepted, rejected and evicted transactions.
+	// This field can be nil.
+	Metrics Metrics
 }
 
 // Policy houses the policy (configuration parameters) which is used to
@@ -103,8 +165,20 @@ type Policy struct {
 	// non-standard.
 	MaxTxVersion int32
 
+	// LegacyFreeTxRelay defines whether to admit free and low-fee
+	// transactions based on their size and priority as well as the free
+	// transaction rate limiter.  Otherwise, every transaction must pay the
+	// minimum relay fee unless it is exempt from it, see
+	// Config.IsFeeExempt.
+	//
+	// Deprecated: This is provided for compatibility during one release
+	// and will be removed along with DisableRelayPriority and
+	// FreeTxRelayLimit.
+	LegacyFreeTxRelay bool
+
 	// DisableRelayPriority defines whether to relay free or low-fee
-	// transactions that do not have enough priority to be relayed.
+	// transactions that do not have enough priority to be relayed.  It is
+	// only used when LegacyFreeTxRelay is set.
 	DisableRelayPriority bool
 
 	// AcceptNonStd defines whether to accept non-standard transactions. If
@@ -113,9 +187,22 @@ type Policy struct {
 	AcceptNonStd bool
 
 	// FreeTxRelayLimit defines the given amount in thousands of bytes
-	// per minute that transactions with no fee are rate limited to.
+	// per minute that transactions with no fee are rate limited to.  It is
+	// only used when LegacyFreeTxRelay is set.
 	FreeTxRelayLimit float64
 
+	// PeerTxRelayLimit defines the given amount in thousands of bytes per
+	// minute that the low-fee transactions relayed by a single peer are
+	// rate limited to.  The default of DefaultPeerTxRelayLimit is used when
+	// it is zero.
+	PeerTxRelayLimit float64
+
+	// PeerLowFeeRate defines the fee rate in nanoSoter/kB below which the
+	// transactions relayed by a peer count against its rate limit.
+	// DefaultPeerLowFeeMultiplier times the current minimum fee rate is
+	// used when it is zero.
+	PeerLowFeeRate soterutil.Amount
+
 	// MaxOrphanTxs is the maximum number of orphan transactions
 	// that can be queued.
 	MaxOrphanTxs int
@@ -125,14 +212,146 @@ type Policy struct {
 	// of big orphans.
 	MaxOrphanTxSize int
 
+	// MaxOrphanBytes is the maximum total serialized size in bytes of the
+	// transactions in the orphan pool.  Orphans of the tag using the most
+	// bytes are evicted first once it is exceeded.  The default of
+	// DefaultMaxOrphanBytes is used when it is zero.
+	MaxOrphanBytes int64
+
+	// MaxOrphanBytesPerTag is the maximum total serialized size in bytes of
+	// the orphan transactions sharing the same tag.  Since tags typically
+	// identify peers, this prevents a single peer from pushing the orphans
+	// of other peers out of the orphan pool.  The default of
+	// DefaultMaxOrphanBytesPerTag is used when it is zero.
+	MaxOrphanBytesPerTag int64
+
 	// MaxSigOpCostPerTx is the cumulative maximum cost of all the signature
 	// operations in a single transaction we will relay or mine.  It is a
 	// fraction of the max signature operations for a block.
 	MaxSigOpCostPerTx int
 
-	// MinRelayTxFee defines the minimum transaction fee in SOTER/kB to be
-	// considered a non-zero fee.
+	// MinRelayTxFee defines the minimum transaction fee in nanoSoter/kB to
+	// be considered a non-zero fee.
 	MinRelayTxFee soterutil.Amount
+
+	// DustRelayFee defines the fee rate in nanoSoter/kB used to determine
+	// whether transaction outputs are dust.  The dust relay fee of the
+	// network parameters is used when it is zero, and MinRelayTxFee when
+	// that is zero as well.
+	DustRelayFee soterutil.Amount
+
+	// DustThresholds defines the minimum value of outputs of specific
+	// script classes for them not to be considered dust, overriding
+	// DustRelayFee for those classes.  Unspendable outputs are always
+	// considered dust.  This field can be nil.
+	DustThresholds map[txscript.ScriptClass]soterutil.Amount
+
+	// MaxStandardTxWeight is the maximum weight of a transaction for it to
+	// be considered standard.  The default of DefaultMaxStandardTxWeight is
+	// used when it is zero.
+	MaxStandardTxWeight int64
+
+	// MaxStandardP2SHSigOps is the maximum number of signature operations
+	// in a pay-to-script-hash input for it to be considered standard.  The
+	// default of DefaultMaxStandardP2SHSigOps is used when it is zero.
+	MaxStandardP2SHSigOps int
+
+	// MaxDataCarrierSize is the maximum number of bytes of data a null
+	// data output may carry for it to be considered standard.  The default
+	// of DefaultMaxDataCarrierSize is used when it is zero.
+	MaxDataCarrierSize int
+
+	// ScriptFlags are the script verification flags used to validate the
+	// scripts of the transactions accepted into the mempool.  The default
+	// of txscript.StandardVerifyFlags is used when it is zero.
+	ScriptFlags txscript.ScriptFlags
+
+	// RejectReplacement, if true, rejects accepting replacement
+	// transactions using the Replace-By-Fee (RBF) signaling policy into
+	// the mempool.
+	RejectReplacement bool
+
+	// FullReplacement, if true, allows any transaction in the mempool to
+	// be replaced according to the Replace-By-Fee (RBF) rules, whether or
+	// not it signals replaceability.  It has no effect when
+	// RejectReplacement is set.
+	FullReplacement bool
+
+	// MaxReplacementEvictions is the maximum number of transactions,
+	// including descendants, that a replacement transaction may evict
+	// from the mempool.  The default of DefaultMaxReplacementEvictions is
+	// used when it is zero.
+	MaxReplacementEvictions int
+
+	// MaxAncestorCount is the maximum number of unconfirmed ancestors,
+	// including the transaction itself, a transaction in the mempool may
+	// have.  The default of DefaultMaxAncestorCount is used when it is
+	// zero.
+	MaxAncestorCount int
+
+	// MaxAncestorSize is the maximum virtual size in bytes of a transaction
+	// along with all of its unconfirmed ancestors.  The default of
+	// DefaultMaxAncestorSize is used when it is zero.
+	MaxAncestorSize int64
+
+	// MaxDescendantCount is the maximum number of unconfirmed descendants,
+	// including the transaction itself, a transaction in the mempool may
+	// have.  The default of DefaultMaxDescendantCount is used when it is
+	// zero.
+	MaxDescendantCount int
+
+	// MaxDescendantSize is the maximum virtual size in bytes of a
+	// transaction along with all of its unconfirmed descendants.  The
+	// default of DefaultMaxDescendantSize is used when it is zero.
+	MaxDescendantSize int64
+
+	// MaxPackageCount is the maximum number of transactions in a package
+	// accepted via AcceptPackage.  The default of DefaultMaxPackageCount
+	// is used when it is zero.
+	MaxPackageCount int
+
+	// MaxPackageSize is the maximum total virtual size in bytes of the
+	// transactions in a package accepted via AcceptPackage.  The default
+	// of DefaultMaxPackageSize is used when it is zero.
+	MaxPackageSize int64
+
+	// MaxPoolSize is the maximum estimated memory usage in bytes of the
+	// transactions in the mempool, including their metadata and index
+	// entries, as reported by GetMemoryUsage.  Once exceeded, the
+	// transactions with the lowest fee rates are evicted and the minimum
+	// fee reported by MinFee is raised accordingly.  The pool size is
+	// unlimited when it is zero.
+	MaxPoolSize int64
+
+	// Expiry is the maximum amount of time a transaction is allowed to stay
+	// in the mempool before it is removed, along with its descendants.
+	// Transactions don't expire when it is zero.  DefaultExpiry provides a
+	// typical value.
+	Expiry time.Duration
+
+	// RevalidateInterval is the minimum amount of time in between periodic
+	// re-checks of the transactions in the mempool against the current
+	// state of the DAG.  The default of DefaultRevalidateInterval is used
+	// when it is zero.
+	RevalidateInterval time.Duration
+
+	// MaxUtxoCacheEntries is the maximum number of outputs of the DAG to
+	// cache for validating transactions.  The default of
+	// DefaultMaxUtxoCacheEntries is used when it is zero, and the cache is
+	// disabled when it is negative.
+	MaxUtxoCacheEntries int
+
+	// MaxRejectCacheEntries is the maximum number of recently rejected
+	// transactions to remember along with the reason for their rejection.
+	// The default of DefaultMaxRejectCacheEntries is used when it is zero.
+	MaxRejectCacheEntries int
+
+	// ConflictResolutionDepth is the number of blocks which must be
+	// ordered after a block in the DAG ordering before the transactions in
+	// the mempool double spending the transactions it confirms are
+	// removed.  The default of DefaultConflictResolutionDepth is used when
+	// it is zero.
+	ConflictResolutionDepth int32
 }
 
 // TxDesc is a descriptor containing a transaction in the mempool along with
@@ -143,6 +362,19 @@ type TxDesc struct {
 	// StartingPriority is the priority of the transaction when it was added
 	// to the pool.
 	StartingPriority float64
+
+	// FeeDelta is the virtual fee delta in nanoSoter applied to the
+	// transaction via PrioritiseTransaction.  It is included in FeePerKB
+	// and in the package statistics, but not in Fee, which is the actual
+	// fee paid by the transaction.
+	FeeDelta int64
+
+	// ancestors and descendants describe the packages made up of the
+	// transaction along with all of its unconfirmed ancestors and
+	// descendants in the pool respectively.  They are protected by the
+	// mempool lock, and are exposed via AncestorStats and DescendantStats.
+	ancestors   PackageStats
+	descendants PackageStats
 }
 
 // orphanTx is normal transaction that references an ancestor transaction
@@ -151,6 +383,7 @@ type TxDesc struct {
 type orphanTx struct {
 	tx         *soterutil.Tx
 	tag        Tag
+	size       int64
 	expiration time.Time
 }
 
@@ -159,26 +392,87 @@ type orphanTx struct {
 // peers.
 type TxPool struct {
 	// The following variables must only be used atomically.
-	lastUpdated int64 // last time pool was updated
+	lastUpdated   int64  // last time pool was updated
+	dagGeneration uint64 // bumped when blocks are (dis)connected
 
 	mtx           sync.RWMutex
 	cfg           Config
 	pool          map[chainhash.Hash]*TxDesc
 	orphans       map[chainhash.Hash]*orphanTx
 	orphansByPrev map[wire.OutPoint]map[chainhash.Hash]*soterutil.Tx
+	orphansByTag  map[Tag]map[chainhash.Hash]*orphanTx
 	outpoints     map[wire.OutPoint]*soterutil.Tx
 	pennyTotal    float64 // exponentially decaying total for penny spends.
 	lastPennyUnix int64   // unix time of last ``penny spend''
 
+	// orphanBytes is the total serialized size of the transactions in the
+	// orphan pool, and orphanBytesByTag the same per tag.
+	orphanBytes      int64
+	orphanBytesByTag map[Tag]int64
+
+	// poolSize is the total virtual size of the transactions in the main
+	// pool, and memoryUsage their estimated memory usage.
+	//
+	// rollingMinFee is the minimum fee rate in nanoSoter/kB raised by
+	// evictions of transactions when the pool exceeds its size limit.  It
+	// decays over time, starting from lastRollingFeeUpdate.
+	poolSize             int64
+	memoryUsage          int64
+	rollingMinFee        float64
+	lastRollingFeeUpdate time.Time
+
 	// nextExpireScan is the time after which the orphan pool will be
 	// scanned in order to evict orphans.  This is NOT a hard deadline as
 	// the scan will only run when an orphan is added to the pool as opposed
 	// to on an unconditional timer.
 	nextExpireScan time.Time
+
+	// nextRevalidation is the time after which the transactions in the
+	// pool will be re-checked against the current state of the DAG during
+	// the next call to Maintain.
+	nextRevalidation time.Time
+
+	// subscriptions are the active subscriptions to the events of the
+	// pool.
+	subscriptions map[*TxSubscription]struct{}
+
+	// generation is incremented whenever the transactions in the main pool
+	// or their descriptors change, and snapshot caches the *Snapshot of
+	// the pool taken at the latest generation requested.
+	generation uint64
+	snapshot   atomic.Value
+
+	// rejects are the recently rejected transactions.
+	rejects *rejectCache
+
+	// utxoCache caches the outputs of the DAG fetched for validating
+	// transactions.
+	utxoCache *utxoCache
+
+	// decisions are the most recent decisions about the processed
+	// transactions, when tracing them is enabled.
+	decisions *decisionTrace
+
+	// feeDeltas are the virtual fee deltas applied to transactions via
+	// PrioritiseTransaction, whether or not they are in the pool.
+	feeDeltas map[chainhash.Hash]int64
+
+	// peerRates tracks the low-fee transactions relayed by each peer for
+	// the per-peer rate limiter.
+	peerRates map[Tag]*peerRateLimit
+
+	// conflicted tracks the transactions in the pool which double spend
+	// transactions confirmed by blocks whose conflicts aren't resolved
+	// yet, and confirmedTxns the transactions confirmed by such blocks.
+	conflicted    map[chainhash.Hash]*txConflict
+	confirmedTxns map[chainhash.Hash]blockConfirmations
 }
 
-// Ensure the TxPool type implements the mining.TxSource interface.
+// Ensure the TxPool type implements the mining.TxSource,
+// miningdag.PackageTxSource and miningdag.GenerationTxSource interfaces.
 var _ miningdag.TxSource = (*TxPool)(nil)
+var _ miningdag.PackageTxSource = (*TxPool)(nil)
+var _ miningdag.GenerationTxSource = (*TxPool)(nil)
 
 // removeOrphan is the internal function which implements the public
 // RemoveOrphan.  See the comment for RemoveOrphan for more details.
@@ -217,8 +511,18 @@ func (mp *TxPool) removeOrphan(tx *soterutil.Tx, removeRedeemers bool) {
 		}
 	}
 
-	// Remove the transaction from the orphan pool.
+	// Remove the transaction from the orphan pool and update the byte
+	// accounting of its tag.
 	delete(mp.orphans, *txHash)
+	mp.orphanBytes -= otx.size
+	if tagOrphans, ok := mp.orphansByTag[otx.tag]; ok {
+		delete(tagOrphans, *txHash)
+		mp.orphanBytesByTag[otx.tag] -= otx.size
+		if len(tagOrphans) == 0 {
+			delete(mp.orphansByTag, otx.tag)
+			delete(mp.orphanBytesByTag, otx.tag)
+		}
+	}
 }
 
 // RemoveOrphan removes the passed orphan transaction from the orphan pool and
@@ -238,21 +542,72 @@ func (mp *TxPool) RemoveOrphan(tx *soterutil.Tx) {
 func (mp *TxPool) RemoveOrphansByTag(tag Tag) uint64 {
 	var numEvicted uint64
 	mp.mtx.Lock()
-	for _, otx := range mp.orphans {
-		if otx.tag == tag {
-			mp.removeOrphan(otx.tx, true)
-			numEvicted++
+	for _, otx := range mp.orphansByTag[tag] {
+		// Skip orphans already removed as the redeemer of another one.
+		if _, exists := mp.orphans[*otx.tx.Hash()]; !exists {
+			continue
 		}
+		mp.removeOrphan(otx.tx, true)
+		numEvicted++
 	}
 	mp.mtx.Unlock()
 	return numEvicted
 }
 
-// limitNumOrphans limits the number of orphan transactions by evicting a random
-// orphan if adding a new one would cause it to overflow the max allowed.
+// orphanLimits returns the orphan pool byte limits of the policy, using the
+// defaults for any limits which aren't set.
+func (p *Policy) orphanLimits() (maxBytes, maxBytesPerTag int64) {
+	maxBytes = p.MaxOrphanBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxOrphanBytes
+	}
+	maxBytesPerTag = p.MaxOrphanBytesPerTag
+	if maxBytesPerTag <= 0 {
+		maxBytesPerTag = DefaultMaxOrphanBytesPerTag
+	}
+	return maxBytes, maxBytesPerTag
+}
+
+// evictOrphan removes a random orphan tagged with the provided identifier from
+// the orphan pool.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *TxPool) limitNumOrphans() error {
+func (mp *TxPool) evictOrphan(tag Tag) {
+	// See limitNumOrphans for why the iteration order is suitable for
+	// picking a random orphan.
+	for _, otx := range mp.orphansByTag[tag] {
+		// Don't remove redeemers in the case of a random eviction since
+		// it is quite possible it might be needed again shortly.
+		mp.removeOrphan(otx.tx, false)
+		break
+	}
+}
+
+// heaviestOrphanTag returns the tag whose orphans use the most bytes in the
+// orphan pool.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) heaviestOrphanTag() Tag {
+	var heaviest Tag
+	var heaviestBytes int64 = -1
+	for tag, numBytes := range mp.orphanBytesByTag {
+		if numBytes > heaviestBytes {
+			heaviest = tag
+			heaviestBytes = numBytes
+		}
+	}
+	return heaviest
+}
+
+// limitNumOrphans limits the number and size of orphan transactions by evicting
+// random orphans if adding a new one of the provided size and tag would cause it
+// to overflow the max allowed.  Orphans sharing the tag of the new one are
+// evicted to make room within the per-tag limit, and orphans of the tag using
+// the most bytes are evicted to make room within the total limits, so that a
+// flood of orphans with one tag doesn't push out the orphans of the others.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) limitNumOrphans(size int64, tag Tag) error {
 	// Scan through the orphan pool and remove any expired orphans when it's
 	// time.  This is done for efficiency so the scan only happens
 	// periodically instead of on every orphan added to the pool.
@@ -279,23 +634,28 @@ func (mp *TxPool) limitNumOrphans() error {
 		}
 	}
 
-	// Nothing to do if adding another orphan will not cause the pool to
-	// exceed the limit.
-	if len(mp.orphans)+1 <= mp.cfg.Policy.MaxOrphanTxs {
-		return nil
+	// Evict orphans sharing the tag of the new one until it fits within
+	// the per-tag limit.
+	//
+	// Random entries are removed relying on the iteration order of maps.
+	// For most compilers, Go's range statement iterates starting at a
+	// random item although that is not 100% guaranteed by the spec.  The
+	// iteration order is not important here because an adversary would
+	// have to be able to pull off preimage attacks on the hashing function
+	// in order to target eviction of specific entries anyways.
+	maxBytes, maxBytesPerTag := mp.cfg.Policy.orphanLimits()
+	for len(mp.orphansByTag[tag]) > 0 &&
+		mp.orphanBytesByTag[tag]+size > maxBytesPerTag {
+
+		mp.evictOrphan(tag)
 	}
 
-	// Remove a random entry from the map.  For most compilers, Go's
-	// range statement iterates starting at a random item although
-	// that is not 100% guaranteed by the spec.  The iteration order
-	// is not important here because an adversary would have to be
-	// able to pull off preimage attacks on the hashing function in
-	// order to target eviction of specific entries anyways.
-	for _, otx := range mp.orphans {
-		// Don't remove redeemers in the case of a random eviction since
-		// it is quite possible it might be needed again shortly.
-		mp.removeOrphan(otx.tx, false)
-		break
+	// Evict orphans of the heaviest tag until adding another orphan will
+	// not cause the pool to exceed the limits.
+	for len(mp.orphans) > 0 && (len(mp.orphans)+1 > mp.cfg.Policy.MaxOrphanTxs ||
+		mp.orphanBytes+size > maxBytes) {
+
+		mp.evictOrphan(mp.heaviestOrphanTag())
 	}
 
 	return nil
@@ -310,16 +670,25 @@ func (mp *TxPool) addOrphan(tx *soterutil.Tx, tag Tag) {
 		return
 	}
 
-	// Limit the number orphan transactions to prevent memory exhaustion.
-	// This will periodically remove any expired orphans and evict a random
-	// orphan if space is still needed.
-	mp.limitNumOrphans()
+	// Limit the number and size of orphan transactions to prevent memory
+	// exhaustion.  This will periodically remove any expired orphans and
+	// evict random orphans if space is still needed.
+	size := int64(tx.MsgTx().SerializeSize())
+	mp.limitNumOrphans(size, tag)
 
-	mp.orphans[*tx.Hash()] = &orphanTx{
+	otx := &orphanTx{
 		tx:         tx,
 		tag:        tag,
+		size:       size,
 		expiration: time.Now().Add(orphanTTL),
 	}
+	mp.orphans[*tx.Hash()] = otx
+	if _, exists := mp.orphansByTag[tag]; !exists {
+		mp.orphansByTag[tag] = make(map[chainhash.Hash]*orphanTx)
+	}
+	mp.orphansByTag[tag][*tx.Hash()] = otx
+	mp.orphanBytes += size
+	mp.orphanBytesByTag[tag] += size
 	for _, txIn := range tx.MsgTx().TxIn {
 		if _, exists := mp.orphansByPrev[txIn.PreviousOutPoint]; !exists {
 			mp.orphansByPrev[txIn.PreviousOutPoint] =
@@ -328,8 +697,12 @@ func (mp *TxPool) addOrphan(tx *soterutil.Tx, tag Tag) {
 		mp.orphansByPrev[txIn.PreviousOutPoint][*tx.Hash()] = tx
 	}
 
-	log.Debugf("Stored orphan transaction %v (total: %d)", tx.Hash(),
-		len(mp.orphans))
+	if mp.cfg.Metrics != nil {
+		mp.cfg.Metrics.TxOrphaned()
+	}
+
+	log.Debugf("Stored orphan transaction %v (total: %d, %d bytes)",
+		tx.Hash(), len(mp.orphans), mp.orphanBytes)
 }
 
 // maybeAddOrphan potentially adds an orphan to the orphan pool.
@@ -342,10 +715,10 @@ func (mp *TxPool) maybeAddOrphan(tx *soterutil.Tx, tag Tag) error {
 	// it will ultimtely be rebroadcast after the parent transactions
 	// have been mined or otherwise received.
 	//
-	// Note that the number of orphan transactions in the orphan pool is
-	// also limited, so this equates to a maximum memory used of
-	// mp.cfg.Policy.MaxOrphanTxSize * mp.cfg.Policy.MaxOrphanTxs (which is ~5MB
-	// using the default values at the time this comment was written).
+	// Note that the number and total size of orphan transactions in the
+	// orphan pool are also limited, so this equates to a maximum memory
+	// used of the smaller of mp.cfg.Policy.MaxOrphanTxSize *
+	// mp.cfg.Policy.MaxOrphanTxs and mp.cfg.Policy.MaxOrphanBytes.
 	serializedLen := tx.MsgTx().SerializeSize()
 	if serializedLen > mp.cfg.Policy.MaxOrphanTxSize {
 		str := fmt.Sprintf("orphan transaction size of %d bytes is "+
@@ -354,6 +727,15 @@ func (mp *TxPool) maybeAddOrphan(tx *soterutil.Tx, tag Tag) error {
 		return txRuleError(wire.RejectNonstandard, str)
 	}
 
+	// Ignore orphan transactions that couldn't fit within the byte limits
+	// even after evicting other orphans.
+	maxBytes, maxBytesPerTag := mp.cfg.Policy.orphanLimits()
+	if int64(serializedLen) > maxBytes || int64(serializedLen) > maxBytesPerTag {
+		str := fmt.Sprintf("orphan transaction size of %d bytes "+
+			"exceeds the orphan pool byte limits", serializedLen)
+		return txRuleError(wire.RejectNonstandard, str)
+	}
+
 	// Add the orphan if the none of the above disqualified it.
 	mp.addOrphan(tx, tag)
 
@@ -376,6 +758,22 @@ func (mp *TxPool) removeOrphanDoubleSpends(tx *soterutil.Tx) {
 	}
 }
 
+// OrphansSpending returns the orphan transactions which are blocked on the
+// passed outpoint, meaning they spend it while at least one of their inputs
+// references a transaction that is not yet available.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) OrphansSpending(op wire.OutPoint) []*soterutil.Tx {
+	mp.mtx.RLock()
+	orphans := make([]*soterutil.Tx, 0, len(mp.orphansByPrev[op]))
+	for _, tx := range mp.orphansByPrev[op] {
+		orphans = append(orphans, tx)
+	}
+	mp.mtx.RUnlock()
+
+	return orphans
+}
+
 // isTransactionInPool returns whether or not the passed transaction already
 // exists in the main pool.
 //
@@ -449,16 +847,18 @@ func (mp *TxPool) HaveTransaction(hash *chainhash.Hash) bool {
 
 // removeTransaction is the internal function which implements the public
 // RemoveTransaction.  See the comment for RemoveTransaction for more details.
+// The removal of each transaction is reported to the subscribers of the pool
+// with the passed reason.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *TxPool) removeTransaction(tx *soterutil.Tx, removeRedeemers bool) {
+func (mp *TxPool) removeTransaction(tx *soterutil.Tx, removeRedeemers bool, reason RemovalReason) {
 	txHash := tx.Hash()
 	if removeRedeemers {
 		// Remove any transactions which rely on this one.
 		for i := uint32(0); i < uint32(len(tx.MsgTx().TxOut)); i++ {
 			prevOut := wire.OutPoint{Hash: *txHash, Index: i}
 			if txRedeemer, exists := mp.outpoints[prevOut]; exists {
-				mp.removeTransaction(txRedeemer, true)
+				mp.removeTransaction(txRedeemer, true, reason)
 			}
 		}
 	}
@@ -471,12 +871,37 @@ func (mp *TxPool) removeTransaction(tx *soterutil.Tx, removeRedeemers bool) {
 			mp.cfg.AddrIndex.RemoveUnconfirmedTx(txHash)
 		}
 
+		// Update the package statistics of the related transactions
+		// that remain in the pool.
+		mp.removePackageStats(txDesc)
+
 		// Mark the referenced outpoints as unspent by the pool.
 		for _, txIn := range txDesc.Tx.MsgTx().TxIn {
 			delete(mp.outpoints, txIn.PreviousOutPoint)
 		}
 		delete(mp.pool, *txHash)
+		delete(mp.conflicted, *txHash)
+		mp.poolSize -= GetTxVirtualSize(tx)
+		mp.memoryUsage -= txMemoryUsage(tx)
+		mp.bumpGeneration()
 		atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
+
+		if mp.cfg.Metrics != nil {
+			mp.cfg.Metrics.TxRemoved(reason)
+			mp.reportPoolSize()
+		}
+
+		mp.notifySubscribers(&TxEvent{
+			Type:   TxRemoved,
+			TxDesc: txDesc,
+			Reason: reason,
+		})
+	}
+
+	// The fee delta of the transaction is no longer needed once it is
+	// mined.
+	if reason == RemovalBlock {
+		delete(mp.feeDeltas, *txHash)
 	}
 }
 
@@ -489,7 +914,7 @@ func (mp *TxPool) removeTransaction(tx *soterutil.Tx, removeRedeemers bool) {
 func (mp *TxPool) RemoveTransaction(tx *soterutil.Tx, removeRedeemers bool) {
 	// Protect concurrent access.
 	mp.mtx.Lock()
-	mp.removeTransaction(tx, removeRedeemers)
+	mp.removeTransaction(tx, removeRedeemers, RemovalManual)
 	mp.mtx.Unlock()
 }
 
@@ -506,7 +931,8 @@ func (mp *TxPool) RemoveDoubleSpends(tx *soterutil.Tx) {
 	for _, txIn := range tx.MsgTx().TxIn {
 		if txRedeemer, ok := mp.outpoints[txIn.PreviousOutPoint]; ok {
 			if !txRedeemer.Hash().IsEqual(tx.Hash()) {
-				mp.removeTransaction(txRedeemer, true)
+				mp.removeTransaction(txRedeemer, true,
+					RemovalConflict)
 			}
 		}
 	}
@@ -521,21 +947,38 @@ func (mp *TxPool) RemoveDoubleSpends(tx *soterutil.Tx) {
 func (mp *TxPool) addTransaction(utxoView *blockdag.UtxoViewpoint, tx *soterutil.Tx, height int32, fee int64) *TxDesc {
 	// Add the transaction to the pool and mark the referenced outpoints
 	// as spent by the pool.
+	feeDelta := mp.feeDeltas[*tx.Hash()]
 	txD := &TxDesc{
 		TxDesc: miningdag.TxDesc{
 			Tx:       tx,
 			Added:    time.Now(),
 			Height:   height,
 			Fee:      fee,
-			FeePerKB: fee * 1000 / GetTxVirtualSize(tx),
+			FeePerKB: (fee + feeDelta) * 1000 / GetTxVirtualSize(tx),
 		},
 		StartingPriority: miningdag.CalcPriority(tx.MsgTx(), utxoView, height),
+		FeeDelta:         feeDelta,
 	}
+	mp.insertTransaction(utxoView, txD)
+
+	return txD
+}
 
+// insertTransaction inserts the passed descriptor into the memory pool, and
+// updates the indexes, statistics and subscribers of the pool accordingly.
+// This is a helper for addTransaction and restoreTransactions.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) insertTransaction(utxoView *blockdag.UtxoViewpoint, txD *TxDesc) {
+	tx := txD.Tx
 	mp.pool[*tx.Hash()] = txD
 	for _, txIn := range tx.MsgTx().TxIn {
 		mp.outpoints[txIn.PreviousOutPoint] = tx
 	}
+	mp.addPackageStats(txD)
+	mp.poolSize += GetTxVirtualSize(tx)
+	mp.memoryUsage += txMemoryUsage(tx)
+	mp.bumpGeneration()
 	atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
 
 	// Add unconfirmed address index entries associated with the transaction
@@ -549,26 +992,44 @@ func (mp *TxPool) addTransaction(utxoView *blockdag.UtxoViewpoint, tx *soterutil
 		mp.cfg.FeeEstimator.ObserveTransaction(txD)
 	}
 
-	return txD
+	if mp.cfg.Metrics != nil {
+		mp.cfg.Metrics.TxAccepted()
+		mp.reportPoolSize()
+	}
+
+	mp.notifySubscribers(&TxEvent{Type: TxAdded, TxDesc: txD})
 }
 
 // checkPoolDoubleSpend checks whether or not the passed transaction is
 // attempting to spend coins already spent by other transactions in the pool.
-// Note it does not check for double spends against transactions already in the
-// main chain.
+// If it does, each of those transactions is checked against the replacement
+// policy.  If just one of them isn't replaceable, an error is returned.
+// Otherwise, a boolean is returned signaling that the transaction is a
+// replacement.  Note it does not check for double spends against transactions
+// already in the main chain.
 //
 // This function MUST be called with the mempool lock held (for reads).
-func (mp *TxPool) checkPoolDoubleSpend(tx *soterutil.Tx) error {
+func (mp *TxPool) checkPoolDoubleSpend(tx *soterutil.Tx) (bool, error) {
+	var isReplacement bool
 	for _, txIn := range tx.MsgTx().TxIn {
-		if txR, exists := mp.outpoints[txIn.PreviousOutPoint]; exists {
+		txR, exists := mp.outpoints[txIn.PreviousOutPoint]
+		if !exists {
+			continue
+		}
+
+		// Reject the transaction if the conflicting transaction can't
+		// be replaced according to the replacement policy.
+		if !mp.isReplaceable(txR) {
 			str := fmt.Sprintf("output %v already spent by "+
 				"transaction %v in the memory pool",
 				txIn.PreviousOutPoint, txR.Hash())
-			return txRuleError(wire.RejectDuplicate, str)
+			return false, txRuleError(wire.RejectDuplicate, str)
 		}
+
+		isReplacement = true
 	}
 
-	return nil
+	return isReplacement, nil
 }
 
 // CheckSpend checks whether the passed outpoint is already spent by a
@@ -584,12 +1045,12 @@ func (mp *TxPool) CheckSpend(op wire.OutPoint) *soterutil.Tx {
 
 // fetchInputUtxos loads utxo details about the input transactions referenced by
 // the passed transaction.  First, it loads the details form the viewpoint of
-// the main chain, then it adjusts them based upon the contents of the
-// transaction pool.
+// the main chain, bypassing the utxo cache when fresh details are requested,
+// then it adjusts them based upon the contents of the transaction pool.
 //
 // This function MUST be called with the mempool lock held (for reads).
-func (mp *TxPool) fetchInputUtxos(tx *soterutil.Tx) (*blockdag.UtxoViewpoint, error) {
-	utxoView, err := mp.cfg.FetchUtxoView(tx)
+func (mp *TxPool) fetchInputUtxos(tx *soterutil.Tx, fresh bool) (*blockdag.UtxoViewpoint, error) {
+	utxoView, err := mp.fetchUtxoView(tx, fresh)
 	if err != nil {
 		return nil, err
 	}
@@ -631,12 +1092,39 @@ func (mp *TxPool) FetchTransaction(txHash *chainhash.Hash) (*soterutil.Tx, error
 	return nil, fmt.Errorf("transaction is not in the pool")
 }
 
-// maybeAcceptTransaction is the internal function which implements the public
-// MaybeAcceptTransaction.  See the comment for MaybeAcceptTransaction for
-// more details.
+// txValidation houses the results of validating a transaction for acceptance
+// into the pool which are needed to add it.
+type txValidation struct {
+	// missingParents are the hashes of the transactions referenced by the
+	// inputs which are unknown.  None of the other fields are set when the
+	// transaction is an orphan.
+	missingParents []*chainhash.Hash
+
+	utxoView   *blockdag.UtxoViewpoint
+	bestHeight int32
+	fee        int64
+
+	// conflicts are the transactions in the pool which are replaced by the
+	// transaction, including their descendants.
+	conflicts map[chainhash.Hash]*soterutil.Tx
+}
+
+// validateTransaction performs all of the policy and consensus checks needed
+// to accept the passed transaction into the pool without adding it.  The
+// pending transactions are optional, and their outputs are treated as if the
+// transactions were in the pool.  This is used to validate packages of
+// dependent transactions.  When the deferFees flag is set, the minimum fee
+// checks are skipped since the caller evaluates them for the package as a
+// whole.
+//
+// The pool is not modified, except for the state of the free transaction rate
+// limiter when the rate limit flag is set.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *TxPool) maybeAcceptTransaction(tx *soterutil.Tx, isNew, rateLimit, rejectDupOrphans bool) ([]*chainhash.Hash, *TxDesc, error) {
+func (mp *TxPool) validateTransaction(tx *soterutil.Tx, isNew, rateLimit,
+	rejectDupOrphans bool, pending map[chainhash.Hash]*soterutil.Tx,
+	deferFees bool) (*txValidation, error) {
+
 	txHash := tx.Hash()
 
 	// If a transaction has iwtness data, and segwit isn't active yet, If
@@ -645,13 +1133,13 @@ func (mp *TxPool) maybeAcceptTransaction(tx *soterutil.Tx, isNew, rateLimit, rej
 	if tx.MsgTx().HasWitness() {
 		segwitActive, err := mp.cfg.IsDeploymentActive(chaincfg.DeploymentSegwit)
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 
 		if !segwitActive {
 			str := fmt.Sprintf("transaction %v has witness data, "+
 				"but segwit isn't active yet", txHash)
-			return nil, nil, txRuleError(wire.RejectNonstandard, str)
+			return nil, txRuleError(wire.RejectNonstandard, str)
 		}
 	}
 
@@ -663,7 +1151,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *soterutil.Tx, isNew, rateLimit, rej
 		mp.isOrphanInPool(txHash)) {
 
 		str := fmt.Sprintf("already have transaction %v", txHash)
-		return nil, nil, txRuleError(wire.RejectDuplicate, str)
+		return nil, txRuleError(wire.RejectDuplicate, str)
 	}
 
 	// Perform preliminary sanity checks on the transaction.  This makes
@@ -672,16 +1160,16 @@ func (mp *TxPool) maybeAcceptTransaction(tx *soterutil.Tx, isNew, rateLimit, rej
 	err := blockdag.CheckTransactionSanity(tx)
 	if err != nil {
 		if cerr, ok := err.(blockdag.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
+			return nil, chainRuleError(cerr)
 		}
-		return nil, nil, err
+		return nil, err
 	}
 
 	// A standalone transaction must not be a coinbase transaction.
 	if blockdag.IsCoinBase(tx) {
 		str := fmt.Sprintf("transaction %v is an individual coinbase",
 			txHash)
-		return nil, nil, txRuleError(wire.RejectInvalid, str)
+		return nil, txRuleError(wire.RejectInvalid, str)
 	}
 
 	// Get the current height of the main chain.  A standalone transaction
@@ -695,9 +1183,11 @@ func (mp *TxPool) maybeAcceptTransaction(tx *soterutil.Tx, isNew, rateLimit, rej
 	// Don't allow non-standard transactions if the network parameters
 	// forbid their acceptance.
 	if !mp.cfg.Policy.AcceptNonStd {
+		p := &mp.cfg.Policy
 		err = checkTransactionStandard(tx, nextBlockHeight,
-			medianTimePast, mp.cfg.Policy.MinRelayTxFee,
-			mp.cfg.Policy.MaxTxVersion)
+			medianTimePast, p.dustRelayFee(mp.cfg.ChainParams),
+			p.DustThresholds, p.MaxTxVersion,
+			p.maxStandardTxWeight(), p.maxDataCarrierSize())
 		if err != nil {
 			// Attempt to extract a reject code from the error so
 			// it can be retained.  When not possible, fall back to
@@ -708,7 +1198,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *soterutil.Tx, isNew, rateLimit, rej
 			}
 			str := fmt.Sprintf("transaction %v is not standard: %v",
 				txHash, err)
-			return nil, nil, txRuleError(rejectCode, str)
+			return nil, txRuleError(rejectCode, str)
 		}
 	}
 
@@ -720,21 +1210,38 @@ func (mp *TxPool) maybeAcceptTransaction(tx *soterutil.Tx, isNew, rateLimit, rej
 	// at this point.  There is a more in-depth check that happens later
 	// after fetching the referenced transaction inputs from the main chain
 	// which examines the actual spend data and prevents double spends.
-	err = mp.checkPoolDoubleSpend(tx)
+	//
+	// Transactions which double spend replaceable transactions in the pool
+	// are allowed through as potential replacements, and are validated
+	// against the replacement policy once their fee is known.
+	isReplacement, err := mp.checkPoolDoubleSpend(tx)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	// Fetch all of the unspent transaction outputs referenced by the inputs
 	// to this transaction.  This function also attempts to fetch the
 	// transaction itself to be used for detecting a duplicate transaction
 	// without needing to do a separate lookup.
-	utxoView, err := mp.fetchInputUtxos(tx)
+	utxoView, err := mp.fetchInputUtxos(tx, false)
 	if err != nil {
 		if cerr, ok := err.(blockdag.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
+			return nil, chainRuleError(cerr)
+		}
+		return nil, err
+	}
+
+	// Populate any inputs still missing from the pending transactions.
+	for _, txIn := range tx.MsgTx().TxIn {
+		prevOut := txIn.PreviousOutPoint
+		entry := utxoView.LookupEntry(prevOut)
+		if entry != nil && !entry.IsSpent() {
+			continue
+		}
+		if pendingTx, ok := pending[prevOut.Hash]; ok {
+			utxoView.AddTxOut(pendingTx, prevOut.Index,
+				miningdag.UnminedHeight)
 		}
-		return nil, nil, err
 	}
 
 	// Don't allow the transaction if it exists in the main chain and is not
@@ -744,7 +1251,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *soterutil.Tx, isNew, rateLimit, rej
 		prevOut.Index = uint32(txOutIdx)
 		entry := utxoView.LookupEntry(prevOut)
 		if entry != nil && !entry.IsSpent() {
-			return nil, nil, txRuleError(wire.RejectDuplicate,
+			return nil, txRuleError(wire.RejectDuplicate,
 				"transaction already exists")
 		}
 		utxoView.RemoveEntry(prevOut)
@@ -766,7 +1273,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *soterutil.Tx, isNew, rateLimit, rej
 		}
 	}
 	if len(missingParents) > 0 {
-		return missingParents, nil, nil
+		return &txValidation{missingParents: missingParents}, nil
 	}
 
 	// Don't allow the transaction into the mempool unless its sequence
@@ -775,13 +1282,13 @@ func (mp *TxPool) maybeAcceptTransaction(tx *soterutil.Tx, isNew, rateLimit, rej
 	sequenceLock, err := mp.cfg.CalcSequenceLock(tx, utxoView)
 	if err != nil {
 		if cerr, ok := err.(blockdag.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
+			return nil, chainRuleError(cerr)
 		}
-		return nil, nil, err
+		return nil, err
 	}
 	if !blockdag.SequenceLockActive(sequenceLock, nextBlockHeight,
 		medianTimePast) {
-		return nil, nil, txRuleError(wire.RejectNonstandard,
+		return nil, txRuleError(wire.RejectNonstandard,
 			"transaction's sequence locks on inputs not met")
 	}
 
@@ -792,15 +1299,21 @@ func (mp *TxPool) maybeAcceptTransaction(tx *soterutil.Tx, isNew, rateLimit, rej
 	txFee, err := blockdag.CheckTransactionInputs(tx, nextBlockHeight, utxoView, mp.cfg.ChainParams, false)
 	if err != nil {
 		if cerr, ok := err.(blockdag.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
+			return nil, chainRuleError(cerr)
 		}
-		return nil, nil, err
+		return nil, err
 	}
 
+	// The fee policy checks below use the fee including the virtual fee
+	// delta applied to the transaction via PrioritiseTransaction, if any.
+	// The actual fee is retained for the descriptor of the transaction.
+	modifiedFee := txFee + mp.feeDeltas[*txHash]
+
 	// Don't allow transactions with non-standard inputs if the network
 	// parameters forbid their acceptance.
 	if !mp.cfg.Policy.AcceptNonStd {
-		err := checkInputsStandard(tx, utxoView)
+		err := checkInputsStandard(tx, utxoView,
+			mp.cfg.Policy.maxStandardP2SHSigOps())
 		if err != nil {
 			// Attempt to extract a reject code from the error so
 			// it can be retained.  When not possible, fall back to
@@ -811,7 +1324,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *soterutil.Tx, isNew, rateLimit, rej
 			}
 			str := fmt.Sprintf("transaction %v has a non-standard "+
 				"input: %v", txHash, err)
-			return nil, nil, txRuleError(rejectCode, str)
+			return nil, txRuleError(rejectCode, str)
 		}
 	}
 
@@ -828,90 +1341,150 @@ func (mp *TxPool) maybeAcceptTransaction(tx *soterutil.Tx, isNew, rateLimit, rej
 	sigOpCost, err := blockdag.GetSigOpCost(tx, false, utxoView, true, true)
 	if err != nil {
 		if cerr, ok := err.(blockdag.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
+			return nil, chainRuleError(cerr)
 		}
-		return nil, nil, err
+		return nil, err
 	}
 	if sigOpCost > mp.cfg.Policy.MaxSigOpCostPerTx {
 		str := fmt.Sprintf("transaction %v sigop cost is too high: %d > %d",
 			txHash, sigOpCost, mp.cfg.Policy.MaxSigOpCostPerTx)
-		return nil, nil, txRuleError(wire.RejectNonstandard, str)
+		return nil, txRuleError(wire.RejectNonstandard, str)
 	}
 
-	// Don't allow transactions with fees too low to get into a mined block.
-	//
-	// Most miners allow a free transaction area in blocks they mine to go
-	// alongside the area used for high-priority transactions as well as
-	// transactions with fees.  A transaction size of up to 1000 bytes is
-	// considered safe to go into this section.  Further, the minimum fee
-	// calculated below on its own would encourage several small
-	// transactions to avoid fees rather than one single larger transaction
-	// which is more desirable.  Therefore, as long as the size of the
-	// transaction does not exceeed 1000 less than the reserved space for
-	// high-priority transactions, don't require a fee for it.
+	// Don't allow transactions with fees too low to get into a mined block
+	// unless they are exempt from the fee policy.
 	serializedSize := GetTxVirtualSize(tx)
 	minFee := calcMinRequiredTxRelayFee(serializedSize,
 		mp.cfg.Policy.MinRelayTxFee)
-	if serializedSize >= (DefaultBlockPrioritySize-1000) && txFee < minFee {
-		str := fmt.Sprintf("transaction %v has %d fees which is under "+
-			"the required amount of %d", txHash, txFee,
-			minFee)
-		return nil, nil, txRuleError(wire.RejectInsufficientFee, str)
+	if !deferFees {
+		err = mp.checkRelayFee(tx, isNew, utxoView, nextBlockHeight,
+			serializedSize, modifiedFee, minFee)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// Require that free transactions have sufficient priority to be mined
-	// in the next block.  Transactions which are being added back to the
-	// memory pool from blocks that have been disconnected during a reorg
-	// are exempted.
-	if isNew && !mp.cfg.Policy.DisableRelayPriority && txFee < minFee {
-		currentPriority := miningdag.CalcPriority(tx.MsgTx(), utxoView,
-			nextBlockHeight)
-		if currentPriority <= miningdag.MinHighPriority {
-			str := fmt.Sprintf("transaction %v has insufficient "+
-				"priority (%g <= %g)", txHash,
-				currentPriority, miningdag.MinHighPriority)
-			return nil, nil, txRuleError(wire.RejectInsufficientFee, str)
+	// Require transactions to pay at least the rolling minimum fee when
+	// the pool has recently been full.
+	if !deferFees {
+		err = mp.checkMinFee(tx, modifiedFee)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	// Free-to-relay transactions are rate limited here to prevent
-	// penny-flooding with tiny transactions as a form of attack.
-	if rateLimit && txFee < minFee {
-		nowUnix := time.Now().Unix()
-		// Decay passed data with an exponentially decaying ~10 minute
-		// window - matches bitcoind handling.
-		mp.pennyTotal *= math.Pow(1.0-1.0/600.0,
-			float64(nowUnix-mp.lastPennyUnix))
-		mp.lastPennyUnix = nowUnix
-
-		// Are we still over the limit?
-		if mp.pennyTotal >= mp.cfg.Policy.FreeTxRelayLimit*10*1000 {
-			str := fmt.Sprintf("transaction %v has been rejected "+
-				"by the rate limiter due to low fees", txHash)
-			return nil, nil, txRuleError(wire.RejectInsufficientFee, str)
+	// Free-to-relay transactions admitted by the deprecated legacy policy
+	// are rate limited.
+	if mp.cfg.Policy.LegacyFreeTxRelay && rateLimit && modifiedFee < minFee {
+		err = mp.limitLegacyFreeTx(tx, serializedSize)
+		if err != nil {
+			return nil, err
 		}
-		oldTotal := mp.pennyTotal
-
-		mp.pennyTotal += float64(serializedSize)
-		log.Tracef("rate limit: curTotal %v, nextTotal: %v, "+
-			"limit %v", oldTotal, mp.pennyTotal,
-			mp.cfg.Policy.FreeTxRelayLimit*10*1000)
 	}
 
 	// Verify crypto signatures for each input and reject the transaction if
 	// any don't verify.
 	err = blockdag.ValidateTransactionScripts(tx, utxoView,
-		txscript.StandardVerifyFlags, mp.cfg.SigCache,
+		mp.cfg.Policy.scriptFlags(), mp.cfg.SigCache,
 		mp.cfg.HashCache)
 	if err != nil {
 		if cerr, ok := err.(blockdag.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
+			return nil, chainRuleError(cerr)
 		}
+		return nil, err
+	}
+
+	// Don't allow transactions which would make unconfirmed chains of
+	// transactions in the pool too long or too large.
+	err = mp.checkPackageLimits(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	// If the transaction is replacing others in the pool, ensure it is a
+	// valid replacement according to the replacement policy.
+	var conflicts map[chainhash.Hash]*soterutil.Tx
+	if isReplacement {
+		conflicts, err = mp.validateReplacement(tx, modifiedFee)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &txValidation{
+		utxoView:   utxoView,
+		bestHeight: bestHeight,
+		fee:        txFee,
+		conflicts:  conflicts,
+	}, nil
+}
+
+// maybeAcceptTransaction is the internal function which implements the public
+// MaybeAcceptTransaction.  See the comment for MaybeAcceptTransaction for
+// more details.  When rate limiting is requested and the peer the transaction
+// originates from is known, the per-peer rate limiter is applied as well.  The
+// peer is nil otherwise.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) maybeAcceptTransaction(tx *soterutil.Tx, isNew, rateLimit, rejectDupOrphans bool, peer *Tag) ([]*chainhash.Hash, *TxDesc, error) {
+	txHash := tx.Hash()
+
+	v, err := mp.validateTransaction(tx, isNew, rateLimit,
+		rejectDupOrphans, nil, false)
+	if err != nil {
 		return nil, nil, err
 	}
+	if len(v.missingParents) > 0 {
+		return v.missingParents, nil, nil
+	}
+
+	// Prevent a single peer from churning the pool with low-fee
+	// transactions.
+	if rateLimit && peer != nil {
+		err := mp.checkPeerRateLimit(tx,
+			v.fee+mp.feeDeltas[*txHash], *peer)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Now that the transaction has passed all the checks, evict the
+	// transactions it replaces from the pool.
+	rollingMinFee := mp.rollingMinFee
+	lastRollingFeeUpdate := mp.lastRollingFeeUpdate
+	var replaced []*TxDesc
+	if len(v.conflicts) > 0 {
+		replaced = mp.replaceConflicts(tx, v.conflicts)
+	}
 
 	// Add to transaction pool.
-	txD := mp.addTransaction(utxoView, tx, bestHeight, txFee)
+	txD := mp.addTransaction(v.utxoView, tx, v.bestHeight, v.fee)
+
+	// Evict the lowest fee rate transactions if the pool is now over its
+	// size limit.  Reject the transaction when it was evicted itself, and
+	// restore what it replaced and what was evicted along with it, since
+	// the pool fit within the limit without it.
+	evicted := mp.trimToSize()
+	if !mp.isTransactionInPool(txHash) {
+		restore := make([]*TxDesc, 0, len(replaced)+len(evicted))
+		restore = append(restore, replaced...)
+		for _, evictedDesc := range evicted {
+			if evictedDesc != txD {
+				restore = append(restore, evictedDesc)
+			}
+		}
+		mp.restoreTransactions(restore)
+		mp.rollingMinFee = rollingMinFee
+		mp.lastRollingFeeUpdate = lastRollingFeeUpdate
+
+		str := fmt.Sprintf("transaction %v was not accepted because "+
+			"the mempool is full", txHash)
+		return nil, nil, txRuleError(wire.RejectInsufficientFee, str)
+	}
+
+	if len(replaced) > 0 && mp.cfg.OnTxReplaced != nil {
+		mp.cfg.OnTxReplaced(replaced, txD)
+	}
 
 	log.Debugf("Accepted transaction %v (pool size: %v)", txHash,
 		len(mp.pool))
@@ -933,7 +1506,8 @@ func (mp *TxPool) maybeAcceptTransaction(tx *soterutil.Tx, isNew, rateLimit, rej
 func (mp *TxPool) MaybeAcceptTransaction(tx *soterutil.Tx, isNew, rateLimit bool) ([]*chainhash.Hash, *TxDesc, error) {
 	// Protect concurrent access.
 	mp.mtx.Lock()
-	hashes, txD, err := mp.maybeAcceptTransaction(tx, isNew, rateLimit, true)
+	hashes, txD, err := mp.maybeAcceptTransaction(tx, isNew, rateLimit, true,
+		nil)
 	mp.mtx.Unlock()
 
 	return hashes, txD, err
@@ -973,10 +1547,15 @@ func (mp *TxPool) processOrphans(acceptedTx *soterutil.Tx) []*TxDesc {
 				continue
 			}
 
-			// Potentially accept an orphan into the tx pool.
+			// Potentially accept an orphan into the tx pool,
+			// rate limiting the peer it originates from.
 			for _, tx := range orphans {
+				var peer *Tag
+				if otx, ok := mp.orphans[*tx.Hash()]; ok {
+					peer = &otx.tag
+				}
 				missing, txD, err := mp.maybeAcceptTransaction(
-					tx, true, true, false)
+					tx, true, true, false, peer)
 				if err != nil {
 					// The orphan is now invalid, so there
 					// is no way any other orphans which
@@ -1050,6 +1629,11 @@ func (mp *TxPool) ProcessOrphans(acceptedTx *soterutil.Tx) []*TxDesc {
 // with any additional orphan transaactions that were added as a result of
 // the passed one being accepted.
 //
+// When rateLimit is set, free and low-fee transactions are rate limited both
+// globally and per the peer identified by the passed tag.
+//
+// The decision about the transaction is logged and traced, see DecisionTrace.
+//
 // This function is safe for concurrent access.
 func (mp *TxPool) ProcessTransaction(tx *soterutil.Tx, allowOrphan, rateLimit bool, tag Tag) ([]*TxDesc, error) {
 	log.Tracef("Processing transaction %v", tx.Hash())
@@ -1058,10 +1642,33 @@ func (mp *TxPool) ProcessTransaction(tx *soterutil.Tx, allowOrphan, rateLimit bo
 	mp.mtx.Lock()
 	defer mp.mtx.Unlock()
 
+	acceptedTxs, err := mp.processTransaction(tx, allowOrphan, rateLimit,
+		tag)
+	mp.traceProcessed(tx, tag, acceptedTxs, err)
+	return acceptedTxs, err
+}
+
+// processTransaction is the internal function which implements the public
+// ProcessTransaction.  See the comment for ProcessTransaction for more
+// details.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) processTransaction(tx *soterutil.Tx, allowOrphan, rateLimit bool, tag Tag) ([]*TxDesc, error) {
+	// Don't validate transactions which were recently rejected again as
+	// long as the rejection still applies.
+	if err := mp.cachedReject(tx); err != nil {
+		if mp.cfg.Metrics != nil {
+			code, _ := ErrToRejectErr(err)
+			mp.cfg.Metrics.TxRejected(code)
+		}
+		return nil, err
+	}
+
 	// Potentially accept the transaction to the memory pool.
 	missingParents, txD, err := mp.maybeAcceptTransaction(tx, true, rateLimit,
-		true)
+		true, &tag)
 	if err != nil {
+		mp.recordReject(tx, err, tag)
 		return nil, err
 	}
 
@@ -1078,6 +1685,7 @@ func (mp *TxPool) ProcessTransaction(tx *soterutil.Tx, allowOrphan, rateLimit bo
 		acceptedTxs[0] = txD
 		copy(acceptedTxs[1:], newTxs)
 
+		mp.rejects.remove(tx.Hash())
 		return acceptedTxs, nil
 	}
 
@@ -1096,11 +1704,16 @@ func (mp *TxPool) ProcessTransaction(tx *soterutil.Tx, allowOrphan, rateLimit bo
 		str := fmt.Sprintf("orphan transaction %v references "+
 			"outputs of unknown or fully-spent "+
 			"transaction %v", tx.Hash(), missingParents[0])
-		return nil, txRuleError(wire.RejectDuplicate, str)
+		err := txRuleError(wire.RejectDuplicate, str)
+		mp.recordReject(tx, err, tag)
+		return nil, err
 	}
 
 	// Potentially add the orphan transaction to the orphan pool.
 	err = mp.maybeAddOrphan(tx, tag)
+	if err != nil {
+		mp.recordReject(tx, err, tag)
+	}
 	return nil, err
 }
 
@@ -1152,19 +1765,20 @@ func (mp *TxPool) TxDescs() []*TxDesc {
 }
 
 // MiningDescs returns a slice of mining descriptors for all the transactions
-// in the pool.
+// in the pool.  The descriptors are taken from a snapshot of the pool, so they
+// are not modified as the pool changes.
 //
 // This is part of the mining.TxSource interface implementation and is safe for
 // concurrent access as required by the interface contract.
 func (mp *TxPool) MiningDescs() []*miningdag.TxDesc {
-	mp.mtx.RLock()
-	descs := make([]*miningdag.TxDesc, len(mp.pool))
-	i := 0
-	for _, desc := range mp.pool {
-		descs[i] = &desc.TxDesc
-		i++
-	}
-	mp.mtx.RUnlock()
+	snapshot := mp.Snapshot()
+	descs := make([]*miningdag.TxDesc, 0, snapshot.Count())
+	snapshot.ForEach(func(desc *TxDesc) bool {
+		if !snapshot.IsConflicted(desc.Tx.Hash()) {
+			descs = append(descs, &desc.TxDesc)
+		}
+		return true
+	})
 
 	return descs
 }
@@ -1187,7 +1801,7 @@ func (mp *TxPool) RawMempoolVerbose() map[string]*soterjson.GetRawMempoolVerbose
 		// input transactions can't be found for some reason.
 		tx := desc.Tx
 		var currentPriority float64
-		utxos, err := mp.fetchInputUtxos(tx)
+		utxos, err := mp.fetchInputUtxos(tx, false)
 		if err == nil {
 			currentPriority = miningdag.CalcPriority(tx.MsgTx(), utxos,
 				bestHeight+1)
@@ -1226,14 +1840,38 @@ func (mp *TxPool) LastUpdated() time.Time {
 }
 
 // New returns a new memory pool for validating and storing standalone
-// transactions until they are mined into a block.
-func New(cfg *Config) *TxPool {
-	return &TxPool{
+// transactions until they are mined into a block.  An error is returned when
+// the policy of the configuration fails Policy.Validate.
+func New(cfg *Config) (*TxPool, error) {
+	if err := cfg.Policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	mp := &TxPool{
 		cfg:            *cfg,
 		pool:           make(map[chainhash.Hash]*TxDesc),
 		orphans:        make(map[chainhash.Hash]*orphanTx),
 		orphansByPrev:  make(map[wire.OutPoint]map[chainhash.Hash]*soterutil.Tx),
+		orphansByTag:   make(map[Tag]map[chainhash.Hash]*orphanTx),
 		nextExpireScan: time.Now().Add(orphanExpireScanInterval),
 		outpoints:      make(map[wire.OutPoint]*soterutil.Tx),
+
+		orphanBytesByTag:     make(map[Tag]int64),
+		lastRollingFeeUpdate: time.Now(),
+		nextRevalidation:     time.Now(),
+		subscriptions:        make(map[*TxSubscription]struct{}),
+		rejects:              newRejectCache(),
+		utxoCache:            newUtxoCache(),
+		decisions:            newDecisionTrace(cfg.DecisionTraceSize),
+		feeDeltas:            make(map[chainhash.Hash]int64),
+		peerRates:            make(map[Tag]*peerRateLimit),
+		conflicted:           make(map[chainhash.Hash]*txConflict),
+		confirmedTxns:        make(map[chainhash.Hash]blockConfirmations),
 	}
+
+	if cfg.SubscribeDAG != nil {
+		cfg.SubscribeDAG(mp.handleDAGNotification)
+	}
+
+	return mp, nil
 }
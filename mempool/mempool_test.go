@@ -6,6 +6,7 @@
 package mempool
 
 import (
+	"bytes"
 	"encoding/hex"
 	"reflect"
 	"runtime"
@@ -30,6 +31,8 @@ type fakeChain struct {
 	utxos          *blockdag.UtxoViewpoint
 	currentHeight  int32
 	medianTimePast time.Time
+	order          []*chainhash.Hash
+	callbacks      []blockdag.NotificationCallback
 }
 
 // FetchUtxoView loads utxo details about the inputs referenced by the passed
@@ -79,6 +82,46 @@ func (s *fakeChain) SetHeight(height int32) {
 	s.Unlock()
 }
 
+// DAGOrdering returns the ordering of the blocks of the fake chain instance.
+func (s *fakeChain) DAGOrdering() []*chainhash.Hash {
+	s.RLock()
+	order := s.order
+	s.RUnlock()
+	return order
+}
+
+// AddOrderedBlocks appends the passed blocks to the ordering of the fake chain
+// instance.
+func (s *fakeChain) AddOrderedBlocks(blocks ...chainhash.Hash) {
+	s.Lock()
+	order := make([]*chainhash.Hash, len(s.order), len(s.order)+len(blocks))
+	copy(order, s.order)
+	for i := range blocks {
+		order = append(order, &blocks[i])
+	}
+	s.order = order
+	s.Unlock()
+}
+
+// Subscribe registers the passed callback for the notifications sent via
+// Notify.
+func (s *fakeChain) Subscribe(callback blockdag.NotificationCallback) {
+	s.Lock()
+	s.callbacks = append(s.callbacks, callback)
+	s.Unlock()
+}
+
+// Notify sends a notification with the passed type and data to the registered
+// callbacks.
+func (s *fakeChain) Notify(typ blockdag.NotificationType, data interface{}) {
+	s.RLock()
+	callbacks := s.callbacks
+	s.RUnlock()
+	for _, callback := range callbacks {
+		callback(&blockdag.Notification{Type: typ, Data: data})
+	}
+}
+
 // MedianTimePast returns the current median time past associated with the fake
 // chain instance.
 func (s *fakeChain) MedianTimePast() time.Time {
@@ -232,6 +275,65 @@ func (p *poolHarness) CreateSignedTx(inputs []spendableOutput, numOutputs uint32
 	return soterutil.NewTx(tx), nil
 }
 
+// CreateSignedTxWithFee creates a new signed transaction that consumes the
+// provided inputs using the provided sequence number, and pays the total input
+// amount minus the provided fee to the payment script associated with the
+// harness using a single output.
+func (p *poolHarness) CreateSignedTxWithFee(inputs []spendableOutput, fee soterutil.Amount, sequence uint32) (*soterutil.Tx, error) {
+	var totalInput soterutil.Amount
+	for _, input := range inputs {
+		totalInput += input.amount
+	}
+
+	tx := wire.NewMsgTx(wire.TxVersion)
+	for _, input := range inputs {
+		tx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: input.outPoint,
+			SignatureScript:  nil,
+			Sequence:         sequence,
+		})
+	}
+	tx.AddTxOut(&wire.TxOut{
+		PkScript: p.payScript,
+		Value:    int64(totalInput - fee),
+	})
+
+	// Sign the new transaction.
+	for i := range tx.TxIn {
+		sigScript, err := txscript.SignatureScript(tx, i, p.payScript,
+			txscript.SigHashAll, p.signKey, true)
+		if err != nil {
+			return nil, err
+		}
+		tx.TxIn[i].SignatureScript = sigScript
+	}
+
+	return soterutil.NewTx(tx), nil
+}
+
+// CreateConfirmedOutputs returns the requested number of outputs confirmed by
+// the fake chain, which are created by a transaction spending the provided
+// outputs.  This is useful for tests needing several unrelated outputs.
+func (p *poolHarness) CreateConfirmedOutputs(inputs []spendableOutput, numOutputs uint32) ([]spendableOutput, error) {
+	tx, err := p.CreateSignedTx(inputs, numOutputs)
+	if err != nil {
+		return nil, err
+	}
+
+	p.chain.Lock()
+	for _, input := range inputs {
+		p.chain.utxos.LookupEntry(input.outPoint).Spend()
+	}
+	p.chain.utxos.AddTxOuts(tx, p.chain.currentHeight)
+	p.chain.Unlock()
+
+	outputs := make([]spendableOutput, 0, numOutputs)
+	for i := uint32(0); i < numOutputs; i++ {
+		outputs = append(outputs, txOutToSpendableOut(tx, i))
+	}
+	return outputs, nil
+}
+
 // CreateTxChain creates a chain of zero-fee transactions (each subsequent
 // transaction spends the entire amount from the previous one) with the first
 // one spending the provided outpoint.  Each transaction spends the entire
@@ -308,25 +410,34 @@ func newPoolHarness(chainParams *chaincfg.Params) (*poolHarness, []spendableOutp
 		chainParams: chainParams,
 
 		chain: chain,
-		txPool: New(&Config{
-			Policy: Policy{
-				DisableRelayPriority: true,
-				FreeTxRelayLimit:     15.0,
-				MaxOrphanTxs:         5,
-				MaxOrphanTxSize:      1000,
-				MaxSigOpCostPerTx:    blockdag.MaxBlockSigOpsCost / 4,
-				MinRelayTxFee:        1000, // 1 nanoSoter per byte
-				MaxTxVersion:         1,
-			},
-			ChainParams:      chainParams,
-			FetchUtxoView:    chain.FetchUtxoView,
-			BestHeight:       chain.BestHeight,
-			MedianTimePast:   chain.MedianTimePast,
-			CalcSequenceLock: chain.CalcSequenceLock,
-			SigCache:         nil,
-			AddrIndex:        nil,
-		}),
 	}
+	txPool, err := New(&Config{
+		// The tests predate the feerate-only admission
+		// and mostly use transactions which pay no fees.
+		Policy: Policy{
+			LegacyFreeTxRelay:    true,
+			DisableRelayPriority: true,
+			FreeTxRelayLimit:     15.0,
+			MaxOrphanTxs:         5,
+			MaxOrphanTxSize:      1000,
+			MaxSigOpCostPerTx:    blockdag.MaxBlockSigOpsCost / 4,
+			MinRelayTxFee:        1000, // 1 nanoSoter per byte
+			MaxTxVersion:         1,
+		},
+		ChainParams:      chainParams,
+		FetchUtxoView:    chain.FetchUtxoView,
+		BestHeight:       chain.BestHeight,
+		DAGOrdering:      chain.DAGOrdering,
+		SubscribeDAG:     chain.Subscribe,
+		MedianTimePast:   chain.MedianTimePast,
+		CalcSequenceLock: chain.CalcSequenceLock,
+		SigCache:         nil,
+		AddrIndex:        nil,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	harness.txPool = txPool
 
 	// Create a single coinbase transaction and add it to the harness
 	// chain's utxo set and set the harness chain height such that the
@@ -867,3 +978,1846 @@ func TestCheckSpend(t *testing.T) {
 		t.Fatalf("Unexpeced spend found in pool: %v", spend)
 	}
 }
+
+// TestReplacement ensures that transactions double spending other transactions
+// in the pool are handled according to the replacement policy.
+func TestReplacement(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+
+	var replacedTxns []*TxDesc
+	harness.txPool.cfg.OnTxReplaced = func(replaced []*TxDesc, replacement *TxDesc) {
+		replacedTxns = replaced
+	}
+
+	// Add a transaction that signals replacement, along with a child
+	// spending it.
+	original, err := harness.CreateSignedTxWithFee(outputs[:1], 1000,
+		MaxRBFSequence)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	child, err := harness.CreateSignedTxWithFee(
+		[]spendableOutput{txOutToSpendableOut(original, 0)}, 1000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	for _, tx := range []*soterutil.Tx{original, child} {
+		_, err := harness.txPool.ProcessTransaction(tx, false, false, 0)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept tx: %v",
+				err)
+		}
+	}
+
+	// A replacement that doesn't pay more than the transactions it evicts
+	// must be rejected.
+	lowFee, err := harness.CreateSignedTxWithFee(outputs[:1], 1500,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(lowFee, false, false, 0)
+	if err == nil {
+		t.Fatalf("ProcessTransaction: accepted underpaying replacement")
+	}
+	testPoolMembership(tc, original, false, true)
+	testPoolMembership(tc, child, false, true)
+
+	// A replacement paying enough must evict the original transaction and
+	// its descendants.
+	replacement, err := harness.CreateSignedTxWithFee(outputs[:1], 5000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(replacement, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept replacement: %v",
+			err)
+	}
+	testPoolMembership(tc, original, false, false)
+	testPoolMembership(tc, child, false, false)
+	testPoolMembership(tc, replacement, false, true)
+	if len(replacedTxns) != 2 {
+		t.Fatalf("expected 2 replaced transactions, got %d",
+			len(replacedTxns))
+	}
+
+	// The replacement doesn't signal replaceability, so it can't be
+	// replaced itself unless full replacement is enabled.
+	secondReplacement, err := harness.CreateSignedTxWithFee(outputs[:1],
+		10000, wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(secondReplacement, false,
+		false, 0)
+	if err == nil {
+		t.Fatalf("ProcessTransaction: replaced non-signaling tx")
+	}
+
+	harness.txPool.cfg.Policy.FullReplacement = true
+	_, err = harness.txPool.ProcessTransaction(secondReplacement, false,
+		false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept full "+
+			"replacement: %v", err)
+	}
+	testPoolMembership(tc, replacement, false, false)
+	testPoolMembership(tc, secondReplacement, false, true)
+
+	// No replacements are allowed when they are rejected by policy.
+	harness.txPool.cfg.Policy.RejectReplacement = true
+	thirdReplacement, err := harness.CreateSignedTxWithFee(outputs[:1],
+		20000, MaxRBFSequence)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(thirdReplacement, false,
+		false, 0)
+	if err == nil {
+		t.Fatalf("ProcessTransaction: accepted replacement rejected " +
+			"by policy")
+	}
+	testPoolMembership(tc, secondReplacement, false, true)
+}
+
+// TestAncestorLimits ensures that the ancestor and descendant statistics of the
+// transactions in the pool are tracked, and that transactions exceeding the
+// configured limits are rejected.
+func TestAncestorLimits(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+
+	const maxAncestors = 3
+	harness.txPool.cfg.Policy.MaxAncestorCount = maxAncestors
+	chainedTxns, err := harness.CreateTxChain(outputs[0], maxAncestors+1)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+
+	// Accept the transactions up to the ancestor limit.
+	for _, tx := range chainedTxns[:maxAncestors] {
+		_, err := harness.txPool.ProcessTransaction(tx, false, false, 0)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept tx: %v",
+				err)
+		}
+	}
+
+	// Ensure the statistics match the chain of transactions.
+	for i, tx := range chainedTxns[:maxAncestors] {
+		ancestors, err := harness.txPool.AncestorStats(tx.Hash())
+		if err != nil {
+			t.Fatalf("AncestorStats: unexpected error: %v", err)
+		}
+		if ancestors.Count != i+1 {
+			t.Fatalf("AncestorStats: tx %d has %d ancestors, want %d",
+				i, ancestors.Count, i+1)
+		}
+
+		descendants, err := harness.txPool.DescendantStats(tx.Hash())
+		if err != nil {
+			t.Fatalf("DescendantStats: unexpected error: %v", err)
+		}
+		if descendants.Count != maxAncestors-i {
+			t.Fatalf("DescendantStats: tx %d has %d descendants, "+
+				"want %d", i, descendants.Count, maxAncestors-i)
+		}
+	}
+
+	// The next transaction in the chain exceeds the ancestor limit.
+	_, err = harness.txPool.ProcessTransaction(chainedTxns[maxAncestors],
+		false, false, 0)
+	if err == nil {
+		t.Fatalf("ProcessTransaction: accepted tx exceeding the " +
+			"ancestor limit")
+	}
+	testPoolMembership(tc, chainedTxns[maxAncestors], false, false)
+
+	// Removing the first transaction, such as when it is mined, must update
+	// the statistics of its descendants and allow the chain to grow again.
+	harness.txPool.RemoveTransaction(chainedTxns[0], false)
+	ancestors, err := harness.txPool.AncestorStats(chainedTxns[maxAncestors-1].Hash())
+	if err != nil {
+		t.Fatalf("AncestorStats: unexpected error: %v", err)
+	}
+	if ancestors.Count != maxAncestors-1 {
+		t.Fatalf("AncestorStats: got %d ancestors after removal, want %d",
+			ancestors.Count, maxAncestors-1)
+	}
+	_, err = harness.txPool.ProcessTransaction(chainedTxns[maxAncestors],
+		false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept tx: %v", err)
+	}
+}
+
+// TestPoolSizeEviction ensures that the lowest fee rate transactions are
+// evicted once the pool exceeds its size limit, and that the minimum fee is
+// raised accordingly.
+func TestPoolSizeEviction(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+
+	// Create a parent transaction with two outputs, and two children
+	// spending them with different fee rates.
+	parent, err := harness.CreateSignedTx(outputs, 2)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	lowFeeChild, err := harness.CreateSignedTxWithFee(
+		[]spendableOutput{txOutToSpendableOut(parent, 0)}, 1000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	highFeeChild, err := harness.CreateSignedTxWithFee(
+		[]spendableOutput{txOutToSpendableOut(parent, 1)}, 50000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+
+	// Limit the pool so that all three transactions don't fit.
+	harness.txPool.cfg.Policy.MaxPoolSize = txMemoryUsage(parent) +
+		txMemoryUsage(lowFeeChild) + txMemoryUsage(highFeeChild) - 1
+
+	for _, tx := range []*soterutil.Tx{parent, lowFeeChild, highFeeChild} {
+		_, err := harness.txPool.ProcessTransaction(tx, false, false, 0)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept tx: %v",
+				err)
+		}
+	}
+
+	// The low fee child must have been evicted, while the parent is kept
+	// since the high fee child pays for it.
+	testPoolMembership(tc, parent, false, true)
+	testPoolMembership(tc, lowFeeChild, false, false)
+	testPoolMembership(tc, highFeeChild, false, true)
+
+	// The minimum fee must exceed the fee rate of the evicted transaction,
+	// so it can't be accepted again.
+	lowFeeRate := soterutil.Amount(1000 * 1000 / GetTxVirtualSize(lowFeeChild))
+	if minFee := harness.txPool.MinFee(); minFee <= lowFeeRate {
+		t.Fatalf("MinFee: got %v, want more than %v", minFee, lowFeeRate)
+	}
+	_, err = harness.txPool.ProcessTransaction(lowFeeChild, false, false, 0)
+	if err == nil {
+		t.Fatalf("ProcessTransaction: accepted tx below the minimum fee")
+	}
+
+	// The rolling minimum fee must decay over time.
+	harness.txPool.mtx.Lock()
+	harness.txPool.lastRollingFeeUpdate = time.Now().Add(-rollingFeeHalfLife * 10)
+	harness.txPool.mtx.Unlock()
+	if minFee := harness.txPool.MinFee(); minFee != harness.txPool.cfg.Policy.MinRelayTxFee {
+		t.Fatalf("MinFee: got %v after decay, want %v", minFee,
+			harness.txPool.cfg.Policy.MinRelayTxFee)
+	}
+}
+
+// TestReplacementPoolFull ensures a replacement which doesn't fit within the
+// pool size limit is rejected without evicting the transactions it conflicts
+// with or raising the minimum fee.
+func TestReplacementPoolFull(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+	outputs, err = harness.CreateConfirmedOutputs(outputs, 3)
+	if err != nil {
+		t.Fatalf("unable to create confirmed outputs: %v", err)
+	}
+
+	var replacedTxns []*TxDesc
+	harness.txPool.cfg.OnTxReplaced = func(replaced []*TxDesc, replacement *TxDesc) {
+		replacedTxns = replaced
+	}
+
+	original, err := harness.CreateSignedTxWithFee(outputs[:1], 1000,
+		MaxRBFSequence)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	other, err := harness.CreateSignedTxWithFee(outputs[1:2], 50000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+
+	// The replacement spends another input, so it's larger than the
+	// original and doesn't fit in a pool limited to the two transactions,
+	// while its fee rate is the lowest of the pool.
+	replacement, err := harness.CreateSignedTxWithFee(
+		[]spendableOutput{outputs[0], outputs[2]}, 5000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	harness.txPool.cfg.Policy.MaxPoolSize = txMemoryUsage(original) +
+		txMemoryUsage(other)
+
+	for _, tx := range []*soterutil.Tx{original, other} {
+		_, err := harness.txPool.ProcessTransaction(tx, false, false, 0)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept tx: %v",
+				err)
+		}
+	}
+	minFee := harness.txPool.MinFee()
+
+	_, err = harness.txPool.ProcessTransaction(replacement, false, false, 0)
+	if err == nil {
+		t.Fatalf("ProcessTransaction: accepted replacement exceeding " +
+			"the pool size limit")
+	}
+	testPoolMembership(tc, replacement, false, false)
+	testPoolMembership(tc, original, false, true)
+	testPoolMembership(tc, other, false, true)
+	if replacedTxns != nil {
+		t.Fatalf("OnTxReplaced called for a rejected replacement")
+	}
+	if got := harness.txPool.MinFee(); got != minFee {
+		t.Fatalf("MinFee: got %v after the rejection, want %v", got,
+			minFee)
+	}
+	if usage := harness.txPool.GetMemoryUsage(); usage !=
+		harness.txPool.cfg.Policy.MaxPoolSize {
+
+		t.Fatalf("GetMemoryUsage: got %d after the rejection, want %d",
+			usage, harness.txPool.cfg.Policy.MaxPoolSize)
+	}
+}
+
+// TestExpiryAndRevalidation ensures that expired transactions and transactions
+// spending outputs which are no longer available are removed from the pool
+// along with their descendants, and that the removals are reported.
+func TestExpiryAndRevalidation(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+
+	removed := make(map[chainhash.Hash]RemovalReason)
+	harness.txPool.cfg.OnTxRemoved = func(txDesc *TxDesc, reason RemovalReason) {
+		removed[*txDesc.Tx.Hash()] = reason
+	}
+
+	chainedTxns, err := harness.CreateTxChain(outputs[0], 2)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+	for _, tx := range chainedTxns {
+		_, err := harness.txPool.ProcessTransaction(tx, false, false, 0)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept tx: %v",
+				err)
+		}
+	}
+
+	// Nothing expires without an expiry policy, and nothing has expired
+	// yet with one.
+	if txns := harness.txPool.RemoveExpired(); len(txns) != 0 {
+		t.Fatalf("RemoveExpired: removed %d transactions without an "+
+			"expiry policy", len(txns))
+	}
+	harness.txPool.cfg.Policy.Expiry = DefaultExpiry
+	if txns := harness.txPool.RemoveExpired(); len(txns) != 0 {
+		t.Fatalf("RemoveExpired: removed %d unexpired transactions",
+			len(txns))
+	}
+
+	// Make the first transaction expire, which must remove its descendant
+	// as well.
+	harness.txPool.mtx.Lock()
+	harness.txPool.pool[*chainedTxns[0].Hash()].Added =
+		time.Now().Add(-DefaultExpiry - time.Minute)
+	harness.txPool.mtx.Unlock()
+	if txns := harness.txPool.RemoveExpired(); len(txns) != 2 {
+		t.Fatalf("RemoveExpired: removed %d transactions, want 2",
+			len(txns))
+	}
+	for _, tx := range chainedTxns {
+		testPoolMembership(tc, tx, false, false)
+		if reason, ok := removed[*tx.Hash()]; !ok || reason != RemovalExpiry {
+			t.Fatalf("expected %v to be removed due to expiry, got %v",
+				tx.Hash(), reason)
+		}
+	}
+
+	// Add the chain again, then spend its input from the fake chain as if
+	// a block on another branch of the DAG spent it.
+	for _, tx := range chainedTxns {
+		_, err := harness.txPool.ProcessTransaction(tx, false, false, 0)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept tx: %v",
+				err)
+		}
+	}
+	harness.chain.Lock()
+	harness.chain.utxos.LookupEntry(outputs[0].outPoint).Spend()
+	harness.chain.Unlock()
+
+	txns, err := harness.txPool.Revalidate()
+	if err != nil {
+		t.Fatalf("Revalidate: unexpected error: %v", err)
+	}
+	if len(txns) != 2 {
+		t.Fatalf("Revalidate: removed %d transactions, want 2", len(txns))
+	}
+	for _, tx := range chainedTxns {
+		testPoolMembership(tc, tx, false, false)
+		if reason := removed[*tx.Hash()]; reason != RemovalConflict {
+			t.Fatalf("expected %v to be removed due to a conflict, "+
+				"got %v", tx.Hash(), reason)
+		}
+	}
+}
+
+// TestCheckTransaction ensures that checking transactions and packages reports
+// the correct verdicts without modifying the pool.
+func TestCheckTransaction(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+
+	parent, err := harness.CreateSignedTxWithFee(outputs[:1], 1000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	child, err := harness.CreateSignedTxWithFee(
+		[]spendableOutput{txOutToSpendableOut(parent, 0)}, 2000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	doubleSpend, err := harness.CreateSignedTxWithFee(outputs[:1], 3000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+
+	// The parent is valid on its own and reports its fee.
+	result := harness.txPool.CheckTransaction(parent)
+	if !result.Accepted || result.Err != nil {
+		t.Fatalf("CheckTransaction: parent rejected: %v", result.Err)
+	}
+	if result.Fee != 1000 {
+		t.Fatalf("CheckTransaction: unexpected fee: got %d, want %d",
+			result.Fee, 1000)
+	}
+
+	// The child is an orphan on its own.
+	result = harness.txPool.CheckTransaction(child)
+	if result.Accepted || len(result.MissingParents) != 1 ||
+		*result.MissingParents[0] != *parent.Hash() {
+
+		t.Fatalf("CheckTransaction: child not reported as orphan of "+
+			"parent: %+v", result)
+	}
+
+	// The child is valid as part of a package with its parent, while the
+	// double spend of the parent in the same package is not.
+	results := harness.txPool.CheckPackage([]*soterutil.Tx{parent, child,
+		doubleSpend})
+	if len(results) != 3 {
+		t.Fatalf("CheckPackage: got %d results, want 3", len(results))
+	}
+	for i, tx := range []*soterutil.Tx{parent, child} {
+		if !results[i].Accepted {
+			t.Fatalf("CheckPackage: tx %v rejected: %v", tx.Hash(),
+				results[i].Err)
+		}
+	}
+	if results[2].Accepted || results[2].RejectCode != wire.RejectDuplicate {
+		t.Fatalf("CheckPackage: double spend not rejected as a "+
+			"duplicate: %+v", results[2])
+	}
+
+	// None of the checks may have modified the pool.
+	for _, tx := range []*soterutil.Tx{parent, child, doubleSpend} {
+		testPoolMembership(tc, tx, false, false)
+	}
+	if count := harness.txPool.Count(); count != 0 {
+		t.Fatalf("pool contains %d transactions after checks", count)
+	}
+}
+
+// TestOrphanByteLimits ensures that the orphan pool enforces its per-tag and
+// total byte limits by evicting orphans of the offending tag first.
+func TestOrphanByteLimits(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+
+	chainedTxns, err := harness.CreateTxChain(outputs[0], 6)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+
+	// Allow two orphans per tag and three in total.
+	var maxSize int64
+	for _, tx := range chainedTxns {
+		if size := int64(tx.MsgTx().SerializeSize()); size > maxSize {
+			maxSize = size
+		}
+	}
+	harness.txPool.cfg.Policy.MaxOrphanTxs = 10
+	harness.txPool.cfg.Policy.MaxOrphanBytesPerTag = 2 * maxSize
+	harness.txPool.cfg.Policy.MaxOrphanBytes = 3 * maxSize
+
+	// Add orphans to the pool with the provided tag, ensuring each one is
+	// accepted into the orphan pool.
+	addOrphans := func(txns []*soterutil.Tx, tag Tag) {
+		for _, tx := range txns {
+			_, err := harness.txPool.ProcessTransaction(tx, true,
+				false, tag)
+			if err != nil {
+				t.Fatalf("ProcessTransaction: failed to accept "+
+					"valid orphan %v", err)
+			}
+			testPoolMembership(tc, tx, true, false)
+		}
+	}
+	numTagged := func(tag Tag) int {
+		harness.txPool.mtx.RLock()
+		defer harness.txPool.mtx.RUnlock()
+		return len(harness.txPool.orphansByTag[tag])
+	}
+
+	// A third orphan from the same tag must evict one of the tag's own
+	// orphans.
+	addOrphans(chainedTxns[1:4], 1)
+	if n := numTagged(1); n != 2 {
+		t.Fatalf("unexpected number of orphans for tag 1 -- got %d, "+
+			"want 2", n)
+	}
+
+	// Orphans from another tag fit until the total limit is reached, at
+	// which point the orphans of the heaviest tag are evicted.
+	addOrphans(chainedTxns[4:6], 2)
+	if n := numTagged(1); n != 1 {
+		t.Fatalf("unexpected number of orphans for tag 1 -- got %d, "+
+			"want 1", n)
+	}
+	if n := numTagged(2); n != 2 {
+		t.Fatalf("unexpected number of orphans for tag 2 -- got %d, "+
+			"want 2", n)
+	}
+
+	// The orphan spending the output of the previous transaction in the
+	// chain must be reported as blocked on it.
+	op := wire.OutPoint{Hash: *chainedTxns[4].Hash(), Index: 0}
+	orphans := harness.txPool.OrphansSpending(op)
+	if len(orphans) != 1 || *orphans[0].Hash() != *chainedTxns[5].Hash() {
+		t.Fatalf("OrphansSpending: unexpected orphans %v", orphans)
+	}
+}
+
+// TestSubscribe ensures that subscribers of the pool receive the events of the
+// transactions added to and removed from the pool in order.
+func TestSubscribe(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+
+	sub := harness.txPool.Subscribe()
+	defer sub.Unsubscribe()
+
+	// expectEvent waits for the next event of the subscription and ensures
+	// it matches the expected one.
+	expectEvent := func(eventType TxEventType, tx *soterutil.Tx, reason RemovalReason) {
+		select {
+		case event := <-sub.Events():
+			if event.Type != eventType ||
+				*event.TxDesc.Tx.Hash() != *tx.Hash() ||
+				(eventType == TxRemoved && event.Reason != reason) {
+
+				t.Fatalf("unexpected event %v for %v (reason %v), "+
+					"want %v for %v (reason %v)", event.Type,
+					event.TxDesc.Tx.Hash(), event.Reason,
+					eventType, tx.Hash(), reason)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("timeout waiting for %v event for %v", eventType,
+				tx.Hash())
+		}
+	}
+
+	chainedTxns, err := harness.CreateTxChain(outputs[0], 2)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+	for _, tx := range chainedTxns {
+		_, err := harness.txPool.ProcessTransaction(tx, false, false, 0)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept tx: %v",
+				err)
+		}
+	}
+	harness.txPool.ConfirmTransaction(chainedTxns[0], &chainhash.Hash{})
+	harness.txPool.RemoveTransaction(chainedTxns[1], false)
+
+	expectEvent(TxAdded, chainedTxns[0], 0)
+	expectEvent(TxAdded, chainedTxns[1], 0)
+	expectEvent(TxRemoved, chainedTxns[0], RemovalBlock)
+	expectEvent(TxRemoved, chainedTxns[1], RemovalManual)
+
+	// The events channel must be closed once unsubscribed.
+	sub.Unsubscribe()
+	select {
+	case _, ok := <-sub.Events():
+		if ok {
+			t.Fatalf("received event after unsubscribing")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("timeout waiting for the events channel to close")
+	}
+}
+
+// TestPackageMiningDescs ensures that the mining descriptors ordered by package
+// fee rate let a high fee child pay for its low fee parent while keeping
+// parents before their children.
+func TestPackageMiningDescs(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+
+	// Create a zero fee root transaction with two outputs.  The first one
+	// is spent by a low fee parent which has a high fee child, and the
+	// second one by a transaction paying a moderate fee.
+	root, err := harness.CreateSignedTx(outputs, 2)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	parent, err := harness.CreateSignedTxWithFee(
+		[]spendableOutput{txOutToSpendableOut(root, 0)}, 1000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	child, err := harness.CreateSignedTxWithFee(
+		[]spendableOutput{txOutToSpendableOut(parent, 0)}, 100000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	sibling, err := harness.CreateSignedTxWithFee(
+		[]spendableOutput{txOutToSpendableOut(root, 1)}, 20000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	txns := []*soterutil.Tx{root, parent, child, sibling}
+	for _, tx := range txns {
+		_, err := harness.txPool.ProcessTransaction(tx, false, false, 0)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept tx: %v",
+				err)
+		}
+	}
+
+	descs := harness.txPool.PackageMiningDescs()
+	if len(descs) != len(txns) {
+		t.Fatalf("PackageMiningDescs: got %d descriptors, want %d",
+			len(descs), len(txns))
+	}
+	for i, tx := range txns {
+		if *descs[i].Tx.Hash() != *tx.Hash() {
+			t.Fatalf("PackageMiningDescs: descriptor %d is %v, want %v",
+				i, descs[i].Tx.Hash(), tx.Hash())
+		}
+	}
+
+	// The parent is selected along with its child, so it inherits the fee
+	// rate of the package, while the sibling is selected on its own once
+	// the root is.
+	parentDesc, childDesc, siblingDesc := descs[1], descs[2], descs[3]
+	if parentDesc.PackageFeePerKB != childDesc.PackageFeePerKB ||
+		parentDesc.PackageFeePerKB <= parentDesc.FeePerKB {
+
+		t.Fatalf("PackageMiningDescs: parent package fee rate %d not "+
+			"inherited from child package fee rate %d",
+			parentDesc.PackageFeePerKB, childDesc.PackageFeePerKB)
+	}
+	if siblingDesc.PackageFeePerKB != siblingDesc.FeePerKB {
+		t.Fatalf("PackageMiningDescs: sibling package fee rate %d, "+
+			"want %d", siblingDesc.PackageFeePerKB,
+			siblingDesc.FeePerKB)
+	}
+}
+
+// TestConflictTracking ensures that transactions double spending transactions
+// confirmed by a block are kept in the pool as conflicted, without being handed
+// out for mining, until the block is ordered deep enough in the DAG, and become
+// pending again when the block is disconnected.
+func TestConflictTracking(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+
+	tx, err := harness.CreateSignedTxWithFee(outputs, 1000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(tx, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept tx: %v", err)
+	}
+	if state := harness.txPool.TxState(tx.Hash()).State; state != TxStatePending {
+		t.Fatalf("TxState: got %v, want %v", state, TxStatePending)
+	}
+
+	// Confirm a double spend of the transaction in a block on another tip
+	// of the DAG.
+	doubleSpend, err := harness.CreateSignedTxWithFee(outputs, 2000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	block := chainhash.Hash{0x01}
+	harness.chain.AddOrderedBlocks(block)
+	harness.txPool.ConfirmTransaction(doubleSpend, &block)
+
+	testPoolMembership(tc, tx, false, true)
+	if !harness.txPool.IsConflicted(tx.Hash()) {
+		t.Fatalf("IsConflicted: transaction is not conflicted")
+	}
+	if descs := harness.txPool.MiningDescs(); len(descs) != 0 {
+		t.Fatalf("MiningDescs: got %d descriptors for a conflicted "+
+			"transaction", len(descs))
+	}
+	if descs := harness.txPool.PackageMiningDescs(); len(descs) != 0 {
+		t.Fatalf("PackageMiningDescs: got %d descriptors for a "+
+			"conflicted transaction", len(descs))
+	}
+	info := harness.txPool.TxState(tx.Hash())
+	if info.State != TxStateConflicted {
+		t.Fatalf("TxState: got %v, want %v", info.State,
+			TxStateConflicted)
+	}
+	if len(info.ConflictsWith) != 1 ||
+		info.ConflictsWith[0] != *doubleSpend.Hash() {
+
+		t.Fatalf("TxState: conflicts with %v, want %v",
+			info.ConflictsWith, doubleSpend.Hash())
+	}
+	info = harness.txPool.TxState(doubleSpend.Hash())
+	if info.State != TxStateConfirmed || len(info.Blocks) != 1 ||
+		info.Blocks[0] != block {
+
+		t.Fatalf("TxState: got %v in blocks %v, want %v in block %v",
+			info.State, info.Blocks, TxStateConfirmed, block)
+	}
+
+	// Disconnecting the block makes the transaction pending again.
+	harness.txPool.DisconnectBlock(&block)
+	if state := harness.txPool.TxState(tx.Hash()).State; state != TxStatePending {
+		t.Fatalf("TxState: got %v, want %v", state, TxStatePending)
+	}
+	if state := harness.txPool.TxState(doubleSpend.Hash()).State; state != TxStateUnknown {
+		t.Fatalf("TxState: got %v, want %v", state, TxStateUnknown)
+	}
+	if descs := harness.txPool.MiningDescs(); len(descs) != 1 {
+		t.Fatalf("MiningDescs: got %d descriptors for a pending "+
+			"transaction, want 1", len(descs))
+	}
+
+	// The conflict is only resolved once enough blocks are ordered after
+	// the block in the DAG, regardless of their heights.
+	harness.txPool.ConfirmTransaction(doubleSpend, &block)
+	for i := 0; i < DefaultConflictResolutionDepth-1; i++ {
+		harness.chain.AddOrderedBlocks(chainhash.Hash{0x02, byte(i)})
+	}
+	if err := harness.txPool.Maintain(); err != nil {
+		t.Fatalf("Maintain: unexpected error: %v", err)
+	}
+	testPoolMembership(tc, tx, false, true)
+
+	harness.chain.AddOrderedBlocks(chainhash.Hash{0x03})
+	if err := harness.txPool.Maintain(); err != nil {
+		t.Fatalf("Maintain: unexpected error: %v", err)
+	}
+	testPoolMembership(tc, tx, false, false)
+	if state := harness.txPool.TxState(doubleSpend.Hash()).State; state != TxStateUnknown {
+		t.Fatalf("TxState: got %v, want %v", state, TxStateUnknown)
+	}
+}
+
+// TestPeerRateLimit ensures that the low-fee transactions relayed by a single
+// peer are rate limited independently of the other peers, and that the
+// counters of the rate limiter are reported.
+func TestPeerRateLimit(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+
+	// Limit each peer to 100 bytes of low-fee transactions, and consider
+	// all of the transactions below low-fee.
+	harness.txPool.cfg.Policy.PeerTxRelayLimit = 0.01
+	harness.txPool.cfg.Policy.PeerLowFeeRate = soterutil.Amount(1e6)
+
+	root, err := harness.CreateSignedTx(outputs, 3)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(root, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept tx: %v", err)
+	}
+	txns := make([]*soterutil.Tx, 3)
+	for i := range txns {
+		txns[i], err = harness.CreateSignedTxWithFee(
+			[]spendableOutput{txOutToSpendableOut(root, uint32(i))},
+			1000, wire.MaxTxInSequenceNum)
+		if err != nil {
+			t.Fatalf("unable to create transaction: %v", err)
+		}
+	}
+
+	// The first peer may relay a single transaction before reaching its
+	// limit, which doesn't affect the second peer.
+	_, err = harness.txPool.ProcessTransaction(txns[0], false, true, 1)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept tx: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(txns[1], false, true, 1)
+	if code, _ := extractRejectCode(err); code != wire.RejectInsufficientFee {
+		t.Fatalf("ProcessTransaction: got %v, want reject code %v", err,
+			wire.RejectInsufficientFee)
+	}
+	testPoolMembership(tc, txns[1], false, false)
+	_, err = harness.txPool.ProcessTransaction(txns[2], false, true, 2)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept tx: %v", err)
+	}
+
+	stats := harness.txPool.PeerRateStats()
+	if len(stats) != 2 {
+		t.Fatalf("PeerRateStats: got %d peers, want 2", len(stats))
+	}
+	if stats[0].Tag != 1 || stats[0].Accepted != 1 || stats[0].Rejected != 1 {
+		t.Fatalf("PeerRateStats: got %+v for the first peer, want 1 "+
+			"accepted and 1 rejected", stats[0])
+	}
+	if stats[1].Tag != 2 || stats[1].Accepted != 1 || stats[1].Rejected != 0 {
+		t.Fatalf("PeerRateStats: got %+v for the second peer, want 1 "+
+			"accepted", stats[1])
+	}
+
+	// The state of a peer is dropped once it disconnects.
+	harness.txPool.RemovePeerRateLimit(1)
+	if stats := harness.txPool.PeerRateStats(); len(stats) != 1 {
+		t.Fatalf("PeerRateStats: got %d peers, want 1", len(stats))
+	}
+}
+
+// TestPrioritiseTransaction ensures that fee deltas are applied to the fee
+// rates and package statistics of transactions in the pool without changing
+// their actual fees, and that they are persisted along with the transactions
+// by the mempool dump.
+func TestPrioritiseTransaction(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+
+	chainedTxns, err := harness.CreateTxChain(outputs[0], 2)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+	parent, child := chainedTxns[0], chainedTxns[1]
+
+	// A delta applied before the transaction is accepted takes effect once
+	// it is in the pool.
+	const parentDelta, childDelta = 5000, 20000
+	harness.txPool.PrioritiseTransaction(parent.Hash(), parentDelta)
+	for _, tx := range chainedTxns {
+		_, err := harness.txPool.ProcessTransaction(tx, false, false, 0)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept tx: %v",
+				err)
+		}
+	}
+	harness.txPool.PrioritiseTransaction(child.Hash(), childDelta/2)
+	harness.txPool.PrioritiseTransaction(child.Hash(), childDelta/2)
+
+	descs := make(map[chainhash.Hash]*TxDesc)
+	for _, txD := range harness.txPool.TxDescs() {
+		descs[*txD.Tx.Hash()] = txD
+	}
+	for _, test := range []struct {
+		tx       *soterutil.Tx
+		feeDelta int64
+	}{
+		{parent, parentDelta},
+		{child, childDelta},
+	} {
+		txD := descs[*test.tx.Hash()]
+		if txD.Fee != 0 || txD.FeeDelta != test.feeDelta {
+			t.Fatalf("transaction %v has fee %d and delta %d, want "+
+				"0 and %d", test.tx.Hash(), txD.Fee, txD.FeeDelta,
+				test.feeDelta)
+		}
+		wantFeePerKB := test.feeDelta * 1000 / GetTxVirtualSize(test.tx)
+		if txD.FeePerKB != wantFeePerKB {
+			t.Fatalf("transaction %v has fee rate %d, want %d",
+				test.tx.Hash(), txD.FeePerKB, wantFeePerKB)
+		}
+	}
+	stats, err := harness.txPool.DescendantStats(parent.Hash())
+	if err != nil {
+		t.Fatalf("DescendantStats: unexpected error: %v", err)
+	}
+	if stats.Fees != parentDelta+childDelta {
+		t.Fatalf("DescendantStats: got fees %d, want %d", stats.Fees,
+			parentDelta+childDelta)
+	}
+	stats, err = harness.txPool.AncestorStats(child.Hash())
+	if err != nil {
+		t.Fatalf("AncestorStats: unexpected error: %v", err)
+	}
+	if stats.Fees != parentDelta+childDelta {
+		t.Fatalf("AncestorStats: got fees %d, want %d", stats.Fees,
+			parentDelta+childDelta)
+	}
+
+	// Restore the dump into a new pool backed by the same chain.
+	var buf bytes.Buffer
+	if err := harness.txPool.Dump(&buf); err != nil {
+		t.Fatalf("Dump: unexpected error: %v", err)
+	}
+	restored, err := New(&harness.txPool.cfg)
+	if err != nil {
+		t.Fatalf("New: unexpected error: %v", err)
+	}
+	accepted, err := restored.LoadDump(&buf)
+	if err != nil {
+		t.Fatalf("LoadDump: unexpected error: %v", err)
+	}
+	if accepted != len(chainedTxns) {
+		t.Fatalf("LoadDump: accepted %d transactions, want %d",
+			accepted, len(chainedTxns))
+	}
+	if delta := restored.FeeDelta(child.Hash()); delta != childDelta {
+		t.Fatalf("FeeDelta: got %d, want %d", delta, childDelta)
+	}
+	stats, err = restored.AncestorStats(child.Hash())
+	if err != nil {
+		t.Fatalf("AncestorStats: unexpected error: %v", err)
+	}
+	if stats.Fees != parentDelta+childDelta {
+		t.Fatalf("AncestorStats: got fees %d, want %d", stats.Fees,
+			parentDelta+childDelta)
+	}
+
+	// The delta is dropped once the transaction is mined.
+	block := chainhash.Hash{0x01}
+	harness.txPool.ConfirmTransaction(parent, &block)
+	testPoolMembership(tc, parent, false, false)
+	if delta := harness.txPool.FeeDelta(parent.Hash()); delta != 0 {
+		t.Fatalf("FeeDelta: got %d after the transaction was mined, "+
+			"want 0", delta)
+	}
+}
+
+// TestRebroadcastManager ensures that locally submitted transactions are
+// re-announced with exponential backoff until they leave the pool.
+func TestRebroadcastManager(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+
+	tx, err := harness.CreateSignedTx(outputs, 1)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(tx, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept tx: %v", err)
+	}
+
+	var announced []*TxDesc
+	m := NewRebroadcastManager(&RebroadcastConfig{
+		Pool: harness.txPool,
+		Announce: func(txns []*TxDesc) {
+			announced = append(announced, txns...)
+		},
+		Interval:    time.Minute,
+		MaxInterval: time.Minute * 3,
+	})
+	start := time.Now()
+	m.Add(tx)
+	m.Add(tx)
+	if count := m.Count(); count != 1 {
+		t.Fatalf("Count: got %d tracked transactions, want 1", count)
+	}
+
+	// The announcements happen after 1, 2 and then 3 minutes, since the
+	// interval is capped.
+	tests := []struct {
+		elapsed  time.Duration
+		announce bool
+	}{
+		{time.Second * 30, false},
+		{time.Minute + time.Second, true},
+		{time.Minute * 2, false},
+		{time.Minute*3 + time.Second, true},
+		{time.Minute * 6, false},
+		{time.Minute*6 + time.Second*2, true},
+	}
+	for i, test := range tests {
+		announced = nil
+		m.rebroadcast(start.Add(test.elapsed))
+		if got := len(announced) == 1; got != test.announce {
+			t.Fatalf("#%d: announced %v after %v, want %v", i, got,
+				test.elapsed, test.announce)
+		}
+	}
+
+	// The transaction is no longer tracked once it leaves the pool.
+	harness.txPool.RemoveTransaction(tx, true)
+	announced = nil
+	m.rebroadcast(start.Add(time.Hour))
+	if len(announced) != 0 {
+		t.Fatalf("announced %d transactions which left the pool",
+			len(announced))
+	}
+	if count := m.Count(); count != 0 {
+		t.Fatalf("Count: got %d tracked transactions, want 0", count)
+	}
+}
+
+// TestMemoryUsage ensures that the estimated memory usage of the pool accounts
+// for the transactions along with their metadata and index entries.
+func TestMemoryUsage(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+
+	chainedTxns, err := harness.CreateTxChain(outputs[0], 3)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+	var want int64
+	for _, tx := range chainedTxns {
+		_, err := harness.txPool.ProcessTransaction(tx, false, false, 0)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept tx: %v",
+				err)
+		}
+
+		// The memory used by a transaction always exceeds its size.
+		usage := txMemoryUsage(tx)
+		if usage <= int64(tx.MsgTx().SerializeSize()) {
+			t.Fatalf("txMemoryUsage: got %d for a transaction of %d "+
+				"bytes", usage, tx.MsgTx().SerializeSize())
+		}
+		want += usage
+		if got := harness.txPool.GetMemoryUsage(); got != want {
+			t.Fatalf("GetMemoryUsage: got %d, want %d", got, want)
+		}
+	}
+
+	harness.txPool.RemoveTransaction(chainedTxns[0], true)
+	if got := harness.txPool.GetMemoryUsage(); got != 0 {
+		t.Fatalf("GetMemoryUsage: got %d after removing all of the "+
+			"transactions, want 0", got)
+	}
+}
+
+// TestSnapshot ensures that snapshots of the pool are immutable, and that they
+// are only taken again once the pool changes.
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+
+	chainedTxns, err := harness.CreateTxChain(outputs[0], 2)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(chainedTxns[0], false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept tx: %v", err)
+	}
+
+	first := harness.txPool.Snapshot()
+	if first.Count() != 1 {
+		t.Fatalf("Snapshot: got %d transactions, want 1", first.Count())
+	}
+	if harness.txPool.Snapshot() != first {
+		t.Fatalf("Snapshot: new snapshot taken of an unchanged pool")
+	}
+
+	// Changes to the pool don't affect existing snapshots.
+	_, err = harness.txPool.ProcessTransaction(chainedTxns[1], false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept tx: %v", err)
+	}
+	harness.txPool.PrioritiseTransaction(chainedTxns[0].Hash(), 1000)
+
+	second := harness.txPool.Snapshot()
+	if second.Generation() == first.Generation() {
+		t.Fatalf("Snapshot: generation %d unchanged", second.Generation())
+	}
+	if first.Count() != 1 || second.Count() != 2 {
+		t.Fatalf("Snapshot: got %d and %d transactions, want 1 and 2",
+			first.Count(), second.Count())
+	}
+	if _, ok := first.Lookup(chainedTxns[1].Hash()); ok {
+		t.Fatalf("Lookup: transaction added after the snapshot found")
+	}
+	for _, test := range []struct {
+		snapshot *Snapshot
+		feeDelta int64
+	}{
+		{first, 0},
+		{second, 1000},
+	} {
+		txD, ok := test.snapshot.Lookup(chainedTxns[0].Hash())
+		if !ok {
+			t.Fatalf("Lookup: transaction not found")
+		}
+		if txD.FeeDelta != test.feeDelta {
+			t.Fatalf("Lookup: got fee delta %d, want %d", txD.FeeDelta,
+				test.feeDelta)
+		}
+	}
+	if second.MemoryUsage() != harness.txPool.GetMemoryUsage() {
+		t.Fatalf("MemoryUsage: got %d, want %d", second.MemoryUsage(),
+			harness.txPool.GetMemoryUsage())
+	}
+}
+
+// TestAcceptPackage ensures that packages of a child and its parents are
+// accepted as a unit when each transaction along with its descendants in the
+// package pays the minimum fee, so that a child can pay for a parent paying
+// less, and that invalid packages are rejected entirely.
+func TestAcceptPackage(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+	outputs, err = harness.CreateConfirmedOutputs(outputs, 3)
+	if err != nil {
+		t.Fatalf("unable to create confirmed outputs: %v", err)
+	}
+
+	// Raise the minimum fee as if the pool had recently been full.
+	harness.txPool.mtx.Lock()
+	harness.txPool.rollingMinFee = 100000
+	harness.txPool.lastRollingFeeUpdate = time.Now()
+	harness.txPool.mtx.Unlock()
+
+	parent, err := harness.CreateSignedTx(outputs[:1], 1)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	lowFeeChild, err := harness.CreateSignedTxWithFee(
+		[]spendableOutput{txOutToSpendableOut(parent, 0)}, 1000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	child, err := harness.CreateSignedTxWithFee(
+		[]spendableOutput{txOutToSpendableOut(parent, 0)}, 100000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+
+	unrelated, err := harness.CreateSignedTx(outputs[1:2], 1)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	highFeeParent, err := harness.CreateSignedTxWithFee(outputs[2:3],
+		200000, wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	jointChild, err := harness.CreateSignedTxWithFee(
+		[]spendableOutput{txOutToSpendableOut(parent, 0),
+			txOutToSpendableOut(highFeeParent, 0)}, 1000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+
+	// The parent doesn't pay the minimum fee on its own.
+	_, err = harness.txPool.ProcessTransaction(parent, false, false, 0)
+	if code, _ := extractRejectCode(err); code != wire.RejectInsufficientFee {
+		t.Fatalf("ProcessTransaction: got %v, want reject code %v", err,
+			wire.RejectInsufficientFee)
+	}
+
+	tests := []struct {
+		name string
+		txns []*soterutil.Tx
+		code wire.RejectCode
+	}{
+		{
+			name: "child before parent",
+			txns: []*soterutil.Tx{child, parent},
+			code: wire.RejectInvalid,
+		},
+		{
+			name: "double spend within the package",
+			txns: []*soterutil.Tx{parent, lowFeeChild, child},
+			code: wire.RejectDuplicate,
+		},
+		{
+			name: "package below the minimum fee",
+			txns: []*soterutil.Tx{parent, lowFeeChild},
+			code: wire.RejectInsufficientFee,
+		},
+		{
+			name: "transaction which isn't a parent of the child",
+			txns: []*soterutil.Tx{unrelated, parent, child},
+			code: wire.RejectNonstandard,
+		},
+		{
+			name: "parent paying for its sibling",
+			txns: []*soterutil.Tx{parent, highFeeParent, jointChild},
+			code: wire.RejectInsufficientFee,
+		},
+	}
+	for _, test := range tests {
+		_, err := harness.txPool.AcceptPackage(test.txns)
+		if code, _ := extractRejectCode(err); code != test.code {
+			t.Fatalf("AcceptPackage (%s): got %v, want reject code "+
+				"%v", test.name, err, test.code)
+		}
+		for _, tx := range test.txns {
+			testPoolMembership(tc, tx, false, false)
+		}
+	}
+
+	// The child pays for its parent.
+	accepted, err := harness.txPool.AcceptPackage(
+		[]*soterutil.Tx{parent, child})
+	if err != nil {
+		t.Fatalf("AcceptPackage: unexpected error: %v", err)
+	}
+	if len(accepted) != 2 {
+		t.Fatalf("AcceptPackage: accepted %d transactions, want 2",
+			len(accepted))
+	}
+	testPoolMembership(tc, parent, false, true)
+	testPoolMembership(tc, child, false, true)
+}
+
+// TestAcceptPackagePoolFull ensures a package which doesn't fit in a full pool
+// is rejected without losing the transactions evicted to make room for it, nor
+// raising the minimum fee.
+func TestAcceptPackagePoolFull(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+	outputs, err = harness.CreateConfirmedOutputs(outputs, 2)
+	if err != nil {
+		t.Fatalf("unable to create confirmed outputs: %v", err)
+	}
+
+	other, err := harness.CreateSignedTxWithFee(outputs[:1], 1000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	parent, err := harness.CreateSignedTxWithFee(outputs[1:2], 0,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	child, err := harness.CreateSignedTxWithFee(
+		[]spendableOutput{txOutToSpendableOut(parent, 0)}, 100000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+
+	// The pool is limited to the other transaction, which has a lower fee
+	// rate than the package, so it's evicted first, yet the package
+	// doesn't fit either.
+	harness.txPool.cfg.Policy.MaxPoolSize = txMemoryUsage(other)
+	_, err = harness.txPool.ProcessTransaction(other, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept tx: %v", err)
+	}
+	minFee := harness.txPool.MinFee()
+
+	_, err = harness.txPool.AcceptPackage([]*soterutil.Tx{parent, child})
+	if code, _ := extractRejectCode(err); code != wire.RejectInsufficientFee {
+		t.Fatalf("AcceptPackage: got %v, want reject code %v", err,
+			wire.RejectInsufficientFee)
+	}
+	testPoolMembership(tc, parent, false, false)
+	testPoolMembership(tc, child, false, false)
+	testPoolMembership(tc, other, false, true)
+	if got := harness.txPool.MinFee(); got != minFee {
+		t.Fatalf("MinFee: got %v after the rejection, want %v", got,
+			minFee)
+	}
+	if usage := harness.txPool.GetMemoryUsage(); usage !=
+		harness.txPool.cfg.Policy.MaxPoolSize {
+
+		t.Fatalf("GetMemoryUsage: got %d after the rejection, want %d",
+			usage, harness.txPool.cfg.Policy.MaxPoolSize)
+	}
+}
+
+// TestRejectCache ensures rejected transactions are remembered along with the
+// reason for their rejection, that the cached rejections are reused until the
+// DAG changes, and that the cache is bounded.
+func TestRejectCache(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+	harness.txPool.cfg.Policy.MaxRejectCacheEntries = 2
+
+	// Create transactions with a version which isn't standard.
+	chainedTxns, err := harness.CreateTxChain(outputs[0], 3)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+	var nonStdTxns []*soterutil.Tx
+	for _, tx := range chainedTxns {
+		msgTx := tx.MsgTx()
+		msgTx.Version = 2
+		nonStdTxns = append(nonStdTxns, soterutil.NewTx(msgTx))
+	}
+
+	tx := nonStdTxns[0]
+	_, err = harness.txPool.ProcessTransaction(tx, false, false, 7)
+	if code, _ := extractRejectCode(err); code != wire.RejectNonstandard {
+		t.Fatalf("ProcessTransaction: got %v, want reject code %v", err,
+			wire.RejectNonstandard)
+	}
+	entry, ok := harness.txPool.RejectedTransaction(tx.Hash())
+	if !ok {
+		t.Fatalf("RejectedTransaction: rejection of %v not found",
+			tx.Hash())
+	}
+	if entry.Code != wire.RejectNonstandard || entry.Tag != 7 ||
+		entry.Height != harness.chain.BestHeight() {
+
+		t.Fatalf("RejectedTransaction: unexpected entry %+v", entry)
+	}
+
+	// Submitting the transaction again at the same height must return the
+	// cached rejection.
+	_, err = harness.txPool.ProcessTransaction(tx, false, false, 0)
+	if code, _ := extractRejectCode(err); code != wire.RejectNonstandard {
+		t.Fatalf("ProcessTransaction: got %v, want reject code %v", err,
+			wire.RejectNonstandard)
+	}
+	harness.txPool.mtx.RLock()
+	cachedErr := harness.txPool.cachedReject(tx)
+	harness.txPool.mtx.RUnlock()
+	if cachedErr == nil {
+		t.Fatalf("cachedReject: rejection of %v not reused", tx.Hash())
+	}
+
+	// The rejection doesn't apply to a copy of the transaction with a
+	// different witness.
+	malleated := tx.MsgTx().Copy()
+	malleated.TxIn[0].Witness = wire.TxWitness{{0x01}}
+	harness.txPool.mtx.RLock()
+	cachedErr = harness.txPool.cachedReject(soterutil.NewTx(malleated))
+	harness.txPool.mtx.RUnlock()
+	if cachedErr != nil {
+		t.Fatalf("cachedReject: got %v for a transaction with a "+
+			"different witness, want nil", cachedErr)
+	}
+
+	// The rejection no longer applies once a block is connected, even
+	// when the height of the DAG doesn't change.
+	harness.chain.Notify(blockdag.NTBlockConnected,
+		soterutil.NewBlock(&wire.MsgBlock{}))
+	harness.txPool.mtx.RLock()
+	cachedErr = harness.txPool.cachedReject(tx)
+	harness.txPool.mtx.RUnlock()
+	if cachedErr != nil {
+		t.Fatalf("cachedReject: got %v after the DAG changed, want nil",
+			cachedErr)
+	}
+
+	// The oldest rejections are forgotten once the cache is full.
+	for _, tx := range nonStdTxns[1:] {
+		_, err := harness.txPool.ProcessTransaction(tx, false, false, 0)
+		if err == nil {
+			t.Fatalf("ProcessTransaction: accepted non-standard "+
+				"transaction %v", tx.Hash())
+		}
+	}
+	if _, ok := harness.txPool.RejectedTransaction(tx.Hash()); ok {
+		t.Fatalf("RejectedTransaction: rejection of %v not evicted",
+			tx.Hash())
+	}
+	for _, tx := range nonStdTxns[1:] {
+		if _, ok := harness.txPool.RejectedTransaction(tx.Hash()); !ok {
+			t.Fatalf("RejectedTransaction: rejection of %v not "+
+				"found", tx.Hash())
+		}
+	}
+
+	// Accepted transactions are never reported as rejected.
+	validTx, err := harness.CreateSignedTx(outputs, 1)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	harness.txPool.mtx.Lock()
+	harness.txPool.recordReject(validTx,
+		txRuleError(wire.RejectNonstandard, "test"), 0)
+	harness.txPool.cfg.Policy.MaxRejectCacheEntries = 3
+	harness.txPool.mtx.Unlock()
+	harness.chain.Notify(blockdag.NTBlockConnected,
+		soterutil.NewBlock(&wire.MsgBlock{}))
+	_, err = harness.txPool.ProcessTransaction(validTx, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+	testPoolMembership(tc, validTx, false, true)
+	if _, ok := harness.txPool.RejectedTransaction(validTx.Hash()); ok {
+		t.Fatalf("RejectedTransaction: accepted transaction %v "+
+			"reported as rejected", validTx.Hash())
+	}
+}
+
+// testMetrics is a Metrics implementation recording what the pool reports.
+type testMetrics struct {
+	accepted    int
+	rejected    map[wire.RejectCode]int
+	orphaned    int
+	removed     map[RemovalReason]int
+	count       int
+	virtualSize int64
+	memoryUsage int64
+}
+
+func (m *testMetrics) TxAccepted()                     { m.accepted++ }
+func (m *testMetrics) TxRejected(code wire.RejectCode) { m.rejected[code]++ }
+func (m *testMetrics) TxOrphaned()                     { m.orphaned++ }
+func (m *testMetrics) TxRemoved(reason RemovalReason)  { m.removed[reason]++ }
+
+func (m *testMetrics) SetPoolSize(count int, virtualSize, memoryUsage int64) {
+	m.count = count
+	m.virtualSize = virtualSize
+	m.memoryUsage = memoryUsage
+}
+
+// TestMetrics ensures the pool reports accepted, rejected, orphaned and removed
+// transactions along with its size to the configured metrics.
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	metrics := &testMetrics{
+		rejected: make(map[wire.RejectCode]int),
+		removed:  make(map[RemovalReason]int),
+	}
+	harness.txPool.cfg.Metrics = metrics
+
+	chainedTxns, err := harness.CreateTxChain(outputs[0], 2)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+
+	// The child is orphaned until its parent is accepted.
+	_, err = harness.txPool.ProcessTransaction(chainedTxns[1], true, false,
+		0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(chainedTxns[0], true, false,
+		0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+	if metrics.orphaned != 1 || metrics.accepted != 2 {
+		t.Fatalf("got %d orphaned and %d accepted transactions, want "+
+			"1 and 2", metrics.orphaned, metrics.accepted)
+	}
+	if metrics.count != 2 ||
+		metrics.virtualSize != harness.txPool.poolSize ||
+		metrics.memoryUsage != harness.txPool.GetMemoryUsage() {
+
+		t.Fatalf("unexpected pool size: got %d transactions, %d bytes "+
+			"and %d bytes of memory", metrics.count,
+			metrics.virtualSize, metrics.memoryUsage)
+	}
+
+	// Rejections are reported with their reject code.
+	nonStdTx, err := harness.CreateSignedTx(outputs, 1)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	msgTx := nonStdTx.MsgTx()
+	msgTx.Version = 2
+	_, err = harness.txPool.ProcessTransaction(soterutil.NewTx(msgTx), true,
+		false, 0)
+	if err == nil {
+		t.Fatal("ProcessTransaction: accepted non-standard transaction")
+	}
+	if metrics.rejected[wire.RejectNonstandard] != 1 {
+		t.Fatalf("got rejections %v, want one non-standard rejection",
+			metrics.rejected)
+	}
+
+	// Rejections reused from the reject cache are reported as well.
+	_, err = harness.txPool.ProcessTransaction(soterutil.NewTx(msgTx), true,
+		false, 0)
+	if err == nil {
+		t.Fatal("ProcessTransaction: accepted non-standard transaction")
+	}
+	if metrics.rejected[wire.RejectNonstandard] != 2 {
+		t.Fatalf("got rejections %v, want two non-standard rejections",
+			metrics.rejected)
+	}
+
+	// Removing the parent removes its child too.
+	harness.txPool.RemoveTransaction(chainedTxns[0], true)
+	if metrics.removed[RemovalManual] != 2 {
+		t.Fatalf("got removals %v, want two manual removals",
+			metrics.removed)
+	}
+	if metrics.count != 0 || metrics.virtualSize != 0 ||
+		metrics.memoryUsage != 0 {
+
+		t.Fatalf("unexpected pool size: got %d transactions, %d bytes "+
+			"and %d bytes of memory", metrics.count,
+			metrics.virtualSize, metrics.memoryUsage)
+	}
+}
+
+// TestFeeRateAdmission ensures that without the legacy free transaction relay
+// every transaction must pay the minimum relay fee unless it is exempt.
+func TestFeeRateAdmission(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+	harness.txPool.cfg.Policy.LegacyFreeTxRelay = false
+
+	freeTx, err := harness.CreateSignedTx(outputs, 1)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(freeTx, false, false, 0)
+	if code, _ := extractRejectCode(err); code != wire.RejectInsufficientFee {
+		t.Fatalf("ProcessTransaction: got %v, want reject code %v", err,
+			wire.RejectInsufficientFee)
+	}
+	testPoolMembership(tc, freeTx, false, false)
+
+	// Transactions being added back from disconnected blocks are exempt.
+	_, _, err = harness.txPool.MaybeAcceptTransaction(freeTx, false, false)
+	if err != nil {
+		t.Fatalf("MaybeAcceptTransaction: unexpected error: %v", err)
+	}
+	testPoolMembership(tc, freeTx, false, true)
+	harness.txPool.RemoveTransaction(freeTx, true)
+
+	// Transactions the exemption hook approves of are exempt.
+	harness.txPool.cfg.IsFeeExempt = func(tx *soterutil.Tx) bool {
+		return tx.Hash().IsEqual(freeTx.Hash())
+	}
+	_, err = harness.txPool.ProcessTransaction(freeTx, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+	testPoolMembership(tc, freeTx, false, true)
+	harness.txPool.RemoveTransaction(freeTx, true)
+	harness.txPool.cfg.IsFeeExempt = nil
+
+	// Transactions paying the minimum relay fee are accepted.
+	feeTx, err := harness.CreateSignedTxWithFee(outputs, 1000,
+		wire.MaxTxInSequenceNum)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(feeTx, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+	testPoolMembership(tc, feeTx, false, true)
+}
+
+// TestUtxoCache ensures the outputs of the DAG fetched for validating
+// transactions are cached until the DAG notifies blocks spending or creating
+// them are connected, or blocks are disconnected.
+func TestUtxoCache(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+
+	// Count the views fetched from the fake chain.
+	var fetches int
+	harness.txPool.cfg.FetchUtxoView = func(tx *soterutil.Tx) (*blockdag.UtxoViewpoint, error) {
+		fetches++
+		return harness.chain.FetchUtxoView(tx)
+	}
+
+	tx, err := harness.CreateSignedTx(outputs, 1)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(tx, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+	if fetches != 1 {
+		t.Fatalf("fetched %d views, want 1", fetches)
+	}
+
+	// Validating the transaction again uses the cached outputs.
+	harness.txPool.RemoveTransaction(tx, true)
+	_, err = harness.txPool.ProcessTransaction(tx, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+	testPoolMembership(tc, tx, false, true)
+	if fetches != 1 {
+		t.Fatalf("fetched %d views, want 1", fetches)
+	}
+
+	// Disconnecting a block clears the cache.
+	coinbase, err := harness.CreateCoinbaseTx(harness.chain.BestHeight()+1, 1)
+	if err != nil {
+		t.Fatalf("unable to create coinbase: %v", err)
+	}
+	harness.txPool.RemoveTransaction(tx, true)
+	harness.chain.Notify(blockdag.NTBlockDisconnected,
+		soterutil.NewBlock(&wire.MsgBlock{
+			Transactions: []*wire.MsgTx{coinbase.MsgTx()},
+		}))
+	_, err = harness.txPool.ProcessTransaction(tx, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+	if fetches != 2 {
+		t.Fatalf("fetched %d views, want 2", fetches)
+	}
+
+	// A view fetched while a block is connected isn't cached, since it may
+	// predate the block.
+	countFetches := harness.txPool.cfg.FetchUtxoView
+	harness.txPool.cfg.FetchUtxoView = func(tx *soterutil.Tx) (*blockdag.UtxoViewpoint, error) {
+		view, err := countFetches(tx)
+		harness.chain.Notify(blockdag.NTBlockConnected,
+			soterutil.NewBlock(&wire.MsgBlock{
+				Transactions: []*wire.MsgTx{coinbase.MsgTx()},
+			}))
+		return view, err
+	}
+	harness.txPool.RemoveTransaction(tx, true)
+	harness.chain.Notify(blockdag.NTBlockDisconnected,
+		soterutil.NewBlock(&wire.MsgBlock{
+			Transactions: []*wire.MsgTx{coinbase.MsgTx()},
+		}))
+	_, err = harness.txPool.ProcessTransaction(tx, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+	harness.txPool.cfg.FetchUtxoView = countFetches
+	harness.txPool.RemoveTransaction(tx, true)
+	_, err = harness.txPool.ProcessTransaction(tx, false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+	if fetches != 4 {
+		t.Fatalf("fetched %d views, want 4", fetches)
+	}
+
+	// Connecting a block spending the output invalidates it, so the
+	// transaction is no longer accepted.
+	harness.txPool.RemoveTransaction(tx, true)
+	harness.chain.Lock()
+	harness.chain.utxos.LookupEntry(outputs[0].outPoint).Spend()
+	harness.chain.Unlock()
+	harness.chain.Notify(blockdag.NTBlockConnected,
+		soterutil.NewBlock(&wire.MsgBlock{
+			Transactions: []*wire.MsgTx{coinbase.MsgTx(), tx.MsgTx()},
+		}))
+	_, err = harness.txPool.ProcessTransaction(tx, false, false, 0)
+	if err == nil {
+		t.Fatal("ProcessTransaction: accepted transaction spending a " +
+			"spent output")
+	}
+	testPoolMembership(tc, tx, false, false)
+	if fetches != 5 {
+		t.Fatalf("fetched %d views, want 5", fetches)
+	}
+}
+
+// TestDecisionTrace ensures the decisions about processed transactions are
+// traced, that the trace only keeps the most recent decisions, and that they
+// can be queried per transaction.
+func TestDecisionTrace(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	if trace := harness.txPool.DecisionTrace(nil); trace != nil {
+		t.Fatalf("DecisionTrace: got %v while tracing is disabled",
+			trace)
+	}
+	harness.txPool.decisions = newDecisionTrace(3)
+
+	chainedTxns, err := harness.CreateTxChain(outputs[0], 2)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+	nonStdTx, err := harness.CreateSignedTx(outputs, 1)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	msgTx := nonStdTx.MsgTx()
+	msgTx.Version = 2
+	nonStdTx = soterutil.NewTx(msgTx)
+
+	// Orphan the child, reject the non-standard transaction, and then
+	// accept the parent along with the child.
+	_, err = harness.txPool.ProcessTransaction(chainedTxns[1], true, false,
+		1)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(nonStdTx, true, false, 2)
+	if err == nil {
+		t.Fatal("ProcessTransaction: accepted non-standard transaction")
+	}
+	_, err = harness.txPool.ProcessTransaction(chainedTxns[0], true, false,
+		3)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+
+	// The decision about the orphan is the oldest, so it's overwritten.
+	trace := harness.txPool.DecisionTrace(nil)
+	want := []struct {
+		hash    *chainhash.Hash
+		outcome DecisionOutcome
+		peer    Tag
+	}{
+		{nonStdTx.Hash(), DecisionRejected, 2},
+		{chainedTxns[0].Hash(), DecisionAccepted, 3},
+		{chainedTxns[1].Hash(), DecisionAccepted, 0},
+	}
+	if len(trace) != len(want) {
+		t.Fatalf("DecisionTrace: got %d decisions, want %d", len(trace),
+			len(want))
+	}
+	for i, w := range want {
+		d := trace[i]
+		if d.Hash != *w.hash || d.Outcome != w.outcome || d.Peer != w.peer {
+			t.Fatalf("DecisionTrace: decision #%d is %v, want txid=%v "+
+				"outcome=%v peer=%d", i, &d, w.hash, w.outcome,
+				w.peer)
+		}
+	}
+	if trace[0].Code != wire.RejectNonstandard {
+		t.Fatalf("DecisionTrace: got reject code %v, want %v",
+			trace[0].Code, wire.RejectNonstandard)
+	}
+
+	trace = harness.txPool.DecisionTrace(chainedTxns[1].Hash())
+	if len(trace) != 1 || trace[0].Outcome != DecisionAccepted {
+		t.Fatalf("DecisionTrace: unexpected decisions %v about %v",
+			trace, chainedTxns[1].Hash())
+	}
+}
+
+// TestLogFields ensures log fields are formatted as key=value pairs with values
+// quoted when needed.
+func TestLogFields(t *testing.T) {
+	got := logFields("txid", "abc", "size", 250, "reason", "too low")
+	want := `txid=abc size=250 reason="too low"`
+	if got != want {
+		t.Fatalf("logFields: got %s, want %s", got, want)
+	}
+}
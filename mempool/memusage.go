@@ -0,0 +1,79 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"unsafe"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+const (
+	// mapEntryOverhead is the approximate memory used by a map for each of
+	// its entries in addition to the key and value, such as the tophash
+	// byte, the bucket overflow pointers, and the unused slots due to the
+	// load factor.
+	mapEntryOverhead = 16
+
+	// sliceHeaderSize is the memory used by a slice header.
+	sliceHeaderSize = int64(unsafe.Sizeof([]byte(nil)))
+
+	// pointerSize is the memory used by a pointer.
+	pointerSize = int64(unsafe.Sizeof(uintptr(0)))
+)
+
+var (
+	// The memory used by the structures making up a transaction in the
+	// pool, excluding the variable length data they refer to.
+	txDescSize   = int64(unsafe.Sizeof(TxDesc{}))
+	txSize       = int64(unsafe.Sizeof(soterutil.Tx{}))
+	msgTxSize    = int64(unsafe.Sizeof(wire.MsgTx{}))
+	txInSize     = int64(unsafe.Sizeof(wire.TxIn{}))
+	txOutSize    = int64(unsafe.Sizeof(wire.TxOut{}))
+	outPointSize = int64(unsafe.Sizeof(wire.OutPoint{}))
+)
+
+// txMemoryUsage returns the estimated memory used by the passed transaction
+// once it is in the pool.  It includes the deserialized transaction, its
+// descriptor, and its entries in the indexes of the pool.
+func txMemoryUsage(tx *soterutil.Tx) int64 {
+	msgTx := tx.MsgTx()
+
+	// The transaction itself, along with its descriptor.
+	usage := txDescSize + txSize + msgTxSize
+	for _, txIn := range msgTx.TxIn {
+		usage += pointerSize + txInSize + int64(len(txIn.SignatureScript))
+		for _, item := range txIn.Witness {
+			usage += sliceHeaderSize + int64(len(item))
+		}
+	}
+	for _, txOut := range msgTx.TxOut {
+		usage += pointerSize + txOutSize + int64(len(txOut.PkScript))
+	}
+
+	// The entry of the transaction in the pool, and the entries of the
+	// outpoints it spends.
+	usage += chainhash.HashSize + pointerSize + mapEntryOverhead
+	usage += int64(len(msgTx.TxIn)) *
+		(outPointSize + pointerSize + mapEntryOverhead)
+
+	return usage
+}
+
+// GetMemoryUsage returns the estimated memory in bytes used by the
+// transactions in the main pool, including their metadata and their entries in
+// the indexes of the pool.  It is the usage that MaxPoolSize limits.  The
+// orphan pool is not included.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) GetMemoryUsage() int64 {
+	mp.mtx.RLock()
+	usage := mp.memoryUsage
+	mp.mtx.RUnlock()
+
+	return usage
+}
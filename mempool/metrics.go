@@ -0,0 +1,47 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import "github.com/soteria-dag/soterd/wire"
+
+// Metrics defines the interface the pool uses to report its health, such as
+// to export it to a monitoring system, without depending on any particular
+// metrics library.
+//
+// The methods are called with the mempool lock held, so they MUST NOT call
+// back into the mempool and should return quickly.
+type Metrics interface {
+	// TxAccepted is called when a transaction is added to the main pool.
+	TxAccepted()
+
+	// TxRejected is called when a transaction is rejected by the pool
+	// with the reject code describing why.
+	TxRejected(code wire.RejectCode)
+
+	// TxOrphaned is called when a transaction is added to the orphan
+	// pool.
+	TxOrphaned()
+
+	// TxRemoved is called when a transaction is removed from the main
+	// pool with the reason for its removal.  Transactions evicted to keep
+	// the pool within its size limit are reported with RemovalEviction.
+	TxRemoved(reason RemovalReason)
+
+	// SetPoolSize is called when the main pool changes with the number of
+	// transactions in it, their total virtual size in bytes and their
+	// estimated memory usage in bytes.
+	SetPoolSize(count int, virtualSize, memoryUsage int64)
+}
+
+// reportPoolSize reports the current size of the main pool to the metrics, if
+// any.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) reportPoolSize() {
+	if mp.cfg.Metrics != nil {
+		mp.cfg.Metrics.SetPoolSize(len(mp.pool), mp.poolSize,
+			mp.memoryUsage)
+	}
+}
@@ -0,0 +1,389 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"fmt"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+const (
+	// DefaultMaxPackageCount is the default maximum number of transactions
+	// in a package accepted via AcceptPackage.
+	DefaultMaxPackageCount = 25
+
+	// DefaultMaxPackageSize is the default maximum total virtual size in
+	// bytes of the transactions in a package accepted via AcceptPackage.
+	DefaultMaxPackageSize = 101000
+)
+
+// packageRelayLimits returns the package limits of the policy, using the
+// defaults for any limits which aren't set.
+func (p *Policy) packageRelayLimits() (maxCount int, maxSize int64) {
+	maxCount = p.MaxPackageCount
+	if maxCount <= 0 {
+		maxCount = DefaultMaxPackageCount
+	}
+	maxSize = p.MaxPackageSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxPackageSize
+	}
+	return maxCount, maxSize
+}
+
+// checkPackageTopology ensures the passed package contains no duplicates, that
+// its transactions don't spend the same outputs as each other, that they are
+// ordered such that parents come before the transactions spending them, and
+// that the package consists of a child and its parents, meaning every
+// transaction but the last one is an ancestor of the last one.
+func checkPackageTopology(txns []*soterutil.Tx) error {
+	positions := make(map[chainhash.Hash]int, len(txns))
+	for i, tx := range txns {
+		if _, ok := positions[*tx.Hash()]; ok {
+			str := fmt.Sprintf("transaction %v is included in the "+
+				"package more than once", tx.Hash())
+			return txRuleError(wire.RejectInvalid, str)
+		}
+		positions[*tx.Hash()] = i
+	}
+
+	spent := make(map[wire.OutPoint]*soterutil.Tx)
+	for i, tx := range txns {
+		for _, txIn := range tx.MsgTx().TxIn {
+			prevOut := txIn.PreviousOutPoint
+			if spender, ok := spent[prevOut]; ok {
+				str := fmt.Sprintf("output %v already spent by "+
+					"transaction %v in the package", prevOut,
+					spender.Hash())
+				return txRuleError(wire.RejectDuplicate, str)
+			}
+			spent[prevOut] = tx
+
+			if pos, ok := positions[prevOut.Hash]; ok && pos >= i {
+				str := fmt.Sprintf("transaction %v spends "+
+					"transaction %v which comes later in the "+
+					"package", tx.Hash(), prevOut.Hash)
+				return txRuleError(wire.RejectInvalid, str)
+			}
+		}
+	}
+
+	// Walk back from the child to find its ancestors in the package.
+	// Parents come first, so each transaction is reached before its own
+	// parents are examined.
+	ancestors := make(map[chainhash.Hash]struct{}, len(txns))
+	ancestors[*txns[len(txns)-1].Hash()] = struct{}{}
+	for i := len(txns) - 1; i >= 0; i-- {
+		tx := txns[i]
+		if _, ok := ancestors[*tx.Hash()]; !ok {
+			str := fmt.Sprintf("package transaction %v is not an "+
+				"ancestor of the last transaction %v", tx.Hash(),
+				txns[len(txns)-1].Hash())
+			return txRuleError(wire.RejectNonstandard, str)
+		}
+		for _, txIn := range tx.MsgTx().TxIn {
+			if _, ok := positions[txIn.PreviousOutPoint.Hash]; ok {
+				ancestors[txIn.PreviousOutPoint.Hash] = struct{}{}
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkPackageFees ensures that each of the passed transactions, along with
+// its descendants among them, pays the minimum fee for the passed fee rate.
+// This lets the fees of children pay for their parents, but not the fees of
+// unrelated transactions.  The transactions must be ordered such that parents
+// come first.
+func checkPackageFees(txns []*soterutil.Tx, fees map[chainhash.Hash]int64,
+	minFeeRate soterutil.Amount) error {
+
+	// Collect the descendants of each transaction, children first, so
+	// the descendants of a transaction are known once it's reached.
+	descendants := make(map[chainhash.Hash]map[chainhash.Hash]*soterutil.Tx,
+		len(txns))
+	for i := len(txns) - 1; i >= 0; i-- {
+		tx := txns[i]
+		set, ok := descendants[*tx.Hash()]
+		if !ok {
+			set = make(map[chainhash.Hash]*soterutil.Tx)
+			descendants[*tx.Hash()] = set
+		}
+		set[*tx.Hash()] = tx
+
+		for _, txIn := range tx.MsgTx().TxIn {
+			parentHash := txIn.PreviousOutPoint.Hash
+			if _, ok := fees[parentHash]; !ok {
+				continue
+			}
+			parentSet, ok := descendants[parentHash]
+			if !ok {
+				parentSet = make(map[chainhash.Hash]*soterutil.Tx)
+				descendants[parentHash] = parentSet
+			}
+			for hash, descendant := range set {
+				parentSet[hash] = descendant
+			}
+		}
+	}
+
+	for _, tx := range txns {
+		var size, fee int64
+		for hash, descendant := range descendants[*tx.Hash()] {
+			size += GetTxVirtualSize(descendant)
+			fee += fees[hash]
+		}
+		minFee := calcMinRequiredTxRelayFee(size, minFeeRate)
+		if fee < minFee {
+			str := fmt.Sprintf("package transaction %v along with "+
+				"its descendants in the package has %d fees "+
+				"which is under the required amount of %d",
+				tx.Hash(), fee, minFee)
+			return txRuleError(wire.RejectInsufficientFee, str)
+		}
+	}
+
+	return nil
+}
+
+// checkPackageAncestry ensures that adding the passed transactions to the pool
+// would not exceed the ancestor and descendant limits of the policy, taking
+// both the unconfirmed ancestors in the pool and the ones in the package into
+// account.  The transactions must be ordered such that parents come first.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) checkPackageAncestry(txns []*soterutil.Tx) error {
+	maxAncestors, maxAncestorSize, maxDescendants, maxDescendantSize :=
+		mp.cfg.Policy.packageLimits()
+
+	// vsize returns the virtual size of a transaction in the pool or the
+	// package.
+	pkgTxns := make(map[chainhash.Hash]*soterutil.Tx, len(txns))
+	vsize := func(hash chainhash.Hash) int64 {
+		if tx, ok := pkgTxns[hash]; ok {
+			return GetTxVirtualSize(tx)
+		}
+		return GetTxVirtualSize(mp.pool[hash].Tx)
+	}
+
+	// Determine the ancestors of each transaction across the pool and the
+	// package, and how many descendants each transaction in the pool
+	// gains.
+	ancestorSets := make(map[chainhash.Hash]map[chainhash.Hash]struct{})
+	newDescendants := make(map[chainhash.Hash]PackageStats)
+	for _, tx := range txns {
+		ancestors := make(map[chainhash.Hash]struct{})
+		for hash := range mp.txAncestors(tx, nil) {
+			ancestors[hash] = struct{}{}
+		}
+		for _, txIn := range tx.MsgTx().TxIn {
+			parentHash := txIn.PreviousOutPoint.Hash
+			parentAncestors, ok := ancestorSets[parentHash]
+			if !ok {
+				continue
+			}
+			ancestors[parentHash] = struct{}{}
+			for hash := range parentAncestors {
+				ancestors[hash] = struct{}{}
+			}
+		}
+		pkgTxns[*tx.Hash()] = tx
+		ancestorSets[*tx.Hash()] = ancestors
+
+		txSize := GetTxVirtualSize(tx)
+		stats := PackageStats{Count: 1, Size: txSize}
+		for hash := range ancestors {
+			stats.Count++
+			stats.Size += vsize(hash)
+
+			if _, ok := mp.pool[hash]; ok {
+				descendants := newDescendants[hash]
+				descendants.Count++
+				descendants.Size += txSize
+				newDescendants[hash] = descendants
+			}
+		}
+		if stats.Count > maxAncestors {
+			str := fmt.Sprintf("transaction %v has too many "+
+				"unconfirmed ancestors in the pool and the "+
+				"package: %d > %d", tx.Hash(), stats.Count,
+				maxAncestors)
+			return txRuleError(wire.RejectNonstandard, str)
+		}
+		if stats.Size > maxAncestorSize {
+			str := fmt.Sprintf("transaction %v unconfirmed ancestors "+
+				"in the pool and the package are too large: "+
+				"%d > %d bytes", tx.Hash(), stats.Size,
+				maxAncestorSize)
+			return txRuleError(wire.RejectNonstandard, str)
+		}
+	}
+
+	for hash, added := range newDescendants {
+		descendants := mp.pool[hash].descendants
+		if descendants.Count+added.Count > maxDescendants {
+			str := fmt.Sprintf("package would exceed the descendant "+
+				"limit of transaction %v: %d > %d", hash,
+				descendants.Count+added.Count, maxDescendants)
+			return txRuleError(wire.RejectNonstandard, str)
+		}
+		if descendants.Size+added.Size > maxDescendantSize {
+			str := fmt.Sprintf("package would exceed the descendant "+
+				"size limit of transaction %v: %d > %d bytes",
+				hash, descendants.Size+added.Size,
+				maxDescendantSize)
+			return txRuleError(wire.RejectNonstandard, str)
+		}
+	}
+
+	return nil
+}
+
+// AcceptPackage validates the passed package of transactions as a unit and
+// adds all of them to the pool, or none of them.  The transactions must be
+// ordered such that parents come before the transactions spending them, and
+// transactions of the package which are already in the pool are skipped.
+//
+// The package must consist of a child and its parents, so every transaction
+// but the last one must be an ancestor of the last one.  Unlike
+// ProcessTransaction, the minimum fee is required of each transaction along
+// with its descendants in the package rather than of each transaction on its
+// own, which allows the fee of a child to pay for a parent paying less than
+// the minimum fee (child pays for parent).  This lets wallets bump the fee of
+// transactions while the minimum fee of the pool is raised.  The package may
+// not replace transactions in the pool, nor contain orphans, and it must fit
+// within the package limits of the policy along with the ancestor and
+// descendant limits.
+//
+// It returns a slice of transactions added to the mempool, which includes the
+// transactions of the package along with any orphans accepted as a result.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) AcceptPackage(txns []*soterutil.Tx) ([]*TxDesc, error) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	// Only consider the transactions of the package which aren't in the
+	// pool yet.
+	newTxns := make([]*soterutil.Tx, 0, len(txns))
+	for _, tx := range txns {
+		if !mp.isTransactionInPool(tx.Hash()) {
+			newTxns = append(newTxns, tx)
+		}
+	}
+	if len(newTxns) == 0 {
+		return nil, nil
+	}
+
+	maxCount, maxSize := mp.cfg.Policy.packageRelayLimits()
+	if len(txns) > maxCount {
+		str := fmt.Sprintf("package has too many transactions: %d > %d",
+			len(txns), maxCount)
+		return nil, txRuleError(wire.RejectNonstandard, str)
+	}
+	if err := checkPackageTopology(txns); err != nil {
+		return nil, err
+	}
+	if err := mp.checkPackageAncestry(newTxns); err != nil {
+		return nil, err
+	}
+
+	// Validate each transaction as if the preceding ones were in the pool,
+	// deferring the fee checks to the package as a whole.
+	validations := make([]*txValidation, 0, len(newTxns))
+	pending := make(map[chainhash.Hash]*soterutil.Tx, len(newTxns))
+	fees := make(map[chainhash.Hash]int64, len(newTxns))
+	var packageSize, packageFee int64
+	for _, tx := range newTxns {
+		v, err := mp.validateTransaction(tx, true, false, true, pending,
+			true)
+		if err != nil {
+			return nil, err
+		}
+		if len(v.missingParents) > 0 {
+			str := fmt.Sprintf("package transaction %v references "+
+				"outputs of unknown or fully-spent transaction %v",
+				tx.Hash(), v.missingParents[0])
+			return nil, txRuleError(wire.RejectDuplicate, str)
+		}
+		if len(v.conflicts) > 0 {
+			str := fmt.Sprintf("package transaction %v double spends "+
+				"transactions in the pool", tx.Hash())
+			return nil, txRuleError(wire.RejectDuplicate, str)
+		}
+
+		validations = append(validations, v)
+		pending[*tx.Hash()] = tx
+		packageSize += GetTxVirtualSize(tx)
+		fees[*tx.Hash()] = v.fee + mp.feeDeltas[*tx.Hash()]
+		packageFee += fees[*tx.Hash()]
+	}
+
+	if packageSize > maxSize {
+		str := fmt.Sprintf("package is too large: %d > %d bytes",
+			packageSize, maxSize)
+		return nil, txRuleError(wire.RejectNonstandard, str)
+	}
+	minFee := calcMinRequiredTxRelayFee(packageSize, mp.minFee())
+	if packageFee < minFee {
+		str := fmt.Sprintf("package has %d fees which is under the "+
+			"required amount of %d", packageFee, minFee)
+		return nil, txRuleError(wire.RejectInsufficientFee, str)
+	}
+	if err := checkPackageFees(newTxns, fees, mp.minFee()); err != nil {
+		return nil, err
+	}
+
+	// Add the package to the pool now that all of the checks passed.
+	rollingMinFee := mp.rollingMinFee
+	lastRollingFeeUpdate := mp.lastRollingFeeUpdate
+	accepted := make([]*TxDesc, 0, len(newTxns))
+	for i, tx := range newTxns {
+		v := validations[i]
+		accepted = append(accepted, mp.addTransaction(v.utxoView, tx,
+			v.bestHeight, v.fee))
+	}
+
+	// Evict the lowest fee rate transactions if the pool is now over its
+	// size limit.  Reject the package when any of it was evicted, along
+	// with the rest of it, and restore what was evicted along with it,
+	// since the pool fit within the limit without the package.
+	evicted := mp.trimToSize()
+	for _, txD := range accepted {
+		if mp.isTransactionInPool(txD.Tx.Hash()) {
+			continue
+		}
+		for _, txD := range accepted {
+			mp.removeTransaction(txD.Tx, true, RemovalEviction)
+		}
+		restore := make([]*TxDesc, 0, len(evicted))
+		for _, evictedDesc := range evicted {
+			if _, ok := pending[*evictedDesc.Tx.Hash()]; !ok {
+				restore = append(restore, evictedDesc)
+			}
+		}
+		mp.restoreTransactions(restore)
+		mp.rollingMinFee = rollingMinFee
+		mp.lastRollingFeeUpdate = lastRollingFeeUpdate
+
+		return nil, txRuleError(wire.RejectInsufficientFee, "package "+
+			"was not accepted because the mempool is full")
+	}
+
+	log.Debugf("Accepted package of %d %s (pool size: %v)", len(accepted),
+		pickNoun(len(accepted), "transaction", "transactions"),
+		len(mp.pool))
+
+	// Accept any orphans which depend on the package.
+	for _, tx := range newTxns {
+		accepted = append(accepted, mp.processOrphans(tx)...)
+	}
+
+	return accepted, nil
+}
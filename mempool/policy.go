@@ -10,19 +10,26 @@ import (
 	"time"
 
 	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/chaincfg"
 	"github.com/soteria-dag/soterd/soterutil"
 	"github.com/soteria-dag/soterd/txscript"
 	"github.com/soteria-dag/soterd/wire"
 )
 
 const (
-	// maxStandardP2SHSigOps is the maximum number of signature operations
-	// that are considered standard in a pay-to-script-hash script.
-	maxStandardP2SHSigOps = 15
+	// DefaultMaxStandardP2SHSigOps is the default maximum number of
+	// signature operations that are considered standard in a
+	// pay-to-script-hash script.
+	DefaultMaxStandardP2SHSigOps = 15
 
-	// maxStandardTxCost is the max weight permitted by any transaction
-	// according to the current default policy.
-	maxStandardTxWeight = 400000
+	// DefaultMaxStandardTxWeight is the default max weight permitted by
+	// any transaction according to the current default policy.
+	DefaultMaxStandardTxWeight = 400000
+
+	// DefaultMaxDataCarrierSize is the default maximum number of bytes of
+	// data that a null data output script may push for it to be considered
+	// standard.
+	DefaultMaxDataCarrierSize = txscript.MaxDataCarrierSize
 
 	// maxStandardSigScriptSize is the maximum size allowed for a
 	// transaction input signature script to be considered standard.  This
@@ -56,6 +63,117 @@ const (
 	maxStandardMultiSigKeys = 3
 )
 
+// dustRelayFee returns the fee rate used to determine whether outputs are dust,
+// falling back to the dust relay fee of the passed network and then the
+// minimum relay fee when the policy doesn't set it.
+func (p *Policy) dustRelayFee(params *chaincfg.Params) soterutil.Amount {
+	if p.DustRelayFee > 0 {
+		return p.DustRelayFee
+	}
+	if params != nil && params.DustRelayFee > 0 {
+		return soterutil.Amount(params.DustRelayFee)
+	}
+	return p.MinRelayTxFee
+}
+
+// maxStandardTxWeight returns the maximum weight of a standard transaction,
+// using the default when the policy doesn't set it.
+func (p *Policy) maxStandardTxWeight() int64 {
+	if p.MaxStandardTxWeight > 0 {
+		return p.MaxStandardTxWeight
+	}
+	return DefaultMaxStandardTxWeight
+}
+
+// maxStandardP2SHSigOps returns the maximum number of signature operations of
+// a standard pay-to-script-hash input, using the default when the policy
+// doesn't set it.
+func (p *Policy) maxStandardP2SHSigOps() int {
+	if p.MaxStandardP2SHSigOps > 0 {
+		return p.MaxStandardP2SHSigOps
+	}
+	return DefaultMaxStandardP2SHSigOps
+}
+
+// maxDataCarrierSize returns the maximum number of bytes carried by a standard
+// null data output, using the default when the policy doesn't set it.
+func (p *Policy) maxDataCarrierSize() int {
+	if p.MaxDataCarrierSize > 0 {
+		return p.MaxDataCarrierSize
+	}
+	return DefaultMaxDataCarrierSize
+}
+
+// scriptFlags returns the script verification flags used to validate the
+// transactions accepted into the mempool, using the standard flags when the
+// policy doesn't set them.
+func (p *Policy) scriptFlags() txscript.ScriptFlags {
+	if p.ScriptFlags != 0 {
+		return p.ScriptFlags
+	}
+	return txscript.StandardVerifyFlags
+}
+
+// Validate checks that the standardness settings of the policy are sane, so
+// that misconfigurations are reported when the mempool is set up rather than
+// silently rejecting or accepting every transaction.  Zero values are valid
+// since they select the defaults.
+func (p *Policy) Validate() error {
+	if p.MinRelayTxFee < 0 || p.DustRelayFee < 0 {
+		return fmt.Errorf("relay fees must not be negative: min relay "+
+			"fee %v, dust relay fee %v", p.MinRelayTxFee,
+			p.DustRelayFee)
+	}
+	if p.MaxSigOpCostPerTx <= 0 ||
+		p.MaxSigOpCostPerTx > blockdag.MaxBlockSigOpsCost {
+
+		return fmt.Errorf("max signature operation cost per transaction "+
+			"of %d is not in the valid range of 1-%d",
+			p.MaxSigOpCostPerTx, blockdag.MaxBlockSigOpsCost)
+	}
+	if p.MaxStandardTxWeight < 0 ||
+		p.MaxStandardTxWeight > blockdag.MaxBlockWeight {
+
+		return fmt.Errorf("max standard transaction weight of %d is not "+
+			"in the valid range of 0-%d", p.MaxStandardTxWeight,
+			blockdag.MaxBlockWeight)
+	}
+	if p.MaxStandardP2SHSigOps < 0 {
+		return fmt.Errorf("max standard pay-to-script-hash signature "+
+			"operations of %d must not be negative",
+			p.MaxStandardP2SHSigOps)
+	}
+	for class, threshold := range p.DustThresholds {
+		if threshold < 0 || threshold > soterutil.MaxNanoSoter {
+			return fmt.Errorf("dust threshold of %v for %v outputs "+
+				"is not in the valid range of 0-%v", threshold,
+				class, soterutil.Amount(soterutil.MaxNanoSoter))
+		}
+	}
+
+	// Null data scripts pushing more than txscript.MaxDataCarrierSize bytes
+	// aren't recognized as such, so a larger limit would have no effect.
+	if p.MaxDataCarrierSize < 0 ||
+		p.MaxDataCarrierSize > txscript.MaxDataCarrierSize {
+
+		return fmt.Errorf("max data carrier size of %d is not in the "+
+			"valid range of 0-%d", p.MaxDataCarrierSize,
+			txscript.MaxDataCarrierSize)
+	}
+
+	// The script engine refuses to verify the clean stack rule without
+	// pay-to-script-hash evaluation, which would reject every transaction.
+	flags := p.scriptFlags()
+	if flags&txscript.ScriptVerifyCleanStack != 0 &&
+		flags&txscript.ScriptBip16 == 0 {
+
+		return fmt.Errorf("script flags %#x enable the clean stack rule "+
+			"without pay-to-script-hash evaluation", flags)
+	}
+
+	return nil
+}
+
 // calcMinRequiredTxRelayFee returns the minimum transaction fee required for a
 // transaction with the passed serialized size to be accepted into the memory
 // pool and relayed.
@@ -84,13 +202,15 @@ func calcMinRequiredTxRelayFee(serializedSize int64, minRelayTxFee soterutil.Amo
 // to ensure they are "standard".  A standard transaction input within the
 // context of this function is one whose referenced public key script is of a
 // standard form and, for pay-to-script-hash, does not have more than
-// maxStandardP2SHSigOps signature operations.  However, it should also be noted
+// maxP2SHSigOps signature operations.  However, it should also be noted
 // that standard inputs also are those which have a clean stack after execution
 // and only contain pushed data in their signature scripts.  This function does
 // not perform those checks because the script engine already does this more
 // accurately and concisely via the txscript.ScriptVerifyCleanStack and
 // txscript.ScriptVerifySigPushOnly flags.
-func checkInputsStandard(tx *soterutil.Tx, utxoView *blockdag.UtxoViewpoint) error {
+func checkInputsStandard(tx *soterutil.Tx, utxoView *blockdag.UtxoViewpoint,
+	maxP2SHSigOps int) error {
+
 	// NOTE: The reference implementation also does a coinbase check here,
 	// but coinbases have already been rejected prior to calling this
 	// function so no need to recheck.
@@ -105,11 +225,11 @@ func checkInputsStandard(tx *soterutil.Tx, utxoView *blockdag.UtxoViewpoint) err
 		case txscript.ScriptHashTy:
 			numSigOps := txscript.GetPreciseSigOpCount(
 				txIn.SignatureScript, originPkScript, true)
-			if numSigOps > maxStandardP2SHSigOps {
+			if numSigOps > maxP2SHSigOps {
 				str := fmt.Sprintf("transaction input #%d has "+
 					"%d signature operations which is more "+
 					"than the allowed max amount of %d",
-					i, numSigOps, maxStandardP2SHSigOps)
+					i, numSigOps, maxP2SHSigOps)
 				return txRuleError(wire.RejectNonstandard, str)
 			}
 
@@ -269,16 +389,40 @@ func isDust(txOut *wire.TxOut, minRelayTxFee soterutil.Amount) bool {
 	return txOut.Value*1000/(3*int64(totalSize)) < int64(minRelayTxFee)
 }
 
+// isDustOutput returns whether or not the passed transaction output of the
+// passed script class is considered dust.  When there is a threshold for the
+// script class in the passed dust thresholds, outputs with a value below it
+// are dust.  Otherwise dust is determined based on the passed dust relay fee
+// as described by isDust.
+func isDustOutput(txOut *wire.TxOut, scriptClass txscript.ScriptClass,
+	dustRelayFee soterutil.Amount,
+	dustThresholds map[txscript.ScriptClass]soterutil.Amount) bool {
+
+	threshold, ok := dustThresholds[scriptClass]
+	if !ok {
+		return isDust(txOut, dustRelayFee)
+	}
+
+	// Unspendable outputs are considered dust regardless of the threshold.
+	if txscript.IsUnspendable(txOut.PkScript) {
+		return true
+	}
+	return txOut.Value < int64(threshold)
+}
+
 // checkTransactionStandard performs a series of checks on a transaction to
 // ensure it is a "standard" transaction.  A standard transaction is one that
 // conforms to several additional limiting cases over what is considered a
 // "sane" transaction such as having a version in the supported range, being
 // finalized, conforming to more stringent size constraints, having scripts
 // of recognized forms, and not containing "dust" outputs (those that are
-// so small it costs more to process them than they are worth).
+// so small it costs more to process them than they are worth).  Dust is
+// determined based on the passed dust thresholds for the script class of the
+// output when there is one, and the passed dust relay fee otherwise.
 func checkTransactionStandard(tx *soterutil.Tx, height int32,
-	medianTimePast time.Time, minRelayTxFee soterutil.Amount,
-	maxTxVersion int32) error {
+	medianTimePast time.Time, dustRelayFee soterutil.Amount,
+	dustThresholds map[txscript.ScriptClass]soterutil.Amount,
+	maxTxVersion int32, maxTxWeight int64, maxDataCarrierSize int) error {
 
 	// The transaction must be a currently supported version.
 	msgTx := tx.MsgTx()
@@ -301,9 +445,9 @@ func checkTransactionStandard(tx *soterutil.Tx, height int32,
 	// size of a transaction.  This also helps mitigate CPU exhaustion
 	// attacks.
 	txWeight := blockdag.GetTransactionWeight(tx)
-	if txWeight > maxStandardTxWeight {
+	if txWeight > maxTxWeight {
 		str := fmt.Sprintf("weight of transaction %v is larger than max "+
-			"allowed weight of %v", txWeight, maxStandardTxWeight)
+			"allowed weight of %v", txWeight, maxTxWeight)
 		return txRuleError(wire.RejectNonstandard, str)
 	}
 
@@ -347,12 +491,32 @@ func checkTransactionStandard(tx *soterutil.Tx, height int32,
 			return txRuleError(rejectCode, str)
 		}
 
-		// Accumulate the number of outputs which only carry data.  For
-		// all other script types, ensure the output value is not
-		// "dust".
+		// Accumulate the number of outputs which only carry data, and
+		// ensure they don't carry more data than allowed.  For all other
+		// script types, ensure the output value is not "dust".
 		if scriptClass == txscript.NullDataTy {
 			numNullDataOutputs++
-		} else if isDust(txOut, minRelayTxFee) {
+
+			pushes, err := txscript.PushedData(txOut.PkScript)
+			if err != nil {
+				str := fmt.Sprintf("transaction output %d: %v",
+					i, err)
+				return txRuleError(wire.RejectNonstandard, str)
+			}
+			dataSize := 0
+			for _, push := range pushes {
+				dataSize += len(push)
+			}
+			if dataSize > maxDataCarrierSize {
+				str := fmt.Sprintf("transaction output %d: null "+
+					"data script carries %d bytes which is "+
+					"more than the allowed max of %d", i,
+					dataSize, maxDataCarrierSize)
+				return txRuleError(wire.RejectNonstandard, str)
+			}
+		} else if isDustOutput(txOut, scriptClass, dustRelayFee,
+			dustThresholds) {
+
 			str := fmt.Sprintf("transaction output %d: payment "+
 				"of %d is dust", i, txOut.Value)
 			return txRuleError(wire.RejectDust, str)
@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/soteria-dag/soterd/blockdag"
 	"github.com/soteria-dag/soterd/soterec"
 	"github.com/soteria-dag/soterd/chaincfg"
 	"github.com/soteria-dag/soterd/chaincfg/chainhash"
@@ -42,13 +43,13 @@ func TestCalcMinRequiredTxRelayFee(t *testing.T) {
 		},
 		{
 			"max standard tx size with default minimum relay fee",
-			maxStandardTxWeight / 4,
+			DefaultMaxStandardTxWeight / 4,
 			DefaultMinRelayTxFee,
 			100000,
 		},
 		{
 			"max standard tx size with max nanoSoter relay fee",
-			maxStandardTxWeight / 4,
+			DefaultMaxStandardTxWeight / 4,
 			soterutil.MaxNanoSoter,
 			soterutil.MaxNanoSoter,
 		},
@@ -361,7 +362,7 @@ func TestCheckTransactionStandard(t *testing.T) {
 				TxOut: []*wire.TxOut{{
 					Value: 0,
 					PkScript: bytes.Repeat([]byte{0x00},
-						(maxStandardTxWeight/4)+1),
+						(DefaultMaxStandardTxWeight/4)+1),
 				}},
 				LockTime: 0,
 			},
@@ -471,7 +472,8 @@ func TestCheckTransactionStandard(t *testing.T) {
 	for _, test := range tests {
 		// Ensure standardness is as expected.
 		err := checkTransactionStandard(soterutil.NewTx(&test.tx),
-			test.height, pastMedianTime, DefaultMinRelayTxFee, 1)
+			test.height, pastMedianTime, DefaultMinRelayTxFee, nil,
+			1, DefaultMaxStandardTxWeight, DefaultMaxDataCarrierSize)
 		if err == nil && test.isStandard {
 			// Test passes since function returned standard for a
 			// transaction which is intended to be standard.
@@ -511,3 +513,250 @@ func TestCheckTransactionStandard(t *testing.T) {
 		}
 	}
 }
+
+// TestPolicyValidate ensures that sane policies pass validation and that the
+// misconfigured standardness settings are reported.
+func TestPolicyValidate(t *testing.T) {
+	valid := Policy{
+		MaxSigOpCostPerTx: blockdag.MaxBlockSigOpsCost / 4,
+		MinRelayTxFee:     DefaultMinRelayTxFee,
+	}
+
+	tests := []struct {
+		name   string
+		modify func(p *Policy)
+		valid  bool
+	}{
+		{
+			name:   "defaults",
+			modify: func(p *Policy) {},
+			valid:  true,
+		},
+		{
+			name: "custom standardness settings",
+			modify: func(p *Policy) {
+				p.DustRelayFee = 3000
+				p.DustThresholds = map[txscript.ScriptClass]soterutil.Amount{
+					txscript.PubKeyHashTy: 1000,
+				}
+				p.MaxStandardTxWeight = 100000
+				p.MaxStandardP2SHSigOps = 5
+				p.MaxDataCarrierSize = 40
+				p.ScriptFlags = txscript.ScriptBip16 |
+					txscript.ScriptVerifyCleanStack
+			},
+			valid: true,
+		},
+		{
+			name:   "negative dust relay fee",
+			modify: func(p *Policy) { p.DustRelayFee = -1 },
+		},
+		{
+			name:   "zero sigop cost per transaction",
+			modify: func(p *Policy) { p.MaxSigOpCostPerTx = 0 },
+		},
+		{
+			name: "sigop cost per transaction above block limit",
+			modify: func(p *Policy) {
+				p.MaxSigOpCostPerTx = blockdag.MaxBlockSigOpsCost + 1
+			},
+		},
+		{
+			name: "standard weight above block limit",
+			modify: func(p *Policy) {
+				p.MaxStandardTxWeight = blockdag.MaxBlockWeight + 1
+			},
+		},
+		{
+			name: "negative dust threshold",
+			modify: func(p *Policy) {
+				p.DustThresholds = map[txscript.ScriptClass]soterutil.Amount{
+					txscript.PubKeyHashTy: -1,
+				}
+			},
+		},
+		{
+			name:   "negative p2sh sigops",
+			modify: func(p *Policy) { p.MaxStandardP2SHSigOps = -1 },
+		},
+		{
+			name: "data carrier size above script limit",
+			modify: func(p *Policy) {
+				p.MaxDataCarrierSize = txscript.MaxDataCarrierSize + 1
+			},
+		},
+		{
+			name: "clean stack without p2sh",
+			modify: func(p *Policy) {
+				p.ScriptFlags = txscript.ScriptVerifyCleanStack
+			},
+		},
+	}
+
+	for _, test := range tests {
+		policy := valid
+		test.modify(&policy)
+		err := policy.Validate()
+		if test.valid && err != nil {
+			t.Errorf("Validate (%s): unexpected error: %v", test.name,
+				err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("Validate (%s): invalid policy accepted",
+				test.name)
+		}
+	}
+}
+
+// TestCheckTransactionStandardLimits ensures that the configurable standardness
+// limits are applied by checkTransactionStandard.
+func TestCheckTransactionStandardLimits(t *testing.T) {
+	prevOutHash, err := chainhash.NewHashFromStr("01")
+	if err != nil {
+		t.Fatalf("NewShaHashFromStr: unexpected error: %v", err)
+	}
+	txIn := wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: *prevOutHash, Index: 1},
+		SignatureScript:  bytes.Repeat([]byte{0x00}, 65),
+		Sequence:         wire.MaxTxInSequenceNum,
+	}
+	pkScript, err := txscript.NullDataScript(bytes.Repeat([]byte{0x01}, 20))
+	if err != nil {
+		t.Fatalf("NullDataScript: unexpected error: %v", err)
+	}
+	tx := soterutil.NewTx(&wire.MsgTx{
+		Version: 1,
+		TxIn:    []*wire.TxIn{&txIn},
+		TxOut:   []*wire.TxOut{{Value: 0, PkScript: pkScript}},
+	})
+	now := time.Now()
+
+	err = checkTransactionStandard(tx, 300000, now, DefaultMinRelayTxFee,
+		nil, 1, DefaultMaxStandardTxWeight, 20)
+	if err != nil {
+		t.Fatalf("checkTransactionStandard: unexpected error: %v", err)
+	}
+
+	// The data carried by the output exceeds a lower limit.
+	err = checkTransactionStandard(tx, 300000, now, DefaultMinRelayTxFee,
+		nil, 1, DefaultMaxStandardTxWeight, 19)
+	if code, _ := extractRejectCode(err); code != wire.RejectNonstandard {
+		t.Fatalf("checkTransactionStandard: got %v, want reject code "+
+			"%v", err, wire.RejectNonstandard)
+	}
+
+	// The transaction exceeds a lower weight limit.
+	weight := blockdag.GetTransactionWeight(tx)
+	err = checkTransactionStandard(tx, 300000, now, DefaultMinRelayTxFee,
+		nil, 1, weight-1, 20)
+	if code, _ := extractRejectCode(err); code != wire.RejectNonstandard {
+		t.Fatalf("checkTransactionStandard: got %v, want reject code "+
+			"%v", err, wire.RejectNonstandard)
+	}
+}
+
+// TestDustThresholds ensures that dust is determined by the thresholds for the
+// script class of outputs when there is one, and by the dust relay fee of the
+// policy or network otherwise.
+func TestDustThresholds(t *testing.T) {
+	addrHash := [20]byte{0x01}
+	addr, err := soterutil.NewAddressPubKeyHash(addrHash[:],
+		&chaincfg.TestNet1Params)
+	if err != nil {
+		t.Fatalf("NewAddressPubKeyHash: unexpected error: %v", err)
+	}
+	p2pkhScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatalf("PayToAddrScript: unexpected error: %v", err)
+	}
+	scriptHash := [20]byte{0x02}
+	p2shAddr, err := soterutil.NewAddressScriptHashFromHash(scriptHash[:],
+		&chaincfg.TestNet1Params)
+	if err != nil {
+		t.Fatalf("NewAddressScriptHashFromHash: unexpected error: %v", err)
+	}
+	p2shScript, err := txscript.PayToAddrScript(p2shAddr)
+	if err != nil {
+		t.Fatalf("PayToAddrScript: unexpected error: %v", err)
+	}
+	thresholds := map[txscript.ScriptClass]soterutil.Amount{
+		txscript.PubKeyHashTy: 10000,
+	}
+
+	tests := []struct {
+		name       string
+		txOut      wire.TxOut
+		relayFee   soterutil.Amount
+		thresholds map[txscript.ScriptClass]soterutil.Amount
+		isDust     bool
+	}{
+		{
+			name:     "p2pkh above relay fee dust without thresholds",
+			txOut:    wire.TxOut{Value: 546, PkScript: p2pkhScript},
+			relayFee: 1000,
+			isDust:   false,
+		},
+		{
+			name:       "p2pkh below class threshold",
+			txOut:      wire.TxOut{Value: 9999, PkScript: p2pkhScript},
+			relayFee:   1000,
+			thresholds: thresholds,
+			isDust:     true,
+		},
+		{
+			name:       "p2pkh at class threshold",
+			txOut:      wire.TxOut{Value: 10000, PkScript: p2pkhScript},
+			relayFee:   1000,
+			thresholds: thresholds,
+			isDust:     false,
+		},
+		{
+			name:       "p2sh without class threshold uses relay fee",
+			txOut:      wire.TxOut{Value: 540, PkScript: p2shScript},
+			relayFee:   1000,
+			thresholds: thresholds,
+			isDust:     false,
+		},
+		{
+			name:       "p2sh below relay fee dust",
+			txOut:      wire.TxOut{Value: 539, PkScript: p2shScript},
+			relayFee:   1000,
+			thresholds: thresholds,
+			isDust:     true,
+		},
+		{
+			name:  "unspendable output with zero class threshold",
+			txOut: wire.TxOut{Value: 5000, PkScript: []byte{0x01}},
+			thresholds: map[txscript.ScriptClass]soterutil.Amount{
+				txscript.NonStandardTy: 0,
+			},
+			isDust: true,
+		},
+	}
+	for _, test := range tests {
+		scriptClass := txscript.GetScriptClass(test.txOut.PkScript)
+		res := isDustOutput(&test.txOut, scriptClass, test.relayFee,
+			test.thresholds)
+		if res != test.isDust {
+			t.Errorf("isDustOutput (%s): want %v got %v", test.name,
+				test.isDust, res)
+		}
+	}
+
+	// The dust relay fee of the policy takes precedence over the one of
+	// the network, which takes precedence over the minimum relay fee.
+	params := chaincfg.SimNetParams
+	params.DustRelayFee = 0
+	policy := Policy{MinRelayTxFee: 1000}
+	if fee := policy.dustRelayFee(&params); fee != 1000 {
+		t.Errorf("dustRelayFee: got %v, want the min relay fee", fee)
+	}
+	params.DustRelayFee = 300
+	if fee := policy.dustRelayFee(&params); fee != 300 {
+		t.Errorf("dustRelayFee: got %v, want the network fee", fee)
+	}
+	policy.DustRelayFee = 3000
+	if fee := policy.dustRelayFee(&params); fee != 3000 {
+		t.Errorf("dustRelayFee: got %v, want the policy fee", fee)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+)
+
+// modifiedFee returns the fee of the transaction including the fee delta
+// applied via PrioritiseTransaction.
+func (txD *TxDesc) modifiedFee() int64 {
+	return txD.Fee + txD.FeeDelta
+}
+
+// applyFeeDelta adds the passed fee delta to the descriptor of a transaction in
+// the pool, along with the package statistics of the transaction, its
+// unconfirmed ancestors, and its descendants.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) applyFeeDelta(txD *TxDesc, feeDelta int64) {
+	for hash := range mp.txAncestors(txD.Tx, nil) {
+		mp.pool[hash].descendants.Fees += feeDelta
+	}
+	for hash := range mp.txDescendants(txD.Tx, nil) {
+		if descendant, ok := mp.pool[hash]; ok {
+			descendant.ancestors.Fees += feeDelta
+		}
+	}
+	txD.ancestors.Fees += feeDelta
+	txD.descendants.Fees += feeDelta
+
+	txD.FeeDelta += feeDelta
+	txD.FeePerKB = txD.modifiedFee() * 1000 / GetTxVirtualSize(txD.Tx)
+	mp.bumpGeneration()
+}
+
+// PrioritiseTransaction adds the passed virtual fee delta in nanoSoter to the
+// transaction with the passed hash, which allows miners to prioritise or
+// deprioritise transactions without them paying a different fee.  The delta is
+// taken into account by the fee policy when the transaction is accepted, by
+// the eviction of the lowest fee rate transactions, by replacements, and by the
+// ordering of the transactions selected for mining.  It does not change the
+// actual fee of the transaction collected by blocks.
+//
+// Deltas accumulate over multiple calls.  The transaction does not need to be
+// in the pool, in which case the delta is applied once it is accepted.  Deltas
+// are kept until the transaction is mined and are persisted along with the
+// transactions by Dump.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) PrioritiseTransaction(hash *chainhash.Hash, feeDelta int64) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	mp.prioritiseTransaction(hash, feeDelta)
+}
+
+// prioritiseTransaction is the internal function which implements the public
+// PrioritiseTransaction.  See the comment for PrioritiseTransaction for more
+// details.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) prioritiseTransaction(hash *chainhash.Hash, feeDelta int64) {
+	total := mp.feeDeltas[*hash] + feeDelta
+	if total == 0 {
+		delete(mp.feeDeltas, *hash)
+	} else {
+		mp.feeDeltas[*hash] = total
+	}
+
+	if txD, ok := mp.pool[*hash]; ok {
+		mp.applyFeeDelta(txD, feeDelta)
+	}
+
+	// The delta may change the outcome of validating the transaction if
+	// it was rejected.
+	mp.rejects.remove(hash)
+
+	log.Debugf("Prioritised transaction %v by %d nanoSoter (total delta: "+
+		"%d)", hash, feeDelta, total)
+}
+
+// FeeDelta returns the virtual fee delta applied to the transaction with the
+// passed hash via PrioritiseTransaction, or zero when there is none.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) FeeDelta(hash *chainhash.Hash) int64 {
+	mp.mtx.RLock()
+	feeDelta := mp.feeDeltas[*hash]
+	mp.mtx.RUnlock()
+
+	return feeDelta
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+const (
+	// DefaultPeerTxRelayLimit is the default amount in thousands of bytes
+	// per minute that the low-fee transactions relayed by a single peer
+	// are rate limited to.
+	DefaultPeerTxRelayLimit = 15.0
+
+	// DefaultPeerLowFeeMultiplier is the default multiple of the current
+	// minimum fee rate below which transactions are considered low-fee by
+	// the per-peer rate limiter.
+	DefaultPeerLowFeeMultiplier = 2
+)
+
+// peerRateLimit tracks the low-fee transactions relayed by a single peer.
+type peerRateLimit struct {
+	// total is the exponentially decaying total virtual size of the
+	// low-fee transactions accepted from the peer, as of lastUpdate.
+	total      float64
+	lastUpdate int64
+
+	// accepted and rejected are the number of low-fee transactions
+	// accepted from the peer and rejected by the rate limiter.
+	accepted uint64
+	rejected uint64
+}
+
+// decay decays the total of the rate limit up to the passed unix time with an
+// exponentially decaying ~10 minute window, matching the global free
+// transaction rate limiter.
+func (r *peerRateLimit) decay(nowUnix int64) {
+	r.total *= math.Pow(1.0-1.0/600.0, float64(nowUnix-r.lastUpdate))
+	r.lastUpdate = nowUnix
+}
+
+// PeerRateStats describes the state of the rate limiter for the transactions
+// relayed by a peer.
+type PeerRateStats struct {
+	// Tag identifies the peer.
+	Tag Tag
+
+	// LowFeeBytes is the decaying total virtual size of the low-fee
+	// transactions accepted from the peer, and Limit the total at which
+	// more low-fee transactions from the peer are rejected.
+	LowFeeBytes float64
+	Limit       float64
+
+	// Accepted and Rejected are the number of low-fee transactions from
+	// the peer which were accepted and rejected by the rate limiter
+	// respectively.
+	Accepted uint64
+	Rejected uint64
+}
+
+// peerTxRelayLimit returns the per-peer low-fee transaction relay limit in
+// bytes, using the default when the policy doesn't set it.
+func (p *Policy) peerTxRelayLimit() float64 {
+	limit := p.PeerTxRelayLimit
+	if limit <= 0 {
+		limit = DefaultPeerTxRelayLimit
+	}
+	return limit * 10 * 1000
+}
+
+// peerLowFeeRate returns the fee rate in nanoSoter/kB below which transactions
+// count against the rate limit of the peer relaying them.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) peerLowFeeRate() soterutil.Amount {
+	if mp.cfg.Policy.PeerLowFeeRate > 0 {
+		return mp.cfg.Policy.PeerLowFeeRate
+	}
+	return mp.minFee() * DefaultPeerLowFeeMultiplier
+}
+
+// checkPeerRateLimit rate limits the low-fee transactions relayed by the peer
+// identified by the passed tag, so that a single peer can't continuously churn
+// the pool with transactions paying the minimum fee.  The transaction is
+// counted against the limit of the peer when it is allowed.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) checkPeerRateLimit(tx *soterutil.Tx, txFee int64, tag Tag) error {
+	serializedSize := GetTxVirtualSize(tx)
+	lowFee := calcMinRequiredTxRelayFee(serializedSize, mp.peerLowFeeRate())
+	if txFee >= lowFee {
+		return nil
+	}
+
+	r, ok := mp.peerRates[tag]
+	if !ok {
+		r = &peerRateLimit{}
+		mp.peerRates[tag] = r
+	}
+	r.decay(time.Now().Unix())
+
+	limit := mp.cfg.Policy.peerTxRelayLimit()
+	if r.total >= limit {
+		r.rejected++
+		str := fmt.Sprintf("transaction %v has been rejected by the "+
+			"rate limiter of peer %d due to low fees", tx.Hash(), tag)
+		return txRuleError(wire.RejectInsufficientFee, str)
+	}
+
+	oldTotal := r.total
+	r.total += float64(serializedSize)
+	r.accepted++
+	log.Tracef("peer %d rate limit: curTotal %v, nextTotal: %v, limit %v",
+		tag, oldTotal, r.total, limit)
+
+	return nil
+}
+
+// PeerRateStats returns the state of the rate limiter for the transactions
+// relayed by all of the peers that relayed low-fee transactions, sorted by
+// tag.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) PeerRateStats() []PeerRateStats {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	nowUnix := time.Now().Unix()
+	limit := mp.cfg.Policy.peerTxRelayLimit()
+	stats := make([]PeerRateStats, 0, len(mp.peerRates))
+	for tag, r := range mp.peerRates {
+		r.decay(nowUnix)
+		stats = append(stats, PeerRateStats{
+			Tag:         tag,
+			LowFeeBytes: r.total,
+			Limit:       limit,
+			Accepted:    r.accepted,
+			Rejected:    r.rejected,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Tag < stats[j].Tag
+	})
+
+	return stats
+}
+
+// RemovePeerRateLimit forgets about the rate limiter state of the peer
+// identified by the passed tag.  It is intended to be called once the peer
+// disconnects.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) RemovePeerRateLimit(tag Tag) {
+	mp.mtx.Lock()
+	delete(mp.peerRates, tag)
+	mp.mtx.Unlock()
+}
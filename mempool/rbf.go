@@ -0,0 +1,326 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"fmt"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+const (
+	// MaxRBFSequence is the maximum sequence number an input can use to
+	// signal that the transaction spending it can be replaced using the
+	// Replace-By-Fee (RBF) policy.
+	MaxRBFSequence = 0xfffffffd
+
+	// DefaultMaxReplacementEvictions is the default maximum number of
+	// transactions that can be evicted from the mempool when accepting a
+	// replacement transaction.
+	DefaultMaxReplacementEvictions = 100
+)
+
+// signalsReplacement determines if a transaction is signaling that it can be
+// replaced using the Replace-By-Fee (RBF) policy.  This policy specifies two
+// ways a transaction can signal that it is replaceable:
+//
+// Explicit signaling: A transaction is considered to have opted in to allowing
+// replacement of itself if any of its inputs have a sequence number less than
+// or equal to MaxRBFSequence.
+//
+// Inherited signaling: Transactions that don't explicitly signal replaceability
+// are replaceable under this policy for as long as any one of their ancestors
+// signals replaceability and remains unconfirmed.
+//
+// The cache is optional and serves as an optimization to avoid visiting
+// transactions that were already determined to not signal replacement.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) signalsReplacement(tx *soterutil.Tx,
+	cache map[chainhash.Hash]struct{}) bool {
+
+	// If a cache was not provided, initialize one now to use for the
+	// recursive calls.
+	if cache == nil {
+		cache = make(map[chainhash.Hash]struct{})
+	}
+
+	for _, txIn := range tx.MsgTx().TxIn {
+		if txIn.Sequence <= MaxRBFSequence {
+			return true
+		}
+
+		hash := txIn.PreviousOutPoint.Hash
+		unconfirmedParent, ok := mp.pool[hash]
+		if !ok {
+			continue
+		}
+
+		// Avoid visiting transactions that are already known to not
+		// signal replacement.
+		if _, ok := cache[hash]; ok {
+			continue
+		}
+
+		if mp.signalsReplacement(unconfirmedParent.Tx, cache) {
+			return true
+		}
+
+		cache[hash] = struct{}{}
+	}
+
+	return false
+}
+
+// isReplaceable returns whether the passed transaction in the pool can be
+// replaced according to the replacement policy.  Every transaction is
+// replaceable when full replacement is enabled, otherwise only transactions
+// that signal replacement are.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) isReplaceable(tx *soterutil.Tx) bool {
+	if mp.cfg.Policy.RejectReplacement {
+		return false
+	}
+
+	return mp.cfg.Policy.FullReplacement || mp.signalsReplacement(tx, nil)
+}
+
+// txAncestors returns all of the unconfirmed ancestors of the given
+// transaction.  Given transactions A, B, and C where C spends B and B spends A,
+// A and B are considered ancestors of C.
+//
+// The cache is optional and serves as an optimization to avoid visiting
+// transactions whose ancestors were already determined.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) txAncestors(tx *soterutil.Tx,
+	cache map[chainhash.Hash]map[chainhash.Hash]*soterutil.Tx) map[chainhash.Hash]*soterutil.Tx {
+
+	// If a cache was not provided, initialize one now to use for the
+	// recursive calls.
+	if cache == nil {
+		cache = make(map[chainhash.Hash]map[chainhash.Hash]*soterutil.Tx)
+	}
+
+	ancestors := make(map[chainhash.Hash]*soterutil.Tx)
+	for _, txIn := range tx.MsgTx().TxIn {
+		parent, ok := mp.pool[txIn.PreviousOutPoint.Hash]
+		if !ok {
+			continue
+		}
+		ancestors[*parent.Tx.Hash()] = parent.Tx
+
+		// Determine if the ancestors of this ancestor have already been
+		// computed, and compute and cache them otherwise.
+		moreAncestors, ok := cache[*parent.Tx.Hash()]
+		if !ok {
+			moreAncestors = mp.txAncestors(parent.Tx, cache)
+			cache[*parent.Tx.Hash()] = moreAncestors
+		}
+
+		for hash, ancestor := range moreAncestors {
+			ancestors[hash] = ancestor
+		}
+	}
+
+	return ancestors
+}
+
+// txDescendants returns all of the unconfirmed descendants of the given
+// transaction.  Given transactions A, B, and C where C spends B and B spends A,
+// B and C are considered descendants of A.
+//
+// The cache is optional and serves as an optimization to avoid visiting
+// transactions whose descendants were already determined.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) txDescendants(tx *soterutil.Tx,
+	cache map[chainhash.Hash]map[chainhash.Hash]*soterutil.Tx) map[chainhash.Hash]*soterutil.Tx {
+
+	// If a cache was not provided, initialize one now to use for the
+	// recursive calls.
+	if cache == nil {
+		cache = make(map[chainhash.Hash]map[chainhash.Hash]*soterutil.Tx)
+	}
+
+	descendants := make(map[chainhash.Hash]*soterutil.Tx)
+	prevOut := wire.OutPoint{Hash: *tx.Hash()}
+	for txOutIdx := range tx.MsgTx().TxOut {
+		prevOut.Index = uint32(txOutIdx)
+		child, ok := mp.outpoints[prevOut]
+		if !ok {
+			continue
+		}
+		descendants[*child.Hash()] = child
+
+		// Determine if the descendants of this descendant have already
+		// been computed, and compute and cache them otherwise.
+		moreDescendants, ok := cache[*child.Hash()]
+		if !ok {
+			moreDescendants = mp.txDescendants(child, cache)
+			cache[*child.Hash()] = moreDescendants
+		}
+
+		for hash, descendant := range moreDescendants {
+			descendants[hash] = descendant
+		}
+	}
+
+	return descendants
+}
+
+// txConflicts returns all of the unconfirmed transactions that would become
+// conflicts if the given transaction were accepted into the mempool.  An
+// unconfirmed conflict is known as a transaction that spends an output already
+// spent by a different transaction within the mempool.  Any descendants of
+// these transactions are also considered conflicts as they would no longer
+// exist.  These are generally not allowed except for transactions that signal
+// replacement (or when full replacement is enabled).
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) txConflicts(tx *soterutil.Tx) map[chainhash.Hash]*soterutil.Tx {
+	conflicts := make(map[chainhash.Hash]*soterutil.Tx)
+	for _, txIn := range tx.MsgTx().TxIn {
+		conflict, ok := mp.outpoints[txIn.PreviousOutPoint]
+		if !ok {
+			continue
+		}
+		conflicts[*conflict.Hash()] = conflict
+		for hash, descendant := range mp.txDescendants(conflict, nil) {
+			conflicts[hash] = descendant
+		}
+	}
+
+	return conflicts
+}
+
+// validateReplacement determines whether a transaction is deemed as a valid
+// replacement of all of its conflicts according to the replacement policy.  If
+// it is valid, the set of conflicts which will be evicted from the mempool is
+// returned.  The fees of the transaction and its conflicts include the fee
+// deltas applied via PrioritiseTransaction.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) validateReplacement(tx *soterutil.Tx,
+	txFee int64) (map[chainhash.Hash]*soterutil.Tx, error) {
+
+	// First, make sure the set of conflicting transactions doesn't exceed
+	// the maximum allowed.
+	maxEvictions := mp.cfg.Policy.MaxReplacementEvictions
+	if maxEvictions <= 0 {
+		maxEvictions = DefaultMaxReplacementEvictions
+	}
+	conflicts := mp.txConflicts(tx)
+	if len(conflicts) > maxEvictions {
+		str := fmt.Sprintf("replacement transaction %v evicts more "+
+			"transactions than permitted: max is %v, evicts %v",
+			tx.Hash(), maxEvictions, len(conflicts))
+		return nil, txRuleError(wire.RejectNonstandard, str)
+	}
+
+	// The set of conflicts (transactions to be replaced) and ancestors
+	// should not overlap, otherwise the replacement would be spending an
+	// output that no longer exists.
+	for ancestorHash := range mp.txAncestors(tx, nil) {
+		if _, ok := conflicts[ancestorHash]; !ok {
+			continue
+		}
+		str := fmt.Sprintf("replacement transaction %v spends parent "+
+			"transaction %v", tx.Hash(), ancestorHash)
+		return nil, txRuleError(wire.RejectInvalid, str)
+	}
+
+	// The replacement should have a higher fee rate than each of the
+	// conflicting transactions and a higher absolute fee than the fee sum
+	// of all the conflicting transactions.
+	//
+	// Replacements with lower fee rates than what they replace are not
+	// accepted as that would lower the fee rate of the next block.
+	// Requiring that the fee rate always be increased is also an easy to
+	// reason about way to prevent DoS attacks via replacements.
+	var (
+		txSize           = GetTxVirtualSize(tx)
+		txFeeRate        = txFee * 1000 / txSize
+		conflictsFee     int64
+		conflictsParents = make(map[chainhash.Hash]struct{})
+	)
+	for hash, conflict := range conflicts {
+		if txFeeRate <= mp.pool[hash].FeePerKB {
+			str := fmt.Sprintf("replacement transaction %v has an "+
+				"insufficient fee rate: needs more than %v, "+
+				"has %v", tx.Hash(), mp.pool[hash].FeePerKB,
+				txFeeRate)
+			return nil, txRuleError(wire.RejectInsufficientFee, str)
+		}
+
+		conflictsFee += mp.pool[hash].modifiedFee()
+
+		// Track each conflict's parents to ensure the replacement isn't
+		// spending any new unconfirmed inputs.
+		for _, txIn := range conflict.MsgTx().TxIn {
+			conflictsParents[txIn.PreviousOutPoint.Hash] = struct{}{}
+		}
+	}
+
+	// It should also have an absolute fee greater than all of the
+	// transactions it intends to replace and pay for its own bandwidth,
+	// which is determined by the minimum relay fee.
+	minFee := calcMinRequiredTxRelayFee(txSize, mp.cfg.Policy.MinRelayTxFee)
+	if txFee < conflictsFee+minFee {
+		str := fmt.Sprintf("replacement transaction %v has an "+
+			"insufficient absolute fee: needs %v, has %v",
+			tx.Hash(), conflictsFee+minFee, txFee)
+		return nil, txRuleError(wire.RejectInsufficientFee, str)
+	}
+
+	// Finally, it should not spend any new unconfirmed outputs, other than
+	// the ones already included in the parents of the conflicting
+	// transactions it replaces.
+	for _, txIn := range tx.MsgTx().TxIn {
+		if _, ok := conflictsParents[txIn.PreviousOutPoint.Hash]; ok {
+			continue
+		}
+
+		// Confirmed outputs are valid to spend in the replacement.
+		if _, ok := mp.pool[txIn.PreviousOutPoint.Hash]; !ok {
+			continue
+		}
+		str := fmt.Sprintf("replacement transaction spends new "+
+			"unconfirmed input %v not found in conflicting "+
+			"transactions", txIn.PreviousOutPoint)
+		return nil, txRuleError(wire.RejectInvalid, str)
+	}
+
+	return conflicts, nil
+}
+
+// replaceConflicts removes the passed conflicts of a replacement transaction
+// from the pool, and returns the descriptors of the removed transactions.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) replaceConflicts(replacement *soterutil.Tx,
+	conflicts map[chainhash.Hash]*soterutil.Tx) []*TxDesc {
+
+	// Gather the descriptors first since removing a conflict also removes
+	// its descendants, which are conflicts themselves.
+	replaced := make([]*TxDesc, 0, len(conflicts))
+	for hash := range conflicts {
+		if txDesc, ok := mp.pool[hash]; ok {
+			replaced = append(replaced, txDesc)
+		}
+	}
+
+	for _, txDesc := range replaced {
+		log.Debugf("Replacing transaction %v (fee rate %v nanoSoter/kB) "+
+			"with %v", txDesc.Tx.Hash(), txDesc.FeePerKB,
+			replacement.Hash())
+		mp.removeTransaction(txDesc.Tx, true, RemovalReplacement)
+	}
+
+	return replaced
+}
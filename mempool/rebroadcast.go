@@ -0,0 +1,218 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"sync"
+	"time"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/soterutil"
+)
+
+const (
+	// DefaultRebroadcastInterval is the default amount of time to wait
+	// before re-announcing a locally submitted transaction for the first
+	// time.  The interval doubles after each announcement.
+	DefaultRebroadcastInterval = time.Minute * 5
+
+	// DefaultMaxRebroadcastInterval is the default maximum amount of time
+	// in between announcements of a locally submitted transaction.
+	DefaultMaxRebroadcastInterval = time.Hour * 4
+
+	// rebroadcastTickInterval is the interval at which the rebroadcast
+	// manager checks for transactions due to be re-announced.
+	rebroadcastTickInterval = time.Second * 30
+)
+
+// RebroadcastConfig is a descriptor containing the configuration of a
+// RebroadcastManager.
+type RebroadcastConfig struct {
+	// Pool is the transaction pool the tracked transactions are expected
+	// to be in.  Transactions are no longer tracked once they leave it,
+	// such as when they are mined or expire.
+	Pool *TxPool
+
+	// Announce defines the function to call to announce the passed
+	// transactions to the peers again.
+	Announce func(txns []*TxDesc)
+
+	// Interval is the amount of time to wait before re-announcing a
+	// transaction for the first time, which doubles after each
+	// announcement up to MaxInterval.  The defaults of
+	// DefaultRebroadcastInterval and DefaultMaxRebroadcastInterval are
+	// used when they are zero.
+	Interval    time.Duration
+	MaxInterval time.Duration
+}
+
+// rebroadcastTx tracks the announcements of a locally submitted transaction.
+type rebroadcastTx struct {
+	tx           *soterutil.Tx
+	interval     time.Duration
+	nextAnnounce time.Time
+	announced    int
+}
+
+// RebroadcastManager tracks the transactions submitted locally, such as via
+// RPC, separately from the ones relayed by peers, and periodically re-announces
+// them with exponential backoff until they leave the pool.  This prevents
+// transactions of the users of the node from being lost when their initial
+// propagation fails.
+type RebroadcastManager struct {
+	cfg RebroadcastConfig
+
+	mtx  sync.Mutex
+	txns map[chainhash.Hash]*rebroadcastTx
+
+	wg   sync.WaitGroup
+	quit chan struct{}
+}
+
+// NewRebroadcastManager returns a new rebroadcast manager using the passed
+// configuration.  Use Start to begin re-announcing transactions.
+func NewRebroadcastManager(cfg *RebroadcastConfig) *RebroadcastManager {
+	m := &RebroadcastManager{
+		cfg:  *cfg,
+		txns: make(map[chainhash.Hash]*rebroadcastTx),
+		quit: make(chan struct{}),
+	}
+	if m.cfg.Interval <= 0 {
+		m.cfg.Interval = DefaultRebroadcastInterval
+	}
+	if m.cfg.MaxInterval <= 0 {
+		m.cfg.MaxInterval = DefaultMaxRebroadcastInterval
+	}
+	return m
+}
+
+// Add starts tracking the passed locally submitted transaction, which is
+// expected to have been announced already.  Adding a transaction which is
+// already tracked has no effect.
+//
+// This function is safe for concurrent access.
+func (m *RebroadcastManager) Add(tx *soterutil.Tx) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if _, ok := m.txns[*tx.Hash()]; ok {
+		return
+	}
+	m.txns[*tx.Hash()] = &rebroadcastTx{
+		tx:           tx,
+		interval:     m.cfg.Interval,
+		nextAnnounce: time.Now().Add(m.cfg.Interval),
+	}
+}
+
+// Remove stops tracking the transaction with the passed hash.
+//
+// This function is safe for concurrent access.
+func (m *RebroadcastManager) Remove(hash *chainhash.Hash) {
+	m.mtx.Lock()
+	delete(m.txns, *hash)
+	m.mtx.Unlock()
+}
+
+// Count returns the number of tracked transactions.
+//
+// This function is safe for concurrent access.
+func (m *RebroadcastManager) Count() int {
+	m.mtx.Lock()
+	count := len(m.txns)
+	m.mtx.Unlock()
+
+	return count
+}
+
+// rebroadcast re-announces the tracked transactions which are due as of the
+// passed time, and stops tracking the ones which left the pool.  The announced
+// transactions are returned.
+//
+// This function is safe for concurrent access.
+func (m *RebroadcastManager) rebroadcast(now time.Time) []*TxDesc {
+	due := m.dueTransactions(now)
+	if len(due) > 0 {
+		log.Debugf("Rebroadcasting %d local %s", len(due),
+			pickNoun(len(due), "transaction", "transactions"))
+		m.cfg.Announce(due)
+	}
+
+	return due
+}
+
+// dueTransactions returns the descriptors of the tracked transactions which are
+// due to be re-announced as of the passed time and schedules their next
+// announcement.  The transactions which left the pool are no longer tracked.
+//
+// This function is safe for concurrent access.
+func (m *RebroadcastManager) dueTransactions(now time.Time) []*TxDesc {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	var due []*TxDesc
+	m.cfg.Pool.mtx.RLock()
+	for hash, rtx := range m.txns {
+		txD, ok := m.cfg.Pool.pool[hash]
+		if !ok {
+			log.Debugf("Stopped rebroadcasting transaction %v after "+
+				"%d %s", hash, rtx.announced,
+				pickNoun(rtx.announced, "announcement",
+					"announcements"))
+			delete(m.txns, hash)
+			continue
+		}
+		if now.Before(rtx.nextAnnounce) {
+			continue
+		}
+
+		// Conflicted transactions are still tracked, but aren't
+		// announced until they're pending again.
+		if m.cfg.Pool.isConflicted(txD.Tx) {
+			continue
+		}
+
+		due = append(due, txD)
+		rtx.announced++
+		rtx.interval *= 2
+		if rtx.interval > m.cfg.MaxInterval {
+			rtx.interval = m.cfg.MaxInterval
+		}
+		rtx.nextAnnounce = now.Add(rtx.interval)
+	}
+	m.cfg.Pool.mtx.RUnlock()
+
+	return due
+}
+
+// handler periodically re-announces the tracked transactions.  It must be run
+// as a goroutine.
+func (m *RebroadcastManager) handler() {
+	ticker := time.NewTicker(rebroadcastTickInterval)
+	defer ticker.Stop()
+	defer m.wg.Done()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			m.rebroadcast(now)
+
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// Start begins periodically re-announcing the tracked transactions.
+func (m *RebroadcastManager) Start() {
+	m.wg.Add(1)
+	go m.handler()
+}
+
+// Stop stops re-announcing transactions and waits for the handler to exit.
+func (m *RebroadcastManager) Stop() {
+	close(m.quit)
+	m.wg.Wait()
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"container/list"
+	"sync/atomic"
+	"time"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// DefaultMaxRejectCacheEntries is the default maximum number of recently
+// rejected transactions remembered by the pool.
+const DefaultMaxRejectCacheEntries = 10000
+
+// RejectEntry describes why a transaction was recently rejected by the pool.
+type RejectEntry struct {
+	// Hash is the hash of the rejected transaction, and WitnessHash its
+	// hash including the witness data.
+	Hash        chainhash.Hash
+	WitnessHash chainhash.Hash
+
+	// Code and Reason describe why the transaction was rejected in the
+	// form suitable for a wire.MsgReject message.
+	Code   wire.RejectCode
+	Reason string
+
+	// Time is when the transaction was rejected, and Height the height of
+	// the DAG at the time.
+	Time   time.Time
+	Height int32
+
+	// Tag identifies the peer the transaction was received from, if any.
+	Tag Tag
+
+	// dagGeneration is the generation of the DAG at the time of the
+	// rejection, see TxPool.dagGeneration.
+	dagGeneration uint64
+}
+
+// rejectCache is a bounded cache of recently rejected transactions, which
+// forgets about the oldest rejections once it is full.
+type rejectCache struct {
+	entries map[chainhash.Hash]*list.Element
+	order   *list.List
+}
+
+// newRejectCache returns a new empty reject cache.
+func newRejectCache() *rejectCache {
+	return &rejectCache{
+		entries: make(map[chainhash.Hash]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// add records the passed rejection, replacing any previous rejection of the
+// same transaction, and evicts the oldest rejections beyond the passed limit.
+func (c *rejectCache) add(entry *RejectEntry, limit int) {
+	c.remove(&entry.Hash)
+	c.entries[entry.Hash] = c.order.PushBack(entry)
+	for c.order.Len() > limit {
+		oldest := c.order.Remove(c.order.Front()).(*RejectEntry)
+		delete(c.entries, oldest.Hash)
+	}
+}
+
+// lookup returns the rejection of the transaction with the passed hash, if
+// any.
+func (c *rejectCache) lookup(hash *chainhash.Hash) (*RejectEntry, bool) {
+	elem, ok := c.entries[*hash]
+	if !ok {
+		return nil, false
+	}
+	return elem.Value.(*RejectEntry), true
+}
+
+// remove forgets about the rejection of the transaction with the passed hash.
+func (c *rejectCache) remove(hash *chainhash.Hash) {
+	if elem, ok := c.entries[*hash]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, *hash)
+	}
+}
+
+// maxRejectCacheEntries returns the size of the reject cache, using the
+// default when the policy doesn't set it.
+func (p *Policy) maxRejectCacheEntries() int {
+	if p.MaxRejectCacheEntries > 0 {
+		return p.MaxRejectCacheEntries
+	}
+	return DefaultMaxRejectCacheEntries
+}
+
+// recordReject remembers that the passed transaction was rejected due to the
+// passed error and reports the rejection to the metrics, if any.  Only rule
+// errors are recorded since other errors don't describe a problem with the
+// transaction.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) recordReject(tx *soterutil.Tx, err error, tag Tag) {
+	if _, ok := err.(RuleError); !ok {
+		return
+	}
+
+	code, reason := ErrToRejectErr(err)
+	if mp.cfg.Metrics != nil {
+		mp.cfg.Metrics.TxRejected(code)
+	}
+	mp.rejects.add(&RejectEntry{
+		Hash:          *tx.Hash(),
+		WitnessHash:   tx.MsgTx().WitnessHash(),
+		Code:          code,
+		Reason:        reason,
+		Time:          time.Now(),
+		Height:        mp.cfg.BestHeight(),
+		Tag:           tag,
+		dagGeneration: atomic.LoadUint64(&mp.dagGeneration),
+	}, mp.cfg.Policy.maxRejectCacheEntries())
+}
+
+// cachedReject returns the error of a recent rejection of the passed
+// transaction which still applies, so the transaction doesn't need to be
+// validated again.  Rejections only apply to the same transaction including
+// its witness, since a copy with a malleated witness may be rejected while the
+// original is valid.  They also only apply until blocks are connected to or
+// disconnected from the DAG, as that may make the transaction valid, so they
+// are never reused when the mempool isn't subscribed to the notifications of
+// the DAG.  Rejections due to insufficient fees or duplicates aren't reused
+// either, since they depend on the changing contents of the pool.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) cachedReject(tx *soterutil.Tx) error {
+	entry, ok := mp.rejects.lookup(tx.Hash())
+	if !ok || mp.cfg.SubscribeDAG == nil ||
+		entry.dagGeneration != atomic.LoadUint64(&mp.dagGeneration) ||
+		entry.WitnessHash != tx.MsgTx().WitnessHash() {
+
+		return nil
+	}
+
+	switch entry.Code {
+	case wire.RejectInsufficientFee, wire.RejectDuplicate:
+		return nil
+	}
+
+	return txRuleError(entry.Code, entry.Reason)
+}
+
+// RejectedTransaction returns why the transaction with the passed hash was
+// rejected, if it was rejected recently.  This allows reporting why a
+// transaction isn't in the pool after the fact.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) RejectedTransaction(hash *chainhash.Hash) (*RejectEntry, bool) {
+	mp.mtx.RLock()
+	entry, ok := mp.rejects.lookup(hash)
+	mp.mtx.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+	entryCopy := *entry
+	return &entryCopy, true
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+)
+
+// Snapshot is an immutable view of the transactions in the main pool at a
+// point in time.  It can be iterated without holding the mempool lock, so that
+// long running readers, such as RPC handlers listing the pool or the block
+// template generator, don't block the acceptance of new transactions.  The
+// descriptors in a snapshot are copies which are never modified, and are to be
+// treated as read only.
+type Snapshot struct {
+	generation  uint64
+	descs       []*TxDesc
+	index       map[chainhash.Hash]*TxDesc
+	conflicted  map[chainhash.Hash]struct{}
+	poolSize    int64
+	memoryUsage int64
+}
+
+// Generation returns the generation of the pool the snapshot was taken at.
+// The generation changes whenever the transactions in the pool or their
+// descriptors change, so two snapshots with the same generation are
+// identical.
+func (s *Snapshot) Generation() uint64 {
+	return s.generation
+}
+
+// Count returns the number of transactions in the snapshot.
+func (s *Snapshot) Count() int {
+	return len(s.descs)
+}
+
+// TxDescs returns the descriptors of all of the transactions in the snapshot
+// in no particular order.  The returned slice is shared by all of the readers
+// of the snapshot and MUST NOT be modified.
+func (s *Snapshot) TxDescs() []*TxDesc {
+	return s.descs
+}
+
+// Lookup returns the descriptor of the transaction with the passed hash, and
+// whether it is in the snapshot.
+func (s *Snapshot) Lookup(hash *chainhash.Hash) (*TxDesc, bool) {
+	txD, ok := s.index[*hash]
+	return txD, ok
+}
+
+// IsConflicted returns whether the transaction with the passed hash, or one of
+// its unconfirmed ancestors, was conflicted when the snapshot was taken.  See
+// TxStateConflicted.
+func (s *Snapshot) IsConflicted(hash *chainhash.Hash) bool {
+	_, ok := s.conflicted[*hash]
+	return ok
+}
+
+// ForEach calls the passed function with each of the descriptors in the
+// snapshot until it returns false.
+func (s *Snapshot) ForEach(fn func(txD *TxDesc) bool) {
+	for _, txD := range s.descs {
+		if !fn(txD) {
+			return
+		}
+	}
+}
+
+// VirtualSize returns the total virtual size of the transactions in the
+// snapshot.
+func (s *Snapshot) VirtualSize() int64 {
+	return s.poolSize
+}
+
+// MemoryUsage returns the estimated memory usage of the transactions in the
+// snapshot as reported by GetMemoryUsage.
+func (s *Snapshot) MemoryUsage() int64 {
+	return s.memoryUsage
+}
+
+// bumpGeneration marks the contents of the pool as changed, so that the next
+// call to Snapshot takes a new snapshot.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) bumpGeneration() {
+	mp.generation++
+}
+
+// Generation returns the current generation of the pool, which changes
+// whenever the transactions in the pool or their descriptors change.  It
+// implements the miningdag.GenerationTxSource interface, so the block template
+// generator can tell whether the pool changed since its last template.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Generation() uint64 {
+	mp.mtx.RLock()
+	generation := mp.generation
+	mp.mtx.RUnlock()
+
+	return generation
+}
+
+// Snapshot returns an immutable view of the transactions in the main pool.
+// Snapshots are only taken when the pool changed since the last one, so
+// repeated calls are cheap while the pool doesn't change.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Snapshot() *Snapshot {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	if s, ok := mp.snapshot.Load().(*Snapshot); ok &&
+		s.generation == mp.generation {
+
+		return s
+	}
+
+	s := &Snapshot{
+		generation:  mp.generation,
+		descs:       make([]*TxDesc, 0, len(mp.pool)),
+		index:       make(map[chainhash.Hash]*TxDesc, len(mp.pool)),
+		conflicted:  mp.conflictedTxns(),
+		poolSize:    mp.poolSize,
+		memoryUsage: mp.memoryUsage,
+	}
+	for hash, txD := range mp.pool {
+		txDCopy := *txD
+		s.descs = append(s.descs, &txDCopy)
+		s.index[hash] = &txDCopy
+	}
+	mp.snapshot.Store(s)
+
+	return s
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/soterlog"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// DecisionOutcome describes what the pool decided to do with a transaction it
+// processed.
+type DecisionOutcome int
+
+const (
+	// DecisionAccepted indicates the transaction was added to the main
+	// pool.
+	DecisionAccepted DecisionOutcome = iota
+
+	// DecisionOrphaned indicates the transaction was added to the orphan
+	// pool since it spends unknown outputs.
+	DecisionOrphaned
+
+	// DecisionRejected indicates the transaction was rejected.
+	DecisionRejected
+)
+
+// Map of DecisionOutcome values back to their constant names for pretty
+// printing.
+var decisionOutcomeStrings = map[DecisionOutcome]string{
+	DecisionAccepted: "DecisionAccepted",
+	DecisionOrphaned: "DecisionOrphaned",
+	DecisionRejected: "DecisionRejected",
+}
+
+// String returns the DecisionOutcome as a human-readable name.
+func (o DecisionOutcome) String() string {
+	if s := decisionOutcomeStrings[o]; s != "" {
+		return s
+	}
+	return fmt.Sprintf("Unknown DecisionOutcome (%d)", int(o))
+}
+
+// Decision describes the decision of the pool about a transaction it
+// processed, so it can be analyzed after the fact why a transaction wasn't
+// relayed.
+type Decision struct {
+	// Time is when the decision was made.
+	Time time.Time
+
+	// Hash is the hash of the transaction.
+	Hash chainhash.Hash
+
+	// Outcome is what the pool decided to do with the transaction.
+	Outcome DecisionOutcome
+
+	// FeeRate is the fee rate of the transaction in nanoSoter/kB
+	// including its fee delta, and Size its virtual size in bytes.  The fee
+	// rate is only known for accepted transactions and zero otherwise.
+	FeeRate int64
+	Size    int64
+
+	// Code and Reason describe why the transaction was rejected.  The code
+	// is only meaningful for rejected transactions.
+	Code   wire.RejectCode
+	Reason string
+
+	// Peer identifies the peer the transaction was received from.
+	Peer Tag
+}
+
+// String returns the decision formatted as structured log fields.
+func (d *Decision) String() string {
+	fields := []interface{}{
+		"txid", d.Hash,
+		"outcome", d.Outcome,
+		"feerate", d.FeeRate,
+		"size", d.Size,
+		"peer", d.Peer,
+	}
+	if d.Outcome == DecisionRejected {
+		fields = append(fields, "code", d.Code, "reason", d.Reason)
+	}
+	return logFields(fields...)
+}
+
+// decisionTrace is a ring buffer of the most recent decisions of the pool.
+type decisionTrace struct {
+	decisions []Decision
+	next      int
+	full      bool
+}
+
+// newDecisionTrace returns a new empty decision trace keeping the passed number
+// of decisions.  It returns nil when the size is not positive, in which case
+// decisions aren't traced.
+func newDecisionTrace(size int) *decisionTrace {
+	if size <= 0 {
+		return nil
+	}
+	return &decisionTrace{decisions: make([]Decision, size)}
+}
+
+// add records the passed decision, overwriting the oldest one when the trace
+// is full.
+func (t *decisionTrace) add(d *Decision) {
+	t.decisions[t.next] = *d
+	t.next = (t.next + 1) % len(t.decisions)
+	if t.next == 0 {
+		t.full = true
+	}
+}
+
+// all returns copies of the traced decisions ordered from oldest to newest.
+func (t *decisionTrace) all() []Decision {
+	if !t.full {
+		return append([]Decision(nil), t.decisions[:t.next]...)
+	}
+	decisions := make([]Decision, 0, len(t.decisions))
+	decisions = append(decisions, t.decisions[t.next:]...)
+	return append(decisions, t.decisions[:t.next]...)
+}
+
+// recordDecision logs the passed decision and adds it to the decision trace
+// when tracing is enabled.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) recordDecision(d *Decision) {
+	if log.Level() <= soterlog.LevelDebug {
+		log.Debugf("Transaction decision: %v", d)
+	}
+	if mp.decisions != nil {
+		mp.decisions.add(d)
+	}
+}
+
+// traceProcessed records the decisions about the transaction processed by
+// ProcessTransaction given its results, including the orphans accepted along
+// with it.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) traceProcessed(tx *soterutil.Tx, tag Tag,
+	acceptedTxs []*TxDesc, err error) {
+
+	now := time.Now()
+	if err != nil || len(acceptedTxs) == 0 {
+		d := &Decision{
+			Time:    now,
+			Hash:    *tx.Hash(),
+			Outcome: DecisionOrphaned,
+			Size:    GetTxVirtualSize(tx),
+			Peer:    tag,
+		}
+		if err != nil {
+			d.Outcome = DecisionRejected
+			d.Code, d.Reason = ErrToRejectErr(err)
+		}
+		mp.recordDecision(d)
+		return
+	}
+
+	for i, txD := range acceptedTxs {
+		// The orphans accepted along with the transaction were
+		// received from the peers which relayed them, which the pool
+		// no longer knows about.
+		peer := tag
+		if i > 0 {
+			peer = 0
+		}
+		mp.recordDecision(&Decision{
+			Time:    now,
+			Hash:    *txD.Tx.Hash(),
+			Outcome: DecisionAccepted,
+			FeeRate: txD.FeePerKB,
+			Size:    GetTxVirtualSize(txD.Tx),
+			Peer:    peer,
+		})
+	}
+}
+
+// DecisionTrace returns the most recent decisions about the transactions
+// processed by the pool ordered from oldest to newest, or only the ones about
+// the transaction with the passed hash when it is not nil.  Nothing is
+// returned unless tracing is enabled via Config.DecisionTraceSize.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) DecisionTrace(hash *chainhash.Hash) []Decision {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	if mp.decisions == nil {
+		return nil
+	}
+	decisions := mp.decisions.all()
+	if hash == nil {
+		return decisions
+	}
+
+	var matching []Decision
+	for _, d := range decisions {
+		if d.Hash == *hash {
+			matching = append(matching, d)
+		}
+	}
+	return matching
+}
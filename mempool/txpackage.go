@@ -0,0 +1,329 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/miningdag"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+const (
+	// DefaultMaxAncestorCount is the default maximum number of unconfirmed
+	// ancestors a transaction in the pool may have, including itself.
+	DefaultMaxAncestorCount = 25
+
+	// DefaultMaxAncestorSize is the default maximum virtual size in bytes of
+	// a transaction along with all of its unconfirmed ancestors.
+	DefaultMaxAncestorSize = 101000
+
+	// DefaultMaxDescendantCount is the default maximum number of
+	// unconfirmed descendants a transaction in the pool may have, including
+	// itself.
+	DefaultMaxDescendantCount = 25
+
+	// DefaultMaxDescendantSize is the default maximum virtual size in bytes
+	// of a transaction along with all of its unconfirmed descendants.
+	DefaultMaxDescendantSize = 101000
+)
+
+// PackageStats describes a set of related transactions in the pool, such as a
+// transaction along with all of its unconfirmed ancestors.
+type PackageStats struct {
+	// Count is the number of transactions in the package.
+	Count int
+
+	// Size is the total virtual size of the transactions in the package.
+	Size int64
+
+	// Fees is the total fee paid by the transactions in the package,
+	// including the fee deltas applied via PrioritiseTransaction.
+	Fees int64
+}
+
+// FeePerKB returns the fee rate of the package in nanoSoter per 1000 bytes.
+func (s PackageStats) FeePerKB() int64 {
+	if s.Size == 0 {
+		return 0
+	}
+	return s.Fees * 1000 / s.Size
+}
+
+// add adds the passed transaction descriptor to the package.
+func (s *PackageStats) add(txD *TxDesc) {
+	s.Count++
+	s.Size += GetTxVirtualSize(txD.Tx)
+	s.Fees += txD.modifiedFee()
+}
+
+// remove removes the passed transaction descriptor from the package.
+func (s *PackageStats) remove(txD *TxDesc) {
+	s.Count--
+	s.Size -= GetTxVirtualSize(txD.Tx)
+	s.Fees -= txD.modifiedFee()
+}
+
+// packageLimits returns the ancestor and descendant limits of the policy,
+// using the defaults for any limits which aren't set.
+func (p *Policy) packageLimits() (maxAncestors int, maxAncestorSize int64,
+	maxDescendants int, maxDescendantSize int64) {
+
+	maxAncestors = p.MaxAncestorCount
+	if maxAncestors <= 0 {
+		maxAncestors = DefaultMaxAncestorCount
+	}
+	maxAncestorSize = p.MaxAncestorSize
+	if maxAncestorSize <= 0 {
+		maxAncestorSize = DefaultMaxAncestorSize
+	}
+	maxDescendants = p.MaxDescendantCount
+	if maxDescendants <= 0 {
+		maxDescendants = DefaultMaxDescendantCount
+	}
+	maxDescendantSize = p.MaxDescendantSize
+	if maxDescendantSize <= 0 {
+		maxDescendantSize = DefaultMaxDescendantSize
+	}
+
+	return maxAncestors, maxAncestorSize, maxDescendants, maxDescendantSize
+}
+
+// checkPackageLimits ensures that adding the passed transaction to the pool
+// would not exceed the ancestor and descendant limits of the policy, either
+// for the transaction itself or for any of its unconfirmed ancestors.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) checkPackageLimits(tx *soterutil.Tx) error {
+	maxAncestors, maxAncestorSize, maxDescendants, maxDescendantSize :=
+		mp.cfg.Policy.packageLimits()
+
+	txSize := GetTxVirtualSize(tx)
+	ancestors := mp.txAncestors(tx, nil)
+	stats := PackageStats{Count: 1, Size: txSize}
+	for hash := range ancestors {
+		stats.add(mp.pool[hash])
+	}
+
+	if stats.Count > maxAncestors {
+		str := fmt.Sprintf("transaction %v has too many unconfirmed "+
+			"ancestors: %d > %d", tx.Hash(), stats.Count,
+			maxAncestors)
+		return txRuleError(wire.RejectNonstandard, str)
+	}
+	if stats.Size > maxAncestorSize {
+		str := fmt.Sprintf("transaction %v unconfirmed ancestors "+
+			"are too large: %d > %d bytes", tx.Hash(), stats.Size,
+			maxAncestorSize)
+		return txRuleError(wire.RejectNonstandard, str)
+	}
+
+	for hash := range ancestors {
+		descendants := mp.pool[hash].descendants
+		if descendants.Count+1 > maxDescendants {
+			str := fmt.Sprintf("transaction %v would exceed the "+
+				"unconfirmed descendant limit of %d for "+
+				"ancestor %v", tx.Hash(), maxDescendants, hash)
+			return txRuleError(wire.RejectNonstandard, str)
+		}
+		if descendants.Size+txSize > maxDescendantSize {
+			str := fmt.Sprintf("transaction %v would exceed the "+
+				"unconfirmed descendant size limit of %d "+
+				"bytes for ancestor %v", tx.Hash(),
+				maxDescendantSize, hash)
+			return txRuleError(wire.RejectNonstandard, str)
+		}
+	}
+
+	return nil
+}
+
+// addPackageStats updates the package statistics of a transaction that was
+// just added to the pool, along with the descendant statistics of all of its
+// unconfirmed ancestors.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) addPackageStats(txD *TxDesc) {
+	txD.ancestors = PackageStats{}
+	txD.ancestors.add(txD)
+	txD.descendants = PackageStats{}
+	txD.descendants.add(txD)
+
+	for hash := range mp.txAncestors(txD.Tx, nil) {
+		ancestor := mp.pool[hash]
+		txD.ancestors.add(ancestor)
+		ancestor.descendants.add(txD)
+	}
+}
+
+// removePackageStats updates the package statistics of the unconfirmed
+// ancestors and descendants of a transaction that is about to be removed from
+// the pool.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) removePackageStats(txD *TxDesc) {
+	for hash := range mp.txAncestors(txD.Tx, nil) {
+		mp.pool[hash].descendants.remove(txD)
+	}
+	for hash := range mp.txDescendants(txD.Tx, nil) {
+		if descendant, ok := mp.pool[hash]; ok {
+			descendant.ancestors.remove(txD)
+		}
+	}
+}
+
+// AncestorStats returns the statistics of the package made up of the passed
+// transaction along with all of its unconfirmed ancestors in the pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) AncestorStats(hash *chainhash.Hash) (PackageStats, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	txDesc, exists := mp.pool[*hash]
+	if !exists {
+		return PackageStats{}, fmt.Errorf("transaction is not in the pool")
+	}
+
+	return txDesc.ancestors, nil
+}
+
+// DescendantStats returns the statistics of the package made up of the passed
+// transaction along with all of its unconfirmed descendants in the pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) DescendantStats(hash *chainhash.Hash) (PackageStats, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	txDesc, exists := mp.pool[*hash]
+	if !exists {
+		return PackageStats{}, fmt.Errorf("transaction is not in the pool")
+	}
+
+	return txDesc.descendants, nil
+}
+
+// packageCandidate is a transaction which hasn't been selected yet while
+// ordering the transactions of the pool by package fee rate, along with the
+// statistics of the package made up of it and its unselected ancestors.
+type packageCandidate struct {
+	txDesc    *TxDesc
+	ancestors PackageStats
+}
+
+// packageScore is an entry of a packageHeap.  Entries become stale when the
+// package of their candidate changes, in which case a new entry is pushed.
+type packageScore struct {
+	hash     chainhash.Hash
+	feePerKB int64
+}
+
+// packageHeap implements a max-heap of package scores using heap.Interface.
+type packageHeap []packageScore
+
+func (h packageHeap) Len() int            { return len(h) }
+func (h packageHeap) Less(i, j int) bool  { return h[i].feePerKB > h[j].feePerKB }
+func (h packageHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *packageHeap) Push(x interface{}) { *h = append(*h, x.(packageScore)) }
+func (h *packageHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// PackageMiningDescs returns a slice of mining descriptors for all the
+// transactions in the pool, ordered such that the packages made up of
+// transactions along with their unconfirmed ancestors with the highest fee
+// rates come first and parents always come before their children.  Each
+// descriptor has its PackageFeePerKB set to the fee rate of the package it was
+// selected with, so a low fee parent inherits the fee rate of a descendant
+// paying for it.
+//
+// This is part of the miningdag.PackageTxSource interface implementation and
+// is safe for concurrent access as required by the interface contract.
+func (mp *TxPool) PackageMiningDescs() []*miningdag.TxDesc {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	// Conflicted transactions aren't mined.  Their descendants are
+	// conflicted as well, so the ancestors of the remaining candidates are
+	// all candidates themselves.
+	conflicted := mp.conflictedTxns()
+	remaining := make(map[chainhash.Hash]*packageCandidate, len(mp.pool))
+	scores := make(packageHeap, 0, len(mp.pool))
+	for hash, txDesc := range mp.pool {
+		if _, ok := conflicted[hash]; ok {
+			continue
+		}
+		remaining[hash] = &packageCandidate{
+			txDesc:    txDesc,
+			ancestors: txDesc.ancestors,
+		}
+		scores = append(scores, packageScore{
+			hash:     hash,
+			feePerKB: txDesc.ancestors.FeePerKB(),
+		})
+	}
+	heap.Init(&scores)
+
+	ancestorsCache := make(map[chainhash.Hash]map[chainhash.Hash]*soterutil.Tx)
+	descendantsCache := make(map[chainhash.Hash]map[chainhash.Hash]*soterutil.Tx)
+	descs := make([]*miningdag.TxDesc, 0, len(mp.pool))
+	for scores.Len() > 0 {
+		score := heap.Pop(&scores).(packageScore)
+		candidate, ok := remaining[score.hash]
+		if !ok || candidate.ancestors.FeePerKB() != score.feePerKB {
+			// The candidate was already selected or its score is
+			// stale.
+			continue
+		}
+
+		// Select the candidate along with all of its unselected
+		// ancestors.  A parent always has fewer ancestors than its
+		// children, so sorting by the number of ancestors puts them in
+		// a valid order.
+		pkg := []*TxDesc{candidate.txDesc}
+		for hash := range mp.txAncestors(candidate.txDesc.Tx, ancestorsCache) {
+			if ancestor, ok := remaining[hash]; ok {
+				pkg = append(pkg, ancestor.txDesc)
+			}
+		}
+		sort.Slice(pkg, func(i, j int) bool {
+			return pkg[i].ancestors.Count < pkg[j].ancestors.Count
+		})
+
+		for _, txDesc := range pkg {
+			desc := txDesc.TxDesc
+			desc.PackageFeePerKB = score.feePerKB
+			descs = append(descs, &desc)
+			delete(remaining, *txDesc.Tx.Hash())
+		}
+
+		// The packages of the unselected descendants no longer include
+		// the selected transactions, so update their scores.
+		for _, txDesc := range pkg {
+			for hash := range mp.txDescendants(txDesc.Tx, descendantsCache) {
+				descendant, ok := remaining[hash]
+				if !ok {
+					continue
+				}
+				descendant.ancestors.remove(txDesc)
+				heap.Push(&scores, packageScore{
+					hash:     hash,
+					feePerKB: descendant.ancestors.FeePerKB(),
+				})
+			}
+		}
+	}
+
+	return descs
+}
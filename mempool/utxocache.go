@@ -0,0 +1,211 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// DefaultMaxUtxoCacheEntries is the default maximum number of outputs of the
+// DAG cached for validating transactions.
+const DefaultMaxUtxoCacheEntries = 50000
+
+// utxoCache caches the unspent transaction outputs fetched from the DAG, along
+// with the outputs known not to exist, so that validating transactions which
+// reference the same outputs doesn't hit the database repeatedly.  Entries are
+// invalidated when blocks spending or creating the outputs are connected, and
+// the whole cache is cleared when blocks are disconnected, as notified by the
+// DAG.
+//
+// The generation of the cache is bumped whenever entries are invalidated, so
+// views fetched from the DAG before a block was connected aren't cached once
+// the block was taken into account.
+//
+// The cache has its own lock since it is also updated while the mempool lock
+// is only held for reads.
+type utxoCache struct {
+	mtx        sync.Mutex
+	generation uint64
+	entries    map[wire.OutPoint]*blockdag.UtxoEntry
+}
+
+// newUtxoCache returns a new empty utxo cache.
+func newUtxoCache() *utxoCache {
+	return &utxoCache{
+		entries: make(map[wire.OutPoint]*blockdag.UtxoEntry),
+	}
+}
+
+// view returns a view containing the passed outputs when all of them are
+// cached.  The entries of the view are copies, so modifying the view doesn't
+// affect the cache.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) view(outpoints []wire.OutPoint) (*blockdag.UtxoViewpoint, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	view := blockdag.NewUtxoViewpoint()
+	for _, outpoint := range outpoints {
+		entry, ok := c.entries[outpoint]
+		if !ok {
+			return nil, false
+		}
+		view.Entries()[outpoint] = entry.Clone()
+	}
+	return view, true
+}
+
+// currentGeneration returns the generation of the cache, which must be passed
+// to add along with the view fetched from the DAG after calling it.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) currentGeneration() uint64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.generation
+}
+
+// add caches the passed outputs of the passed view, evicting random entries
+// when needed to stay within the passed limit.  Nothing is cached when entries
+// were invalidated since the passed generation, as the view may predate the
+// blocks they were invalidated for.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) add(view *blockdag.UtxoViewpoint, outpoints []wire.OutPoint,
+	limit int, generation uint64) {
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	for _, outpoint := range outpoints {
+		if _, ok := c.entries[outpoint]; !ok && len(c.entries) >= limit {
+			// Map iteration is random, so this evicts a random
+			// entry.
+			for evict := range c.entries {
+				delete(c.entries, evict)
+				break
+			}
+		}
+		if len(c.entries) < limit {
+			c.entries[outpoint] = view.LookupEntry(outpoint).Clone()
+		}
+	}
+}
+
+// removeBlock forgets about the outputs spent or created by the transactions
+// of the passed block.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) removeBlock(block *soterutil.Block) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.generation++
+	for _, tx := range block.Transactions() {
+		for _, outpoint := range viewOutpoints(tx) {
+			delete(c.entries, outpoint)
+		}
+	}
+}
+
+// clear forgets about all of the cached outputs.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) clear() {
+	c.mtx.Lock()
+	c.generation++
+	c.entries = make(map[wire.OutPoint]*blockdag.UtxoEntry)
+	c.mtx.Unlock()
+}
+
+// maxUtxoCacheEntries returns the size of the utxo cache, using the default
+// when the policy doesn't set it.  The cache is disabled when it is negative.
+func (p *Policy) maxUtxoCacheEntries() int {
+	if p.MaxUtxoCacheEntries != 0 {
+		return p.MaxUtxoCacheEntries
+	}
+	return DefaultMaxUtxoCacheEntries
+}
+
+// viewOutpoints returns the outputs which are fetched from the DAG for the
+// passed transaction, which are the ones referenced by its inputs along with
+// its own outputs, so the view can be examined for duplicate transactions.
+func viewOutpoints(tx *soterutil.Tx) []wire.OutPoint {
+	msgTx := tx.MsgTx()
+	outpoints := make([]wire.OutPoint, 0, len(msgTx.TxIn)+len(msgTx.TxOut))
+	prevOut := wire.OutPoint{Hash: *tx.Hash()}
+	for txOutIdx := range msgTx.TxOut {
+		prevOut.Index = uint32(txOutIdx)
+		outpoints = append(outpoints, prevOut)
+	}
+	if !blockdag.IsCoinBase(tx) {
+		for _, txIn := range msgTx.TxIn {
+			outpoints = append(outpoints, txIn.PreviousOutPoint)
+		}
+	}
+	return outpoints
+}
+
+// fetchUtxoView returns a view of the outputs of the DAG needed to validate
+// the passed transaction.  The view is built from the utxo cache when all of
+// the outputs are cached, unless a fresh view is requested.  Otherwise the
+// view is fetched from the DAG and cached.  Nothing is cached when the mempool
+// isn't subscribed to the notifications of the DAG, since the entries couldn't
+// be invalidated.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) fetchUtxoView(tx *soterutil.Tx, fresh bool) (*blockdag.UtxoViewpoint, error) {
+	limit := mp.cfg.Policy.maxUtxoCacheEntries()
+	if limit < 0 || mp.cfg.SubscribeDAG == nil {
+		return mp.cfg.FetchUtxoView(tx)
+	}
+
+	outpoints := viewOutpoints(tx)
+	if !fresh {
+		if view, ok := mp.utxoCache.view(outpoints); ok {
+			return view, nil
+		}
+	}
+
+	generation := mp.utxoCache.currentGeneration()
+	view, err := mp.cfg.FetchUtxoView(tx)
+	if err != nil {
+		return nil, err
+	}
+	mp.utxoCache.add(view, outpoints, limit, generation)
+	return view, nil
+}
+
+// handleDAGNotification invalidates the cached outputs of the DAG spent or
+// created by the blocks connected to the DAG, and clears the cache when blocks
+// are disconnected, since the outputs they spent are available again.  The
+// generation of the DAG is bumped for both, so cached rejections no longer
+// apply.  It's registered via the SubscribeDAG function of the config.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) handleDAGNotification(notification *blockdag.Notification) {
+	switch notification.Type {
+	case blockdag.NTBlockConnected:
+		if block, ok := notification.Data.(*soterutil.Block); ok {
+			mp.utxoCache.removeBlock(block)
+		}
+		atomic.AddUint64(&mp.dagGeneration, 1)
+
+	case blockdag.NTBlockDisconnected:
+		mp.utxoCache.clear()
+		atomic.AddUint64(&mp.dagGeneration, 1)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"sync"
+
+	"github.com/soteria-dag/soterd/addrmgr"
+)
+
+// AddrManagerMetrics collects the metrics reported by the address manager.  It
+// implements the addrmgr.Metrics interface, so it can be set as the Metrics
+// field of the address manager config.
+type AddrManagerMetrics struct {
+	mtx sync.RWMutex
+
+	count   int
+	evicted map[addrmgr.EvictionReason]uint64
+}
+
+// Ensure AddrManagerMetrics implements the addrmgr.Metrics interface.
+var _ addrmgr.Metrics = (*AddrManagerMetrics)(nil)
+
+// NewAddrManagerMetrics returns a new AddrManagerMetrics with all counters at
+// zero.
+func NewAddrManagerMetrics() *AddrManagerMetrics {
+	return &AddrManagerMetrics{
+		evicted: make(map[addrmgr.EvictionReason]uint64),
+	}
+}
+
+// AddressEvicted counts an address evicted from the address manager for the
+// given reason.
+func (m *AddrManagerMetrics) AddressEvicted(reason addrmgr.EvictionReason) {
+	m.mtx.Lock()
+	m.evicted[reason]++
+	m.mtx.Unlock()
+}
+
+// SetAddressCount records the current number of addresses known to the
+// address manager.
+func (m *AddrManagerMetrics) SetAddressCount(count int) {
+	m.mtx.Lock()
+	m.count = count
+	m.mtx.Unlock()
+}
+
+// AddrManagerSnapshot is a copy of the address manager metrics at a point in
+// time.
+type AddrManagerSnapshot struct {
+	Count   int
+	Evicted map[addrmgr.EvictionReason]uint64
+}
+
+// Snapshot returns a copy of the current address manager metrics.
+func (m *AddrManagerMetrics) Snapshot() *AddrManagerSnapshot {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	snap := &AddrManagerSnapshot{
+		Count:   m.count,
+		Evicted: make(map[addrmgr.EvictionReason]uint64, len(m.evicted)),
+	}
+	for reason, n := range m.evicted {
+		snap.Evicted[reason] = n
+	}
+
+	return snap
+}
@@ -23,6 +23,24 @@ type Config struct {
 	MinerSolveCount *chan struct{}
 	MinerSolveHashes *chan string
 	MinerSolveTimes *chan time.Duration
+
+	// Mempool collects the metrics reported by the transaction memory
+	// pool, and should also be set as the Metrics of its config.
+	// This field can be nil.
+	Mempool *MempoolMetrics
+
+	// AddrManager collects the metrics reported by the address manager,
+	// and should also be set as the Metrics of its config.
+	// This field can be nil.
+	AddrManager *AddrManagerMetrics
+
+	// PeerCount returns the number of connected peers.
+	// This field can be nil.
+	PeerCount func() int32
+
+	// DAGTipCount returns the number of tips of the block DAG.
+	// This field can be nil.
+	DAGTipCount func() int
 }
 
 // MetricsManager is used to collect metrics from other managers in code, and make the data
@@ -49,6 +67,12 @@ type MetricsManager struct {
 	// A lock to prevent multiple updates to cache at the same time
 	minerSolveTimesLock sync.RWMutex
 
+	// Sources of metrics which are read when metrics are requested
+	mempool     *MempoolMetrics
+	addrManager *AddrManagerMetrics
+	peerCount   func() int32
+	dagTipCount func() int
+
 	// Helps wait for goroutines to finish before continuing
 	wg sync.WaitGroup
 
@@ -112,6 +136,10 @@ func New(config *Config) (*MetricsManager, error) {
 		minerSolveCount: config.MinerSolveCount,
 		minerSolveHashes: config.MinerSolveHashes,
 		minerSolveTimes: config.MinerSolveTimes,
+		mempool: config.Mempool,
+		addrManager: config.AddrManager,
+		peerCount: config.PeerCount,
+		dagTipCount: config.DAGTipCount,
 		quit: make(chan struct{}),
 	}
 
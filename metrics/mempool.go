@@ -0,0 +1,114 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"sync"
+
+	"github.com/soteria-dag/soterd/mempool"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// MempoolMetrics collects the metrics reported by the transaction memory pool.
+// It implements the mempool.Metrics interface, so it can be set as the Metrics
+// field of the mempool config.
+type MempoolMetrics struct {
+	mtx sync.RWMutex
+
+	accepted uint64
+	orphaned uint64
+	rejected map[wire.RejectCode]uint64
+	removed  map[mempool.RemovalReason]uint64
+
+	count       int
+	virtualSize int64
+	memoryUsage int64
+}
+
+// Ensure MempoolMetrics implements the mempool.Metrics interface.
+var _ mempool.Metrics = (*MempoolMetrics)(nil)
+
+// NewMempoolMetrics returns a new MempoolMetrics with all counters at zero.
+func NewMempoolMetrics() *MempoolMetrics {
+	return &MempoolMetrics{
+		rejected: make(map[wire.RejectCode]uint64),
+		removed:  make(map[mempool.RemovalReason]uint64),
+	}
+}
+
+// TxAccepted counts a transaction added to the main pool.
+func (m *MempoolMetrics) TxAccepted() {
+	m.mtx.Lock()
+	m.accepted++
+	m.mtx.Unlock()
+}
+
+// TxRejected counts a transaction rejected by the pool with the given reject
+// code.
+func (m *MempoolMetrics) TxRejected(code wire.RejectCode) {
+	m.mtx.Lock()
+	m.rejected[code]++
+	m.mtx.Unlock()
+}
+
+// TxOrphaned counts a transaction added to the orphan pool.
+func (m *MempoolMetrics) TxOrphaned() {
+	m.mtx.Lock()
+	m.orphaned++
+	m.mtx.Unlock()
+}
+
+// TxRemoved counts a transaction removed from the main pool for the given
+// reason.
+func (m *MempoolMetrics) TxRemoved(reason mempool.RemovalReason) {
+	m.mtx.Lock()
+	m.removed[reason]++
+	m.mtx.Unlock()
+}
+
+// SetPoolSize records the current size of the main pool.
+func (m *MempoolMetrics) SetPoolSize(count int, virtualSize, memoryUsage int64) {
+	m.mtx.Lock()
+	m.count = count
+	m.virtualSize = virtualSize
+	m.memoryUsage = memoryUsage
+	m.mtx.Unlock()
+}
+
+// MempoolSnapshot is a copy of the mempool metrics at a point in time.
+type MempoolSnapshot struct {
+	Accepted uint64
+	Orphaned uint64
+	Rejected map[wire.RejectCode]uint64
+	Removed  map[mempool.RemovalReason]uint64
+
+	Count       int
+	VirtualSize int64
+	MemoryUsage int64
+}
+
+// Snapshot returns a copy of the current mempool metrics.
+func (m *MempoolMetrics) Snapshot() *MempoolSnapshot {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	snap := &MempoolSnapshot{
+		Accepted:    m.accepted,
+		Orphaned:    m.orphaned,
+		Rejected:    make(map[wire.RejectCode]uint64, len(m.rejected)),
+		Removed:     make(map[mempool.RemovalReason]uint64, len(m.removed)),
+		Count:       m.count,
+		VirtualSize: m.virtualSize,
+		MemoryUsage: m.memoryUsage,
+	}
+	for code, n := range m.rejected {
+		snap.Rejected[code] = n
+	}
+	for reason, n := range m.removed {
+		snap.Removed[reason] = n
+	}
+
+	return snap
+}
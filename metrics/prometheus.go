@@ -0,0 +1,158 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// prometheusContentType is the content type of the Prometheus text exposition
+// format written by WritePrometheus.
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// promWriter writes metrics in the Prometheus text exposition format, keeping
+// the first write error so callers only need to check it once.
+type promWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// header writes the help and type lines describing the named metric.
+func (pw *promWriter) header(name, kind, help string) {
+	if pw.err != nil {
+		return
+	}
+	_, pw.err = fmt.Fprintf(pw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help,
+		name, kind)
+}
+
+// sample writes a single sample of the named metric, with an optional label.
+// The label is omitted when labelName is empty.
+func (pw *promWriter) sample(name, labelName, labelValue string, value interface{}) {
+	if pw.err != nil {
+		return
+	}
+	if labelName == "" {
+		_, pw.err = fmt.Fprintf(pw.w, "%s %v\n", name, value)
+		return
+	}
+	_, pw.err = fmt.Fprintf(pw.w, "%s{%s=%q} %v\n", name, labelName,
+		labelValue, value)
+}
+
+// metric writes the help and type lines along with a single unlabelled sample
+// of the named metric.
+func (pw *promWriter) metric(name, kind, help string, value interface{}) {
+	pw.header(name, kind, help)
+	pw.sample(name, "", "", value)
+}
+
+// labelledCounts writes the samples of a counter with one sample per label
+// value, sorted by label value so the output is stable.
+func (pw *promWriter) labelledCounts(name, labelName string, counts map[string]uint64) {
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	for _, value := range values {
+		pw.sample(name, labelName, value, counts[value])
+	}
+}
+
+// WritePrometheus writes the current metrics to w in the Prometheus text
+// exposition format.  Metrics whose source wasn't configured are left out.
+func (mm *MetricsManager) WritePrometheus(w io.Writer) error {
+	pw := &promWriter{w: bufio.NewWriter(w)}
+
+	pw.metric("soterd_miner_solved_blocks_total", "counter",
+		"Number of blocks solved by the miner.", mm.MinerSolveCount())
+
+	if mm.peerCount != nil {
+		pw.metric("soterd_peers", "gauge", "Number of connected peers.",
+			mm.peerCount())
+	}
+	if mm.dagTipCount != nil {
+		pw.metric("soterd_dag_tips", "gauge",
+			"Number of tips of the block DAG.", mm.dagTipCount())
+	}
+
+	if mm.mempool != nil {
+		snap := mm.mempool.Snapshot()
+
+		pw.metric("soterd_mempool_transactions", "gauge",
+			"Number of transactions in the mempool.", snap.Count)
+		pw.metric("soterd_mempool_virtual_size_bytes", "gauge",
+			"Total virtual size of the transactions in the mempool.",
+			snap.VirtualSize)
+		pw.metric("soterd_mempool_memory_usage_bytes", "gauge",
+			"Estimated memory usage of the transactions in the mempool.",
+			snap.MemoryUsage)
+		pw.metric("soterd_mempool_accepted_total", "counter",
+			"Number of transactions accepted to the mempool.",
+			snap.Accepted)
+		pw.metric("soterd_mempool_orphaned_total", "counter",
+			"Number of transactions added to the orphan pool.",
+			snap.Orphaned)
+
+		rejected := make(map[string]uint64, len(snap.Rejected))
+		for code, n := range snap.Rejected {
+			rejected[code.String()] = n
+		}
+		pw.header("soterd_mempool_rejected_total", "counter",
+			"Number of transactions rejected by the mempool.")
+		pw.labelledCounts("soterd_mempool_rejected_total", "code", rejected)
+
+		removed := make(map[string]uint64, len(snap.Removed))
+		for reason, n := range snap.Removed {
+			removed[reason.String()] = n
+		}
+		pw.header("soterd_mempool_removed_total", "counter",
+			"Number of transactions removed from the mempool.")
+		pw.labelledCounts("soterd_mempool_removed_total", "reason", removed)
+	}
+
+	if mm.addrManager != nil {
+		snap := mm.addrManager.Snapshot()
+
+		pw.metric("soterd_addrmgr_addresses", "gauge",
+			"Number of addresses known to the address manager.",
+			snap.Count)
+
+		evicted := make(map[string]uint64, len(snap.Evicted))
+		for reason, n := range snap.Evicted {
+			evicted[reason.String()] = n
+		}
+		pw.header("soterd_addrmgr_evicted_total", "counter",
+			"Number of addresses evicted from the address manager.")
+		pw.labelledCounts("soterd_addrmgr_evicted_total", "reason", evicted)
+	}
+
+	if pw.err != nil {
+		return pw.err
+	}
+	return pw.w.Flush()
+}
+
+// ServeHTTP serves the current metrics in the Prometheus text exposition
+// format, which allows the metrics manager to be registered as the handler of
+// a /metrics endpoint.
+func (mm *MetricsManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", prometheusContentType)
+	if err := mm.WritePrometheus(w); err != nil {
+		log.Debugf("Unable to write metrics: %v", err)
+	}
+}
@@ -11,11 +11,13 @@ import (
 	"fmt"
 	"math/rand"
 	"runtime"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/Qitmeer/qitmeer-lib/crypto/cuckoo"
 	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/blockdag/pow"
 	"github.com/soteria-dag/soterd/chaincfg"
 	"github.com/soteria-dag/soterd/chaincfg/chainhash"
 	"github.com/soteria-dag/soterd/miningdag"
@@ -64,6 +66,13 @@ type Config struct {
 	// blocks.  Each generated block will randomly choose one of them.
 	MiningAddrs []soterutil.Address
 
+	// Payouts is the payout schedule deciding which addresses the
+	// generated blocks pay to.  It takes precedence over MiningAddrs, and
+	// its payouts can be changed while the miner is running.
+	//
+	// This field can be nil.
+	Payouts *miningdag.PayoutSchedule
+
 	// ProcessBlock defines the function to call with any solved blocks.
 	// It typically must run the provided block through the same set of
 	// rules and handling as any other block coming from the network.
@@ -84,6 +93,59 @@ type Config struct {
 	// not current since any solved blocks would be on a side chain and and
 	// up orphaned anyways.
 	IsCurrent func() bool
+
+	// MinedBlock defines the function to call with the hash of every block
+	// solved by the CPU miner which was accepted, such as to track how many
+	// of them end up stale.
+	//
+	// This field can be nil.
+	MinedBlock func(*chainhash.Hash)
+}
+
+// workerState houses the statistics of a mining worker.  The counters must
+// only be used atomically.
+type workerState struct {
+	hashes   uint64
+	solved   uint64
+	accepted uint64
+	id       int
+}
+
+// WorkerStats describes the work done by a mining worker.
+type WorkerStats struct {
+	// ID identifies the worker.  Workers are numbered from zero in the order
+	// they were launched.
+	ID int
+
+	// Parents are the blocks the worker references as parents, or nil when
+	// it mines on all of the tips of the DAG.
+	Parents []chainhash.Hash
+
+	// Hashes is the number of hashes the worker performed.
+	Hashes uint64
+
+	// Solved is the number of blocks the worker solved, and Accepted the
+	// number of them which were accepted.
+	Solved   uint64
+	Accepted uint64
+}
+
+// MinerStats describes the work done by the CPU miner since it was created.
+type MinerStats struct {
+	// HashesPerSec is the number of hashes per second the miner is
+	// performing, or zero when the miner isn't running.
+	HashesPerSec float64
+
+	// Hashes is the number of hashes the miner performed.
+	Hashes uint64
+
+	// Solved is the number of blocks the miner solved.  Each of them was
+	// either accepted, dropped as stale since the tips changed while it
+	// was being solved, or rejected when processing it.
+	Solved   uint64
+	Accepted uint64
+	Stale    uint64
+	Rejected uint64
 }
 
 // CPUMiner provides facilities for solving blocks (mining) using the CPU in
@@ -93,6 +155,13 @@ type Config struct {
 // function, but the default is based on the number of processor cores in the
 // system which is typically sufficient.
 type CPUMiner struct {
+	// The following variables must only be used atomically.
+	totalHashes    uint64
+	solvedBlocks   uint64
+	acceptedBlocks uint64
+	staleBlocks    uint64
+	rejectedBlocks uint64
+
 	sync.Mutex
 	g                 *miningdag.BlkTmplGenerator
 	cfg               Config
@@ -111,6 +180,15 @@ type CPUMiner struct {
 
 	speedMonitorQuit  chan struct{}
 	quit              chan struct{}
+
+	// parentSets are the parent sets the workers mine on when running in
+	// multi-tip mode, and parentSetsGen is incremented whenever they
+	// change so workers abandon the work on the previous ones.  workers
+	// are the states of the running workers by id.
+	workersMtx    sync.Mutex
+	parentSets    [][]chainhash.Hash
+	parentSetsGen uint64
+	workers       map[int]*workerState
 }
 
 // speedMonitor handles tracking the number of hashes per second the mining
@@ -130,6 +208,7 @@ out:
 		// have performed.
 		case numHashes := <-m.updateHashes:
 			totalHashes += numHashes
+			atomic.AddUint64(&m.totalHashes, numHashes)
 
 		// Time to update the hashes per second.
 		case <-ticker.C:
@@ -158,26 +237,33 @@ out:
 }
 
 // submitBlock submits the passed block to network after ensuring it passes all
-// of the consensus validation rules.
-func (m *CPUMiner) submitBlock(block *soterutil.Block) bool {
+// of the consensus validation rules.  Blocks mined in multi-tip mode, as told
+// by multiTip, are submitted even if they no longer extend all of the tips.
+func (m *CPUMiner) submitBlock(block *soterutil.Block, multiTip bool) bool {
 	m.submitBlockLock.Lock()
 	defer m.submitBlockLock.Unlock()
 
+	atomic.AddUint64(&m.solvedBlocks, 1)
+
 	// Ensure the block is not stale since a new block could have shown up
 	// while the solution was being found.  Typically that condition is
 	// detected and all work on the stale block is halted to start work on
 	// a new block, but the check only happens periodically, so it is
 	// possible a block was found and submitted in between.
 	msgBlock := block.MsgBlock()
-	if !msgBlock.Header.PrevBlock.IsEqual(&m.g.DAGSnapshot().Hash) {
+	if !multiTip && !msgBlock.Header.PrevBlock.IsEqual(&m.g.DAGSnapshot().Hash) {
 		log.Debugf("Block submitted via CPU miner with previous "+
 			"block %s is stale", msgBlock.Header.PrevBlock)
+		atomic.AddUint64(&m.staleBlocks, 1)
 		return false
 	}
 
 	// Process this block using the same rules as blocks coming from other
 	// nodes.  This will in turn relay it to the network like normal.
 	isOrphan, err := m.cfg.ProcessBlock(block, blockdag.BFNone)
+	if err != nil || isOrphan {
+		atomic.AddUint64(&m.rejectedBlocks, 1)
+	}
 	if err != nil {
 		// Anything other than a rule violation is an unexpected error,
 		// so log that error as an internal error.
@@ -196,9 +282,17 @@ func (m *CPUMiner) submitBlock(block *soterutil.Block) bool {
 	}
 
 	// The block was accepted.
-	coinbaseTx := block.MsgBlock().Transactions[0].TxOut[0]
+	atomic.AddUint64(&m.acceptedBlocks, 1)
+	if m.cfg.MinedBlock != nil {
+		m.cfg.MinedBlock(block.Hash())
+	}
+	// The coinbase may be split across several payment outputs.
+	var amount int64
+	for _, txOut := range block.MsgBlock().Transactions[0].TxOut {
+		amount += txOut.Value
+	}
 	log.Infof("Block submitted via CPU miner accepted (hash %s, "+
-		"amount %v)", block.Hash(), soterutil.Amount(coinbaseTx.Value))
+		"amount %v)", block.Hash(), soterutil.Amount(amount))
 	return true
 }
 
@@ -211,8 +305,13 @@ func (m *CPUMiner) submitBlock(block *soterutil.Block) bool {
 // This function will return early with false when conditions that trigger a
 // stale block such as a new block showing up or periodically when there are
 // new transactions and enough time has elapsed without finding a solution.
+//
+// The passed parents are the ones the block was generated for in multi-tip
+// mode, in which case the block only becomes stale when the parent sets of the
+// miner change, or nil when it extends all of the tips.
 func (m *CPUMiner) solveBlock(msgBlock *wire.MsgBlock, blockHeight int32,
-	ticker *time.Ticker, quit chan struct{}) (bool, []uint32) {
+	ticker *time.Ticker, quit chan struct{}, w *workerState,
+	parents []chainhash.Hash, parentSetsGen uint64) (bool, []uint32) {
 
 	// Choose a random extra nonce offset for this block template and
 	// worker.
@@ -232,7 +331,8 @@ func (m *CPUMiner) solveBlock(msgBlock *wire.MsgBlock, blockHeight int32,
 	var lastGenerated = time.Now()
 	var lastTxUpdate = m.g.TxSource().LastUpdated()
 	var hashesCompleted = uint64(0)
-	var c = cuckoo.NewCuckoo()
+	var backend = m.g.PowBackend()
+	var solver = backend.NewSolver()
 
 	// Note that the entire extra nonce range is iterated and the offset is
 	// added relying on the fact that overflow will wrap around 0 as
@@ -253,10 +353,17 @@ func (m *CPUMiner) solveBlock(msgBlock *wire.MsgBlock, blockHeight int32,
 
 			case <-ticker.C:
 				m.updateHashes <- hashesCompleted
+				atomic.AddUint64(&w.hashes, hashesCompleted)
 				hashesCompleted = 0
 
-				// The current block is stale if tips have changed.
-				if !header.PrevBlock.IsEqual(&m.g.DAGSnapshot().Hash) {
+				// The current block is stale if tips have changed,
+				// or in multi-tip mode if the parent sets did.
+				if parents == nil &&
+					!header.PrevBlock.IsEqual(&m.g.DAGSnapshot().Hash) {
+					return false, []uint32{}
+				}
+				if parents != nil &&
+					m.currentParentSetsGen() != parentSetsGen {
 					return false, []uint32{}
 				}
 
@@ -284,27 +391,24 @@ func (m *CPUMiner) solveBlock(msgBlock *wire.MsgBlock, blockHeight int32,
 			hash := header.BlockHash()
 			hashesCompleted += 2
 
-			// Run cuckoo cycle against the header. The block is solved when a cycle >= length cuckoo.ProofSize is found.
+			// Search for a proof of work for the header, like a cuckoo cycle with the default backend.
 			hashBytes := hash.CloneBytes()
-			cycleNonces, isFound := c.PoW(hashBytes)
+			cycleNonces, isFound := solver.Solve(hashBytes)
 
 			if !isFound {
 				continue
 			}
 
-			if err := cuckoo.Verify(hashBytes, cycleNonces); err != nil {
-				continue
-			}
-
 			// The block is solved when:
-			// a) The cuckoo cycle is valid
-			// b) The cuckoo cycle proof difficulty is greater than or equal to the target difficulty
-			proofDifficulty := blockdag.ProofDifficulty(cycleNonces)
-			if proofDifficulty.Cmp(targetDifficulty) >= 0 {
+			// a) The proof is valid
+			// b) The proof difficulty is greater than or equal to the target difficulty
+			_, solved, err := pow.Solved(backend, hashBytes, cycleNonces, targetDifficulty)
+			if err == nil && solved {
 				log.Debugf("Current Nonce:%d", i)
-				log.Debugf("Found %d Cycles Nonces:", cuckoo.ProofSize, cycleNonces)
+				log.Debugf("Found %d Cycles Nonces: %v", backend.ProofSize(), cycleNonces)
 
 				m.updateHashes <- hashesCompleted
+				atomic.AddUint64(&w.hashes, hashesCompleted)
 
 				// Return with cycleNonces, so that Verify could be called again against this block's header
 				return true, cycleNonces
@@ -319,11 +423,15 @@ func (m *CPUMiner) solveBlock(msgBlock *wire.MsgBlock, blockHeight int32,
 // It is self contained in that it creates block templates and attempts to solve
 // them while detecting when it is performing stale work and reacting
 // accordingly by generating a new block template.  When a block is solved, it
-// is submitted.
+// is submitted.  In multi-tip mode, the worker with the passed id mines on the
+// parent set assigned to it by parentSet.
 //
 // It must be run as a goroutine.
-func (m *CPUMiner) generateBlocks(quit chan struct{}) {
-	log.Tracef("Starting generate blocks worker")
+func (m *CPUMiner) generateBlocks(quit chan struct{}, id int) {
+	log.Tracef("Starting generate blocks worker %d", id)
+
+	w := m.addWorker(id)
+	defer m.removeWorker(id)
 
 	// Start a ticker which is used to signal checks for stale work and
 	// updates to the speed monitor.
@@ -364,19 +472,24 @@ out:
 			continue
 		}
 
-		// Choose a payment address at random.
-		rand.Seed(time.Now().UnixNano())
-		payToAddr := m.cfg.MiningAddrs[rand.Intn(len(m.cfg.MiningAddrs))]
-
 		// Create a new block template using the available transactions
 		// in the memory pool as a source of transactions to potentially
-		// include in the block.
-		template, err := m.g.NewBlockTemplate(payToAddr)
+		// include in the block.  In multi-tip mode, the template
+		// references the parent set of the worker instead of all of the
+		// tips.
+		parents, parentSetsGen := m.parentSet(id)
+		template, err := m.g.NewBlockTemplateWithPayouts(m.nextPayouts(),
+			parents)
 		m.submitBlockLock.Unlock()
 		if err != nil {
 			errStr := fmt.Sprintf("Failed to create new block "+
 				"template: %v", err)
 			log.Errorf(errStr)
+			if parents != nil {
+				// Don't spin on a parent set which can't be
+				// mined on.
+				time.Sleep(time.Second)
+			}
 			continue
 		}
 
@@ -384,14 +497,17 @@ out:
 		// with false when conditions that trigger a stale block, so
 		// a new block template can be generated.  When the return is
 		// true a solution was found, so submit the solved block.
-		ok, cycleNonces := m.solveBlock(template.Block, curHeight+1, ticker, quit)
+		ok, cycleNonces := m.solveBlock(template.Block, template.Height,
+			ticker, quit, w, parents, parentSetsGen)
 		if ok {
 			block := soterutil.NewBlock(template.Block)
 			block.MsgBlock().Verification.CycleNonces = cycleNonces
 			block.MsgBlock().Verification.Size = int32(len(cycleNonces))
 
-			accepted := m.submitBlock(block)
+			atomic.AddUint64(&w.solved, 1)
+			accepted := m.submitBlock(block, parents != nil)
 			if accepted {
+				atomic.AddUint64(&w.accepted, 1)
 				m.SolveTimes <- time.Since(startMine)
 				m.SolveCount <- struct{}{}
 				if m.cfg.ChainParams.Net == wire.SimNet {
@@ -406,7 +522,7 @@ out:
 	}
 
 	m.workerWg.Done()
-	log.Tracef("Generate blocks worker done")
+	log.Tracef("Generate blocks worker %d done", id)
 }
 
 // miningWorkerController launches the worker goroutines that are used to
@@ -420,11 +536,12 @@ func (m *CPUMiner) miningWorkerController() {
 	var runningWorkers []chan struct{}
 	launchWorkers := func(numWorkers uint32) {
 		for i := uint32(0); i < numWorkers; i++ {
+			id := len(runningWorkers)
 			quit := make(chan struct{})
 			runningWorkers = append(runningWorkers, quit)
 
 			m.workerWg.Add(1)
-			go m.generateBlocks(quit)
+			go m.generateBlocks(quit, id)
 		}
 	}
 
@@ -544,6 +661,21 @@ func (m *CPUMiner) HashesPerSecond() float64 {
 	return <-m.queryHashesPerSec
 }
 
+// Stats returns the statistics of the work the miner did since it was
+// created.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) Stats() MinerStats {
+	return MinerStats{
+		HashesPerSec: m.HashesPerSecond(),
+		Hashes:       atomic.LoadUint64(&m.totalHashes),
+		Solved:       atomic.LoadUint64(&m.solvedBlocks),
+		Accepted:     atomic.LoadUint64(&m.acceptedBlocks),
+		Stale:        atomic.LoadUint64(&m.staleBlocks),
+		Rejected:     atomic.LoadUint64(&m.rejectedBlocks),
+	}
+}
+
 // SetNumWorkers sets the number of workers to create which solve blocks.  Any
 // negative values will cause a default number of workers to be used which is
 // based on the number of processor cores in the system.  A value of 0 will
@@ -590,6 +722,36 @@ func (m *CPUMiner) NumWorkers() int32 {
 // generating a new block template.  When a block is solved, it is submitted.
 // The function returns a list of the hashes of generated blocks.
 func (m *CPUMiner) GenerateNBlocks(n uint32) ([]*chainhash.Hash, error) {
+	return m.generateNBlocks(n, nil)
+}
+
+// GenerateToAddress generates the requested number of blocks like
+// GenerateNBlocks, except that their coinbase pays to the passed address
+// instead of the payout schedule or mining addresses of the miner, which
+// needn't be configured.
+func (m *CPUMiner) GenerateToAddress(n uint32, addr soterutil.Address) ([]*chainhash.Hash, error) {
+	if addr == nil {
+		return nil, errors.New("no address to generate blocks to")
+	}
+	return m.generateNBlocks(n, []miningdag.Payout{{Address: addr}})
+}
+
+// GenerateToDescriptor generates the requested number of blocks like
+// GenerateToAddress, except that their coinbase pays to the script described
+// by the passed output descriptor.  See miningdag.DescriptorScript for the
+// supported descriptors.
+func (m *CPUMiner) GenerateToDescriptor(n uint32, desc string) ([]*chainhash.Hash, error) {
+	pkScript, err := miningdag.DescriptorScript(desc, m.cfg.ChainParams)
+	if err != nil {
+		return nil, err
+	}
+	return m.generateNBlocks(n, []miningdag.Payout{{PkScript: pkScript}})
+}
+
+// generateNBlocks generates the requested number of blocks paying to the passed
+// payouts, or to the ones returned by nextPayouts when they're nil.  See
+// GenerateNBlocks for details.
+func (m *CPUMiner) generateNBlocks(n uint32, payouts []miningdag.Payout) ([]*chainhash.Hash, error) {
 	m.Lock()
 
 	// Respond with an error if server is already mining.
@@ -621,6 +783,10 @@ func (m *CPUMiner) GenerateNBlocks(n uint32) ([]*chainhash.Hash, error) {
 	// Track how long it's been since a block has been mined _and_ accepted
 	startMine := time.Now()
 
+	// The blocks are generated by a single worker, which always extends
+	// all of the tips.
+	w := &workerState{}
+
 	for {
 		// Read updateNumWorkers in case someone tries a `setgenerate` while
 		// we're generating. We can ignore it as the `generate` RPC call only
@@ -636,14 +802,14 @@ func (m *CPUMiner) GenerateNBlocks(n uint32) ([]*chainhash.Hash, error) {
 		m.submitBlockLock.Lock()
 		curHeight := m.g.DAGSnapshot().MaxHeight //m.g.BestSnapshot().Height
 
-		// Choose a payment address at random.
-		rand.Seed(time.Now().UnixNano())
-		payToAddr := m.cfg.MiningAddrs[rand.Intn(len(m.cfg.MiningAddrs))]
-
 		// Create a new block template using the available transactions
 		// in the memory pool as a source of transactions to potentially
 		// include in the block.
-		template, err := m.g.NewBlockTemplate(payToAddr)
+		blockPayouts := payouts
+		if blockPayouts == nil {
+			blockPayouts = m.nextPayouts()
+		}
+		template, err := m.g.NewBlockTemplateWithPayouts(blockPayouts, nil)
 		m.submitBlockLock.Unlock()
 		if err != nil {
 			errStr := fmt.Sprintf("Failed to create new block "+
@@ -656,13 +822,14 @@ func (m *CPUMiner) GenerateNBlocks(n uint32) ([]*chainhash.Hash, error) {
 		// with false when conditions that trigger a stale block, so
 		// a new block template can be generated.  When the return is
 		// true a solution was found, so submit the solved block.
-		ok, cycleNonces := m.solveBlock(template.Block, curHeight+1, ticker, nil)
+		ok, cycleNonces := m.solveBlock(template.Block, curHeight+1, ticker,
+			nil, w, nil, 0)
 		if ok {
 			block := soterutil.NewBlock(template.Block)
 			block.MsgBlock().Verification.CycleNonces = cycleNonces
 			block.MsgBlock().Verification.Size = int32(len(cycleNonces))
 
-			accepted := m.submitBlock(block)
+			accepted := m.submitBlock(block, false)
 			if accepted {
 				m.SolveTimes <- time.Since(startMine)
 				m.SolveCount <- struct{}{}
@@ -705,7 +872,144 @@ func New(cfg *Config) *CPUMiner {
 		SolveCount:        make(chan struct{}),
 		SolveTimes:        make(chan time.Duration),
 		SolveHashes:       make(chan string),
+		workers:           make(map[int]*workerState),
+	}
+}
+
+// addWorker registers the state of the worker with the passed id.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) addWorker(id int) *workerState {
+	w := &workerState{id: id}
+
+	m.workersMtx.Lock()
+	m.workers[id] = w
+	m.workersMtx.Unlock()
+	return w
+}
+
+// removeWorker unregisters the state of the worker with the passed id.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) removeWorker(id int) {
+	m.workersMtx.Lock()
+	delete(m.workers, id)
+	m.workersMtx.Unlock()
+}
+
+// nextPayouts returns the payouts of the next generated block, which come from
+// the payout schedule when there is one, or else pay to one of the mining
+// addresses chosen at random.
+func (m *CPUMiner) nextPayouts() []miningdag.Payout {
+	if m.cfg.Payouts != nil {
+		return m.cfg.Payouts.Next()
+	}
+
+	rand.Seed(time.Now().UnixNano())
+	payToAddr := m.cfg.MiningAddrs[rand.Intn(len(m.cfg.MiningAddrs))]
+	return []miningdag.Payout{{Address: payToAddr}}
+}
+
+// parentSet returns the parent set the worker with the passed id mines on in
+// multi-tip mode, or nil when it mines on all of the tips, along with the
+// generation of the parent sets.  The parent sets are assigned to the workers
+// round-robin.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) parentSet(id int) ([]chainhash.Hash, uint64) {
+	m.workersMtx.Lock()
+	defer m.workersMtx.Unlock()
+
+	if len(m.parentSets) == 0 {
+		return nil, m.parentSetsGen
+	}
+	return m.parentSets[id%len(m.parentSets)], m.parentSetsGen
+}
+
+// currentParentSetsGen returns the generation of the parent sets, which is
+// incremented whenever they change.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) currentParentSetsGen() uint64 {
+	m.workersMtx.Lock()
+	defer m.workersMtx.Unlock()
+
+	return m.parentSetsGen
+}
+
+// SetParentSets switches the miner to multi-tip mode, in which the workers
+// mine on the passed parent sets concurrently instead of extending all of the
+// tips of the DAG.  The sets are assigned to the workers round-robin, so there
+// should be at least as many workers as sets.  Since the blocks of a parent
+// set don't need to be tips, this allows deliberately producing sibling
+// blocks, which is mostly useful on the simulation test network.
+//
+// Passing no sets switches the miner back to extending all of the tips.  The
+// workers abandon their current work whenever the sets change.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) SetParentSets(sets [][]chainhash.Hash) {
+	copied := make([][]chainhash.Hash, 0, len(sets))
+	for _, set := range sets {
+		copied = append(copied, append([]chainhash.Hash(nil), set...))
+	}
+
+	m.workersMtx.Lock()
+	m.parentSets = copied
+	m.parentSetsGen++
+	m.workersMtx.Unlock()
+
+	if len(copied) == 0 {
+		log.Infof("CPU miner extending all tips")
+	} else {
+		log.Infof("CPU miner mining on %d parent sets", len(copied))
+	}
+}
+
+// ParentSets returns the parent sets the workers mine on in multi-tip mode, or
+// nil when they extend all of the tips.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) ParentSets() [][]chainhash.Hash {
+	m.workersMtx.Lock()
+	defer m.workersMtx.Unlock()
+
+	if len(m.parentSets) == 0 {
+		return nil
+	}
+	sets := make([][]chainhash.Hash, 0, len(m.parentSets))
+	for _, set := range m.parentSets {
+		sets = append(sets, append([]chainhash.Hash(nil), set...))
+	}
+	return sets
+}
+
+// WorkerStats returns the statistics of the running workers, ordered by id.
+//
+// This function is safe for concurrent access.
+func (m *CPUMiner) WorkerStats() []WorkerStats {
+	m.workersMtx.Lock()
+	defer m.workersMtx.Unlock()
+
+	stats := make([]WorkerStats, 0, len(m.workers))
+	for _, w := range m.workers {
+		var parents []chainhash.Hash
+		if len(m.parentSets) > 0 {
+			set := m.parentSets[w.id%len(m.parentSets)]
+			parents = append([]chainhash.Hash(nil), set...)
+		}
+		stats = append(stats, WorkerStats{
+			ID:       w.id,
+			Parents:  parents,
+			Hashes:   atomic.LoadUint64(&w.hashes),
+			Solved:   atomic.LoadUint64(&w.solved),
+			Accepted: atomic.LoadUint64(&w.accepted),
+		})
 	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].ID < stats[j].ID
+	})
+	return stats
 }
 
 // HashToBig converts a hash.Hash into a big.Int that can be used to
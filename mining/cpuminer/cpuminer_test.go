@@ -0,0 +1,106 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package cpuminer
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+)
+
+// TestSetParentSets ensures parent sets are assigned to the workers
+// round-robin, that changing them bumps their generation, and that the sets
+// handed out don't share memory with the ones of the miner.
+func TestSetParentSets(t *testing.T) {
+	m := New(&Config{})
+
+	if parents, _ := m.parentSet(0); parents != nil {
+		t.Fatalf("worker mines on %v before any sets were assigned",
+			parents)
+	}
+	if sets := m.ParentSets(); sets != nil {
+		t.Fatalf("got parent sets %v, want nil", sets)
+	}
+
+	setA := []chainhash.Hash{{0x01}}
+	setB := []chainhash.Hash{{0x02}, {0x03}}
+	gen := m.currentParentSetsGen()
+	m.SetParentSets([][]chainhash.Hash{setA, setB})
+	if m.currentParentSetsGen() == gen {
+		t.Fatal("generation not bumped when the parent sets changed")
+	}
+
+	// Modifying the passed sets must not modify the ones of the miner.
+	setA[0] = chainhash.Hash{0xff}
+	for id, want := range []chainhash.Hash{{0x01}, {0x02}, {0x01}} {
+		parents, gen := m.parentSet(id)
+		if len(parents) == 0 || parents[0] != want {
+			t.Fatalf("worker %d mines on %v, want %v first", id,
+				parents, want)
+		}
+		if gen != m.currentParentSetsGen() {
+			t.Fatalf("worker %d got generation %d, want %d", id, gen,
+				m.currentParentSetsGen())
+		}
+	}
+
+	sets := m.ParentSets()
+	if len(sets) != 2 || len(sets[1]) != 2 {
+		t.Fatalf("got parent sets %v, want 2 sets", sets)
+	}
+	sets[0][0] = chainhash.Hash{0xff}
+	if parents, _ := m.parentSet(0); parents[0] != (chainhash.Hash{0x01}) {
+		t.Fatal("modifying the returned sets modified the miner")
+	}
+
+	// Passing no sets switches back to extending all of the tips.
+	m.SetParentSets(nil)
+	if parents, _ := m.parentSet(0); parents != nil {
+		t.Fatalf("worker mines on %v after the sets were cleared",
+			parents)
+	}
+}
+
+// TestWorkerStats ensures the statistics of the running workers are reported
+// by id, along with the parent sets they mine on.
+func TestWorkerStats(t *testing.T) {
+	m := New(&Config{})
+	m.SetParentSets([][]chainhash.Hash{{{0x01}}, {{0x02}}})
+
+	w1 := m.addWorker(1)
+	w0 := m.addWorker(0)
+	m.addWorker(2)
+	atomic.AddUint64(&w0.hashes, 100)
+	atomic.AddUint64(&w1.solved, 2)
+	atomic.AddUint64(&w1.accepted, 1)
+
+	stats := m.WorkerStats()
+	if len(stats) != 3 {
+		t.Fatalf("got stats of %d workers, want 3", len(stats))
+	}
+	for i, s := range stats {
+		if s.ID != i {
+			t.Fatalf("stats %d are of worker %d", i, s.ID)
+		}
+	}
+	if stats[0].Hashes != 100 || stats[1].Solved != 2 ||
+		stats[1].Accepted != 1 {
+
+		t.Fatalf("unexpected worker stats %+v", stats)
+	}
+	for i, want := range []chainhash.Hash{{0x01}, {0x02}, {0x01}} {
+		if len(stats[i].Parents) != 1 || stats[i].Parents[0] != want {
+			t.Fatalf("worker %d reported parents %v, want %v", i,
+				stats[i].Parents, want)
+		}
+	}
+
+	// Workers which stopped are no longer reported.
+	m.removeWorker(1)
+	if stats := m.WorkerStats(); len(stats) != 2 || stats[1].ID != 2 {
+		t.Fatalf("got stats %+v after removing worker 1", stats)
+	}
+}
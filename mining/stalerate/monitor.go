@@ -0,0 +1,418 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package stalerate monitors how many of the blocks mined by the node end up
+// stale.
+//
+// A block mined locally is accepted in the DAG, but it only counts towards
+// the ordering of the DAG when the coloring places it in the blue set.  Blocks
+// left out of the blue set, which happens when they're mined in parallel with
+// too many blocks the miner didn't know of yet, are stale.  A high stale rate
+// signals connectivity or latency problems to the miner operator, so the
+// Monitor tracks the rate over sliding windows and raises an alert when it
+// exceeds a threshold.  The rate of the blocks mined alongside siblings at
+// the same height, which are blue but show the miner is racing with others,
+// is reported as well.
+package stalerate
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+)
+
+const (
+	// defaultConfirmations is the default number of generations built on
+	// top of a mined block before its color is considered settled.
+	defaultConfirmations = 6
+
+	// defaultCheckInterval is the default time between two settlements of
+	// the mined blocks.
+	defaultCheckInterval = 30 * time.Second
+
+	// defaultMinSamples is the default minimum number of settled blocks in
+	// a window for its stale rate to raise alerts.
+	defaultMinSamples = 10
+)
+
+// defaultWindows are the default windows the rates are reported over.
+var defaultWindows = []time.Duration{time.Hour, 6 * time.Hour, 24 * time.Hour}
+
+// DAG describes the view of the block DAG the monitor settles the mined
+// blocks with.  It is implemented by blockdag.BlockDAG.
+type DAG interface {
+	// DAGSnapshot returns information about the current tips of the DAG.
+	DAGSnapshot() *blockdag.DAGState
+
+	// BlockHeightByHash returns the height of the block with the passed
+	// hash.
+	BlockHeightByHash(hash *chainhash.Hash) (int32, error)
+
+	// BlockHashesByHeight returns the hashes of the blocks at the passed
+	// height.
+	BlockHashesByHeight(height int32) ([]chainhash.Hash, error)
+
+	// DAGColoring returns the hashes of the blocks of the blue set.
+	DAGColoring() []*chainhash.Hash
+}
+
+// Alert describes the stale rate of a window exceeding the threshold.
+type Alert struct {
+	// Window is the window the stale rate was measured over.
+	Window time.Duration
+
+	// StaleRate is the stale rate of the window, and Threshold the rate
+	// it exceeded.
+	StaleRate float64
+	Threshold float64
+
+	// Settled is the number of settled blocks of the window.
+	Settled int
+}
+
+// String returns the alert in human-readable form.
+func (a *Alert) String() string {
+	return fmt.Sprintf("stale rate of %.1f%% over the last %v (%d blocks) "+
+		"exceeds %.1f%%", a.StaleRate*100, a.Window, a.Settled,
+		a.Threshold*100)
+}
+
+// WindowStats describes the outcome of the blocks mined within a window.
+type WindowStats struct {
+	// Window is the duration of the window, which ends at the time the
+	// statistics were taken.
+	Window time.Duration
+
+	// Settled is the number of blocks mined within the window whose color
+	// is settled.  Stale is the number of them which aren't blue, and
+	// Siblings the number of them with other blocks at the same height.
+	Settled  int
+	Stale    int
+	Siblings int
+
+	// StaleRate and SiblingRate are the ratios of the stale blocks and of
+	// the blocks with siblings to the settled blocks.  They are zero when
+	// no block was settled.
+	StaleRate   float64
+	SiblingRate float64
+}
+
+// Config is a descriptor containing the stale rate monitor configuration.
+type Config struct {
+	// DAG is the block DAG the mined blocks are settled with.
+	DAG DAG
+
+	// Confirmations is the number of generations built on top of a mined
+	// block before its color is considered settled.  A default of 6 is
+	// used when it is zero.
+	Confirmations int32
+
+	// CheckInterval is the time between two settlements of the mined
+	// blocks.  A default of 30 seconds is used when it is zero.
+	CheckInterval time.Duration
+
+	// Windows are the windows the rates are reported over, which default
+	// to one hour, six hours and a day when empty.
+	Windows []time.Duration
+
+	// AlertThreshold is the stale rate which raises an alert when exceeded
+	// over any of the windows.  No alerts are raised when it is zero.
+	AlertThreshold float64
+
+	// MinSamples is the minimum number of settled blocks in a window for
+	// its stale rate to raise alerts, so a couple of unlucky blocks don't
+	// raise one.  A default of 10 is used when it is zero.
+	MinSamples int
+
+	// OnAlert defines the function to call when the stale rate of a window
+	// exceeds the threshold.  It is called once when the rate crosses the
+	// threshold, and again only after it fell back below it.
+	//
+	// This field can be nil.
+	OnAlert func(*Alert)
+}
+
+// settledBlock houses the outcome of a settled mined block.
+type settledBlock struct {
+	minedAt  time.Time
+	stale    bool
+	siblings bool
+}
+
+// Monitor tracks the outcome of the blocks mined by the node.  See the package
+// documentation for details.
+type Monitor struct {
+	cfg Config
+
+	started  int32
+	shutdown int32
+
+	mtx sync.Mutex
+
+	// pending are the mined blocks whose color isn't settled yet, by hash,
+	// along with the time they were mined at.
+	pending map[chainhash.Hash]time.Time
+
+	// settled are the settled mined blocks, ordered by the time they were
+	// mined at.  The ones older than the largest window are removed.
+	settled []settledBlock
+
+	// alerting tells which windows have a stale rate above the threshold.
+	alerting map[time.Duration]bool
+
+	wg   sync.WaitGroup
+	quit chan struct{}
+}
+
+// New returns a new instance of a stale rate monitor for the provided
+// configuration.  Use Start to begin settling the mined blocks.
+func New(cfg *Config) *Monitor {
+	if cfg.Confirmations == 0 {
+		cfg.Confirmations = defaultConfirmations
+	}
+	if cfg.CheckInterval == 0 {
+		cfg.CheckInterval = defaultCheckInterval
+	}
+	if len(cfg.Windows) == 0 {
+		cfg.Windows = defaultWindows
+	}
+	if cfg.MinSamples == 0 {
+		cfg.MinSamples = defaultMinSamples
+	}
+
+	windows := append([]time.Duration(nil), cfg.Windows...)
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i] < windows[j]
+	})
+	cfg.Windows = windows
+
+	return &Monitor{
+		cfg:      *cfg,
+		pending:  make(map[chainhash.Hash]time.Time),
+		alerting: make(map[time.Duration]bool),
+		quit:     make(chan struct{}),
+	}
+}
+
+// Start begins periodically settling the mined blocks.  Calling this function
+// when the monitor has already been started will have no effect.
+//
+// This function is safe for concurrent access.
+func (m *Monitor) Start() {
+	if atomic.AddInt32(&m.started, 1) != 1 {
+		return
+	}
+
+	log.Trace("Starting stale rate monitor")
+	m.wg.Add(1)
+	go m.checkHandler()
+}
+
+// Stop stops settling the mined blocks.  Calling this function when the
+// monitor has already been stopped will have no effect.
+//
+// This function is safe for concurrent access.
+func (m *Monitor) Stop() {
+	if atomic.AddInt32(&m.shutdown, 1) != 1 {
+		return
+	}
+
+	close(m.quit)
+	m.wg.Wait()
+	log.Trace("Stale rate monitor stopped")
+}
+
+// BlockMined records the block with the passed hash as mined by the node.  It
+// is meant to be called by the miners once the block is accepted.
+//
+// This function is safe for concurrent access.
+func (m *Monitor) BlockMined(hash *chainhash.Hash) {
+	m.mtx.Lock()
+	m.pending[*hash] = time.Now()
+	m.mtx.Unlock()
+}
+
+// Pending returns the number of mined blocks whose color isn't settled yet.
+//
+// This function is safe for concurrent access.
+func (m *Monitor) Pending() int {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	return len(m.pending)
+}
+
+// Stats returns the outcome of the blocks mined within each of the windows,
+// ordered from smallest to largest window.
+//
+// This function is safe for concurrent access.
+func (m *Monitor) Stats() []WindowStats {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	return m.stats(time.Now())
+}
+
+// checkHandler periodically settles the mined blocks.  It must be run as a
+// goroutine.
+func (m *Monitor) checkHandler() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.cfg.CheckInterval)
+	defer ticker.Stop()
+
+out:
+	for {
+		select {
+		case <-ticker.C:
+			m.check(time.Now())
+
+		case <-m.quit:
+			break out
+		}
+	}
+}
+
+// check settles the mined blocks which have enough confirmations as of the
+// passed time, and raises the alerts the settled blocks call for.
+func (m *Monitor) check(now time.Time) {
+	m.mtx.Lock()
+	m.settle(now)
+	alerts := m.updateAlerts(now)
+	m.mtx.Unlock()
+
+	for _, alert := range alerts {
+		log.Warnf("Mined blocks: %v", alert)
+		if m.cfg.OnAlert != nil {
+			m.cfg.OnAlert(alert)
+		}
+	}
+}
+
+// settle records the outcome of the pending mined blocks with enough
+// confirmations, drops the ones which never made it to the DAG, and removes
+// the settled blocks older than the largest window as of the passed time.
+//
+// This function MUST be called with the monitor lock held (for writes).
+func (m *Monitor) settle(now time.Time) {
+	maxWindow := m.cfg.Windows[len(m.cfg.Windows)-1]
+	maxHeight := m.cfg.DAG.DAGSnapshot().MaxHeight
+
+	var blue map[chainhash.Hash]struct{}
+	for hash, minedAt := range m.pending {
+		hash := hash
+		height, err := m.cfg.DAG.BlockHeightByHash(&hash)
+		if err != nil {
+			if now.Sub(minedAt) > maxWindow {
+				log.Debugf("Mined block %s never made it to the "+
+					"DAG", hash)
+				delete(m.pending, hash)
+			}
+			continue
+		}
+		if maxHeight < height+m.cfg.Confirmations {
+			continue
+		}
+
+		// The blue set is only fetched once some block is settled.
+		if blue == nil {
+			coloring := m.cfg.DAG.DAGColoring()
+			blue = make(map[chainhash.Hash]struct{}, len(coloring))
+			for _, blueHash := range coloring {
+				blue[*blueHash] = struct{}{}
+			}
+		}
+
+		_, isBlue := blue[hash]
+		block := settledBlock{minedAt: minedAt, stale: !isBlue}
+		siblings, err := m.cfg.DAG.BlockHashesByHeight(height)
+		block.siblings = err == nil && len(siblings) > 1
+		m.addSettled(block)
+		delete(m.pending, hash)
+
+		log.Debugf("Mined block %s settled (stale %v, siblings %v)",
+			hash, block.stale, block.siblings)
+	}
+
+	// Remove the settled blocks no window covers anymore.
+	expired := sort.Search(len(m.settled), func(i int) bool {
+		return now.Sub(m.settled[i].minedAt) <= maxWindow
+	})
+	m.settled = m.settled[expired:]
+}
+
+// addSettled inserts the passed settled block in the settled blocks, which
+// are ordered by the time they were mined at.
+//
+// This function MUST be called with the monitor lock held (for writes).
+func (m *Monitor) addSettled(block settledBlock) {
+	i := sort.Search(len(m.settled), func(i int) bool {
+		return m.settled[i].minedAt.After(block.minedAt)
+	})
+	m.settled = append(m.settled, settledBlock{})
+	copy(m.settled[i+1:], m.settled[i:])
+	m.settled[i] = block
+}
+
+// stats returns the outcome of the blocks mined within each of the windows as
+// of the passed time.
+//
+// This function MUST be called with the monitor lock held.
+func (m *Monitor) stats(now time.Time) []WindowStats {
+	stats := make([]WindowStats, 0, len(m.cfg.Windows))
+	for _, window := range m.cfg.Windows {
+		ws := WindowStats{Window: window}
+		for i := len(m.settled) - 1; i >= 0; i-- {
+			block := &m.settled[i]
+			if now.Sub(block.minedAt) > window {
+				break
+			}
+			ws.Settled++
+			if block.stale {
+				ws.Stale++
+			}
+			if block.siblings {
+				ws.Siblings++
+			}
+		}
+		if ws.Settled > 0 {
+			ws.StaleRate = float64(ws.Stale) / float64(ws.Settled)
+			ws.SiblingRate = float64(ws.Siblings) / float64(ws.Settled)
+		}
+		stats = append(stats, ws)
+	}
+	return stats
+}
+
+// updateAlerts returns the alerts for the windows whose stale rate crossed the
+// threshold as of the passed time, and rearms the alerts of the windows whose
+// stale rate fell back below it.
+//
+// This function MUST be called with the monitor lock held (for writes).
+func (m *Monitor) updateAlerts(now time.Time) []*Alert {
+	if m.cfg.AlertThreshold <= 0 {
+		return nil
+	}
+
+	var alerts []*Alert
+	for _, ws := range m.stats(now) {
+		above := ws.Settled >= m.cfg.MinSamples &&
+			ws.StaleRate > m.cfg.AlertThreshold
+		if above && !m.alerting[ws.Window] {
+			alerts = append(alerts, &Alert{
+				Window:    ws.Window,
+				StaleRate: ws.StaleRate,
+				Threshold: m.cfg.AlertThreshold,
+				Settled:   ws.Settled,
+			})
+		}
+		m.alerting[ws.Window] = above
+	}
+	return alerts
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package stalerate
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+)
+
+// fakeDAG is a DAG whose blocks, heights and coloring are set by the tests.
+type fakeDAG struct {
+	maxHeight int32
+	heights   map[chainhash.Hash]int32
+	blue      map[chainhash.Hash]bool
+}
+
+func newFakeDAG() *fakeDAG {
+	return &fakeDAG{
+		heights: make(map[chainhash.Hash]int32),
+		blue:    make(map[chainhash.Hash]bool),
+	}
+}
+
+func (d *fakeDAG) DAGSnapshot() *blockdag.DAGState {
+	return &blockdag.DAGState{MaxHeight: d.maxHeight}
+}
+
+func (d *fakeDAG) BlockHeightByHash(hash *chainhash.Hash) (int32, error) {
+	height, ok := d.heights[*hash]
+	if !ok {
+		return 0, errors.New("unknown block")
+	}
+	return height, nil
+}
+
+func (d *fakeDAG) BlockHashesByHeight(height int32) ([]chainhash.Hash, error) {
+	var hashes []chainhash.Hash
+	for hash, h := range d.heights {
+		if h == height {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes, nil
+}
+
+func (d *fakeDAG) DAGColoring() []*chainhash.Hash {
+	var hashes []*chainhash.Hash
+	for hash, blue := range d.blue {
+		hash := hash
+		if blue {
+			hashes = append(hashes, &hash)
+		}
+	}
+	return hashes
+}
+
+// TestMonitor ensures mined blocks are settled once confirmed, that the rates
+// are reported over the windows, and that alerts are raised once when the
+// stale rate crosses the threshold.
+func TestMonitor(t *testing.T) {
+	dag := newFakeDAG()
+	var alerts []*Alert
+	m := New(&Config{
+		DAG:            dag,
+		Confirmations:  2,
+		Windows:        []time.Duration{24 * time.Hour, time.Hour},
+		AlertThreshold: 0.25,
+		MinSamples:     4,
+		OnAlert: func(alert *Alert) {
+			alerts = append(alerts, alert)
+		},
+	})
+
+	// Mine four blocks at heights 1 to 4, the second of which has a
+	// sibling and the third of which is stale.  A fifth block never makes
+	// it to the DAG.
+	now := time.Now()
+	for i := 1; i <= 5; i++ {
+		hash := chainhash.Hash{byte(i)}
+		m.BlockMined(&hash)
+		m.pending[hash] = now.Add(time.Duration(i-5) * time.Minute)
+		if i == 5 {
+			break
+		}
+		dag.heights[hash] = int32(i)
+		dag.blue[hash] = i != 3
+	}
+	dag.heights[chainhash.Hash{0xff}] = 2
+
+	// Only the blocks with two generations on top of them are settled.
+	dag.maxHeight = 4
+	m.check(now)
+	if m.Pending() != 3 {
+		t.Fatalf("got %d pending blocks, want 3", m.Pending())
+	}
+	stats := m.stats(now)
+	if len(stats) != 2 || stats[0].Window != time.Hour {
+		t.Fatalf("got stats %+v, want the one hour window first", stats)
+	}
+	if stats[0].Settled != 2 || stats[0].Stale != 0 ||
+		stats[0].Siblings != 1 || stats[0].SiblingRate != 0.5 {
+
+		t.Fatalf("got stats %+v", stats[0])
+	}
+	if len(alerts) != 0 {
+		t.Fatalf("alert raised with too few samples: %v", alerts[0])
+	}
+
+	// Settling the rest puts the stale rate at the threshold, which doesn't
+	// raise an alert.
+	dag.maxHeight = 6
+	m.check(now)
+	m.check(now)
+	stats = m.stats(now)
+	if stats[0].Settled != 4 || stats[0].Stale != 1 ||
+		stats[0].StaleRate != 0.25 {
+
+		t.Fatalf("got stats %+v", stats[0])
+	}
+	if len(alerts) != 0 {
+		t.Fatalf("alert raised at the threshold: %v", alerts[0])
+	}
+
+	// Lowering the threshold raises an alert for both windows, exactly
+	// once.
+	m.cfg.AlertThreshold = 0.2
+	m.check(now)
+	m.check(now)
+	if len(alerts) != 2 {
+		t.Fatalf("got %d alerts, want 2", len(alerts))
+	}
+	if alerts[0].Window != time.Hour || alerts[0].StaleRate != 0.25 {
+		t.Fatalf("got alert %v", alerts[0])
+	}
+
+	// The block which never made it to the DAG is dropped, and the
+	// settled blocks expire with the largest window.
+	later := now.Add(25 * time.Hour)
+	m.check(later)
+	if m.Pending() != 0 {
+		t.Fatalf("got %d pending blocks, want 0", m.Pending())
+	}
+	if stats := m.stats(later); stats[1].Settled != 0 {
+		t.Fatalf("got stats %+v after expiry", stats[1])
+	}
+	if len(m.settled) != 0 {
+		t.Fatalf("%d settled blocks kept after expiry", len(m.settled))
+	}
+
+	// The alerts are rearmed once the rate falls back below the threshold.
+	if m.alerting[time.Hour] || m.alerting[24*time.Hour] {
+		t.Fatal("alerts not rearmed")
+	}
+}
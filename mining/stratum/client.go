@@ -0,0 +1,411 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package stratum
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/blockdag/pow"
+	"github.com/soteria-dag/soterd/soterutil"
+)
+
+const (
+	// maxLineSize is the maximum size in bytes of a message from a miner.
+	maxLineSize = 16 * 1024
+
+	// idleTimeout is the duration of inactivity before a miner is
+	// disconnected.
+	idleTimeout = 10 * time.Minute
+
+	// writeTimeout is the maximum duration of a write to a miner.
+	writeTimeout = 10 * time.Second
+)
+
+// Error codes returned to the miners, as commonly used by Stratum servers.
+const (
+	errCodeOther          = 20
+	errCodeJobNotFound    = 21
+	errCodeDuplicateShare = 22
+	errCodeLowDifficulty  = 23
+	errCodeUnauthorized   = 24
+	errCodeNotSubscribed  = 25
+)
+
+// stratumError is an error returned to a miner in reply to a request.  It's
+// encoded as an array of its code, message and traceback, per the protocol.
+type stratumError struct {
+	code    int
+	message string
+}
+
+// MarshalJSON encodes the error as a Stratum error array.
+func (e *stratumError) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.code, e.message, nil})
+}
+
+// Error satisfies the error interface.
+func (e *stratumError) Error() string {
+	return fmt.Sprintf("%d: %s", e.code, e.message)
+}
+
+// newError returns a new Stratum error with the passed code and message.
+func newError(code int, message string) *stratumError {
+	return &stratumError{code: code, message: message}
+}
+
+// request is a request from a miner.
+type request struct {
+	ID     interface{}       `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// response is the reply to a request from a miner.
+type response struct {
+	ID     interface{}   `json:"id"`
+	Result interface{}   `json:"result"`
+	Error  *stratumError `json:"error"`
+}
+
+// notification is a message pushed to a miner.
+type notification struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// client is the connection of a miner to the Stratum server.
+type client struct {
+	server      *Server
+	conn        net.Conn
+	extraNonce1 []byte
+
+	writeMtx sync.Mutex
+
+	mtx        sync.Mutex
+	subscribed bool
+	workers    map[string]struct{}
+}
+
+// newClient returns a new client for the passed connection, which is
+// allocated the passed extra nonce.
+func newClient(s *Server, conn net.Conn, extraNonce1 uint32) *client {
+	c := &client{
+		server:      s,
+		conn:        conn,
+		extraNonce1: make([]byte, extraNonce1Size),
+		workers:     make(map[string]struct{}),
+	}
+	binary.BigEndian.PutUint32(c.extraNonce1, extraNonce1)
+	return c
+}
+
+// inHandler reads and handles the requests of the miner until the connection
+// is closed.
+func (c *client) inHandler() {
+	log.Debugf("New Stratum client %s", c.conn.RemoteAddr())
+
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 0, 1024), maxLineSize)
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		if !scanner.Scan() {
+			break
+		}
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var req request
+		if err := json.Unmarshal(line, &req); err != nil {
+			log.Debugf("Malformed request from Stratum client %s: %v",
+				c.conn.RemoteAddr(), err)
+			break
+		}
+		result, err := c.handleRequest(&req)
+		if err := c.send(&response{ID: req.ID, Result: result, Error: err}); err != nil {
+			break
+		}
+
+		// Send the share difficulty and the current job to miners
+		// which just subscribed, now that they know their extra nonce.
+		if req.Method == "mining.subscribe" && err == nil {
+			c.sendWork()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Debugf("Stratum client %s read error: %v",
+			c.conn.RemoteAddr(), err)
+	}
+
+	c.conn.Close()
+	log.Debugf("Stratum client %s disconnected", c.conn.RemoteAddr())
+}
+
+// send writes the passed message to the miner.  The connection is closed when
+// the write fails.
+//
+// This function is safe for concurrent access.
+func (c *client) send(msg interface{}) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	c.writeMtx.Lock()
+	defer c.writeMtx.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.conn.Write(b); err != nil {
+		log.Debugf("Unable to write to Stratum client %s: %v",
+			c.conn.RemoteAddr(), err)
+		c.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// notifyJob sends the passed job to the miner, if it subscribed to jobs.
+func (c *client) notifyJob(j *job, cleanJobs bool) {
+	c.mtx.Lock()
+	subscribed := c.subscribed
+	c.mtx.Unlock()
+	if !subscribed {
+		return
+	}
+
+	c.send(&notification{
+		Method: "mining.notify",
+		Params: j.notifyParams(cleanJobs),
+	})
+}
+
+// handleRequest handles the passed request from the miner and returns the
+// result to reply with.
+func (c *client) handleRequest(req *request) (interface{}, *stratumError) {
+	switch req.Method {
+	case "mining.subscribe":
+		return c.handleSubscribe()
+	case "mining.authorize":
+		return c.handleAuthorize(req.Params)
+	case "mining.submit":
+		return c.handleSubmit(req.Params)
+	default:
+		return nil, newError(errCodeOther, "unknown method "+req.Method)
+	}
+}
+
+// sendWork sends the share difficulty and the current job to the miner.
+func (c *client) sendWork() {
+	c.send(&notification{
+		Method: "mining.set_difficulty",
+		Params: []interface{}{json.Number(c.server.shareDifficulty.String())},
+	})
+	if j := c.server.currentJob(); j != nil {
+		c.notifyJob(j, true)
+	}
+}
+
+// handleSubscribe subscribes the miner to jobs, and replies with the extra
+// nonce of the connection.
+func (c *client) handleSubscribe() (interface{}, *stratumError) {
+	c.mtx.Lock()
+	c.subscribed = true
+	c.mtx.Unlock()
+
+	// The subscription id is the extra nonce, which is unique to the
+	// connection.
+	id := hex.EncodeToString(c.extraNonce1)
+	result := []interface{}{
+		[][]string{
+			{"mining.set_difficulty", id},
+			{"mining.notify", id},
+		},
+		id,
+		extraNonce2Size,
+	}
+
+	return result, nil
+}
+
+// handleAuthorize authorizes the worker identified by the passed parameters,
+// which are its user name and password.
+func (c *client) handleAuthorize(params []json.RawMessage) (interface{}, *stratumError) {
+	args, err := stringParams(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	user := args[0]
+	var password string
+	if len(args) > 1 {
+		password = args[1]
+	}
+
+	authorize := c.server.cfg.Authorize
+	if authorize != nil && !authorize(user, password) {
+		log.Infof("Stratum worker %s from %s is not authorized", user,
+			c.conn.RemoteAddr())
+		return false, newError(errCodeUnauthorized, "unauthorized worker")
+	}
+
+	c.mtx.Lock()
+	c.workers[user] = struct{}{}
+	c.mtx.Unlock()
+	return true, nil
+}
+
+// handleSubmit validates the share identified by the passed parameters, which
+// are the worker name, job id, extra nonce 2, timestamp, nonce and cycle
+// nonces of the proof of work.  A share solving a block is submitted to the
+// network.
+func (c *client) handleSubmit(params []json.RawMessage) (interface{}, *stratumError) {
+	result, err := c.validateShare(params)
+	if err != nil {
+		atomic.AddUint64(&c.server.rejectedShares, 1)
+		log.Debugf("Rejected share from Stratum client %s: %v",
+			c.conn.RemoteAddr(), err)
+		return nil, err
+	}
+	atomic.AddUint64(&c.server.acceptedShares, 1)
+	return result, nil
+}
+
+// validateShare validates the share described by the passed submit
+// parameters, and submits the block when the share solves it.
+func (c *client) validateShare(params []json.RawMessage) (interface{}, *stratumError) {
+	args, err := stringParams(params, 6)
+	if err != nil {
+		return nil, err
+	}
+	worker, jobID := args[0], args[1]
+
+	c.mtx.Lock()
+	subscribed := c.subscribed
+	_, authorized := c.workers[worker]
+	c.mtx.Unlock()
+	if !subscribed {
+		return nil, newError(errCodeNotSubscribed, "not subscribed")
+	}
+	if !authorized {
+		return nil, newError(errCodeUnauthorized, "unauthorized worker")
+	}
+
+	j := c.server.lookupJob(jobID)
+	if j == nil {
+		return nil, newError(errCodeJobNotFound, "job not found")
+	}
+
+	extraNonce2, decodeErr := hex.DecodeString(args[2])
+	if decodeErr != nil || len(extraNonce2) != extraNonce2Size {
+		return nil, newError(errCodeOther, "invalid extranonce2")
+	}
+	ntime, decodeErr := strconv.ParseUint(args[3], 16, 32)
+	if decodeErr != nil {
+		return nil, newError(errCodeOther, "invalid ntime")
+	}
+	minTime := j.template.Block.Header.Timestamp.Unix()
+	maxTime := time.Now().Unix() + blockdag.MaxTimeOffsetSeconds
+	if int64(ntime) < minTime || int64(ntime) > maxTime {
+		return nil, newError(errCodeOther, "ntime out of range")
+	}
+	nonce, decodeErr := strconv.ParseUint(args[4], 16, 32)
+	if decodeErr != nil {
+		return nil, newError(errCodeOther, "invalid nonce")
+	}
+	backend := c.server.g.PowBackend()
+	cycleNonces, decodeErr := decodeCycleNonces(args[5], backend.ProofSize())
+	if decodeErr != nil {
+		return nil, newError(errCodeOther, "invalid cycle nonces")
+	}
+
+	// Reject shares which were already submitted, from any connection.
+	extraNonce := append(append([]byte(nil), c.extraNonce1...), extraNonce2...)
+	key := fmt.Sprintf("%x:%08x:%08x", extraNonce, ntime, nonce)
+	if !j.addShare(key) {
+		return nil, newError(errCodeDuplicateShare, "duplicate share")
+	}
+
+	msgBlock, buildErr := j.block(extraNonce, int64(ntime), uint32(nonce),
+		cycleNonces)
+	if buildErr != nil {
+		return nil, newError(errCodeOther, buildErr.Error())
+	}
+
+	// The share is valid when it's a valid proof of work for the header,
+	// with a proof difficulty of at least the share difficulty.
+	hash := msgBlock.Header.BlockHash()
+	targetDifficulty := blockdag.CompactToBig(msgBlock.Header.Bits)
+	proofDifficulty, solved, powErr := pow.Solved(backend,
+		hash.CloneBytes(), cycleNonces, targetDifficulty)
+	if powErr != nil {
+		return nil, newError(errCodeOther, "invalid proof of work")
+	}
+	if !solved && proofDifficulty.Cmp(c.server.shareDifficulty) < 0 {
+		return nil, newError(errCodeLowDifficulty, "low difficulty share")
+	}
+
+	log.Tracef("Accepted share from Stratum worker %s for job %s "+
+		"(proof difficulty %v)", worker, jobID, proofDifficulty)
+	if solved {
+		block := soterutil.NewBlock(msgBlock)
+		block.SetHeight(j.height)
+		log.Infof("Stratum worker %s solved block %s", worker,
+			block.Hash())
+		c.server.submitBlock(block)
+	}
+
+	return true, nil
+}
+
+// stringParams decodes the passed request parameters as strings.  At least
+// minParams parameters are required.
+func stringParams(params []json.RawMessage, minParams int) ([]string, *stratumError) {
+	if len(params) < minParams {
+		return nil, newError(errCodeOther, fmt.Sprintf("expected at "+
+			"least %d parameters, got %d", minParams, len(params)))
+	}
+
+	args := make([]string, 0, len(params))
+	for i, param := range params {
+		var arg string
+		if err := json.Unmarshal(param, &arg); err != nil {
+			return nil, newError(errCodeOther, fmt.Sprintf("parameter "+
+				"%d is not a string", i))
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// decodeCycleNonces decodes the hex-encoded cycle nonces of a share, which
+// are serialized as proofSize little-endian 32-bit integers.
+func decodeCycleNonces(s string, proofSize int) ([]uint32, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 4*proofSize {
+		return nil, fmt.Errorf("got %d bytes of cycle nonces, want %d",
+			len(b), 4*proofSize)
+	}
+
+	cycleNonces := make([]uint32, proofSize)
+	for i := range cycleNonces {
+		cycleNonces[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	return cycleNonces, nil
+}
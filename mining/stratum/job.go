@@ -0,0 +1,225 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package stratum
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/miningdag"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/txscript"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+const (
+	// extraNonce1Size is the size in bytes of the part of the extra nonce
+	// allocated by the server to each connection.
+	extraNonce1Size = 4
+
+	// extraNonce2Size is the size in bytes of the part of the extra nonce
+	// chosen by the miner for each share.
+	extraNonce2Size = 4
+
+	// extraNonceSize is the size in bytes of the full extra nonce in the
+	// coinbase script of a job.
+	extraNonceSize = extraNonce1Size + extraNonce2Size
+)
+
+// job is a unit of work handed to the miners, which is derived from a block
+// template.  The coinbase of the template is split around the extra nonce, so
+// miners can build their own coinbase and merkle root from it.
+type job struct {
+	id       string
+	template *miningdag.BlockTemplate
+	height   int32
+
+	// coinbase is the coinbase transaction of the template, with the
+	// extra nonce in its script left as zeroes.  The extra nonce starts at
+	// extraNonceOffset in the script.
+	coinbase         *wire.MsgTx
+	extraNonceOffset int
+
+	// coinb1 and coinb2 are the serialized coinbase transaction before
+	// and after the extra nonce.
+	coinb1 []byte
+	coinb2 []byte
+
+	// merkleBranch are the hashes needed to compute the merkle root of
+	// the block from the hash of the coinbase transaction.
+	merkleBranch []*chainhash.Hash
+
+	mtx    sync.Mutex
+	shares map[string]struct{}
+}
+
+// heightScriptLen returns the length of the part of the coinbase script of a
+// block at the passed height which pushes the height, and which precedes the
+// extra nonce.
+func heightScriptLen(height int32) (int, error) {
+	script, err := txscript.NewScriptBuilder().AddInt64(int64(height)).Script()
+	return len(script), err
+}
+
+// merkleBranch returns the hashes needed to compute the merkle root of the
+// passed merkle tree store from the hash of its first transaction.  At each
+// level of the tree, the path of the first transaction is the left node, so
+// the branch is the right node of each level below the root.
+func merkleBranch(merkles []*chainhash.Hash) []*chainhash.Hash {
+	var branch []*chainhash.Hash
+	offset := 0
+	for width := (len(merkles) + 1) / 2; width > 1; width /= 2 {
+		branch = append(branch, merkles[offset+1])
+		offset += width
+	}
+	return branch
+}
+
+// merkleRoot returns the merkle root computed from the hash of the first
+// transaction in the block and the passed merkle branch.
+func merkleRoot(coinbaseHash *chainhash.Hash, branch []*chainhash.Hash) *chainhash.Hash {
+	root := coinbaseHash
+	for _, hash := range branch {
+		root = blockdag.HashMerkleBranches(root, hash)
+	}
+	return root
+}
+
+// newJob returns a new job with the passed id for the passed block template,
+// whose coinbase script is built by the passed generator.
+func newJob(id string, template *miningdag.BlockTemplate, g *miningdag.BlkTmplGenerator) (*job, error) {
+	msgBlock := template.Block
+	if len(msgBlock.Transactions) == 0 {
+		return nil, errors.New("block template has no coinbase")
+	}
+
+	// Replace the coinbase script of the template with one which has room
+	// for the extra nonce of the job.  Unlike the script of the CPU miner,
+	// the extra nonce is always pushed as extraNonceSize bytes, so it is
+	// found at the same offset for every value of the extra nonce.
+	script, err := g.CoinbaseScript(template.Height,
+		make([]byte, extraNonceSize))
+	if err != nil {
+		return nil, err
+	}
+	// The extra nonce follows the height and its own push opcode.
+	heightLen, err := heightScriptLen(template.Height)
+	if err != nil {
+		return nil, err
+	}
+	extraNonceOffset := heightLen + 1
+	coinbase := msgBlock.Transactions[0].Copy()
+	coinbase.TxIn[0].SignatureScript = script
+
+	// Split the serialized coinbase around the extra nonce.  The hash of a
+	// transaction doesn't commit to its witness, so it's left out.
+	var buf bytes.Buffer
+	if err := coinbase.SerializeNoWitness(&buf); err != nil {
+		return nil, err
+	}
+	serialized := buf.Bytes()
+	scriptOffset := bytes.Index(serialized, script)
+	if scriptOffset < 0 {
+		return nil, errors.New("coinbase script not found in the " +
+			"serialized coinbase")
+	}
+	splitOffset := scriptOffset + extraNonceOffset
+
+	// The merkle branch of the coinbase doesn't depend on the coinbase, so
+	// it's computed from the transactions of the template as they are.
+	txns := make([]*soterutil.Tx, 0, len(msgBlock.Transactions))
+	txns = append(txns, soterutil.NewTx(coinbase))
+	for _, tx := range msgBlock.Transactions[1:] {
+		txns = append(txns, soterutil.NewTx(tx))
+	}
+	merkles := blockdag.BuildMerkleTreeStore(txns, false)
+
+	return &job{
+		id:               id,
+		template:         template,
+		height:           template.Height,
+		coinbase:         coinbase,
+		extraNonceOffset: extraNonceOffset,
+		coinb1:           append([]byte(nil), serialized[:splitOffset]...),
+		coinb2:           append([]byte(nil), serialized[splitOffset+extraNonceSize:]...),
+		merkleBranch:     merkleBranch(merkles),
+		shares:           make(map[string]struct{}),
+	}, nil
+}
+
+// notifyParams returns the parameters of the mining.notify message for the
+// job.  cleanJobs tells the miners to abandon the work on previous jobs.
+func (j *job) notifyParams(cleanJobs bool) []interface{} {
+	header := &j.template.Block.Header
+	branch := make([]string, 0, len(j.merkleBranch))
+	for _, hash := range j.merkleBranch {
+		branch = append(branch, hex.EncodeToString(hash[:]))
+	}
+
+	return []interface{}{
+		j.id,
+		hex.EncodeToString(header.PrevBlock[:]),
+		hex.EncodeToString(j.coinb1),
+		hex.EncodeToString(j.coinb2),
+		branch,
+		fmt.Sprintf("%08x", uint32(header.Version)),
+		fmt.Sprintf("%08x", header.Bits),
+		fmt.Sprintf("%08x", uint32(header.Timestamp.Unix())),
+		cleanJobs,
+	}
+}
+
+// addShare records the share identified by the passed key, and returns false
+// if it was already submitted for the job.
+//
+// This function is safe for concurrent access.
+func (j *job) addShare(key string) bool {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	if _, ok := j.shares[key]; ok {
+		return false
+	}
+	j.shares[key] = struct{}{}
+	return true
+}
+
+// block returns the block of the job with the passed extra nonce, timestamp,
+// nonce and cycle nonces.  The returned block shares all of the transactions
+// but the coinbase with the template, so it MUST NOT be modified.
+func (j *job) block(extraNonce []byte, ntime int64, nonce uint32, cycleNonces []uint32) (*wire.MsgBlock, error) {
+	if len(extraNonce) != extraNonceSize {
+		return nil, fmt.Errorf("extra nonce is %d bytes instead of %d",
+			len(extraNonce), extraNonceSize)
+	}
+	coinbase := j.coinbase.Copy()
+	script := coinbase.TxIn[0].SignatureScript
+	copy(script[j.extraNonceOffset:], extraNonce)
+
+	tmpl := j.template.Block
+	msgBlock := &wire.MsgBlock{
+		Header:  tmpl.Header,
+		Parents: tmpl.Parents,
+		Verification: wire.VerificationSubHeader{
+			Size:        int32(len(cycleNonces)),
+			CycleNonces: cycleNonces,
+		},
+		Transactions: make([]*wire.MsgTx, 0, len(tmpl.Transactions)),
+	}
+	msgBlock.Transactions = append(msgBlock.Transactions, coinbase)
+	msgBlock.Transactions = append(msgBlock.Transactions, tmpl.Transactions[1:]...)
+
+	coinbaseHash := coinbase.TxHash()
+	msgBlock.Header.MerkleRoot = *merkleRoot(&coinbaseHash, j.merkleBranch)
+	msgBlock.Header.Timestamp = time.Unix(ntime, 0)
+	msgBlock.Header.Nonce = nonce
+	return msgBlock, nil
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package stratum
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/blockdag/pow"
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/miningdag"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// newTestTemplate returns a block template with a coinbase followed by the
+// passed number of transactions.
+func newTestTemplate(numTxns int) *miningdag.BlockTemplate {
+	var msgBlock wire.MsgBlock
+	msgBlock.Header.Timestamp = time.Unix(time.Now().Unix(), 0)
+
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{},
+			wire.MaxPrevOutIndex),
+		SignatureScript: []byte{0x51, 0x51},
+		Sequence:        wire.MaxTxInSequenceNum,
+	})
+	coinbase.AddTxOut(wire.NewTxOut(5000, []byte{0x51}))
+	msgBlock.AddTransaction(coinbase)
+
+	for i := 0; i < numTxns; i++ {
+		tx := wire.NewMsgTx(wire.TxVersion)
+		prevOut := wire.NewOutPoint(&chainhash.Hash{byte(i + 1)}, 0)
+		tx.AddTxIn(wire.NewTxIn(prevOut, nil, nil))
+		tx.AddTxOut(wire.NewTxOut(int64(i), []byte{0x51}))
+		msgBlock.AddTransaction(tx)
+	}
+
+	return &miningdag.BlockTemplate{
+		Block:  &msgBlock,
+		Height: 1234,
+	}
+}
+
+// TestJobMerkleRoot ensures a miner building the coinbase of a job from its
+// parts and folding its hash with the merkle branch of the job obtains the
+// merkle root of the block the share is validated against.
+func TestJobMerkleRoot(t *testing.T) {
+	extraNonce := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	// The long tag is pushed with OP_PUSHDATA1, which mustn't move the
+	// extra nonce.
+	tags := [][]byte{nil, []byte("/pool/"), bytes.Repeat([]byte{'x'}, 80)}
+	for i := 0; i < 9*len(tags); i++ {
+		numTxns, tag := i%9, tags[i/9]
+		g := miningdag.NewBlkTmplGenerator(
+			&miningdag.Policy{CoinbaseTag: tag}, nil, nil, nil, nil,
+			nil, nil)
+		template := newTestTemplate(numTxns)
+		j, err := newJob("1", template, g)
+		if err != nil {
+			t.Fatalf("newJob (%d transactions): unexpected error: %v",
+				numTxns, err)
+		}
+
+		// Compute the merkle root the way a miner does.
+		var serialized bytes.Buffer
+		serialized.Write(j.coinb1)
+		serialized.Write(extraNonce)
+		serialized.Write(j.coinb2)
+		coinbaseHash := chainhash.DoubleHashH(serialized.Bytes())
+		minerRoot := merkleRoot(&coinbaseHash, j.merkleBranch)
+
+		msgBlock, err := j.block(extraNonce,
+			template.Block.Header.Timestamp.Unix(), 0, nil)
+		if err != nil {
+			t.Fatalf("block (%d transactions): unexpected error: %v",
+				numTxns, err)
+		}
+		if msgBlock.Header.MerkleRoot != *minerRoot {
+			t.Fatalf("merkle root (%d transactions): got %v, miner "+
+				"computed %v", numTxns, msgBlock.Header.MerkleRoot,
+				minerRoot)
+		}
+
+		// The merkle root must also be the one of the transactions of
+		// the block.
+		merkles := blockdag.BuildMerkleTreeStore(
+			soterutil.NewBlock(msgBlock).Transactions(), false)
+		if want := merkles[len(merkles)-1]; msgBlock.Header.MerkleRoot != *want {
+			t.Fatalf("merkle root (%d transactions): got %v, want %v",
+				numTxns, msgBlock.Header.MerkleRoot, want)
+		}
+
+		// The template must be left untouched.
+		if !bytes.Equal(template.Block.Transactions[0].TxIn[0].SignatureScript,
+			[]byte{0x51, 0x51}) {
+			t.Fatalf("coinbase of the template (%d transactions) was "+
+				"modified", numTxns)
+		}
+	}
+}
+
+// TestJobShares ensures duplicate shares are detected.
+func TestJobShares(t *testing.T) {
+	g := miningdag.NewBlkTmplGenerator(&miningdag.Policy{}, nil, nil, nil,
+		nil, nil, nil)
+	j, err := newJob("1", newTestTemplate(1), g)
+	if err != nil {
+		t.Fatalf("newJob: unexpected error: %v", err)
+	}
+
+	if !j.addShare("a") {
+		t.Fatal("first share reported as a duplicate")
+	}
+	if !j.addShare("b") {
+		t.Fatal("second share reported as a duplicate")
+	}
+	if j.addShare("a") {
+		t.Fatal("duplicate share not detected")
+	}
+}
+
+// TestDecodeCycleNonces ensures the cycle nonces of shares are decoded and
+// their size is enforced.
+func TestDecodeCycleNonces(t *testing.T) {
+	proofSize := pow.Default().ProofSize()
+	if _, err := decodeCycleNonces("0102", proofSize); err == nil {
+		t.Fatal("short cycle nonces accepted")
+	}
+	if _, err := decodeCycleNonces("zz", proofSize); err == nil {
+		t.Fatal("invalid hex accepted")
+	}
+}
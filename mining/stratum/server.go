@@ -0,0 +1,468 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package stratum
+
+import (
+	"errors"
+	"math/big"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/chaincfg"
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/miningdag"
+	"github.com/soteria-dag/soterd/soterutil"
+)
+
+const (
+	// jobCheckInterval is how often the server checks whether the current
+	// job is stale.
+	jobCheckInterval = time.Second
+
+	// defaultJobRefreshInterval is the default minimum time between two
+	// jobs for the same tips, which are only created to pick up new
+	// transactions.
+	defaultJobRefreshInterval = time.Minute
+
+	// maxJobs is the number of most recent jobs for which the server
+	// accepts shares.
+	maxJobs = 8
+)
+
+var (
+	// ErrNoPayouts is used to indicate that the configuration provides
+	// neither payment addresses nor a payout schedule.
+	ErrNoPayouts = errors.New("Config: MiningAddrs and Payouts cannot " +
+		"both be empty")
+)
+
+// Config is a descriptor containing the Stratum server configuration.
+type Config struct {
+	// ChainParams identifies which chain parameters the Stratum server is
+	// associated with.
+	ChainParams *chaincfg.Params
+
+	// BlockTemplateGenerator identifies the instance to use in order to
+	// generate the block templates the jobs are derived from.
+	BlockTemplateGenerator *miningdag.BlkTmplGenerator
+
+	// MiningAddrs is a list of payment addresses to use for the generated
+	// blocks.  Each job will randomly choose one of them.  It can only be
+	// empty when Payouts is set.
+	MiningAddrs []soterutil.Address
+
+	// Payouts is the payout schedule deciding which addresses the jobs pay
+	// to.  It takes precedence over MiningAddrs, and its payouts can be
+	// changed while the server is running.
+	//
+	// This field can be nil.
+	Payouts *miningdag.PayoutSchedule
+
+	// ProcessBlock defines the function to call with any solved blocks.
+	// It typically must run the provided block through the same set of
+	// rules and handling as any other block coming from the network.
+	ProcessBlock func(*soterutil.Block, blockdag.BehaviorFlags) (bool, error)
+
+	// Listeners defines a slice of listeners for which the Stratum server
+	// will take ownership of and accept connections from miners.
+	Listeners []net.Listener
+
+	// ShareDifficulty is the minimum proof difficulty of the shares
+	// accepted from the miners.  A difficulty of one is used when it is
+	// nil.  Shares meeting the target difficulty of the block are always
+	// accepted.
+	ShareDifficulty *big.Int
+
+	// JobRefreshInterval is the minimum time between two jobs for the same
+	// tips, which are created when the transaction source was updated.  A
+	// default of one minute is used when it is zero.
+	JobRefreshInterval time.Duration
+
+	// NoEmptyJobs disables handing out a job for an empty block as soon as
+	// the tips change, ahead of the job for the full template.
+	NoEmptyJobs bool
+
+	// Authorize defines the function to use to authorize a worker with
+	// the passed user name and password.  All workers are authorized when
+	// it is nil.
+	//
+	// This field can be nil.
+	Authorize func(user, password string) bool
+
+	// IsCurrent defines the function to use to obtain whether or not the
+	// block DAG is current.  No jobs are handed out until it is, since
+	// any solved blocks would end up orphaned anyways.
+	//
+	// This field can be nil.
+	IsCurrent func() bool
+
+	// MinedBlock defines the function to call with the hash of every block
+	// solved by the Stratum server which was accepted, such as to track how many
+	// of them end up stale.
+	//
+	// This field can be nil.
+	MinedBlock func(*chainhash.Hash)
+}
+
+// Server hands out jobs derived from block templates to miners connected over
+// the Stratum protocol, validates the shares they submit, and submits the
+// shares solving a block to the network.  It allows pointing GPU and ASIC
+// miners at soterd directly, without a separate Stratum proxy.
+type Server struct {
+	// The following variables must only be used atomically.
+	acceptedShares uint64
+	rejectedShares uint64
+	solvedBlocks   uint64
+
+	cfg             Config
+	g               *miningdag.BlkTmplGenerator
+	shareDifficulty *big.Int
+
+	started  int32
+	shutdown int32
+
+	// nextExtraNonce1 is the extra nonce allocated to the next connection.
+	nextExtraNonce1 uint32
+
+	mtx       sync.RWMutex
+	jobs      map[string]*job
+	jobOrder  []string
+	jobSeq    uint64
+	lastJob   time.Time
+	clients   map[*client]struct{}
+	submitMtx sync.Mutex
+
+	wg   sync.WaitGroup
+	quit chan struct{}
+}
+
+// New returns a new instance of a Stratum server for the provided
+// configuration.  Use Start to begin accepting miners.  ErrNoPayouts is
+// returned when the configuration doesn't say who the jobs pay to.
+func New(cfg *Config) (*Server, error) {
+	if cfg.Payouts == nil && len(cfg.MiningAddrs) == 0 {
+		return nil, ErrNoPayouts
+	}
+
+	shareDifficulty := cfg.ShareDifficulty
+	if shareDifficulty == nil {
+		shareDifficulty = big.NewInt(1)
+	}
+	if cfg.JobRefreshInterval == 0 {
+		cfg.JobRefreshInterval = defaultJobRefreshInterval
+	}
+
+	return &Server{
+		cfg:             *cfg,
+		g:               cfg.BlockTemplateGenerator,
+		shareDifficulty: shareDifficulty,
+		nextExtraNonce1: rand.Uint32(),
+		jobs:            make(map[string]*job),
+		clients:         make(map[*client]struct{}),
+		quit:            make(chan struct{}),
+	}, nil
+}
+
+// Start begins accepting miners on the configured listeners and handing out
+// jobs to them.  Calling this function when the server has already been
+// started will have no effect.
+//
+// This function is safe for concurrent access.
+func (s *Server) Start() {
+	if atomic.AddInt32(&s.started, 1) != 1 {
+		return
+	}
+
+	log.Trace("Starting Stratum server")
+	for _, listener := range s.cfg.Listeners {
+		s.wg.Add(1)
+		go s.listenHandler(listener)
+	}
+	s.wg.Add(1)
+	go s.jobHandler()
+}
+
+// Stop gracefully stops the server by closing the listeners and the
+// connections of all of the miners.  Calling this function when the server
+// has already been stopped will have no effect.
+//
+// This function is safe for concurrent access.
+func (s *Server) Stop() {
+	if atomic.AddInt32(&s.shutdown, 1) != 1 {
+		log.Infof("Stratum server is already in the process of " +
+			"shutting down")
+		return
+	}
+
+	log.Warnf("Stratum server shutting down")
+	for _, listener := range s.cfg.Listeners {
+		listener.Close()
+	}
+	close(s.quit)
+
+	s.mtx.RLock()
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	s.mtx.RUnlock()
+
+	s.wg.Wait()
+	log.Infof("Stratum server shutdown complete")
+}
+
+// Stats returns the number of accepted and rejected shares, and the number of
+// blocks solved by the shares accepted.
+//
+// This function is safe for concurrent access.
+func (s *Server) Stats() (acceptedShares, rejectedShares, solvedBlocks uint64) {
+	return atomic.LoadUint64(&s.acceptedShares),
+		atomic.LoadUint64(&s.rejectedShares),
+		atomic.LoadUint64(&s.solvedBlocks)
+}
+
+// listenHandler accepts the connections of miners on the passed listener.  It
+// must be run as a goroutine.
+func (s *Server) listenHandler(listener net.Listener) {
+	defer s.wg.Done()
+
+	log.Infof("Stratum server listening on %s", listener.Addr())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// Only log the error if not forcibly shutting down.
+			if atomic.LoadInt32(&s.shutdown) == 0 {
+				log.Errorf("Can't accept connection: %v", err)
+			}
+			break
+		}
+
+		c := newClient(s, conn, s.allocExtraNonce1())
+		s.mtx.Lock()
+		s.clients[c] = struct{}{}
+		s.mtx.Unlock()
+
+		// The connections are closed by Stop, which might have run
+		// while the connection was being added.
+		if atomic.LoadInt32(&s.shutdown) != 0 {
+			conn.Close()
+		}
+
+		s.wg.Add(1)
+		go func() {
+			c.inHandler()
+			s.mtx.Lock()
+			delete(s.clients, c)
+			s.mtx.Unlock()
+			s.wg.Done()
+		}()
+	}
+	log.Tracef("Stratum listener done for %s", listener.Addr())
+}
+
+// allocExtraNonce1 returns the extra nonce for a new connection.  The extra
+// nonces of the connections differ, so their miners never search the same
+// space.
+//
+// This function is safe for concurrent access.
+func (s *Server) allocExtraNonce1() uint32 {
+	return atomic.AddUint32(&s.nextExtraNonce1, 1)
+}
+
+// currentJob returns the most recent job, or nil if there is none.
+//
+// This function is safe for concurrent access.
+func (s *Server) currentJob() *job {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	if len(s.jobOrder) == 0 {
+		return nil
+	}
+	return s.jobs[s.jobOrder[len(s.jobOrder)-1]]
+}
+
+// lookupJob returns the job with the passed id, if shares for it are still
+// accepted.
+//
+// This function is safe for concurrent access.
+func (s *Server) lookupJob(id string) *job {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.jobs[id]
+}
+
+// jobHandler creates a new job whenever the current one becomes stale and
+// notifies the miners of it.  It must be run as a goroutine.
+func (s *Server) jobHandler() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(jobCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		s.updateJob()
+
+		select {
+		case <-ticker.C:
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// updateJob creates a new job and notifies the miners of it when the tips of
+// the DAG changed since the current job was created, in which case the miners
+// are told to abandon their work on previous jobs, or when the transaction
+// source was updated and JobRefreshInterval elapsed.  When the tips changed,
+// a job for an empty block is handed out first, unless NoEmptyJobs is set, so
+// the miners don't keep mining on stale parents while the transactions of the
+// full template are selected.
+func (s *Server) updateJob() {
+	if s.cfg.IsCurrent != nil && !s.cfg.IsCurrent() {
+		return
+	}
+
+	cur := s.currentJob()
+	tipsChanged := cur == nil ||
+		cur.template.Block.Header.PrevBlock != s.g.DAGSnapshot().Hash
+	if !tipsChanged {
+		s.mtx.RLock()
+		lastJob := s.lastJob
+		s.mtx.RUnlock()
+		if !s.g.TxSource().LastUpdated().After(lastJob) ||
+			time.Since(lastJob) < s.cfg.JobRefreshInterval {
+			return
+		}
+	}
+
+	// Choose the payouts from the schedule, or a payment address at
+	// random.
+	var payouts []miningdag.Payout
+	if s.cfg.Payouts != nil {
+		payouts = s.cfg.Payouts.Next()
+	} else {
+		payToAddr := s.cfg.MiningAddrs[rand.Intn(len(s.cfg.MiningAddrs))]
+		payouts = []miningdag.Payout{{Address: payToAddr}}
+	}
+
+	// Grab the same lock as used for block submission, since the tips
+	// will be changing and this would otherwise end up building a new
+	// template on a block that is in the process of becoming stale.
+	if tipsChanged && !s.cfg.NoEmptyJobs {
+		s.submitMtx.Lock()
+		template, err := s.g.NewEmptyBlockTemplate(payouts)
+		s.submitMtx.Unlock()
+		if err != nil {
+			log.Errorf("Failed to create new empty block template: %v",
+				err)
+		} else {
+			s.publishJob(template)
+		}
+	}
+
+	s.submitMtx.Lock()
+	template, err := s.g.NewBlockTemplateWithPayouts(payouts, nil)
+	s.submitMtx.Unlock()
+	if err != nil {
+		log.Errorf("Failed to create new block template: %v", err)
+		return
+	}
+	s.publishJob(template)
+}
+
+// publishJob creates a new job for the passed template and notifies the miners
+// of it.  The miners are told to abandon their work on previous jobs when the
+// job is for other tips than the current one.
+func (s *Server) publishJob(template *miningdag.BlockTemplate) {
+	s.mtx.Lock()
+	s.jobSeq++
+	id := strconv.FormatUint(s.jobSeq, 16)
+	j, err := newJob(id, template, s.g)
+	if err != nil {
+		s.mtx.Unlock()
+		log.Errorf("Failed to create new job: %v", err)
+		return
+	}
+	var cur *job
+	if len(s.jobOrder) > 0 {
+		cur = s.jobs[s.jobOrder[len(s.jobOrder)-1]]
+	}
+	clean := cur == nil || cur.template.Block.Header.PrevBlock !=
+		template.Block.Header.PrevBlock
+	if clean {
+		s.jobs = make(map[string]*job)
+		s.jobOrder = s.jobOrder[:0]
+	}
+	s.jobs[id] = j
+	s.jobOrder = append(s.jobOrder, id)
+	if len(s.jobOrder) > maxJobs {
+		delete(s.jobs, s.jobOrder[0])
+		s.jobOrder = s.jobOrder[1:]
+	}
+	s.lastJob = time.Now()
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mtx.Unlock()
+
+	log.Debugf("New Stratum job %s at height %d (%d transactions, clean "+
+		"%v, empty %v)", id, j.height, len(template.Block.Transactions),
+		clean, template.Empty)
+	for _, c := range clients {
+		c.notifyJob(j, clean)
+	}
+}
+
+// submitBlock submits the passed solved block to the network, and returns
+// whether it was accepted.
+func (s *Server) submitBlock(block *soterutil.Block) bool {
+	s.submitMtx.Lock()
+	defer s.submitMtx.Unlock()
+
+	// Ensure the block is not stale since the tips could have changed
+	// while the share was being found.
+	msgBlock := block.MsgBlock()
+	if !msgBlock.Header.PrevBlock.IsEqual(&s.g.DAGSnapshot().Hash) {
+		log.Debugf("Block submitted via Stratum with previous block %s "+
+			"is stale", msgBlock.Header.PrevBlock)
+		return false
+	}
+
+	// Process this block using the same rules as blocks coming from other
+	// nodes.  This will in turn relay it to the network like normal.
+	isOrphan, err := s.cfg.ProcessBlock(block, blockdag.BFNone)
+	if err != nil {
+		// Anything other than a rule violation is an unexpected error,
+		// so log that error as an internal error.
+		if _, ok := err.(blockdag.RuleError); !ok {
+			log.Errorf("Unexpected error while processing block "+
+				"submitted via Stratum: %v", err)
+			return false
+		}
+
+		log.Debugf("Block submitted via Stratum rejected: %v", err)
+		return false
+	}
+	if isOrphan {
+		log.Debugf("Block submitted via Stratum is an orphan")
+		return false
+	}
+
+	atomic.AddUint64(&s.solvedBlocks, 1)
+	if s.cfg.MinedBlock != nil {
+		s.cfg.MinedBlock(block.Hash())
+	}
+	coinbaseTx := msgBlock.Transactions[0].TxOut[0]
+	log.Infof("Block submitted via Stratum accepted (hash %s, amount %v)",
+		block.Hash(), soterutil.Amount(coinbaseTx.Value))
+	return true
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package stratum
+
+import (
+	"testing"
+
+	"github.com/soteria-dag/soterd/chaincfg"
+	"github.com/soteria-dag/soterd/miningdag"
+	"github.com/soteria-dag/soterd/soterutil"
+)
+
+// TestNewRequiresPayouts ensures a server can't be created without payment
+// addresses or a payout schedule, since its jobs would have nobody to pay.
+func TestNewRequiresPayouts(t *testing.T) {
+	if _, err := New(&Config{}); err != ErrNoPayouts {
+		t.Fatalf("New: got error %v, want %v", err, ErrNoPayouts)
+	}
+
+	addr, err := soterutil.NewAddressPubKeyHash(make([]byte, 20),
+		&chaincfg.SimNetParams)
+	if err != nil {
+		t.Fatalf("NewAddressPubKeyHash: %v", err)
+	}
+	if _, err := New(&Config{MiningAddrs: []soterutil.Address{addr}}); err != nil {
+		t.Fatalf("New with mining addresses: %v", err)
+	}
+
+	if _, err := New(&Config{Payouts: &miningdag.PayoutSchedule{}}); err != nil {
+		t.Fatalf("New with a payout schedule: %v", err)
+	}
+}
@@ -0,0 +1,445 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package submit implements the asynchronous submission of solved blocks.
+//
+// Processing a block can take a while, since it's fully validated and
+// connected to the DAG.  A Submitter queues the solved blocks it's handed and
+// returns a handle right away, so pools can acknowledge the work of their
+// miners without waiting for the block to be processed.  The verdict on the
+// block is reported later through the handle, and tells whether the block was
+// accepted as a tip of the DAG, accepted on the side, or rejected, along with
+// the rule it broke and the transaction breaking it.
+package submit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/soterutil"
+)
+
+const (
+	// defaultMaxPending is the default maximum number of blocks waiting to
+	// be processed.
+	defaultMaxPending = 64
+
+	// defaultResultTTL is the default time the verdict on a block is kept
+	// after it was decided.
+	defaultResultTTL = 10 * time.Minute
+
+	// pruneInterval is how often the verdicts which expired are removed.
+	pruneInterval = time.Minute
+)
+
+var (
+	// ErrQueueFull is returned by Submit when too many blocks are waiting
+	// to be processed.
+	ErrQueueFull = errors.New("too many blocks waiting to be processed")
+
+	// ErrUnknownHandle is returned when no block was submitted for a
+	// handle, or its verdict expired.
+	ErrUnknownHandle = errors.New("unknown block submission")
+
+	// ErrShutdown is returned when the submitter is shutting down.
+	ErrShutdown = errors.New("block submitter is shutting down")
+)
+
+// Verdict describes the outcome of the submission of a block.
+type Verdict int
+
+const (
+	// VerdictPending means the block wasn't processed yet.
+	VerdictPending Verdict = iota
+
+	// VerdictAcceptedTip means the block was accepted and is a tip of the
+	// DAG.
+	VerdictAcceptedTip
+
+	// VerdictAcceptedSide means the block was accepted, but isn't a tip of
+	// the DAG, such as when it doesn't reference all of the tips and
+	// another block references it already.
+	VerdictAcceptedSide
+
+	// VerdictOrphan means the block was accepted as an orphan, since some
+	// of its parents are unknown.
+	VerdictOrphan
+
+	// VerdictRejected means the block was rejected.
+	VerdictRejected
+)
+
+// Map of Verdict values back to their constant names for pretty printing.
+var verdictStrings = map[Verdict]string{
+	VerdictPending:      "VerdictPending",
+	VerdictAcceptedTip:  "VerdictAcceptedTip",
+	VerdictAcceptedSide: "VerdictAcceptedSide",
+	VerdictOrphan:       "VerdictOrphan",
+	VerdictRejected:     "VerdictRejected",
+}
+
+// String returns the Verdict in human-readable form.
+func (v Verdict) String() string {
+	if s, ok := verdictStrings[v]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown Verdict (%d)", int(v))
+}
+
+// Result describes the verdict on a submitted block.
+type Result struct {
+	// Hash is the hash of the block, which is also the handle of the
+	// submission.
+	Hash chainhash.Hash
+
+	// Verdict is the outcome of the submission.
+	Verdict Verdict
+
+	// IsRuleError tells whether a rejected block broke a consensus rule,
+	// in which case ErrorCode identifies the rule.  Otherwise the block
+	// couldn't be processed because of an internal error.
+	IsRuleError bool
+	ErrorCode   blockdag.ErrorCode
+
+	// Reason describes why a block was rejected.
+	Reason string
+
+	// OffendingTx is the hash of the transaction breaking the rule a
+	// rejected block broke, or nil when the rule doesn't apply to a
+	// single transaction.
+	OffendingTx *chainhash.Hash
+
+	// Submitted and Decided are the times the block was submitted and the
+	// verdict was decided.
+	Submitted time.Time
+	Decided   time.Time
+}
+
+// Config is a descriptor containing the block submitter configuration.
+type Config struct {
+	// ProcessBlock defines the function to call with the submitted blocks.
+	// It typically must run the provided block through the same set of
+	// rules and handling as any other block coming from the network.
+	ProcessBlock func(*soterutil.Block, blockdag.BehaviorFlags) (bool, error)
+
+	// TipHashes defines the function to use to obtain the hashes of the
+	// current tips of the DAG, which tell whether an accepted block is a
+	// tip.
+	TipHashes func() []chainhash.Hash
+
+	// MaxPending is the maximum number of blocks waiting to be processed.
+	// A default of 64 is used when it is zero.
+	MaxPending int
+
+	// ResultTTL is the time the verdict on a block is kept after it was
+	// decided.  A default of ten minutes is used when it is zero.
+	ResultTTL time.Duration
+
+	// Notify defines the function to call with the verdict on every block
+	// once it's decided.  It is called from the goroutine processing the
+	// blocks, so it must not block.
+	//
+	// This field can be nil.
+	Notify func(*Result)
+}
+
+// submission houses the state of a submitted block.
+type submission struct {
+	block  *soterutil.Block
+	result Result
+
+	// done is closed once the verdict is decided.
+	done chan struct{}
+}
+
+// Submitter processes solved blocks asynchronously.  See the package
+// documentation for details.
+type Submitter struct {
+	cfg Config
+
+	started  int32
+	shutdown int32
+
+	mtx         sync.Mutex
+	submissions map[chainhash.Hash]*submission
+
+	queue chan *submission
+	wg    sync.WaitGroup
+	quit  chan struct{}
+}
+
+// New returns a new instance of a block submitter for the provided
+// configuration.  Use Start to begin processing the submitted blocks.
+func New(cfg *Config) *Submitter {
+	if cfg.MaxPending == 0 {
+		cfg.MaxPending = defaultMaxPending
+	}
+	if cfg.ResultTTL == 0 {
+		cfg.ResultTTL = defaultResultTTL
+	}
+
+	return &Submitter{
+		cfg:         *cfg,
+		submissions: make(map[chainhash.Hash]*submission),
+		queue:       make(chan *submission, cfg.MaxPending),
+		quit:        make(chan struct{}),
+	}
+}
+
+// Start begins processing the submitted blocks.  Calling this function when
+// the submitter has already been started will have no effect.
+//
+// This function is safe for concurrent access.
+func (s *Submitter) Start() {
+	if atomic.AddInt32(&s.started, 1) != 1 {
+		return
+	}
+
+	log.Trace("Starting block submitter")
+	s.wg.Add(1)
+	go s.submitHandler()
+}
+
+// Stop gracefully stops processing the submitted blocks.  The blocks still
+// waiting to be processed are rejected.  Calling this function when the
+// submitter has already been stopped will have no effect.
+//
+// This function is safe for concurrent access.
+func (s *Submitter) Stop() {
+	if atomic.AddInt32(&s.shutdown, 1) != 1 {
+		log.Infof("Block submitter is already in the process of " +
+			"shutting down")
+		return
+	}
+
+	log.Infof("Block submitter shutting down")
+	close(s.quit)
+	s.wg.Wait()
+
+	// Reject the blocks which weren't processed, so no one waits on them
+	// forever.
+	s.mtx.Lock()
+	for _, sub := range s.submissions {
+		if sub.result.Verdict == VerdictPending {
+			s.decide(sub, VerdictRejected, ErrShutdown)
+		}
+	}
+	s.mtx.Unlock()
+}
+
+// Submit queues the passed solved block for processing and returns the handle
+// its verdict is obtained with, which is the hash of the block.  Submitting a
+// block which was already submitted returns the same handle, and the verdict
+// on the first submission.
+//
+// This function is safe for concurrent access.
+func (s *Submitter) Submit(block *soterutil.Block) (chainhash.Hash, error) {
+	hash := *block.Hash()
+	if atomic.LoadInt32(&s.shutdown) != 0 {
+		return hash, ErrShutdown
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.submissions[hash]; ok {
+		return hash, nil
+	}
+
+	sub := &submission{
+		block: block,
+		result: Result{
+			Hash:      hash,
+			Verdict:   VerdictPending,
+			Submitted: time.Now(),
+		},
+		done: make(chan struct{}),
+	}
+	select {
+	case s.queue <- sub:
+	default:
+		return hash, ErrQueueFull
+	}
+	s.submissions[hash] = sub
+
+	log.Debugf("Queued block %s for processing", hash)
+	return hash, nil
+}
+
+// Result returns the verdict on the block submitted with the passed handle,
+// which is VerdictPending while the block wasn't processed yet.
+//
+// This function is safe for concurrent access.
+func (s *Submitter) Result(handle *chainhash.Hash) (*Result, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	sub, ok := s.submissions[*handle]
+	if !ok {
+		return nil, ErrUnknownHandle
+	}
+	result := sub.result
+	return &result, nil
+}
+
+// Wait returns the verdict on the block submitted with the passed handle once
+// it's decided, or the pending verdict when it isn't after the passed timeout.
+// A timeout of zero waits until the verdict is decided.
+//
+// This function is safe for concurrent access.
+func (s *Submitter) Wait(handle *chainhash.Hash, timeout time.Duration) (*Result, error) {
+	s.mtx.Lock()
+	sub, ok := s.submissions[*handle]
+	s.mtx.Unlock()
+	if !ok {
+		return nil, ErrUnknownHandle
+	}
+
+	var timeoutChan <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
+
+	select {
+	case <-sub.done:
+	case <-timeoutChan:
+	case <-s.quit:
+		return nil, ErrShutdown
+	}
+	return s.Result(handle)
+}
+
+// submitHandler processes the submitted blocks one at a time and removes the
+// verdicts which expired.  It must be run as a goroutine.
+func (s *Submitter) submitHandler() {
+	defer s.wg.Done()
+
+	pruneTicker := time.NewTicker(pruneInterval)
+	defer pruneTicker.Stop()
+
+out:
+	for {
+		select {
+		case sub := <-s.queue:
+			s.process(sub)
+
+		case <-pruneTicker.C:
+			s.prune(time.Now())
+
+		case <-s.quit:
+			break out
+		}
+	}
+
+	log.Trace("Block submitter done")
+}
+
+// process runs the passed submitted block through ProcessBlock and decides
+// the verdict on it.
+func (s *Submitter) process(sub *submission) {
+	isOrphan, err := s.cfg.ProcessBlock(sub.block, blockdag.BFNone)
+
+	verdict := VerdictAcceptedSide
+	switch {
+	case err != nil:
+		verdict = VerdictRejected
+		if _, ok := err.(blockdag.RuleError); ok {
+			log.Debugf("Submitted block %s rejected: %v",
+				sub.block.Hash(), err)
+		} else {
+			log.Errorf("Unexpected error while processing "+
+				"submitted block %s: %v", sub.block.Hash(), err)
+		}
+
+	case isOrphan:
+		verdict = VerdictOrphan
+
+	default:
+		for _, tip := range s.cfg.TipHashes() {
+			if tip == *sub.block.Hash() {
+				verdict = VerdictAcceptedTip
+				break
+			}
+		}
+	}
+
+	s.mtx.Lock()
+	s.decide(sub, verdict, err)
+	result := sub.result
+	s.mtx.Unlock()
+
+	log.Debugf("Verdict on submitted block %s: %v", result.Hash,
+		result.Verdict)
+	if s.cfg.Notify != nil {
+		s.cfg.Notify(&result)
+	}
+}
+
+// decide records the passed verdict on the passed submission along with the
+// error it was rejected with, and wakes up the callers waiting on it.
+//
+// This function MUST be called with the submitter lock held (for writes).
+func (s *Submitter) decide(sub *submission, verdict Verdict, err error) {
+	result := &sub.result
+	result.Verdict = verdict
+	result.Decided = time.Now()
+	if err != nil {
+		result.Reason = err.Error()
+		if rerr, ok := err.(blockdag.RuleError); ok {
+			result.IsRuleError = true
+			result.ErrorCode = rerr.ErrorCode
+			result.OffendingTx = offendingTx(sub.block, rerr)
+		}
+	}
+
+	// The block is only needed for processing.
+	sub.block = nil
+	close(sub.done)
+}
+
+// prune removes the verdicts decided longer than the result TTL before the
+// passed time.
+func (s *Submitter) prune(now time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for hash, sub := range s.submissions {
+		if sub.result.Verdict != VerdictPending &&
+			now.Sub(sub.result.Decided) > s.cfg.ResultTTL {
+
+			delete(s.submissions, hash)
+		}
+	}
+}
+
+// offendingTx returns the hash of the transaction of the passed block which
+// broke the rule of the passed error, or nil when it can't be told.  The
+// validation rules name the transaction they reject in their description,
+// except for the context-free checks of the transactions, which are run
+// again to find the one failing them.
+func offendingTx(block *soterutil.Block, rerr blockdag.RuleError) *chainhash.Hash {
+	for _, tx := range block.Transactions() {
+		if strings.Contains(rerr.Description, tx.Hash().String()) {
+			return tx.Hash()
+		}
+	}
+
+	for _, tx := range block.Transactions() {
+		err := blockdag.CheckTransactionSanity(tx)
+		if terr, ok := err.(blockdag.RuleError); ok &&
+			terr.ErrorCode == rerr.ErrorCode {
+
+			return tx.Hash()
+		}
+	}
+	return nil
+}
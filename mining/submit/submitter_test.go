@@ -0,0 +1,208 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package submit
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// newTestBlock returns a block with a coinbase and a transaction, made unique
+// by the passed nonce.
+func newTestBlock(nonce uint32) *soterutil.Block {
+	var msgBlock wire.MsgBlock
+	msgBlock.Header.Nonce = nonce
+
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{},
+			wire.MaxPrevOutIndex),
+		SignatureScript: []byte{0x51, 0x51},
+		Sequence:        wire.MaxTxInSequenceNum,
+	})
+	coinbase.AddTxOut(wire.NewTxOut(5000, []byte{0x51}))
+	msgBlock.AddTransaction(coinbase)
+
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil,
+		nil))
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	msgBlock.AddTransaction(tx)
+
+	return soterutil.NewBlock(&msgBlock)
+}
+
+// TestSubmitterVerdicts ensures the verdicts on the submitted blocks reflect
+// the outcome of processing them.
+func TestSubmitterVerdicts(t *testing.T) {
+	tip := newTestBlock(0)
+	side := newTestBlock(1)
+	orphan := newTestBlock(2)
+	invalid := newTestBlock(3)
+	failed := newTestBlock(4)
+	offending := invalid.Transactions()[1].Hash()
+
+	notified := make(chan *Result, 5)
+	s := New(&Config{
+		ProcessBlock: func(block *soterutil.Block, flags blockdag.BehaviorFlags) (bool, error) {
+			switch *block.Hash() {
+			case *orphan.Hash():
+				return true, nil
+			case *invalid.Hash():
+				return false, blockdag.RuleError{
+					ErrorCode: blockdag.ErrMissingTxOut,
+					Description: fmt.Sprintf("output referenced "+
+						"from transaction %s is missing",
+						offending),
+				}
+			case *failed.Hash():
+				return false, errors.New("database failure")
+			}
+			return false, nil
+		},
+		TipHashes: func() []chainhash.Hash {
+			return []chainhash.Hash{{0xff}, *tip.Hash()}
+		},
+		Notify: func(result *Result) {
+			notified <- result
+		},
+	})
+	s.Start()
+	defer s.Stop()
+
+	tests := []struct {
+		name    string
+		block   *soterutil.Block
+		verdict Verdict
+		code    blockdag.ErrorCode
+		tx      *chainhash.Hash
+	}{
+		{"tip", tip, VerdictAcceptedTip, 0, nil},
+		{"side", side, VerdictAcceptedSide, 0, nil},
+		{"orphan", orphan, VerdictOrphan, 0, nil},
+		{"invalid", invalid, VerdictRejected, blockdag.ErrMissingTxOut,
+			offending},
+		{"failed", failed, VerdictRejected, 0, nil},
+	}
+
+	for _, test := range tests {
+		handle, err := s.Submit(test.block)
+		if err != nil {
+			t.Fatalf("%s: Submit: unexpected error: %v", test.name, err)
+		}
+		if handle != *test.block.Hash() {
+			t.Fatalf("%s: got handle %v, want %v", test.name, handle,
+				test.block.Hash())
+		}
+
+		result, err := s.Wait(&handle, 0)
+		if err != nil {
+			t.Fatalf("%s: Wait: unexpected error: %v", test.name, err)
+		}
+		if result.Verdict != test.verdict {
+			t.Fatalf("%s: got verdict %v, want %v", test.name,
+				result.Verdict, test.verdict)
+		}
+		if result.Verdict == VerdictRejected && result.Reason == "" {
+			t.Fatalf("%s: rejected without a reason", test.name)
+		}
+		if result.IsRuleError != (test.code != 0) ||
+			result.ErrorCode != test.code {
+
+			t.Fatalf("%s: got error code %v (rule error %v), want %v",
+				test.name, result.ErrorCode, result.IsRuleError,
+				test.code)
+		}
+		if (result.OffendingTx == nil) != (test.tx == nil) ||
+			(test.tx != nil && *result.OffendingTx != *test.tx) {
+
+			t.Fatalf("%s: got offending tx %v, want %v", test.name,
+				result.OffendingTx, test.tx)
+		}
+
+		select {
+		case n := <-notified:
+			if n.Hash != handle || n.Verdict != test.verdict {
+				t.Fatalf("%s: notified of %v %v", test.name,
+					n.Hash, n.Verdict)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: verdict not notified", test.name)
+		}
+	}
+
+	// Submitting a block again returns the verdict on the first
+	// submission.
+	handle, err := s.Submit(tip)
+	if err != nil {
+		t.Fatalf("Submit: unexpected error: %v", err)
+	}
+	result, err := s.Result(&handle)
+	if err != nil {
+		t.Fatalf("Result: unexpected error: %v", err)
+	}
+	if result.Verdict != VerdictAcceptedTip {
+		t.Fatalf("resubmission: got verdict %v, want %v",
+			result.Verdict, VerdictAcceptedTip)
+	}
+
+	// Expired verdicts are removed.
+	s.prune(time.Now().Add(defaultResultTTL + time.Second))
+	if _, err := s.Result(&handle); err != ErrUnknownHandle {
+		t.Fatalf("Result after expiry: got error %v, want %v", err,
+			ErrUnknownHandle)
+	}
+}
+
+// TestSubmitterQueue ensures the blocks waiting to be processed are bounded
+// and reported as pending, and that they're rejected on shutdown.
+func TestSubmitterQueue(t *testing.T) {
+	s := New(&Config{
+		ProcessBlock: func(*soterutil.Block, blockdag.BehaviorFlags) (bool, error) {
+			return false, nil
+		},
+		TipHashes:  func() []chainhash.Hash { return nil },
+		MaxPending: 1,
+	})
+
+	// The submitter isn't started, so the blocks stay in the queue.
+	handle, err := s.Submit(newTestBlock(0))
+	if err != nil {
+		t.Fatalf("Submit: unexpected error: %v", err)
+	}
+	if _, err := s.Submit(newTestBlock(1)); err != ErrQueueFull {
+		t.Fatalf("Submit: got error %v, want %v", err, ErrQueueFull)
+	}
+
+	result, err := s.Wait(&handle, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Wait: unexpected error: %v", err)
+	}
+	if result.Verdict != VerdictPending {
+		t.Fatalf("got verdict %v, want %v", result.Verdict,
+			VerdictPending)
+	}
+
+	s.Stop()
+	result, err = s.Result(&handle)
+	if err != nil {
+		t.Fatalf("Result: unexpected error: %v", err)
+	}
+	if result.Verdict != VerdictRejected || result.Reason != ErrShutdown.Error() {
+		t.Fatalf("after shutdown: got verdict %v (%s)", result.Verdict,
+			result.Reason)
+	}
+	if _, err := s.Submit(newTestBlock(2)); err != ErrShutdown {
+		t.Fatalf("Submit after shutdown: got error %v, want %v", err,
+			ErrShutdown)
+	}
+}
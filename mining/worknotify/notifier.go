@@ -0,0 +1,409 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package worknotify pushes new mining work to external miners.
+//
+// Mining proxies otherwise poll getblocktemplate in a tight loop to learn about
+// new tips as soon as possible.  A Notifier watches the tips of the DAG and
+// the transaction source instead, and publishes a description of the new work
+// to its subscribers whenever the tips change, or the transactions of the
+// template change materially.  Each piece of work carries a sequence number,
+// so subscribers can tell when they missed some.  It is meant to back the
+// notifywork websocket notification.
+package worknotify
+
+import (
+	"encoding/binary"
+	"math/big"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/miningdag"
+	"github.com/soteria-dag/soterd/soterutil"
+)
+
+const (
+	// checkInterval is how often the notifier checks whether new work is
+	// to be published.
+	checkInterval = time.Second
+
+	// defaultMinInterval is the default minimum time between two pieces of
+	// work published for the same tips.
+	defaultMinInterval = 5 * time.Second
+
+	// defaultBacklog is the default number of pieces of work buffered for
+	// a subscriber.
+	defaultBacklog = 4
+)
+
+// Work describes a new block template miners should work on.
+type Work struct {
+	// Seq is the sequence number of the work, which is incremented for
+	// every piece of work published.
+	Seq uint64
+
+	// Digest identifies the content of the template, which is its parents,
+	// target and transactions, but not its timestamp or the address its
+	// coinbase pays to.  Templates with the same digest are equivalent.
+	Digest chainhash.Hash
+
+	// Height is the height of the template, and Parents the hashes of its
+	// parents, whose tips hash is TipsHash.
+	Height   int32
+	Parents  []chainhash.Hash
+	TipsHash chainhash.Hash
+
+	// Bits is the target difficulty of the template in compact form, and
+	// Target the same difficulty as a number.
+	Bits   uint32
+	Target *big.Int
+
+	// NumTxns is the number of transactions of the template, including the
+	// coinbase, and Fees the fees they pay.
+	NumTxns int
+	Fees    soterutil.Amount
+
+	// Clean tells whether the tips changed since the previous work, in
+	// which case the work on the previous templates is stale.
+	Clean bool
+
+	// Empty tells whether the template has no transactions other than the
+	// coinbase because it was generated for the new tips before selecting
+	// any, in which case work for the full template follows.
+	Empty bool
+
+	// Created is the time the work was published.
+	Created time.Time
+}
+
+// Config is a descriptor containing the work notifier configuration.
+type Config struct {
+	// BlockTemplateGenerator identifies the instance to use in order to
+	// generate the block templates the work is derived from.
+	BlockTemplateGenerator *miningdag.BlkTmplGenerator
+
+	// MiningAddrs is a list of payment addresses to use for the generated
+	// templates.  Each template will randomly choose one of them.  The
+	// templates pay to anyone when it is empty, since only their digest
+	// is published.
+	MiningAddrs []soterutil.Address
+
+	// MinInterval is the minimum time between two pieces of work published
+	// for the same tips.  A default of five seconds is used when it is
+	// zero.  New work is published as soon as the tips change.
+	MinInterval time.Duration
+
+	// MinFeeIncrease is how much the fees of the template must increase
+	// for new work to be published for the same tips.  Work is published
+	// whenever the transactions of the template change when it is zero.
+	MinFeeIncrease soterutil.Amount
+
+	// IsCurrent defines the function to use to obtain whether or not the
+	// block DAG is current.  No work is published until it is.
+	//
+	// This field can be nil.
+	IsCurrent func() bool
+
+	// NoEmptyWork disables publishing work for an empty block as soon as
+	// the tips change, ahead of the work for the full template.
+	NoEmptyWork bool
+}
+
+// Subscription delivers the work published by a notifier to a subscriber.
+type Subscription struct {
+	n    *Notifier
+	work chan *Work
+}
+
+// Work returns the channel the work is delivered on.  When the subscriber
+// doesn't keep up, the oldest work buffered is dropped in favor of the new
+// one, which the subscriber detects through the sequence numbers.  The
+// channel is closed when the subscription is closed.
+func (s *Subscription) Work() <-chan *Work {
+	return s.work
+}
+
+// Close stops delivering the work to the subscriber.
+//
+// This function is safe for concurrent access.
+func (s *Subscription) Close() {
+	s.n.mtx.Lock()
+	defer s.n.mtx.Unlock()
+
+	if _, ok := s.n.subscriptions[s]; ok {
+		delete(s.n.subscriptions, s)
+		close(s.work)
+	}
+}
+
+// deliver delivers the passed work to the subscriber, dropping the oldest work
+// buffered if the subscriber doesn't keep up.
+//
+// This function MUST be called with the notifier lock held.
+func (s *Subscription) deliver(work *Work) {
+	for {
+		select {
+		case s.work <- work:
+			return
+		default:
+		}
+
+		select {
+		case <-s.work:
+		default:
+		}
+	}
+}
+
+// Notifier publishes new mining work to its subscribers.  See the package
+// documentation for details.
+type Notifier struct {
+	cfg Config
+	g   *miningdag.BlkTmplGenerator
+
+	started  int32
+	shutdown int32
+
+	mtx           sync.Mutex
+	subscriptions map[*Subscription]struct{}
+	latest        *Work
+	seq           uint64
+
+	wg   sync.WaitGroup
+	quit chan struct{}
+}
+
+// New returns a new instance of a work notifier for the provided
+// configuration.  Use Start to begin publishing work.
+func New(cfg *Config) *Notifier {
+	if cfg.MinInterval == 0 {
+		cfg.MinInterval = defaultMinInterval
+	}
+
+	return &Notifier{
+		cfg:           *cfg,
+		g:             cfg.BlockTemplateGenerator,
+		subscriptions: make(map[*Subscription]struct{}),
+		quit:          make(chan struct{}),
+	}
+}
+
+// Start begins publishing work whenever the tips or the transactions of the
+// template change.  Calling this function when the notifier has already been
+// started will have no effect.
+//
+// This function is safe for concurrent access.
+func (n *Notifier) Start() {
+	if atomic.AddInt32(&n.started, 1) != 1 {
+		return
+	}
+
+	log.Trace("Starting work notifier")
+	n.wg.Add(1)
+	go n.workHandler()
+}
+
+// Stop stops publishing work and closes all of the subscriptions.  Calling
+// this function when the notifier has already been stopped will have no
+// effect.
+//
+// This function is safe for concurrent access.
+func (n *Notifier) Stop() {
+	if atomic.AddInt32(&n.shutdown, 1) != 1 {
+		return
+	}
+
+	close(n.quit)
+	n.wg.Wait()
+
+	n.mtx.Lock()
+	for s := range n.subscriptions {
+		delete(n.subscriptions, s)
+		close(s.work)
+	}
+	n.mtx.Unlock()
+	log.Trace("Work notifier stopped")
+}
+
+// Subscribe returns a new subscription to the work published by the notifier,
+// which buffers up to the passed number of pieces of work.  A default of four
+// is used when it isn't positive.  The latest work, if any, is delivered
+// right away.
+//
+// This function is safe for concurrent access.
+func (n *Notifier) Subscribe(backlog int) *Subscription {
+	if backlog <= 0 {
+		backlog = defaultBacklog
+	}
+	s := &Subscription{n: n, work: make(chan *Work, backlog)}
+
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	if atomic.LoadInt32(&n.shutdown) != 0 {
+		close(s.work)
+		return s
+	}
+	n.subscriptions[s] = struct{}{}
+	if n.latest != nil {
+		s.deliver(n.latest)
+	}
+	return s
+}
+
+// Latest returns the latest work published, or nil if none was.
+//
+// This function is safe for concurrent access.
+func (n *Notifier) Latest() *Work {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	return n.latest
+}
+
+// workHandler periodically checks whether new work is to be published.  It
+// must be run as a goroutine.
+func (n *Notifier) workHandler() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	var lastUpdated time.Time
+	for {
+		if n.cfg.IsCurrent == nil || n.cfg.IsCurrent() {
+			lastUpdated = n.update(lastUpdated)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-n.quit:
+			return
+		}
+	}
+}
+
+// update publishes new work when the tips changed since the latest work, or
+// when the transaction source was updated since the passed time and the new
+// template differs materially from the latest work.  It returns the last
+// update of the transaction source the latest work accounts for.
+func (n *Notifier) update(lastUpdated time.Time) time.Time {
+	latest := n.Latest()
+	tipsChanged := latest == nil ||
+		latest.TipsHash != n.g.DAGSnapshot().Hash
+	sourceUpdated := n.g.TxSource().LastUpdated()
+	if !tipsChanged && (!sourceUpdated.After(lastUpdated) ||
+		time.Since(latest.Created) < n.cfg.MinInterval) {
+
+		return lastUpdated
+	}
+
+	var payouts []miningdag.Payout
+	if len(n.cfg.MiningAddrs) > 0 {
+		payToAddr := n.cfg.MiningAddrs[rand.Intn(len(n.cfg.MiningAddrs))]
+		payouts = []miningdag.Payout{{Address: payToAddr}}
+	}
+
+	// Publish work for an empty block on the new tips right away, since
+	// selecting the transactions of the full template takes a while.
+	if tipsChanged && !n.cfg.NoEmptyWork {
+		template, err := n.g.NewEmptyBlockTemplate(payouts)
+		if err != nil {
+			log.Errorf("Failed to create new empty block template: %v",
+				err)
+		} else {
+			work := newWork(template)
+			work.Clean = latest == nil || work.TipsHash != latest.TipsHash
+			n.publish(work)
+			latest = work
+		}
+	}
+
+	template, err := n.g.NewBlockTemplateWithPayouts(payouts, nil)
+	if err != nil {
+		log.Errorf("Failed to create new block template: %v", err)
+		return lastUpdated
+	}
+
+	work := newWork(template)
+	work.Clean = latest == nil || work.TipsHash != latest.TipsHash
+	if !work.Clean && !materialChange(latest, work, n.cfg.MinFeeIncrease) {
+		return sourceUpdated
+	}
+	n.publish(work)
+	return sourceUpdated
+}
+
+// materialChange returns whether the passed work for the same tips as the
+// passed latest work differs enough from it to be published.  Work following
+// the one for an empty block is published as soon as it has transactions.
+func materialChange(latest, work *Work, minFeeIncrease soterutil.Amount) bool {
+	if work.Digest == latest.Digest {
+		return false
+	}
+	return latest.Empty || work.Fees-latest.Fees >= minFeeIncrease
+}
+
+// publish assigns the next sequence number to the passed work and delivers it
+// to all of the subscribers.
+//
+// This function is safe for concurrent access.
+func (n *Notifier) publish(work *Work) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	n.seq++
+	work.Seq = n.seq
+	work.Created = time.Now()
+	n.latest = work
+	for s := range n.subscriptions {
+		s.deliver(work)
+	}
+
+	log.Debugf("Published work %d for tips %s (height %d, %d "+
+		"transactions, clean %v, empty %v)", work.Seq, work.TipsHash,
+		work.Height, work.NumTxns, work.Clean, work.Empty)
+}
+
+// newWork returns the work describing the passed block template.  Its sequence
+// number, creation time and whether it's clean are left for the caller to
+// set.
+func newWork(template *miningdag.BlockTemplate) *Work {
+	header := &template.Block.Header
+
+	// The digest commits to the parents, the target and the transactions
+	// of the template other than the coinbase, which only differs by the
+	// address it pays to.
+	preimage := make([]byte, 0, chainhash.HashSize*len(template.Block.Transactions)+4)
+	preimage = append(preimage, header.PrevBlock[:]...)
+	var bits [4]byte
+	binary.LittleEndian.PutUint32(bits[:], header.Bits)
+	preimage = append(preimage, bits[:]...)
+	for _, tx := range template.Block.Transactions[1:] {
+		txHash := tx.TxHash()
+		preimage = append(preimage, txHash[:]...)
+	}
+
+	var fees int64
+	if len(template.Fees) > 0 {
+		// The fee of the coinbase is the negative of the total fees.
+		fees = -template.Fees[0]
+	}
+
+	return &Work{
+		Digest:   chainhash.DoubleHashH(preimage),
+		Height:   template.Height,
+		Parents:  append([]chainhash.Hash(nil), template.ParentHashes...),
+		TipsHash: header.PrevBlock,
+		Bits:     header.Bits,
+		Target:   blockdag.CompactToBig(header.Bits),
+		NumTxns:  len(template.Block.Transactions),
+		Fees:     soterutil.Amount(fees),
+		Empty:    template.Empty,
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package worknotify
+
+import (
+	"testing"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/miningdag"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// newTestTemplate returns a block template for the passed tips hash whose
+// coinbase pays to the passed script, followed by the transactions spending
+// the passed outputs, each of them paying a fee of 1000.
+func newTestTemplate(tipsHash chainhash.Hash, payScript []byte, spent ...byte) *miningdag.BlockTemplate {
+	var msgBlock wire.MsgBlock
+	msgBlock.Header.PrevBlock = tipsHash
+	msgBlock.Header.Bits = 0x1d00ffff
+
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{},
+			wire.MaxPrevOutIndex),
+		SignatureScript: []byte{0x51, 0x51},
+		Sequence:        wire.MaxTxInSequenceNum,
+	})
+	coinbase.AddTxOut(wire.NewTxOut(5000, payScript))
+	msgBlock.AddTransaction(coinbase)
+
+	fees := []int64{0}
+	for _, b := range spent {
+		tx := wire.NewMsgTx(wire.TxVersion)
+		prevOut := wire.NewOutPoint(&chainhash.Hash{b}, 0)
+		tx.AddTxIn(wire.NewTxIn(prevOut, nil, nil))
+		tx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+		msgBlock.AddTransaction(tx)
+		fees = append(fees, 1000)
+		fees[0] -= 1000
+	}
+
+	return &miningdag.BlockTemplate{
+		Block:        &msgBlock,
+		Fees:         fees,
+		Height:       10,
+		ParentHashes: []chainhash.Hash{{0xaa}, {0xbb}},
+	}
+}
+
+// TestNewWork ensures the work describing a template identifies its content
+// regardless of the address the coinbase pays to.
+func TestNewWork(t *testing.T) {
+	tips := chainhash.Hash{1}
+	work := newWork(newTestTemplate(tips, []byte{0x51}, 1, 2))
+	if work.TipsHash != tips || work.Height != 10 || len(work.Parents) != 2 ||
+		work.NumTxns != 3 || work.Fees != 2000 || work.Bits != 0x1d00ffff ||
+		work.Target.Sign() <= 0 {
+
+		t.Fatalf("unexpected work %+v", work)
+	}
+
+	other := newWork(newTestTemplate(tips, []byte{0x52}, 1, 2))
+	if other.Digest != work.Digest {
+		t.Fatal("digest depends on the coinbase")
+	}
+	if other := newWork(newTestTemplate(tips, []byte{0x51}, 1)); other.Digest == work.Digest {
+		t.Fatal("digest doesn't depend on the transactions")
+	}
+	if other := newWork(newTestTemplate(chainhash.Hash{2}, []byte{0x51}, 1, 2)); other.Digest == work.Digest {
+		t.Fatal("digest doesn't depend on the tips")
+	}
+
+	// Work for the same tips is only published when the template changes
+	// and the fees increase enough.
+	more := newWork(newTestTemplate(tips, []byte{0x51}, 1, 2, 3))
+	if materialChange(work, other, 0) {
+		t.Fatal("identical template considered a material change")
+	}
+	if !materialChange(work, more, 1000) {
+		t.Fatal("fee increase of 1000 not considered a material change")
+	}
+	if materialChange(work, more, 1001) {
+		t.Fatal("fee increase below the minimum considered a material " +
+			"change")
+	}
+
+	// The full template following the one for an empty block is always
+	// published, as long as it has transactions.
+	emptyTemplate := newTestTemplate(tips, []byte{0x51})
+	emptyTemplate.Empty = true
+	empty := newWork(emptyTemplate)
+	if !empty.Empty {
+		t.Fatal("work for an empty template not flagged as empty")
+	}
+	if !materialChange(empty, work, 1000000) {
+		t.Fatal("full template after an empty one not considered a " +
+			"material change")
+	}
+	if materialChange(empty, newWork(newTestTemplate(tips, []byte{0x51})), 0) {
+		t.Fatal("template without transactions after an empty one " +
+			"considered a material change")
+	}
+}
+
+// TestSubscriptions ensures the work is delivered to the subscribers with
+// increasing sequence numbers, and that slow subscribers get the latest work.
+func TestSubscriptions(t *testing.T) {
+	n := New(&Config{})
+	tips := chainhash.Hash{1}
+
+	s := n.Subscribe(2)
+	select {
+	case work := <-s.Work():
+		t.Fatalf("work %d delivered before any was published", work.Seq)
+	default:
+	}
+
+	for i := 0; i < 5; i++ {
+		n.publish(newWork(newTestTemplate(tips, []byte{0x51}, byte(i))))
+	}
+
+	// Only the two latest pieces of work are buffered.
+	for _, want := range []uint64{4, 5} {
+		work := <-s.Work()
+		if work.Seq != want {
+			t.Fatalf("got work %d, want %d", work.Seq, want)
+		}
+	}
+
+	// New subscribers get the latest work right away.
+	late := n.Subscribe(0)
+	if work := <-late.Work(); work.Seq != 5 {
+		t.Fatalf("late subscriber got work %d, want 5", work.Seq)
+	}
+
+	s.Close()
+	if _, ok := <-s.Work(); ok {
+		t.Fatal("work delivered after the subscription was closed")
+	}
+	n.publish(newWork(newTestTemplate(tips, []byte{0x51}, 9)))
+
+	n.Stop()
+	if work, ok := <-late.Work(); !ok || work.Seq != 6 {
+		t.Fatal("buffered work not delivered before closing")
+	}
+	if _, ok := <-late.Work(); ok {
+		t.Fatal("subscription not closed on shutdown")
+	}
+	if _, ok := <-n.Subscribe(0).Work(); ok {
+		t.Fatal("subscription after shutdown not closed")
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miningdag
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/txscript"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// CoinbaseCommitment describes an auxiliary commitment added to the coinbase of
+// the generated blocks, such as the hash of a block filter or a commitment to
+// the set of unspent outputs.  Each commitment is added as a zero-valued
+// OP_RETURN output pushing the committed data, after the payment and witness
+// commitment outputs, in the order of the CoinbaseCommitments of the policy.
+type CoinbaseCommitment interface {
+	// Name identifies the commitment in log messages.
+	Name() string
+
+	// Size returns the size in bytes of the data committed to.  The room
+	// needed for the commitment is reserved in the block before its
+	// transactions are selected, so Commit MUST return data of this size.
+	Size() int
+
+	// Commit returns the data to commit to for a block at the passed
+	// height with the passed transactions, which exclude the coinbase.
+	Commit(height int32, txns []*soterutil.Tx) ([]byte, error)
+}
+
+// coinbaseTag returns the tag added to the coinbase script of the generated
+// blocks.
+func (g *BlkTmplGenerator) coinbaseTag() []byte {
+	if len(g.policy.CoinbaseTag) == 0 {
+		return []byte(CoinbaseFlags)
+	}
+	return g.policy.CoinbaseTag
+}
+
+// CoinbaseScript returns the coinbase script of a generated block at the
+// passed height with the passed raw extra nonce.  The script pushes the block
+// height required by version 2 blocks, the extra nonce as data, and the
+// coinbase tag of the policy.  It allows miners which manage the extra nonce
+// space on their own, such as Stratum miners, to build the same scripts as the
+// generator.
+func (g *BlkTmplGenerator) CoinbaseScript(height int32, extraNonce []byte) ([]byte, error) {
+	return checkCoinbaseScript(txscript.NewScriptBuilder().
+		AddInt64(int64(height)).AddData(extraNonce).
+		AddData(g.coinbaseTag()).Script())
+}
+
+// checkCoinbaseScript returns the passed coinbase script, or an error if
+// building it failed or it's longer than allowed by the consensus rules.
+func checkCoinbaseScript(script []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	if len(script) > blockdag.MaxCoinbaseScriptLen {
+		return nil, fmt.Errorf("coinbase transaction script length "+
+			"of %d is out of range (min: %d, max: %d)",
+			len(script), blockdag.MinCoinbaseScriptLen,
+			blockdag.MaxCoinbaseScriptLen)
+	}
+	return script, nil
+}
+
+// extraNonceScript returns the coinbase script of a generated block at the
+// passed height with the passed extra nonce.  The extra nonce is pushed as a
+// number unless the policy reserves ExtraNonceSize bytes for it, in which case
+// it's pushed as that many little-endian bytes, so the extra nonce is always
+// found at the same offset in the script.
+func (g *BlkTmplGenerator) extraNonceScript(height int32, extraNonce uint64) ([]byte, error) {
+	size := g.policy.ExtraNonceSize
+	if size == 0 {
+		return checkCoinbaseScript(txscript.NewScriptBuilder().
+			AddInt64(int64(height)).AddInt64(int64(extraNonce)).
+			AddData(g.coinbaseTag()).Script())
+	}
+
+	if size < 8 && extraNonce>>(8*uint(size)) != 0 {
+		return nil, fmt.Errorf("extra nonce %d doesn't fit in the %d "+
+			"bytes reserved for it", extraNonce, size)
+	}
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], extraNonce)
+	raw := make([]byte, size)
+	copy(raw, buf[:])
+	return g.CoinbaseScript(height, raw)
+}
+
+// commitmentScript returns the script of the coinbase output committing to
+// the passed data.
+func commitmentScript(data []byte) ([]byte, error) {
+	return txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN).
+		AddData(data).Script()
+}
+
+// commitmentsWeight returns the weight of the coinbase outputs of the
+// auxiliary commitments of the policy.
+func (g *BlkTmplGenerator) commitmentsWeight() (uint32, error) {
+	var weight uint32
+	for _, commitment := range g.policy.CoinbaseCommitments {
+		script, err := commitmentScript(make([]byte, commitment.Size()))
+		if err != nil {
+			return 0, err
+		}
+		txOut := wire.TxOut{PkScript: script}
+		weight += uint32(txOut.SerializeSize()) *
+			blockdag.WitnessScaleFactor
+	}
+	return weight, nil
+}
+
+// addCommitments adds the outputs of the auxiliary commitments of the policy
+// to the passed coinbase of a block at the passed height with the passed
+// transactions, which exclude the coinbase.
+func (g *BlkTmplGenerator) addCommitments(coinbase *wire.MsgTx, height int32, txns []*soterutil.Tx) error {
+	for _, commitment := range g.policy.CoinbaseCommitments {
+		data, err := commitment.Commit(height, txns)
+		if err != nil {
+			return fmt.Errorf("unable to create %s commitment: %v",
+				commitment.Name(), err)
+		}
+		if len(data) != commitment.Size() {
+			return fmt.Errorf("%s commitment is %d bytes instead of "+
+				"the %d reserved", commitment.Name(), len(data),
+				commitment.Size())
+		}
+
+		script, err := commitmentScript(data)
+		if err != nil {
+			return err
+		}
+		coinbase.AddTxOut(&wire.TxOut{PkScript: script})
+		log.Tracef("Added %s commitment %x", commitment.Name(), data)
+	}
+	return nil
+}
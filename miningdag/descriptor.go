@@ -0,0 +1,154 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miningdag
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/soteria-dag/soterd/chaincfg"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/txscript"
+)
+
+const (
+	// descriptorInputCharset is the character set of output descriptors,
+	// ordered as the checksum algorithm requires.
+	descriptorInputCharset = "0123456789()[],'/*abcdefgh@:$%{}" +
+		"IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~" +
+		"ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+
+	// descriptorChecksumCharset is the character set of the checksums of
+	// output descriptors.
+	descriptorChecksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+	// descriptorChecksumLen is the length of the checksums of output
+	// descriptors.
+	descriptorChecksumLen = 8
+)
+
+// descriptorPolymod computes the checksum polynomial of the passed symbols of
+// an output descriptor.
+func descriptorPolymod(symbols []uint64) uint64 {
+	generator := [5]uint64{0xf5dee51989, 0xa9fdca3312, 0x1bab10e32d,
+		0x3706b1677a, 0x644d626ffd}
+
+	chk := uint64(1)
+	for _, value := range symbols {
+		top := chk >> 35
+		chk = (chk&0x7ffffffff)<<5 ^ value
+		for i := uint(0); i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// DescriptorChecksum returns the checksum of the passed output descriptor,
+// which must not include one already.
+func DescriptorChecksum(desc string) (string, error) {
+	var symbols, groups []uint64
+	for i := 0; i < len(desc); i++ {
+		pos := strings.IndexByte(descriptorInputCharset, desc[i])
+		if pos < 0 {
+			return "", fmt.Errorf("invalid character %q in descriptor",
+				desc[i])
+		}
+		symbols = append(symbols, uint64(pos&31))
+		groups = append(groups, uint64(pos>>5))
+		if len(groups) == 3 {
+			symbols = append(symbols, groups[0]*9+groups[1]*3+groups[2])
+			groups = groups[:0]
+		}
+	}
+	switch len(groups) {
+	case 1:
+		symbols = append(symbols, groups[0])
+	case 2:
+		symbols = append(symbols, groups[0]*3+groups[1])
+	}
+
+	symbols = append(symbols, make([]uint64, descriptorChecksumLen)...)
+	chk := descriptorPolymod(symbols) ^ 1
+	checksum := make([]byte, descriptorChecksumLen)
+	for i := range checksum {
+		shift := 5 * uint(descriptorChecksumLen-1-i)
+		checksum[i] = descriptorChecksumCharset[(chk>>shift)&31]
+	}
+	return string(checksum), nil
+}
+
+// DescriptorScript returns the output script described by the passed output
+// descriptor for the passed network, so blocks can be mined to it.  The
+// following descriptors are supported, optionally followed by a '#' and their
+// checksum, which is then verified:
+//
+//	addr(ADDRESS)  pays to the address
+//	raw(HEX)       pays to the hex-encoded script
+//	pk(KEY)        pays to the hex-encoded public key
+//	pkh(KEY)       pays to the hash of the hex-encoded public key
+func DescriptorScript(desc string, params *chaincfg.Params) ([]byte, error) {
+	if i := strings.LastIndexByte(desc, '#'); i >= 0 {
+		want, err := DescriptorChecksum(desc[:i])
+		if err != nil {
+			return nil, err
+		}
+		if desc[i+1:] != want {
+			return nil, fmt.Errorf("descriptor checksum %q doesn't "+
+				"match the expected %q", desc[i+1:], want)
+		}
+		desc = desc[:i]
+	}
+
+	open := strings.IndexByte(desc, '(')
+	if open < 0 || !strings.HasSuffix(desc, ")") {
+		return nil, fmt.Errorf("malformed descriptor %q", desc)
+	}
+	fn, arg := desc[:open], desc[open+1:len(desc)-1]
+
+	switch fn {
+	case "addr":
+		addr, err := soterutil.DecodeAddress(arg, params)
+		if err != nil {
+			return nil, err
+		}
+		if !addr.IsForNet(params) {
+			return nil, fmt.Errorf("address %s is not for %s", arg,
+				params.Name)
+		}
+		return txscript.PayToAddrScript(addr)
+
+	case "raw":
+		script, err := hex.DecodeString(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid script %q: %v", arg, err)
+		}
+		if len(script) == 0 {
+			return nil, errors.New("empty script")
+		}
+		return script, nil
+
+	case "pk", "pkh":
+		pubKey, err := hex.DecodeString(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %q: %v", arg,
+				err)
+		}
+		addr, err := soterutil.NewAddressPubKey(pubKey, params)
+		if err != nil {
+			return nil, err
+		}
+		if fn == "pkh" {
+			return txscript.PayToAddrScript(addr.AddressPubKeyHash())
+		}
+		return txscript.PayToAddrScript(addr)
+	}
+
+	return nil, fmt.Errorf("unsupported descriptor %s()", fn)
+}
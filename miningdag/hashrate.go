@@ -0,0 +1,123 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miningdag
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// DefaultHashRateGenerations is the default number of generations of blocks
+// the network hash rate is estimated over.
+const DefaultHashRateGenerations = 120
+
+// HashRateEstimate describes an estimate of the hash rate of the network.
+type HashRateEstimate struct {
+	// HashesPerSec is the estimated number of proof attempts per second
+	// performed by the network, which is the number of cuckoo cycle proofs
+	// checked against the target difficulty.  It isn't in the unit the CPU
+	// miner reports, which counts two hashes for every nonce it tries,
+	// including the nonces for which no cycle is found.
+	HashesPerSec float64
+
+	// BlocksPerSec is the number of blocks per second the network produced
+	// over the sampled generations, counting all of the blocks of each
+	// generation rather than one per height.
+	BlocksPerSec float64
+
+	// Blocks is the number of blocks sampled, and Span the time between
+	// the oldest and newest of them.
+	Blocks int
+	Span   time.Duration
+}
+
+// EstimateNetworkHashRate estimates the hash rate of the network from the
+// blocks of the passed number of most recent generations.  All of the blocks
+// of each generation are sampled, since blocks mined in parallel on different
+// tips account for work as well.  DefaultHashRateGenerations are sampled when
+// the passed number isn't positive.
+//
+// This function is safe for concurrent access.
+func (g *BlkTmplGenerator) EstimateNetworkHashRate(generations int32) (*HashRateEstimate, error) {
+	if generations <= 0 {
+		generations = DefaultHashRateGenerations
+	}
+
+	// The genesis block is left out, since its timestamp isn't the time
+	// it was mined at.
+	maxHeight := g.chain.DAGSnapshot().MaxHeight
+	lowHeight := maxHeight - generations + 1
+	if lowHeight < 1 {
+		lowHeight = 1
+	}
+
+	var headers []wire.BlockHeader
+	for height := lowHeight; height <= maxHeight; height++ {
+		hashes, err := g.chain.BlockHashesByHeight(height)
+		if err != nil {
+			return nil, err
+		}
+		for i := range hashes {
+			header, err := g.chain.HeaderByHash(&hashes[i])
+			if err != nil {
+				return nil, err
+			}
+			headers = append(headers, header)
+		}
+	}
+
+	return estimateHashRate(headers), nil
+}
+
+// estimateHashRate returns the hash rate estimated from the passed sampled
+// block headers.
+//
+// A block is solved once the difficulty of its proof reaches the difficulty of
+// its bits, and the difficulty of a proof exceeds a difficulty of D with a
+// probability of 1/D, so a block at difficulty D takes D proof attempts on
+// average.  The estimate is the number of proof attempts per second rather
+// than the number of nonces tried, since nonces without a cycle yield no proof
+// to check.  The work of the oldest block is left out, since it was performed
+// before the sampled time span.
+func estimateHashRate(headers []wire.BlockHeader) *HashRateEstimate {
+	estimate := &HashRateEstimate{Blocks: len(headers)}
+	if len(headers) < 2 {
+		return estimate
+	}
+
+	oldest, newest := 0, 0
+	for i := range headers {
+		if headers[i].Timestamp.Before(headers[oldest].Timestamp) {
+			oldest = i
+		}
+		if headers[i].Timestamp.After(headers[newest].Timestamp) {
+			newest = i
+		}
+	}
+	span := headers[newest].Timestamp.Sub(headers[oldest].Timestamp)
+	if span <= 0 {
+		return estimate
+	}
+	estimate.Span = span
+
+	work := new(big.Int)
+	for i := range headers {
+		if i == oldest {
+			continue
+		}
+		difficulty := blockdag.CompactToBig(headers[i].Bits)
+		if difficulty.Sign() > 0 {
+			work.Add(work, difficulty)
+		}
+	}
+
+	hashes, _ := new(big.Float).SetInt(work).Float64()
+	estimate.HashesPerSec = hashes / span.Seconds()
+	estimate.BlocksPerSec = float64(len(headers)-1) / span.Seconds()
+	return estimate
+}
@@ -6,11 +6,25 @@
 package miningdag
 
 import (
+	"bytes"
 	"container/heap"
+	"errors"
+	"io/ioutil"
+	"math/big"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/chaincfg"
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/database"
+	_ "github.com/soteria-dag/soterd/database/ffldb"
 	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/txscript"
+	"github.com/soteria-dag/soterd/wire"
 )
 
 // TestTxFeePrioHeap ensures the priority queue for transaction fees and
@@ -109,3 +123,608 @@ func TestTxFeePrioHeap(t *testing.T) {
 		highest = prioItem
 	}
 }
+
+// TestSortedParentHashes ensures the parents of a block template are ordered
+// the same way the DAG commits to its tips, without modifying the passed tips.
+func TestSortedParentHashes(t *testing.T) {
+	tips := []chainhash.Hash{{0x03}, {0x01}, {0x02}}
+	parents := sortedParentHashes(tips)
+
+	if len(parents) != len(tips) {
+		t.Fatalf("got %d parents, want %d", len(parents), len(tips))
+	}
+	for i := 1; i < len(parents); i++ {
+		if parents[i-1].String() >= parents[i].String() {
+			t.Fatalf("parent %d (%v) is not sorted after %v", i,
+				parents[i], parents[i-1])
+		}
+	}
+	if tips[0] != (chainhash.Hash{0x03}) {
+		t.Fatalf("passed tips were modified: %v", tips)
+	}
+
+	// The tips hash of the sorted parents must be the tips hash of the
+	// original tips, since that's what PrevBlock commits to.
+	tipPtrs := make([]*chainhash.Hash, len(tips))
+	parentPtrs := make([]*chainhash.Hash, len(parents))
+	for i := range tips {
+		tip := tips[i]
+		tipPtrs[i] = &tip
+		parentPtrs[i] = &parents[i]
+	}
+	want := blockdag.GenerateTipsHash(tipPtrs)
+	got := blockdag.GenerateTipsHash(parentPtrs)
+	if *got != *want {
+		t.Fatalf("tips hash of parents %v, want %v", got, want)
+	}
+}
+
+// TestFindConflict ensures transactions spending an output already spent by a
+// transaction selected for a block template are detected.
+func TestFindConflict(t *testing.T) {
+	spentOutPoint := wire.OutPoint{Hash: chainhash.Hash{0x01}, Index: 0}
+	spender := &chainhash.Hash{0xaa}
+	spent := map[wire.OutPoint]*chainhash.Hash{spentOutPoint: spender}
+
+	newTx := func(outPoints ...wire.OutPoint) *soterutil.Tx {
+		tx := wire.NewMsgTx(wire.TxVersion)
+		for _, op := range outPoints {
+			tx.AddTxIn(wire.NewTxIn(&op, nil, nil))
+		}
+		return soterutil.NewTx(tx)
+	}
+
+	other := wire.OutPoint{Hash: chainhash.Hash{0x01}, Index: 1}
+	if op, _ := findConflict(newTx(other), spent); op != nil {
+		t.Fatalf("unexpected conflict on %v", op)
+	}
+
+	op, by := findConflict(newTx(other, spentOutPoint), spent)
+	if op == nil || *op != spentOutPoint {
+		t.Fatalf("conflict on %v, want %v", op, spentOutPoint)
+	}
+	if by != spender {
+		t.Fatalf("conflicting spender %v, want %v", by, spender)
+	}
+}
+
+// TestTemplateCache ensures the cached template is only reused for the same
+// tips, transaction source generation and payment address, and that the copies
+// handed out don't share the parts modified while solving a template.
+func TestTemplateCache(t *testing.T) {
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(&wire.TxIn{SignatureScript: []byte{0x51}})
+	template := &BlockTemplate{
+		Block: &wire.MsgBlock{Transactions: []*wire.MsgTx{coinbase}},
+		Fees:  []int64{-10},
+	}
+
+	cache := newTemplateCache()
+	tips := chainhash.Hash{0x01}
+	generation := uint64(7)
+	cache.storeTemplate(&tips, generation, nil, template)
+
+	cached := cache.lookupTemplate(&tips, generation, nil)
+	if cached == nil {
+		t.Fatal("cached template not found")
+	}
+	cached.Block.Header.Nonce = 1
+	cached.Block.Transactions[0].TxIn[0].SignatureScript = []byte{0x52}
+	cached.Fees[0] = 0
+	if template.Block.Header.Nonce != 0 ||
+		template.Block.Transactions[0].TxIn[0].SignatureScript[0] != 0x51 ||
+		template.Fees[0] != -10 {
+
+		t.Fatal("modifying the cached template modified the original")
+	}
+	again := cache.lookupTemplate(&tips, generation, nil)
+	if again == nil || again.Block.Header.Nonce != 0 {
+		t.Fatal("modifying a copy of the cached template modified the " +
+			"cache")
+	}
+
+	if cache.lookupTemplate(&tips, generation+1, nil) != nil {
+		t.Fatal("template reused after the transaction source changed")
+	}
+	otherTips := chainhash.Hash{0x02}
+	if cache.lookupTemplate(&otherTips, generation, nil) != nil {
+		t.Fatal("template reused after the tips changed")
+	}
+	if cache.lookupTemplate(&tips, generation, nil) != nil {
+		t.Fatal("template not invalidated when the tips changed")
+	}
+}
+
+// TestStrategies ensures the transaction selection strategies order and
+// decide on candidates as documented.
+func TestStrategies(t *testing.T) {
+	policy := &Policy{BlockPrioritySize: 1000, BlockMaxWeight: 100000}
+
+	if _, err := NewStrategy("unknown", policy); err == nil {
+		t.Fatal("NewStrategy: unknown strategy accepted")
+	}
+	strategy, err := NewStrategy("", policy)
+	if err != nil {
+		t.Fatalf("NewStrategy: unexpected error: %v", err)
+	}
+	if _, ok := strategy.(*priorityStrategy); !ok {
+		t.Fatalf("NewStrategy: default strategy is %T", strategy)
+	}
+
+	parent := &Candidate{FeePerKB: 100, PackageFeePerKB: 5000, Weight: 800}
+	child := &Candidate{FeePerKB: 1000, Weight: 400}
+	small := &Candidate{FeePerKB: 5000, Weight: 400}
+
+	feeRate, _ := NewStrategy(StrategyFeeRate, policy)
+	if !feeRate.Less(child, parent) {
+		t.Fatal("feerate: the parent is not ordered by its own fee rate")
+	}
+	pkg, _ := NewStrategy(StrategyPackage, policy)
+	if !pkg.Less(parent, child) {
+		t.Fatal("package: the parent is not ordered by its package fee rate")
+	}
+	if !pkg.Less(small, parent) {
+		t.Fatal("package: the smaller candidate is not preferred for the " +
+			"same fee rate")
+	}
+
+	// The priority strategy switches to sorting by fee rate once the
+	// high-priority area is filled.
+	highPrio := &Candidate{Priority: MinHighPriority * 2, Weight: 400}
+	lowPrio := &Candidate{Priority: MinHighPriority / 2, FeePerKB: 2000,
+		Weight: 400}
+	state := &SelectionState{BlockWeight: 100, Policy: policy}
+	if !strategy.Less(highPrio, lowPrio) {
+		t.Fatal("priority: not sorted by priority first")
+	}
+	decision, reorder := strategy.Consider(highPrio, state)
+	if decision != Include || reorder {
+		t.Fatalf("priority: got (%v, %v) for a high-priority candidate, "+
+			"want (Include, false)", decision, reorder)
+	}
+	decision, reorder = strategy.Consider(lowPrio, state)
+	if decision != Requeue || !reorder {
+		t.Fatalf("priority: got (%v, %v) for a low-priority candidate, "+
+			"want (Requeue, true)", decision, reorder)
+	}
+	if !strategy.Less(lowPrio, highPrio) {
+		t.Fatal("priority: not sorted by fee rate after switching")
+	}
+}
+
+// testCommitment is a coinbase commitment to the number of transactions of a
+// block.
+type testCommitment struct {
+	size int
+}
+
+func (c testCommitment) Name() string { return "test" }
+func (c testCommitment) Size() int    { return c.size }
+func (c testCommitment) Commit(height int32, txns []*soterutil.Tx) ([]byte, error) {
+	data := make([]byte, 4)
+	data[0] = byte(len(txns))
+	return data, nil
+}
+
+// TestCoinbaseScript ensures the coinbase scripts of generated blocks carry the
+// configured tag and reserve the configured room for the extra nonce, and that
+// auxiliary commitments are added as OP_RETURN outputs of the reserved size.
+func TestCoinbaseScript(t *testing.T) {
+	// The default tag is CoinbaseFlags and the extra nonce is pushed as a
+	// number.
+	g := NewBlkTmplGenerator(&Policy{}, nil, nil, nil, nil, nil, nil)
+	script, err := g.extraNonceScript(100, 1)
+	if err != nil {
+		t.Fatalf("extraNonceScript: unexpected error: %v", err)
+	}
+	want := []byte{0x01, 100, 0x51, byte(len(CoinbaseFlags))}
+	want = append(want, CoinbaseFlags...)
+	if !bytes.Equal(script, want) {
+		t.Fatalf("extraNonceScript: got %x, want %x", script, want)
+	}
+
+	// With room reserved for the extra nonce, it's pushed as that many
+	// little-endian bytes after the configured tag.
+	tag := bytes.Repeat([]byte{'x'}, 80)
+	g = NewBlkTmplGenerator(&Policy{CoinbaseTag: tag, ExtraNonceSize: 4},
+		nil, nil, nil, nil, nil, nil)
+	script, err = g.extraNonceScript(100, 0x010203)
+	if err != nil {
+		t.Fatalf("extraNonceScript: unexpected error: %v", err)
+	}
+	want = []byte{0x01, 100, 0x04, 0x03, 0x02, 0x01, 0x00, 0x4c, 80}
+	want = append(want, tag...)
+	if !bytes.Equal(script, want) {
+		t.Fatalf("extraNonceScript: got %x, want %x", script, want)
+	}
+	if _, err := g.extraNonceScript(100, 1<<32); err == nil {
+		t.Fatal("extraNonceScript: extra nonce larger than the reserved " +
+			"room accepted")
+	}
+
+	// Scripts longer than allowed by the consensus rules are refused.
+	g = NewBlkTmplGenerator(&Policy{
+		CoinbaseTag: make([]byte, blockdag.MaxCoinbaseScriptLen),
+	}, nil, nil, nil, nil, nil, nil)
+	if _, err := g.CoinbaseScript(100, nil); err == nil {
+		t.Fatal("CoinbaseScript: oversized script accepted")
+	}
+
+	// Commitments are added as OP_RETURN outputs whose weight is the one
+	// reserved for them.
+	g = NewBlkTmplGenerator(&Policy{
+		CoinbaseCommitments: []CoinbaseCommitment{testCommitment{4}},
+	}, nil, nil, nil, nil, nil, nil)
+	reserved, err := g.commitmentsWeight()
+	if err != nil {
+		t.Fatalf("commitmentsWeight: unexpected error: %v", err)
+	}
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	txns := []*soterutil.Tx{soterutil.NewTx(wire.NewMsgTx(wire.TxVersion))}
+	if err := g.addCommitments(coinbase, 100, txns); err != nil {
+		t.Fatalf("addCommitments: unexpected error: %v", err)
+	}
+	if len(coinbase.TxOut) != 1 {
+		t.Fatalf("addCommitments: got %d outputs, want 1",
+			len(coinbase.TxOut))
+	}
+	txOut := coinbase.TxOut[0]
+	want = []byte{0x6a, 0x04, 0x01, 0x00, 0x00, 0x00}
+	if txOut.Value != 0 || !bytes.Equal(txOut.PkScript, want) {
+		t.Fatalf("addCommitments: got output %d %x, want 0 %x",
+			txOut.Value, txOut.PkScript, want)
+	}
+	if weight := uint32(txOut.SerializeSize()) *
+		blockdag.WitnessScaleFactor; weight != reserved {
+		t.Fatalf("commitmentsWeight: got %d, want %d", reserved, weight)
+	}
+
+	// Commitments of another size than the reserved one are refused.
+	g = NewBlkTmplGenerator(&Policy{
+		CoinbaseCommitments: []CoinbaseCommitment{testCommitment{8}},
+	}, nil, nil, nil, nil, nil, nil)
+	if err := g.addCommitments(wire.NewMsgTx(wire.TxVersion), 100, txns); err == nil {
+		t.Fatal("addCommitments: commitment of the wrong size accepted")
+	}
+}
+
+// TestProposalRejectReason ensures the errors block proposals are rejected
+// with are reported with the reasons defined by BIP0022.
+func TestProposalRejectReason(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{errors.New("database failure"), "rejected"},
+		{blockdag.RuleError{ErrorCode: blockdag.ErrBadMerkleRoot},
+			"bad-txnmrklroot"},
+		{blockdag.RuleError{ErrorCode: blockdag.ErrPrevBlockNotBest},
+			"inconclusive-not-best-prevblk"},
+		{blockdag.RuleError{ErrorCode: blockdag.ErrorCode(1 << 16)},
+			"rejected: Unknown ErrorCode (65536)"},
+	}
+
+	for _, test := range tests {
+		if got := ProposalRejectReason(test.err); got != test.want {
+			t.Errorf("ProposalRejectReason(%v): got %q, want %q",
+				test.err, got, test.want)
+		}
+	}
+}
+
+// TestEstimateHashRate ensures the network hash rate is estimated from the
+// difficulty of all of the sampled blocks, including the parallel ones.
+func TestEstimateHashRate(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	bits := blockdag.BigToCompact(big.NewInt(1000))
+	header := func(offset time.Duration) wire.BlockHeader {
+		return wire.BlockHeader{Bits: bits, Timestamp: start.Add(offset)}
+	}
+
+	// Too few blocks to tell.
+	estimate := estimateHashRate([]wire.BlockHeader{header(0)})
+	if estimate.HashesPerSec != 0 || estimate.Blocks != 1 {
+		t.Fatalf("single block: got %+v", estimate)
+	}
+
+	// Two parallel blocks a generation over 10 seconds, the work of the
+	// oldest one excluded.
+	headers := []wire.BlockHeader{
+		header(5 * time.Second),
+		header(0),
+		header(5 * time.Second),
+		header(10 * time.Second),
+		header(10 * time.Second),
+	}
+	estimate = estimateHashRate(headers)
+	if estimate.Span != 10*time.Second || estimate.Blocks != 5 {
+		t.Fatalf("got span %v and %d blocks, want 10s and 5 blocks",
+			estimate.Span, estimate.Blocks)
+	}
+	if estimate.HashesPerSec != 400 {
+		t.Fatalf("got %v hashes/s, want 400", estimate.HashesPerSec)
+	}
+	if estimate.BlocksPerSec != 0.4 {
+		t.Fatalf("got %v blocks/s, want 0.4", estimate.BlocksPerSec)
+	}
+}
+
+// TestSimulateSelection ensures the fee revenue simulation selects the
+// transactions as a block template would, accounting for the dependencies
+// between them, conflicts and the maximum block weight.
+func TestSimulateSelection(t *testing.T) {
+	newDesc := func(prevOut wire.OutPoint, fee, feePerKB int64) *TxDesc {
+		tx := wire.NewMsgTx(wire.TxVersion)
+		tx.AddTxIn(wire.NewTxIn(&prevOut, nil, nil))
+		tx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+		return &TxDesc{Tx: soterutil.NewTx(tx), Fee: fee, FeePerKB: feePerKB}
+	}
+
+	parent := newDesc(wire.OutPoint{Hash: chainhash.Hash{0x01}}, 1000, 10000)
+	other := newDesc(wire.OutPoint{Hash: chainhash.Hash{0x02}}, 500, 5000)
+	conflict := newDesc(wire.OutPoint{Hash: chainhash.Hash{0x01}}, 300, 3000)
+	child := newDesc(wire.OutPoint{Hash: *parent.Tx.Hash()}, 2000, 20000)
+	descs := []*TxDesc{child, other, conflict, parent}
+	txWeight := uint32(blockdag.GetTransactionWeight(parent.Tx))
+
+	// The priority strategy falls back to the fee rate since none of the
+	// transactions have priority, so both behave the same.
+	const baseWeight = 1000
+	for _, name := range []string{StrategyPriority, StrategyFeeRate} {
+		policy := &Policy{
+			BlockPrioritySize: 50000,
+			BlockMaxWeight:    100000,
+			Strategy:          name,
+		}
+		strategy, _ := NewStrategy(name, policy)
+		projection := simulateSelection(policy, strategy, descs, baseWeight)
+		if projection.Fees != 3500 || projection.NumTxns != 3 ||
+			projection.MinFeePerKB != 5000 ||
+			projection.Weight != baseWeight+3*txWeight {
+
+			t.Fatalf("%s: got projection %+v", name, projection)
+		}
+	}
+
+	// Only the parent fits in a smaller block.
+	policy := &Policy{BlockMaxWeight: baseWeight + txWeight + 1}
+	strategy, _ := NewStrategy(StrategyFeeRate, policy)
+	projection := simulateSelection(policy, strategy, descs, baseWeight)
+	if projection.Fees != 1000 || projection.NumTxns != 1 ||
+		projection.MinFeePerKB != 10000 {
+
+		t.Fatalf("small block: got projection %+v", projection)
+	}
+
+	// Nothing fits in a block without room for transactions.
+	policy = &Policy{BlockMaxWeight: baseWeight}
+	strategy, _ = NewStrategy(StrategyFeeRate, policy)
+	projection = simulateSelection(policy, strategy, descs, baseWeight)
+	if projection.Fees != 0 || projection.NumTxns != 0 ||
+		projection.Weight != baseWeight {
+
+		t.Fatalf("full block: got projection %+v", projection)
+	}
+}
+
+// TestPayoutSchedule ensures the coinbase is rotated through and split across
+// the payout addresses in proportion to their weights.
+func TestPayoutSchedule(t *testing.T) {
+	newAddr := func(b byte) soterutil.Address {
+		addr, err := soterutil.NewAddressPubKeyHash(bytes.Repeat([]byte{b},
+			20), &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatalf("NewAddressPubKeyHash: unexpected error: %v", err)
+		}
+		return addr
+	}
+	a, b := newAddr(1), newAddr(2)
+	payouts := []Payout{{Address: a, Weight: 2}, {Address: b}}
+
+	if _, err := NewPayoutSchedule(PayoutRotate, nil); err == nil {
+		t.Fatal("NewPayoutSchedule: empty payouts accepted")
+	}
+	if _, err := NewPayoutSchedule(PayoutRotate, []Payout{{}}); err == nil {
+		t.Fatal("NewPayoutSchedule: payout without address accepted")
+	}
+	if _, err := NewPayoutSchedule(PayoutMode(5), payouts); err == nil {
+		t.Fatal("NewPayoutSchedule: invalid mode accepted")
+	}
+
+	// The first address is paid to twice as often, and never twice in a
+	// row with the second one waiting.
+	s, err := NewPayoutSchedule(PayoutRotate, payouts)
+	if err != nil {
+		t.Fatalf("NewPayoutSchedule: unexpected error: %v", err)
+	}
+	var got []string
+	for i := 0; i < 6; i++ {
+		next := s.Next()
+		if len(next) != 1 {
+			t.Fatalf("Next: got %d payouts in rotation mode", len(next))
+		}
+		got = append(got, next[0].Address.EncodeAddress())
+	}
+	want := []string{a.EncodeAddress(), b.EncodeAddress(), a.EncodeAddress()}
+	for i := range got {
+		if got[i] != want[i%3] {
+			t.Fatalf("Next: got rotation %v", got)
+		}
+	}
+
+	// Switching to split mode pays to all of the addresses.
+	if err := s.Set(PayoutSplit, payouts); err != nil {
+		t.Fatalf("Set: unexpected error: %v", err)
+	}
+	if next := s.Next(); len(next) != 2 || s.Mode() != PayoutSplit {
+		t.Fatalf("Next: got %d payouts in split mode", len(next))
+	}
+
+	// The shares add up to the value, the remainder going to the first
+	// payout.
+	shares := splitValue(100, payouts)
+	if len(shares) != 2 || shares[0] != 67 || shares[1] != 33 {
+		t.Fatalf("splitValue: got shares %v, want [67 33]", shares)
+	}
+	if shares := splitValue(100, nil); len(shares) != 1 || shares[0] != 100 {
+		t.Fatalf("splitValue: got shares %v without payouts", shares)
+	}
+
+	// The template cache tells the payouts apart by their weights.
+	if payoutsKey(payouts) == payoutsKey([]Payout{{Address: a},
+		{Address: b}}) {
+
+		t.Fatal("payoutsKey: weights not accounted for")
+	}
+}
+
+// TestDescriptorScript ensures the scripts described by output descriptors are
+// decoded, and that their checksums are verified.
+func TestDescriptorScript(t *testing.T) {
+	// Test vector of the reference implementation of the checksums.
+	checksum, err := DescriptorChecksum("raw(deadbeef)")
+	if err != nil || checksum != "89f8spxm" {
+		t.Fatalf("DescriptorChecksum: got (%q, %v), want 89f8spxm",
+			checksum, err)
+	}
+
+	params := &chaincfg.MainNetParams
+	script, err := DescriptorScript("raw(deadbeef)#89f8spxm", params)
+	if err != nil || !bytes.Equal(script, []byte{0xde, 0xad, 0xbe, 0xef}) {
+		t.Fatalf("DescriptorScript: got (%x, %v) for a raw script",
+			script, err)
+	}
+
+	addr, err := soterutil.NewAddressPubKeyHash(bytes.Repeat([]byte{1}, 20),
+		params)
+	if err != nil {
+		t.Fatalf("NewAddressPubKeyHash: unexpected error: %v", err)
+	}
+	want, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatalf("PayToAddrScript: unexpected error: %v", err)
+	}
+	script, err = DescriptorScript("addr("+addr.EncodeAddress()+")", params)
+	if err != nil || !bytes.Equal(script, want) {
+		t.Fatalf("DescriptorScript: got (%x, %v) for an address, want %x",
+			script, err, want)
+	}
+
+	for _, desc := range []string{
+		"raw(deadbeef)#89f8spxn",
+		"raw()",
+		"raw(zz)",
+		"raw(deadbeef",
+		"sh(raw(deadbeef))",
+		"pkh(0102)",
+	} {
+		if _, err := DescriptorScript(desc, params); err == nil {
+			t.Fatalf("DescriptorScript: %q accepted", desc)
+		}
+	}
+	if _, err := DescriptorScript("addr("+addr.EncodeAddress()+")",
+		&chaincfg.TestNet1Params); err == nil {
+
+		t.Fatal("DescriptorScript: address of another network accepted")
+	}
+}
+
+// fakeTxSource is a transaction source without transactions, which records
+// whether its transactions were requested.
+type fakeTxSource struct {
+	descsRequested bool
+}
+
+func (s *fakeTxSource) LastUpdated() time.Time { return time.Unix(1000, 0) }
+func (s *fakeTxSource) MiningDescs() []*TxDesc {
+	s.descsRequested = true
+	return nil
+}
+func (s *fakeTxSource) HaveTransaction(hash *chainhash.Hash) bool { return false }
+
+// TestNewBlockTemplateForParents ensures templates reference the requested
+// parents, and only select transactions when the parents are all of the tips
+// of the DAG.
+func TestNewBlockTemplateForParents(t *testing.T) {
+	dbPath, err := ioutil.TempDir("", "miningdag")
+	if err != nil {
+		t.Fatalf("unable to create test db dir: %v", err)
+	}
+	defer os.RemoveAll(dbPath)
+	db, err := database.Create("ffldb", filepath.Join(dbPath, "db"),
+		chaincfg.SimNetParams.Net)
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer db.Close()
+
+	params := chaincfg.SimNetParams
+	timeSource := blockdag.NewMedianTime()
+	chain, err := blockdag.New(&blockdag.Config{
+		DB:          db,
+		ChainParams: &params,
+		TimeSource:  timeSource,
+		SigCache:    txscript.NewSigCache(1000),
+	})
+	if err != nil {
+		t.Fatalf("failed to create chain instance: %v", err)
+	}
+
+	source := &fakeTxSource{}
+	policy := &Policy{BlockMaxWeight: 4000000, BlockMaxSize: 1000000}
+	g := NewBlkTmplGenerator(policy, &params, source, chain, timeSource,
+		nil, nil)
+
+	genesis := *params.GenesisHash
+	if _, err := g.NewBlockTemplateForParents(nil, nil); err == nil {
+		t.Fatal("template without parents accepted")
+	}
+	if _, err := g.NewBlockTemplateForParents(nil,
+		[]chainhash.Hash{genesis, genesis}); err == nil {
+		t.Fatal("template with a duplicate parent accepted")
+	}
+	unknown := chainhash.Hash{0x01}
+	if _, err := g.NewBlockTemplateForParents(nil,
+		[]chainhash.Hash{unknown}); err == nil {
+		t.Fatal("template with an unknown parent accepted")
+	}
+
+	// The genesis block is the only tip, so the template selects
+	// transactions.
+	template, err := g.NewBlockTemplateForParents(nil,
+		[]chainhash.Hash{genesis})
+	if err != nil {
+		t.Fatalf("NewBlockTemplateForParents: unexpected error: %v", err)
+	}
+	if !source.descsRequested || template.Empty {
+		t.Fatal("transactions not selected for parents which are the tips")
+	}
+	parents := template.Block.Parents.Parents
+	if len(parents) != 1 || parents[0].Hash != genesis {
+		t.Fatalf("template references parents %v, want %v", parents,
+			genesis)
+	}
+
+	block := soterutil.NewBlock(template.Block)
+	if _, _, err := chain.ProcessBlock(block, blockdag.BFNoPoWCheck); err != nil {
+		t.Fatalf("ProcessBlock: unexpected error: %v", err)
+	}
+
+	// Once the genesis block is no longer a tip, the transactions aren't
+	// selected since their inputs are looked up in the view of the tips.
+	source.descsRequested = false
+	template, err = g.NewBlockTemplateForParents(nil,
+		[]chainhash.Hash{genesis})
+	if err != nil {
+		t.Fatalf("NewBlockTemplateForParents: unexpected error: %v", err)
+	}
+	if source.descsRequested || !template.Empty ||
+		len(template.Block.Transactions) != 1 {
+
+		t.Fatal("transactions selected for parents which are not the tips")
+	}
+	if template.Block.Header.PrevBlock == chain.DAGSnapshot().Hash {
+		t.Fatal("template of a sibling block commits to the tips")
+	}
+}
@@ -8,10 +8,13 @@ package miningdag
 import (
 	"bytes"
 	"container/heap"
+	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/blockdag/pow"
 	"github.com/soteria-dag/soterd/chaincfg"
 	"github.com/soteria-dag/soterd/chaincfg/chainhash"
 	"github.com/soteria-dag/soterd/soterutil"
@@ -52,6 +55,13 @@ type TxDesc struct {
 
 	// FeePerKB is the fee the transaction pays in nanoSoter per 1000 bytes.
 	FeePerKB int64
+
+	// PackageFeePerKB is the fee rate in nanoSoter per 1000 bytes of the
+	// package of unconfirmed transactions the entry is best mined with.  It
+	// allows a transaction paying a low fee to be picked up along with a
+	// descendant paying enough for both of them (child-pays-for-parent).
+	// It is only set by sources implementing PackageTxSource.
+	PackageFeePerKB int64
 }
 
 // TxSource represents a source of transactions to consider for inclusion in
@@ -73,6 +83,31 @@ type TxSource interface {
 	HaveTransaction(hash *chainhash.Hash) bool
 }
 
+// PackageTxSource represents a source of transactions which tracks the
+// packages of unconfirmed transactions in the source pool.  The block template
+// generator uses the package fee rates instead of the individual fee rates of
+// the transactions when the source implements it.
+type PackageTxSource interface {
+	TxSource
+
+	// PackageMiningDescs returns a slice of mining descriptors for all
+	// the transactions in the source pool with the package fee rates set.
+	PackageMiningDescs() []*TxDesc
+}
+
+// GenerationTxSource represents a source of transactions which counts the
+// changes to the source pool.  The block template generator only reuses its
+// last template when the source implements it, since the last update time of
+// the source doesn't tell apart the changes made within the same second.
+type GenerationTxSource interface {
+	TxSource
+
+	// Generation returns a counter which increases whenever transactions
+	// are added to or removed from the source pool, or their descriptors
+	// change.
+	Generation() uint64
+}
+
 // txPrioItem houses a transaction along with extra information that allows the
 // transaction to be prioritized and track dependencies on other transactions
 // which have not been mined into a block yet.
@@ -87,6 +122,9 @@ type txPrioItem struct {
 	// transactions in the source pool and hence must come after them in
 	// a block.
 	dependsOn map[chainhash.Hash]struct{}
+
+	// candidate describes the transaction to the selection strategy.
+	candidate Candidate
 }
 
 // txPriorityQueueLessFunc describes a function that can be used as a compare
@@ -184,6 +222,21 @@ func newTxPriorityQueue(reserve int, sortByFee bool) *txPriorityQueue {
 	return pq
 }
 
+// newStrategyQueue returns a new transaction priority queue that reserves the
+// passed amount of space for the elements, and which is sorted by the order
+// the passed selection strategy considers the transactions in.  The queue
+// MUST be sorted again with heap.Init when that order changes.
+func newStrategyQueue(reserve int, strategy Strategy) *txPriorityQueue {
+	pq := &txPriorityQueue{
+		items: make([]*txPrioItem, 0, reserve),
+	}
+	pq.SetLessFunc(func(pq *txPriorityQueue, i, j int) bool {
+		return strategy.Less(&pq.items[i].candidate,
+			&pq.items[j].candidate)
+	})
+	return pq
+}
+
 // BlockTemplate houses a block that has yet to be solved along with additional
 // details about the fees and the number of signature operations for each
 // transaction in the block.
@@ -207,6 +260,13 @@ type BlockTemplate struct {
 	// chain.
 	Height int32
 
+	// ParentHashes are the hashes of the blocks the template references as
+	// parents, which are all of the current tips of the DAG in the order
+	// they're committed to by the PrevBlock field of the header.  External
+	// miners building their own block from the template must reference the
+	// same parents.
+	ParentHashes []chainhash.Hash
+
 	// ValidPayAddress indicates whether or not the template coinbase pays
 	// to an address or is redeemable by anyone.  See the documentation on
 	// NewBlockTemplate for details on which this can be useful to generate
@@ -218,6 +278,11 @@ type BlockTemplate struct {
 	// witness has been activated, and the block contains a transaction
 	// which has witness data.
 	WitnessCommitment []byte
+
+	// Empty indicates whether the template was generated without selecting
+	// any transactions by NewEmptyBlockTemplate, in which case a full
+	// template is to follow.
+	Empty bool
 }
 
 // mergeUtxoView adds all of the entries in viewB to viewA.  The result is that
@@ -235,40 +300,32 @@ func mergeUtxoView(viewA *blockdag.UtxoViewpoint, viewB *blockdag.UtxoViewpoint)
 	}
 }
 
-// standardCoinbaseScript returns a standard script suitable for use as the
-// signature script of the coinbase transaction of a new block.  In particular,
-// it starts with the block height that is required by version 2 blocks and adds
-// the extra nonce as well as additional coinbase flags.
-func standardCoinbaseScript(nextBlockHeight int32, extraNonce uint64) ([]byte, error) {
-	return txscript.NewScriptBuilder().AddInt64(int64(nextBlockHeight)).
-		AddInt64(int64(extraNonce)).AddData([]byte(CoinbaseFlags)).
-		Script()
-}
-
 // createCoinbaseTx returns a coinbase transaction paying an appropriate subsidy
-// based on the passed block height to the provided address.  When the address
-// is nil, the coinbase transaction will instead be redeemable by anyone.
+// based on the passed block height to the provided payouts, split in proportion
+// to their weights.  When there are no payouts, the coinbase transaction will
+// instead be redeemable by anyone.
 //
 // See the comment for NewBlockTemplate for more information about why the nil
 // address handling is useful.
-func createCoinbaseTx(params *chaincfg.Params, coinbaseScript []byte, nextBlockHeight int32, addr soterutil.Address) (*soterutil.Tx, error) {
-	// Create the script to pay to the provided payment address if one was
-	// specified.  Otherwise create a script that allows the coinbase to be
-	// redeemable by anyone.
-	var pkScript []byte
-	if addr != nil {
-		var err error
-		pkScript, err = txscript.PayToAddrScript(addr)
+func createCoinbaseTx(params *chaincfg.Params, coinbaseScript []byte, nextBlockHeight int32, payouts []Payout) (*soterutil.Tx, error) {
+	// Create the scripts to pay to the provided payment addresses if any
+	// were specified.  Otherwise create a script that allows the coinbase
+	// to be redeemable by anyone.
+	var pkScripts [][]byte
+	for i := range payouts {
+		pkScript, err := payouts[i].pkScript()
 		if err != nil {
 			return nil, err
 		}
-	} else {
-		var err error
+		pkScripts = append(pkScripts, pkScript)
+	}
+	if len(pkScripts) == 0 {
 		scriptBuilder := txscript.NewScriptBuilder()
-		pkScript, err = scriptBuilder.AddOp(txscript.OP_TRUE).Script()
+		pkScript, err := scriptBuilder.AddOp(txscript.OP_TRUE).Script()
 		if err != nil {
 			return nil, err
 		}
+		pkScripts = append(pkScripts, pkScript)
 	}
 
 	tx := wire.NewMsgTx(wire.TxVersion)
@@ -280,10 +337,13 @@ func createCoinbaseTx(params *chaincfg.Params, coinbaseScript []byte, nextBlockH
 		SignatureScript: coinbaseScript,
 		Sequence:        wire.MaxTxInSequenceNum,
 	})
-	tx.AddTxOut(&wire.TxOut{
-		Value:    blockdag.CalcBlockSubsidy(nextBlockHeight, params),
-		PkScript: pkScript,
-	})
+	subsidy := blockdag.CalcBlockSubsidy(nextBlockHeight, params)
+	for i, value := range splitValue(subsidy, payouts) {
+		tx.AddTxOut(&wire.TxOut{
+			Value:    value,
+			PkScript: pkScripts[i],
+		})
+	}
 	return soterutil.NewTx(tx), nil
 }
 
@@ -302,6 +362,30 @@ func spendTransaction(utxoView *blockdag.UtxoViewpoint, tx *soterutil.Tx, height
 	return nil
 }
 
+// sortedParentHashes returns a copy of the passed tip hashes in the order used
+// by the DAG to commit to a set of tips, which is the order in which a block
+// references them as parents.
+func sortedParentHashes(tips []chainhash.Hash) []chainhash.Hash {
+	parents := make([]chainhash.Hash, len(tips))
+	copy(parents, tips)
+	sort.Slice(parents, func(i, j int) bool {
+		return parents[i].String() < parents[j].String()
+	})
+	return parents
+}
+
+// findConflict returns the outpoint spent by the passed transaction which is
+// already spent by another transaction selected for the block, along with the
+// hash of that transaction, if any.
+func findConflict(tx *soterutil.Tx, spent map[wire.OutPoint]*chainhash.Hash) (*wire.OutPoint, *chainhash.Hash) {
+	for _, txIn := range tx.MsgTx().TxIn {
+		if spender, ok := spent[txIn.PreviousOutPoint]; ok {
+			return &txIn.PreviousOutPoint, spender
+		}
+	}
+	return nil, nil
+}
+
 // logSkippedDeps logs any dependencies which are also skipped as a result of
 // skipping a transaction while generating a block template at the trace level.
 func logSkippedDeps(tx *soterutil.Tx, deps map[chainhash.Hash]*txPrioItem) {
@@ -354,6 +438,7 @@ type BlkTmplGenerator struct {
 	timeSource  blockdag.MedianTimeSource
 	sigCache    *txscript.SigCache
 	hashCache   *txscript.HashCache
+	cache       *templateCache
 }
 
 // NewBlkTmplGenerator returns a new block template generator for the given
@@ -376,6 +461,7 @@ func NewBlkTmplGenerator(policy *Policy, params *chaincfg.Params,
 		timeSource:  timeSource,
 		sigCache:    sigCache,
 		hashCache:   hashCache,
+		cache:       newTemplateCache(),
 	}
 }
 
@@ -388,6 +474,13 @@ func NewBlkTmplGenerator(policy *Policy, params *chaincfg.Params,
 // coinbase which will replace the one generated for the block template.  Thus
 // the need to have configured address can be avoided.
 //
+// The transactions are selected by the strategy named by the Strategy field
+// of the policy.  The rest of this comment describes the default priority
+// strategy, and the other strategies are described by the constants naming
+// them.  Regardless of the strategy, transactions are only selected once the
+// transactions they depend on are, and the limits of the block and the
+// consensus rules are enforced.
+//
 // The transactions selected and included are prioritized according to several
 // factors.  First, each transaction has a priority calculated based on its
 // value, age of inputs, and size.  Transactions which consist of larger
@@ -419,6 +512,13 @@ func NewBlkTmplGenerator(policy *Policy, params *chaincfg.Params,
 // policy setting, exceed the maximum allowed signature operations per block, or
 // otherwise cause the block to be invalid are skipped.
 //
+// When the transaction source implements GenerationTxSource, the last template
+// is cached, and a copy of it with an updated timestamp is returned as long as
+// neither the tips of the DAG nor the generation of the transaction source
+// changed and it pays to the same address.  Otherwise the transactions are
+// selected again, reusing the outputs fetched for the transactions which were
+// already considered for the same tips.
+//
 // Given the above, a block generated by this function is of the following form:
 //
 //   -----------------------------------  --  --
@@ -442,14 +542,131 @@ func NewBlkTmplGenerator(policy *Policy, params *chaincfg.Params,
 //  |  <= policy.BlockMinSize)          |   |
 //   -----------------------------------  --
 func (g *BlkTmplGenerator) NewBlockTemplate(payToAddress soterutil.Address) (*BlockTemplate, error) {
-	// Extend the most recently known best block.
+	return g.newBlockTemplate(addressPayouts(payToAddress), nil, false)
+}
+
+// NewBlockTemplateForParents returns a new block template like
+// NewBlockTemplate, except that the block references the passed blocks as
+// parents instead of all of the current tips of the DAG.  The parents must be
+// known blocks of the DAG, and needn't be tips, which allows deliberately
+// producing sibling blocks, such as on the simulation test network.
+//
+// Since the block doesn't extend all of the tips, it isn't checked against
+// the consensus rules for connecting to them, and the template isn't cached.
+// Unless the parents are exactly the current tips, the block only holds the
+// coinbase, since the transactions of the source are validated against the
+// outputs of the current tips rather than of the parents.
+func (g *BlkTmplGenerator) NewBlockTemplateForParents(payToAddress soterutil.Address, parents []chainhash.Hash) (*BlockTemplate, error) {
+	if len(parents) == 0 {
+		return nil, errors.New("no parents for the block template")
+	}
+	return g.newBlockTemplate(addressPayouts(payToAddress), parents, false)
+}
+
+// NewEmptyBlockTemplate returns a new block template extending all of the
+// current tips of the DAG like NewBlockTemplateWithPayouts, except that no
+// transactions are selected, so the block only holds the coinbase.  It's cheap
+// to generate, which lets miners switch to the new tips as soon as they arrive
+// instead of mining on stale parents while the transactions of the full
+// template are selected.  The template is never cached.
+func (g *BlkTmplGenerator) NewEmptyBlockTemplate(payouts []Payout) (*BlockTemplate, error) {
+	if err := checkPayouts(payouts); err != nil {
+		return nil, err
+	}
+	return g.newBlockTemplate(payouts, nil, true)
+}
+
+// parentsInfo returns the passed parents in the order a block references them,
+// the hash of them committed to by the header, and the height of the highest
+// of them.  An error is returned when a parent isn't a known block of the DAG
+// or is listed more than once.
+func (g *BlkTmplGenerator) parentsInfo(parents []chainhash.Hash) ([]chainhash.Hash, chainhash.Hash, int32, error) {
+	parentHashes := sortedParentHashes(parents)
+	hashPtrs := make([]*chainhash.Hash, 0, len(parentHashes))
+	maxHeight := int32(-1)
+	for i := range parentHashes {
+		hash := &parentHashes[i]
+		if i > 0 && *hash == parentHashes[i-1] {
+			return nil, chainhash.Hash{}, 0, fmt.Errorf("parent %v "+
+				"is listed more than once", hash)
+		}
+		height, err := g.chain.BlockHeightByHash(hash)
+		if err != nil {
+			return nil, chainhash.Hash{}, 0, err
+		}
+		if height > maxHeight {
+			maxHeight = height
+		}
+		hashPtrs = append(hashPtrs, hash)
+	}
+
+	// GenerateTipsHash sorts the slice it's passed, so it's passed a copy
+	// to leave the parents untouched.
+	tipsHash := blockdag.GenerateTipsHash(append([]*chainhash.Hash(nil),
+		hashPtrs...))
+	return parentHashes, *tipsHash, maxHeight, nil
+}
+
+// newBlockTemplate returns a new block template paying to the passed payouts
+// and referencing the passed parents, or all of the current tips of the DAG
+// when they're nil.  No transactions are selected when empty is set.  See the
+// comments of NewBlockTemplate, NewBlockTemplateForParents,
+// NewBlockTemplateWithPayouts and NewEmptyBlockTemplate for details.
+func (g *BlkTmplGenerator) newBlockTemplate(payouts []Payout, parents []chainhash.Hash, empty bool) (*BlockTemplate, error) {
 	best := g.chain.BestSnapshot()
 	snapshot := g.chain.DAGSnapshot()
+	allTips := parents == nil
+	cacheable := allTips && !empty
+
+	// Extend all of the current tips of the DAG, unless other parents
+	// were requested.
+	var parentHashes []chainhash.Hash
+	var prevHash chainhash.Hash
+	var maxParentHeight int32
+	if allTips {
+		parentHashes = sortedParentHashes(snapshot.Tips)
+		prevHash = snapshot.Hash
+		maxParentHeight = snapshot.MaxHeight
+	} else {
+		var err error
+		parentHashes, prevHash, maxParentHeight, err = g.parentsInfo(parents)
+		if err != nil {
+			return nil, err
+		}
+
+		// The outputs the transactions of the source spend are looked up
+		// in the view of all of the current tips, which may hold outputs
+		// outside of the past of other parents.  No transactions are
+		// selected then, so the block can't spend them.
+		if prevHash != snapshot.Hash {
+			empty = true
+		}
+	}
+
+	// Reuse the last template when nothing it depends on changed.
+	var generation uint64
+	if genSource, ok := g.txSource.(GenerationTxSource); ok {
+		generation = genSource.Generation()
+	} else {
+		cacheable = false
+	}
+	if cacheable {
+		cached := g.cache.lookupTemplate(&snapshot.Hash, generation,
+			payouts)
+		if cached != nil {
+			if err := g.UpdateBlockTime(cached.Block); err != nil {
+				return nil, err
+			}
+			log.Debugf("Reusing cached block template (%d "+
+				"transactions)", len(cached.Block.Transactions))
+			return cached, nil
+		}
+	}
 
-	nextBlockHeight := snapshot.MaxHeight + 1
+	nextBlockHeight := maxParentHeight + 1
 
 	// Create a standard coinbase transaction paying to the provided
-	// address.  NOTE: The coinbase value will be updated to include the
+	// payouts.  NOTE: The coinbase value will be updated to include the
 	// fees from the selected transactions later after they have actually
 	// been selected.  It is created here to detect any errors early
 	// before potentially doing a lot of work below.  The extra nonce helps
@@ -457,12 +674,12 @@ func (g *BlkTmplGenerator) NewBlockTemplate(payToAddress soterutil.Address) (*Bl
 	// same value to the same public key address would otherwise be an
 	// identical transaction for block version 1).
 	extraNonce := uint64(0)
-	coinbaseScript, err := standardCoinbaseScript(nextBlockHeight, extraNonce)
+	coinbaseScript, err := g.extraNonceScript(nextBlockHeight, extraNonce)
 	if err != nil {
 		return nil, err
 	}
 	coinbaseTx, err := createCoinbaseTx(g.chainParams, coinbaseScript,
-		nextBlockHeight, payToAddress)
+		nextBlockHeight, payouts)
 	if err != nil {
 		return nil, err
 	}
@@ -471,12 +688,20 @@ func (g *BlkTmplGenerator) NewBlockTemplate(payToAddress soterutil.Address) (*Bl
 	// Get the current source transactions and create a priority queue to
 	// hold the transactions which are ready for inclusion into a block
 	// along with some priority related and fee metadata.  Reserve the same
-	// number of items that are available for the priority queue.  Also,
-	// choose the initial sort order for the priority queue based on whether
-	// or not there is an area allocated for high-priority transactions.
-	sourceTxns := g.txSource.MiningDescs()
-	sortedByFee := g.policy.BlockPrioritySize == 0
-	priorityQueue := newTxPriorityQueue(len(sourceTxns), sortedByFee)
+	// number of items that are available for the priority queue.  The
+	// queue is sorted in the order the selection strategy considers the
+	// transactions in.
+	strategy, err := NewStrategy(g.policy.Strategy, g.policy)
+	if err != nil {
+		return nil, err
+	}
+	var sourceTxns []*TxDesc
+	if pkgSource, ok := g.txSource.(PackageTxSource); ok && !empty {
+		sourceTxns = pkgSource.PackageMiningDescs()
+	} else if !empty {
+		sourceTxns = g.txSource.MiningDescs()
+	}
+	priorityQueue := newStrategyQueue(len(sourceTxns), strategy)
 
 	// Create a slice to hold the transactions to be included in the
 	// generated block with reserved space.  Also create a utxo view to
@@ -493,6 +718,13 @@ func (g *BlkTmplGenerator) NewBlockTemplate(payToAddress soterutil.Address) (*Bl
 	// in the block once each transaction has been included.
 	dependers := make(map[chainhash.Hash]map[chainhash.Hash]*txPrioItem)
 
+	// blockSpent tracks the outputs spent by the transactions selected for
+	// the block, along with the transaction spending them.  Transactions
+	// from the source pool may have been accepted on top of different tips
+	// of the DAG, so a transaction conflicting with one already selected
+	// is excluded, along with the transactions depending on it.
+	blockSpent := make(map[wire.OutPoint]*chainhash.Hash)
+
 	// Create slices to hold the fees and number of signature operations
 	// for each of the selected transactions and add an entry for the
 	// coinbase.  This allows the code below to simply append details about
@@ -528,7 +760,7 @@ mempoolLoop:
 		// mempool since a transaction which depends on other
 		// transactions in the mempool must come after those
 		// dependencies in the final generated block.
-		utxos, err := g.chain.FetchUtxoView(tx)
+		utxos, err := g.cache.fetchUtxoView(g.chain, &snapshot.Hash, tx)
 		if err != nil {
 			log.Warnf("Unable to fetch utxo view for tx %s: %v",
 				tx.Hash(), err)
@@ -578,9 +810,22 @@ mempoolLoop:
 		prioItem.priority = CalcPriority(tx.MsgTx(), utxos,
 			nextBlockHeight)
 
-		// Calculate the fee in nanoSoter/kB.
+		// Calculate the fee in nanoSoter/kB.  Use the fee rate of the
+		// package of the transaction when it is higher, so that a
+		// parent is selected early when a child pays for it.
 		prioItem.feePerKB = txDesc.FeePerKB
+		if txDesc.PackageFeePerKB > prioItem.feePerKB {
+			prioItem.feePerKB = txDesc.PackageFeePerKB
+		}
 		prioItem.fee = txDesc.Fee
+		prioItem.candidate = Candidate{
+			Tx:              tx,
+			Fee:             txDesc.Fee,
+			FeePerKB:        txDesc.FeePerKB,
+			PackageFeePerKB: txDesc.PackageFeePerKB,
+			Priority:        prioItem.priority,
+			Weight:          uint32(blockdag.GetTransactionWeight(tx)),
+		}
 
 		// Add the transaction to the priority queue to mark it ready
 		// for inclusion in the block unless it has dependencies.
@@ -597,11 +842,22 @@ mempoolLoop:
 	log.Tracef("Priority queue len %d, dependers len %d",
 		priorityQueue.Len(), len(dependers))
 
+	// The outputs of transactions which left the source are no longer
+	// needed.  An empty template doesn't look at the source, so the
+	// outputs are kept for the full template to follow.
+	if !empty {
+		g.cache.prune(sourceTxns)
+	}
+
 	// The starting block size is the size of the block header plus the max
 	// possible transaction count size, plus the size of the coinbase
-	// transaction.
+	// transaction, including the outputs of the auxiliary commitments.
+	commitmentsWeight, err := g.commitmentsWeight()
+	if err != nil {
+		return nil, err
+	}
 	blockWeight := uint32((blockHeaderOverhead * blockdag.WitnessScaleFactor) +
-		blockdag.GetTransactionWeight(coinbaseTx))
+		blockdag.GetTransactionWeight(coinbaseTx)) + commitmentsWeight
 	blockSigOpCost := coinbaseSigOpCost
 	totalFees := int64(0)
 
@@ -672,8 +928,20 @@ mempoolLoop:
 		// Grab any transactions which depend on this one.
 		deps := dependers[*tx.Hash()]
 
+		// Skip transactions spending an output which is already spent
+		// by a transaction selected for the block.  The one selected
+		// first wins since it has the higher fee rate of its package
+		// (or priority).
+		if outPoint, spender := findConflict(tx, blockSpent); outPoint != nil {
+			log.Tracef("Skipping tx %s because it double spends "+
+				"output %s which is spent by tx %s", tx.Hash(),
+				outPoint, spender)
+			logSkippedDeps(tx, deps)
+			continue
+		}
+
 		// Enforce maximum block size.  Also check for overflow.
-		txWeight := uint32(blockdag.GetTransactionWeight(tx))
+		txWeight := prioItem.candidate.Weight
 		blockPlusTxWeight := blockWeight + txWeight
 		if blockPlusTxWeight < blockWeight ||
 			blockPlusTxWeight >= g.policy.BlockMaxWeight {
@@ -702,48 +970,21 @@ mempoolLoop:
 			continue
 		}
 
-		// Skip free transactions once the block is larger than the
-		// minimum block size.
-		if sortedByFee &&
-			prioItem.feePerKB < int64(g.policy.TxMinFreeFee) &&
-			blockPlusTxWeight >= g.policy.BlockMinWeight {
-
-			log.Tracef("Skipping tx %s with feePerKB %d "+
-				"< TxMinFreeFee %d and block weight %d >= "+
-				"minBlockWeight %d", tx.Hash(), prioItem.feePerKB,
-				g.policy.TxMinFreeFee, blockPlusTxWeight,
-				g.policy.BlockMinWeight)
+		// Let the selection strategy decide whether the transaction
+		// is included now, later or not at all.
+		decision, reorder := strategy.Consider(&prioItem.candidate,
+			&SelectionState{BlockWeight: blockWeight, Policy: g.policy})
+		if reorder {
+			heap.Init(priorityQueue)
+		}
+		switch decision {
+		case Skip:
 			logSkippedDeps(tx, deps)
 			continue
-		}
-
-		// Prioritize by fee per kilobyte once the block is larger than
-		// the priority size or there are no more high-priority
-		// transactions.
-		if !sortedByFee && (blockPlusTxWeight >= g.policy.BlockPrioritySize ||
-			prioItem.priority <= MinHighPriority) {
-
-			log.Tracef("Switching to sort by fees per "+
-				"kilobyte blockSize %d >= BlockPrioritySize "+
-				"%d || priority %.2f <= minHighPriority %.2f",
-				blockPlusTxWeight, g.policy.BlockPrioritySize,
-				prioItem.priority, MinHighPriority)
-
-			sortedByFee = true
-			priorityQueue.SetLessFunc(txPQByFee)
 
-			// Put the transaction back into the priority queue and
-			// skip it so it is re-priortized by fees if it won't
-			// fit into the high-priority section or the priority
-			// is too low.  Otherwise this transaction will be the
-			// final one in the high-priority section, so just fall
-			// though to the code below so it is added now.
-			if blockPlusTxWeight > g.policy.BlockPrioritySize ||
-				prioItem.priority < MinHighPriority {
-
-				heap.Push(priorityQueue, prioItem)
-				continue
-			}
+		case Requeue:
+			heap.Push(priorityQueue, prioItem)
+			continue
 		}
 
 		// Ensure the transaction inputs pass all of the necessary
@@ -770,6 +1011,9 @@ mempoolLoop:
 		// this one have it available as an input and can ensure they
 		// aren't double spending.
 		spendTransaction(blockUtxos, tx, nextBlockHeight)
+		for _, txIn := range tx.MsgTx().TxIn {
+			blockSpent[txIn.PreviousOutPoint] = tx.Hash()
+		}
 
 		// Add the transaction to the block, increment counters, and
 		// save the fees and signature operation counts to the block
@@ -803,7 +1047,11 @@ mempoolLoop:
 	blockWeight -= wire.MaxVarIntPayload -
 		(uint32(wire.VarIntSerializeSize(uint64(len(blockTxns)))) *
 			blockdag.WitnessScaleFactor)
-	coinbaseTx.MsgTx().TxOut[0].Value += totalFees
+	coinbaseValue := blockdag.CalcBlockSubsidy(nextBlockHeight,
+		g.chainParams) + totalFees
+	for i, value := range splitValue(coinbaseValue, payouts) {
+		coinbaseTx.MsgTx().TxOut[i].Value = value
+	}
 	txFees[0] = -totalFees
 
 	// If segwit is active and we included transactions with witness data,
@@ -847,11 +1095,18 @@ mempoolLoop:
 			commitmentOutput)
 	}
 
+	// Add the auxiliary commitments of the policy after the payment and
+	// witness commitment outputs.
+	err = g.addCommitments(coinbaseTx.MsgTx(), nextBlockHeight, blockTxns[1:])
+	if err != nil {
+		return nil, err
+	}
+
 	// Calculate the required difficulty for the block.  The timestamp
 	// is potentially adjusted to ensure it comes after the median time of
 	// the last several blocks per the chain consensus rules.
 	ts := medianAdjustedTime(best, g.timeSource)
-	targetDifficulty, err := g.chain.TargetDifficulty(snapshot.MaxHeight)
+	targetDifficulty, err := g.chain.TargetDifficulty(maxParentHeight)
 	if err != nil {
 		return nil, err
 	}
@@ -864,9 +1119,8 @@ mempoolLoop:
 		return nil, err
 	}
 
-	// PrevBlock in msgBlock.Header should be the hash of the DAG tips
-	prevHash := snapshot.Hash
-
+	// PrevBlock in msgBlock.Header is the hash of the parents, which is
+	// prevHash.
 	// Create a new block ready to be solved.
 	merkles := blockdag.BuildMerkleTreeStore(blockTxns, false)
 	var msgBlock wire.MsgBlock
@@ -884,17 +1138,19 @@ mempoolLoop:
 		return nil, err
 	}
 
-	var parents []*wire.Parent
-	for _, hash := range snapshot.Tips {
-		parents = append(parents, &wire.Parent{
+	// Reference the parents in the same order the tips hash in PrevBlock
+	// commits to them.
+	wireParents := make([]*wire.Parent, 0, len(parentHashes))
+	for _, hash := range parentHashes {
+		wireParents = append(wireParents, &wire.Parent{
 			Hash: hash,
 		})
 	}
 
 	msgBlock.Parents = wire.ParentSubHeader{
 		Version: nextParentVersion,
-		Size: int32(len(snapshot.Tips)),
-		Parents: parents,
+		Size:    int32(len(wireParents)),
+		Parents: wireParents,
 	}
 
 	msgBlock.Verification = wire.VerificationSubHeader{}
@@ -910,8 +1166,10 @@ mempoolLoop:
 	// chain with no issues.
 	block := soterutil.NewBlock(&msgBlock)
 	block.SetHeight(nextBlockHeight)
-	if err := g.chain.CheckConnectBlockTemplate(block); err != nil {
-		return nil, err
+	if allTips {
+		if err := g.chain.CheckConnectBlockTemplate(block); err != nil {
+			return nil, err
+		}
 	}
 
 	log.Debugf("Created new block template (%d transactions, %d in "+
@@ -919,14 +1177,21 @@ mempoolLoop:
 		"%064x)", len(msgBlock.Transactions), totalFees, blockSigOpCost,
 		blockWeight, blockdag.CompactToBig(msgBlock.Header.Bits))
 
-	return &BlockTemplate{
+	template := &BlockTemplate{
 		Block:             &msgBlock,
 		Fees:              txFees,
 		SigOpCosts:        txSigOpCosts,
 		Height:            nextBlockHeight,
-		ValidPayAddress:   payToAddress != nil,
+		ParentHashes:      parentHashes,
+		ValidPayAddress:   len(payouts) > 0,
 		WitnessCommitment: witnessCommitment,
-	}, nil
+		Empty:             empty,
+	}
+	if cacheable {
+		g.cache.storeTemplate(&snapshot.Hash, generation, payouts,
+			template)
+	}
+	return template, nil
 }
 
 // UpdateBlockTime updates the timestamp in the header of the passed block to
@@ -959,16 +1224,10 @@ func (g *BlkTmplGenerator) UpdateBlockTime(msgBlock *wire.MsgBlock) error {
 // height.  It also recalculates and updates the new merkle root that results
 // from changing the coinbase script.
 func (g *BlkTmplGenerator) UpdateExtraNonce(msgBlock *wire.MsgBlock, blockHeight int32, extraNonce uint64) error {
-	coinbaseScript, err := standardCoinbaseScript(blockHeight, extraNonce)
+	coinbaseScript, err := g.extraNonceScript(blockHeight, extraNonce)
 	if err != nil {
 		return err
 	}
-	if len(coinbaseScript) > blockdag.MaxCoinbaseScriptLen {
-		return fmt.Errorf("coinbase transaction script length "+
-			"of %d is out of range (min: %d, max: %d)",
-			len(coinbaseScript), blockdag.MinCoinbaseScriptLen,
-			blockdag.MaxCoinbaseScriptLen)
-	}
 	msgBlock.Transactions[0].TxIn[0].SignatureScript = coinbaseScript
 
 	// TODO(davec): A soterutil.Block should use saved in the state to avoid
@@ -1002,3 +1261,15 @@ func (g *BlkTmplGenerator) DAGSnapshot() *blockdag.DAGState {
 func (g *BlkTmplGenerator) TxSource() TxSource {
 	return g.txSource
 }
+
+// PowBackend returns the proof of work backend the generated templates are
+// solved with, which is the one of the DAG, or the default cuckoo cycle
+// backend when there's no DAG.
+//
+// This function is safe for concurrent access.
+func (g *BlkTmplGenerator) PowBackend() pow.Backend {
+	if g.chain == nil {
+		return pow.Default()
+	}
+	return g.chain.PowBackend()
+}
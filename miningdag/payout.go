@@ -0,0 +1,257 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miningdag
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/txscript"
+)
+
+// Payout is a payment of a share of the coinbase of a block to an address, or
+// to an arbitrary script.
+type Payout struct {
+	// Address is the address paid to.
+	Address soterutil.Address
+
+	// PkScript is the script paid to when Address is nil, such as one
+	// obtained from an output descriptor with DescriptorScript.
+	PkScript []byte
+
+	// Weight is the share of the coinbase paid to the address, relative to
+	// the weights of the other payouts.  A weight of one is used when it is
+	// zero.
+	Weight uint32
+}
+
+// pkScript returns the script the payout pays to.
+func (p *Payout) pkScript() ([]byte, error) {
+	if p.Address == nil {
+		return p.PkScript, nil
+	}
+	return txscript.PayToAddrScript(p.Address)
+}
+
+// checkPayouts returns an error when one of the passed payouts has neither an
+// address nor a script to pay to.
+func checkPayouts(payouts []Payout) error {
+	for i := range payouts {
+		if payouts[i].Address == nil && len(payouts[i].PkScript) == 0 {
+			return fmt.Errorf("payout %d has no address or script", i)
+		}
+	}
+	return nil
+}
+
+// weight returns the weight of the payout, with the default applied.
+func (p *Payout) weight() uint64 {
+	if p.Weight == 0 {
+		return 1
+	}
+	return uint64(p.Weight)
+}
+
+// PayoutMode describes how the payouts of a schedule are applied to the
+// generated blocks.
+type PayoutMode int
+
+const (
+	// PayoutRotate pays the coinbase of each block to a single address,
+	// rotating through the addresses so each one is paid to in proportion
+	// to its weight.
+	PayoutRotate PayoutMode = iota
+
+	// PayoutSplit splits the coinbase of each block across outputs paying
+	// to all of the addresses, in proportion to their weights.
+	PayoutSplit
+)
+
+// Map of payout modes back to their constant names for pretty printing.
+var payoutModeStrings = map[PayoutMode]string{
+	PayoutRotate: "PayoutRotate",
+	PayoutSplit:  "PayoutSplit",
+}
+
+// String returns the PayoutMode in human-readable form.
+func (m PayoutMode) String() string {
+	if s, ok := payoutModeStrings[m]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown PayoutMode (%d)", int(m))
+}
+
+// PayoutSchedule decides which addresses the generated blocks pay to.  Its
+// payouts can be changed at runtime, such as through an RPC, without
+// restarting the miners using it.
+type PayoutSchedule struct {
+	mtx     sync.Mutex
+	mode    PayoutMode
+	payouts []Payout
+
+	// current holds the credit of each payout for the smooth weighted
+	// round-robin rotation.
+	current []int64
+}
+
+// NewPayoutSchedule returns a new payout schedule applying the passed payouts
+// in the passed mode.  See Set for the requirements on the payouts.
+func NewPayoutSchedule(mode PayoutMode, payouts []Payout) (*PayoutSchedule, error) {
+	s := &PayoutSchedule{}
+	if err := s.Set(mode, payouts); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Set replaces the payouts of the schedule and the mode they're applied in.
+// At least one payout is required, each of which must have an address or a
+// script to pay to.  The rotation starts over.
+//
+// This function is safe for concurrent access.
+func (s *PayoutSchedule) Set(mode PayoutMode, payouts []Payout) error {
+	if _, ok := payoutModeStrings[mode]; !ok {
+		return fmt.Errorf("invalid payout mode %v", mode)
+	}
+	if len(payouts) == 0 {
+		return errors.New("no payouts")
+	}
+	if err := checkPayouts(payouts); err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	s.mode = mode
+	s.payouts = append([]Payout(nil), payouts...)
+	s.current = make([]int64, len(payouts))
+	s.mtx.Unlock()
+	return nil
+}
+
+// Mode returns the mode the payouts of the schedule are applied in.
+//
+// This function is safe for concurrent access.
+func (s *PayoutSchedule) Mode() PayoutMode {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.mode
+}
+
+// Payouts returns a copy of the payouts of the schedule.
+//
+// This function is safe for concurrent access.
+func (s *PayoutSchedule) Payouts() []Payout {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return append([]Payout(nil), s.payouts...)
+}
+
+// Next returns the payouts of the next generated block.  In rotation mode,
+// it's a single payout, picked with a smooth weighted round-robin so the
+// addresses are paid to in proportion to their weights and as evenly as
+// possible.  In split mode, it's all of the payouts.
+//
+// This function is safe for concurrent access.
+func (s *PayoutSchedule) Next() []Payout {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.mode == PayoutSplit {
+		return append([]Payout(nil), s.payouts...)
+	}
+
+	var total int64
+	best := 0
+	for i := range s.payouts {
+		weight := int64(s.payouts[i].weight())
+		total += weight
+		s.current[i] += weight
+		if s.current[i] > s.current[best] {
+			best = i
+		}
+	}
+	s.current[best] -= total
+	return []Payout{{
+		Address:  s.payouts[best].Address,
+		PkScript: s.payouts[best].PkScript,
+	}}
+}
+
+// addressPayouts returns the payouts paying the whole coinbase to the passed
+// address, or nil when the address is nil.
+func addressPayouts(addr soterutil.Address) []Payout {
+	if addr == nil {
+		return nil
+	}
+	return []Payout{{Address: addr}}
+}
+
+// payoutsKey returns the key identifying the passed payouts in the template
+// cache.
+func payoutsKey(payouts []Payout) string {
+	keys := make([]string, 0, len(payouts))
+	for i := range payouts {
+		dest := fmt.Sprintf("%x", payouts[i].PkScript)
+		if payouts[i].Address != nil {
+			dest = payouts[i].Address.EncodeAddress()
+		}
+		keys = append(keys, fmt.Sprintf("%s:%d", dest,
+			payouts[i].weight()))
+	}
+	return strings.Join(keys, ",")
+}
+
+// splitValue splits the passed value across the passed payouts in proportion
+// to their weights.  The remainder of the division goes to the first payout,
+// so the shares add up to the value.  A single share of the whole value is
+// returned when there are no payouts, for the output paying to anyone.
+func splitValue(value int64, payouts []Payout) []int64 {
+	if len(payouts) <= 1 {
+		return []int64{value}
+	}
+
+	var total uint64
+	for i := range payouts {
+		total += payouts[i].weight()
+	}
+
+	// The shares are computed with big integers so the product of the
+	// value and the weight can't overflow.
+	shares := make([]int64, len(payouts))
+	bigValue, bigTotal := big.NewInt(value), new(big.Int).SetUint64(total)
+	var paid int64
+	for i := range payouts {
+		share := new(big.Int).SetUint64(payouts[i].weight())
+		share.Mul(share, bigValue).Quo(share, bigTotal)
+		shares[i] = share.Int64()
+		paid += shares[i]
+	}
+	shares[0] += value - paid
+	return shares
+}
+
+// NewBlockTemplateWithPayouts returns a new block template like
+// NewBlockTemplate, except that the coinbase pays to the passed payouts, split
+// in proportion to their weights, instead of a single address.  Each payout
+// must have an address or a script to pay to.  The template
+// references the passed parents like NewBlockTemplateForParents does, or all of
+// the current tips of the DAG when they're nil.  The coinbase is redeemable by
+// anyone when there are no payouts.
+func (g *BlkTmplGenerator) NewBlockTemplateWithPayouts(payouts []Payout, parents []chainhash.Hash) (*BlockTemplate, error) {
+	if err := checkPayouts(payouts); err != nil {
+		return nil, err
+	}
+	if parents != nil && len(parents) == 0 {
+		return nil, errors.New("no parents for the block template")
+	}
+	return g.newBlockTemplate(payouts, parents, false)
+}
@@ -46,6 +46,27 @@ type Policy struct {
 	// required for a transaction to be treated as free for mining purposes
 	// (block template generation).
 	TxMinFreeFee soterutil.Amount
+
+	// Strategy is the name of the strategy used to select the transactions
+	// of a block template.  It is one of the StrategyPriority,
+	// StrategyFeeRate and StrategyPackage constants, or the name of a
+	// strategy added with RegisterStrategy.  The priority strategy is used
+	// when it is empty.
+	Strategy string
+
+	// CoinbaseTag is added to the coinbase script of the generated blocks,
+	// such as to identify the pool which mined them.  CoinbaseFlags is
+	// used when it is empty.
+	CoinbaseTag []byte
+
+	// ExtraNonceSize is the number of bytes reserved for the extra nonce
+	// in the coinbase script of the generated blocks.  When it is zero,
+	// the extra nonce is pushed as a number of varying size instead.
+	ExtraNonceSize int
+
+	// CoinbaseCommitments are the auxiliary commitments added to the
+	// coinbase of the generated blocks.  See CoinbaseCommitment.
+	CoinbaseCommitments []CoinbaseCommitment
 }
 
 // minInt is a helper function to return the minimum of two ints.  This avoids
@@ -0,0 +1,114 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miningdag
+
+import (
+	"fmt"
+
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/soterutil"
+)
+
+// proposalRejectReasons maps the rule errors to the reasons a block proposal is
+// rejected with, as defined by BIP0022 and BIP0023 for the getblocktemplate
+// proposal mode.
+var proposalRejectReasons = map[blockdag.ErrorCode]string{
+	blockdag.ErrDuplicateBlock:            "duplicate",
+	blockdag.ErrBlockTooBig:               "bad-blk-length",
+	blockdag.ErrBlockWeightTooHigh:        "bad-blk-weight",
+	blockdag.ErrBlockVersionTooOld:        "bad-version",
+	blockdag.ErrInvalidTime:               "bad-time",
+	blockdag.ErrTimeTooOld:                "time-too-old",
+	blockdag.ErrTimeTooNew:                "time-too-new",
+	blockdag.ErrDifficultyTooLow:          "bad-diffbits",
+	blockdag.ErrUnexpectedDifficulty:      "bad-diffbits",
+	blockdag.ErrHighHash:                  "high-hash",
+	blockdag.ErrCuckooFail:                "bad-cycle",
+	blockdag.ErrBadMerkleRoot:             "bad-txnmrklroot",
+	blockdag.ErrBadCheckpoint:             "bad-checkpoint",
+	blockdag.ErrForkTooOld:                "fork-too-old",
+	blockdag.ErrCheckpointTimeTooOld:      "checkpoint-time-too-old",
+	blockdag.ErrNoTransactions:            "bad-txns-none",
+	blockdag.ErrNoTxInputs:                "bad-txns-noinputs",
+	blockdag.ErrNoTxOutputs:               "bad-txns-nooutputs",
+	blockdag.ErrTxTooBig:                  "bad-txns-size",
+	blockdag.ErrBadTxOutValue:             "bad-txns-outputvalue",
+	blockdag.ErrDuplicateTxInputs:         "bad-txns-dupinputs",
+	blockdag.ErrBadTxInput:                "bad-txns-badinput",
+	blockdag.ErrMissingTxOut:              "bad-txns-missinginput",
+	blockdag.ErrUnfinalizedTx:             "bad-txns-unfinalizedtx",
+	blockdag.ErrDuplicateTx:               "bad-txns-duplicate",
+	blockdag.ErrOverwriteTx:               "bad-txns-overwrite",
+	blockdag.ErrImmatureSpend:             "bad-txns-maturity",
+	blockdag.ErrSpendTooHigh:              "bad-txns-highspend",
+	blockdag.ErrBadFees:                   "bad-txns-fees",
+	blockdag.ErrTooManySigOps:             "high-sigops",
+	blockdag.ErrFirstTxNotCoinbase:        "bad-txns-nocoinbase",
+	blockdag.ErrMultipleCoinbases:         "bad-txns-multicoinbase",
+	blockdag.ErrBadCoinbaseScriptLen:      "bad-cb-length",
+	blockdag.ErrBadCoinbaseValue:          "bad-cb-value",
+	blockdag.ErrMissingCoinbaseHeight:     "bad-cb-height",
+	blockdag.ErrBadCoinbaseHeight:         "bad-cb-height",
+	blockdag.ErrScriptMalformed:           "bad-script-malformed",
+	blockdag.ErrScriptValidation:          "bad-script-validate",
+	blockdag.ErrUnexpectedWitness:         "unexpected-witness",
+	blockdag.ErrInvalidWitnessCommitment:  "bad-witness-nonce-size",
+	blockdag.ErrWitnessCommitmentMismatch: "bad-witness-merkle-match",
+	blockdag.ErrPreviousBlockUnknown:      "prev-blk-not-found",
+	blockdag.ErrInvalidAncestorBlock:      "bad-prevblk",
+	blockdag.ErrPrevBlockNotBest:          "inconclusive-not-best-prevblk",
+	blockdag.ErrDuplicateParent:           "bad-parents",
+	blockdag.ErrDeepReorder:               "bad-reorder",
+}
+
+// ProposalRejectReason returns the reason a block proposal which failed
+// CheckProposal with the passed error is rejected with, as reported by the
+// getblocktemplate proposal mode.  It returns an empty string when the error
+// is nil, and "rejected" for the errors which aren't rule errors.
+func ProposalRejectReason(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	rerr, ok := err.(blockdag.RuleError)
+	if !ok {
+		return "rejected"
+	}
+	if reason, ok := proposalRejectReasons[rerr.ErrorCode]; ok {
+		return reason
+	}
+	return "rejected: " + rerr.ErrorCode.String()
+}
+
+// CheckProposal fully validates the passed block, constructed by a miner, as
+// if it was to be connected to the DAG, aside from the proof of work.  The
+// block isn't submitted, which lets miners and pool operators test changes to
+// the blocks they construct, such as to the payouts or commitments of the
+// coinbase, without risking an invalid block.
+//
+// The block must reference all of the current tips as its parents.  The
+// returned error is the rule error describing the rule the block breaks, which
+// ProposalRejectReason maps to the reason reported to the miner.
+//
+// This function is safe for concurrent access.
+func (g *BlkTmplGenerator) CheckProposal(block *soterutil.Block) error {
+	exists, err := g.chain.HaveBlock(block.Hash())
+	if err != nil {
+		return err
+	}
+	if exists {
+		str := fmt.Sprintf("already have block %v", block.Hash())
+		return blockdag.RuleError{
+			ErrorCode:   blockdag.ErrDuplicateBlock,
+			Description: str,
+		}
+	}
+
+	if err := g.chain.CheckConnectBlockTemplate(block); err != nil {
+		log.Debugf("Block proposal %s rejected: %v", block.Hash(), err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miningdag
+
+import (
+	"container/heap"
+	"math"
+
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// p2pkhScriptLen is the length of a pay-to-pubkey-hash script, which the
+// coinbase modeled by the fee revenue simulation pays to.
+const p2pkhScriptLen = 25
+
+// FeeScenario describes a policy a block template is simulated with.
+type FeeScenario struct {
+	// Strategy is the name of the selection strategy.  The strategy of the
+	// policy of the generator is used when it is empty.
+	Strategy string
+
+	// BlockMaxWeight is the maximum block weight.  The maximum block
+	// weight of the policy of the generator is used when it is zero.
+	BlockMaxWeight uint32
+}
+
+// FeeProjection describes the block template projected for a scenario.
+type FeeProjection struct {
+	// Strategy and BlockMaxWeight are the strategy and maximum block weight
+	// of the scenario, with the defaults of the policy applied.
+	Strategy       string
+	BlockMaxWeight uint32
+
+	// Fees is the total fee revenue of the template.
+	Fees soterutil.Amount
+
+	// NumTxns is the number of transactions of the template, excluding
+	// the coinbase, and Weight its weight.
+	NumTxns int
+	Weight  uint32
+
+	// MinFeePerKB is the lowest fee rate of the transactions of the
+	// template, which is roughly the fee rate a transaction has to pay to
+	// make it into the template.  It is zero when the template has no
+	// transactions.
+	MinFeePerKB int64
+}
+
+// SimulateFeeRevenue projects the fee revenue of the block templates selected
+// from the passed transactions under each of the passed scenarios, so miners
+// can tune their policy.  The transactions of the transaction source of the
+// generator are used when descs is nil; the mempool provides the ones of a
+// snapshot of the pool through MiningDescs and PackageMiningDescs.
+//
+// The simulation selects the transactions as NewBlockTemplate does, with the
+// same strategies and dependencies between the transactions, but without
+// looking up the outputs they spend.  It thus assumes the outputs spent from
+// outside of the transactions are available, doesn't enforce the signature
+// operation limits, and considers all of the transactions to have no priority.
+//
+// This function is safe for concurrent access.
+func (g *BlkTmplGenerator) SimulateFeeRevenue(descs []*TxDesc, scenarios []FeeScenario) ([]*FeeProjection, error) {
+	if descs == nil {
+		if pkgSource, ok := g.txSource.(PackageTxSource); ok {
+			descs = pkgSource.PackageMiningDescs()
+		} else {
+			descs = g.txSource.MiningDescs()
+		}
+	}
+
+	baseWeight, err := g.baseBlockWeight()
+	if err != nil {
+		return nil, err
+	}
+
+	projections := make([]*FeeProjection, 0, len(scenarios))
+	for _, scenario := range scenarios {
+		policy := *g.policy
+		if scenario.Strategy != "" {
+			policy.Strategy = scenario.Strategy
+		}
+		if scenario.BlockMaxWeight != 0 {
+			policy.BlockMaxWeight = scenario.BlockMaxWeight
+		}
+		if policy.Strategy == "" {
+			policy.Strategy = StrategyPriority
+		}
+
+		strategy, err := NewStrategy(policy.Strategy, &policy)
+		if err != nil {
+			return nil, err
+		}
+		projections = append(projections,
+			simulateSelection(&policy, strategy, descs, baseWeight))
+	}
+	return projections, nil
+}
+
+// baseBlockWeight returns the weight of a block template without any of its
+// transactions other than the coinbase, which is modeled after the largest
+// coinbase the generator creates with a single payment output.
+func (g *BlkTmplGenerator) baseBlockWeight() (uint32, error) {
+	// The largest extra nonce takes the most bytes when it's pushed as a
+	// number.
+	extraNonce := uint64(math.MaxInt64)
+	if size := g.policy.ExtraNonceSize; size > 0 && size < 8 {
+		extraNonce = 1<<(8*uint(size)) - 1
+	}
+	script, err := g.extraNonceScript(math.MaxInt32, extraNonce)
+	if err != nil {
+		return 0, err
+	}
+
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{},
+			wire.MaxPrevOutIndex),
+		SignatureScript: script,
+		Sequence:        wire.MaxTxInSequenceNum,
+	})
+	coinbase.AddTxOut(&wire.TxOut{PkScript: make([]byte, p2pkhScriptLen)})
+
+	commitmentsWeight, err := g.commitmentsWeight()
+	if err != nil {
+		return 0, err
+	}
+	return uint32(blockHeaderOverhead*blockdag.WitnessScaleFactor) +
+		uint32(blockdag.GetTransactionWeight(soterutil.NewTx(coinbase))) +
+		commitmentsWeight, nil
+}
+
+// simulateSelection returns the projection of the block template selected
+// from the passed transactions with the passed policy and strategy, starting
+// from a block of the passed weight.  See SimulateFeeRevenue for the
+// simplifications of the simulation.
+func simulateSelection(policy *Policy, strategy Strategy, descs []*TxDesc, baseWeight uint32) *FeeProjection {
+	projection := &FeeProjection{
+		Strategy:       policy.Strategy,
+		BlockMaxWeight: policy.BlockMaxWeight,
+	}
+
+	inSource := make(map[chainhash.Hash]struct{}, len(descs))
+	for _, txDesc := range descs {
+		inSource[*txDesc.Tx.Hash()] = struct{}{}
+	}
+
+	// Set up the dependencies between the transactions as NewBlockTemplate
+	// does, and queue the ones without any.
+	priorityQueue := newStrategyQueue(len(descs), strategy)
+	dependers := make(map[chainhash.Hash]map[chainhash.Hash]*txPrioItem)
+	for _, txDesc := range descs {
+		tx := txDesc.Tx
+		if blockdag.IsCoinBase(tx) {
+			continue
+		}
+
+		prioItem := &txPrioItem{tx: tx, fee: txDesc.Fee}
+		for _, txIn := range tx.MsgTx().TxIn {
+			originHash := &txIn.PreviousOutPoint.Hash
+			if _, ok := inSource[*originHash]; !ok {
+				continue
+			}
+
+			deps, exists := dependers[*originHash]
+			if !exists {
+				deps = make(map[chainhash.Hash]*txPrioItem)
+				dependers[*originHash] = deps
+			}
+			deps[*tx.Hash()] = prioItem
+			if prioItem.dependsOn == nil {
+				prioItem.dependsOn = make(map[chainhash.Hash]struct{})
+			}
+			prioItem.dependsOn[*originHash] = struct{}{}
+		}
+
+		prioItem.feePerKB = txDesc.FeePerKB
+		if txDesc.PackageFeePerKB > prioItem.feePerKB {
+			prioItem.feePerKB = txDesc.PackageFeePerKB
+		}
+		prioItem.candidate = Candidate{
+			Tx:              tx,
+			Fee:             txDesc.Fee,
+			FeePerKB:        txDesc.FeePerKB,
+			PackageFeePerKB: txDesc.PackageFeePerKB,
+			Weight:          uint32(blockdag.GetTransactionWeight(tx)),
+		}
+		if prioItem.dependsOn == nil {
+			heap.Push(priorityQueue, prioItem)
+		}
+	}
+
+	blockWeight := baseWeight
+	blockSpent := make(map[wire.OutPoint]*chainhash.Hash)
+	var totalFees int64
+	for priorityQueue.Len() > 0 {
+		prioItem := heap.Pop(priorityQueue).(*txPrioItem)
+		tx := prioItem.tx
+		deps := dependers[*tx.Hash()]
+
+		if outPoint, _ := findConflict(tx, blockSpent); outPoint != nil {
+			continue
+		}
+
+		txWeight := prioItem.candidate.Weight
+		blockPlusTxWeight := blockWeight + txWeight
+		if blockPlusTxWeight < blockWeight ||
+			blockPlusTxWeight >= policy.BlockMaxWeight {
+
+			continue
+		}
+
+		decision, reorder := strategy.Consider(&prioItem.candidate,
+			&SelectionState{BlockWeight: blockWeight, Policy: policy})
+		if reorder {
+			heap.Init(priorityQueue)
+		}
+		switch decision {
+		case Skip:
+			continue
+
+		case Requeue:
+			heap.Push(priorityQueue, prioItem)
+			continue
+		}
+
+		for _, txIn := range tx.MsgTx().TxIn {
+			blockSpent[txIn.PreviousOutPoint] = tx.Hash()
+		}
+		blockWeight += txWeight
+		totalFees += prioItem.fee
+		if projection.NumTxns == 0 ||
+			prioItem.candidate.FeePerKB < projection.MinFeePerKB {
+
+			projection.MinFeePerKB = prioItem.candidate.FeePerKB
+		}
+		projection.NumTxns++
+
+		for _, item := range deps {
+			delete(item.dependsOn, *tx.Hash())
+			if len(item.dependsOn) == 0 {
+				heap.Push(priorityQueue, item)
+			}
+		}
+	}
+
+	projection.Fees = soterutil.Amount(totalFees)
+	projection.Weight = blockWeight
+	return projection
+}
@@ -0,0 +1,347 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miningdag
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/soteria-dag/soterd/soterutil"
+)
+
+const (
+	// StrategyPriority is the name of the strategy selecting transactions
+	// by priority until the high-priority area of the block is filled,
+	// and by fee rate afterwards.  It's the default strategy.
+	StrategyPriority = "priority"
+
+	// StrategyFeeRate is the name of the strategy greedily selecting the
+	// transactions paying the highest fee rate of their own.
+	StrategyFeeRate = "feerate"
+
+	// StrategyPackage is the name of the strategy selecting transactions
+	// by the fee rate of their package of unconfirmed ancestors and
+	// descendants, preferring smaller transactions for the same fee rate.
+	StrategyPackage = "package"
+)
+
+// Candidate describes a transaction which is ready to be included in a block
+// template, since all of the transactions it depends on already are.
+type Candidate struct {
+	// Tx is the transaction.
+	Tx *soterutil.Tx
+
+	// Fee is the fee the transaction pays in nanoSoter.
+	Fee int64
+
+	// FeePerKB is the fee rate the transaction pays in nanoSoter per 1000
+	// bytes.
+	FeePerKB int64
+
+	// PackageFeePerKB is the fee rate of the package the transaction is
+	// best mined with, or zero if the transaction source doesn't implement
+	// PackageTxSource.
+	PackageFeePerKB int64
+
+	// Priority is the priority of the transaction.  See CalcPriority.
+	Priority float64
+
+	// Weight is the weight of the transaction.
+	Weight uint32
+}
+
+// FeeRate returns the higher of the fee rate of the transaction and the one
+// of its package, which is the fee rate it's effectively mined at when a
+// descendant pays for it.
+func (c *Candidate) FeeRate() int64 {
+	if c.PackageFeePerKB > c.FeePerKB {
+		return c.PackageFeePerKB
+	}
+	return c.FeePerKB
+}
+
+// SelectionState describes the block template a candidate is considered for.
+type SelectionState struct {
+	// BlockWeight is the weight of the block without the candidate.
+	BlockWeight uint32
+
+	// Policy is the policy the template is generated with.
+	Policy *Policy
+}
+
+// Decision is what a strategy decided to do with a candidate.
+type Decision int
+
+const (
+	// Include means the candidate is included in the block, provided it
+	// passes the consensus checks.
+	Include Decision = iota
+
+	// Skip means the candidate is left out of the block, along with the
+	// transactions depending on it.
+	Skip
+
+	// Requeue means the candidate is put back with the remaining ones, to
+	// be considered again in the order defined by Less.
+	Requeue
+)
+
+// Map of Decision values back to their constant names for pretty printing.
+var decisionStrings = map[Decision]string{
+	Include: "Include",
+	Skip:    "Skip",
+	Requeue: "Requeue",
+}
+
+// String returns the Decision in human-readable form.
+func (d Decision) String() string {
+	if s, ok := decisionStrings[d]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown Decision (%d)", int(d))
+}
+
+// Strategy decides which of the transactions of the source are selected for a
+// block template, and in which order.  A new strategy is created for every
+// template, so it may keep state about the template being generated.
+//
+// Candidates are considered one at a time, in the order defined by Less, and
+// the transactions depending on a candidate become candidates once it's
+// included.  The generator enforces the limits of the block and the consensus
+// rules on its own, and candidates which would break them are skipped
+// regardless of the strategy, so a strategy only has to express preferences.
+type Strategy interface {
+	// Less returns whether candidate a should be considered before
+	// candidate b.
+	Less(a, b *Candidate) bool
+
+	// Consider decides what to do with the passed candidate, which is the
+	// first one according to Less.  reorder reports whether the order
+	// defined by Less changed, in which case the remaining candidates are
+	// sorted again.
+	Consider(c *Candidate, state *SelectionState) (decision Decision, reorder bool)
+}
+
+// NewStrategyFunc describes a function which returns a new strategy for a
+// block template generated with the passed policy.
+type NewStrategyFunc func(policy *Policy) Strategy
+
+var (
+	strategiesMtx sync.RWMutex
+	strategies    = map[string]NewStrategyFunc{
+		StrategyPriority: newPriorityStrategy,
+		StrategyFeeRate:  newFeeRateStrategy,
+		StrategyPackage:  newPackageStrategy,
+	}
+)
+
+// RegisterStrategy registers a strategy under the passed name, so it can be
+// selected through the Strategy field of the policy.  It allows experimenting
+// with other ways of selecting transactions without patching the generator.
+// Registering a strategy under the name of an existing one replaces it.
+//
+// This function is safe for concurrent access.
+func RegisterStrategy(name string, newStrategy NewStrategyFunc) {
+	strategiesMtx.Lock()
+	strategies[name] = newStrategy
+	strategiesMtx.Unlock()
+}
+
+// StrategyNames returns the sorted names of the registered strategies.
+//
+// This function is safe for concurrent access.
+func StrategyNames() []string {
+	strategiesMtx.RLock()
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	strategiesMtx.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// NewStrategy returns a new instance of the strategy registered under the
+// passed name for a template generated with the passed policy.  The priority
+// strategy is returned when the name is empty.
+//
+// This function is safe for concurrent access.
+func NewStrategy(name string, policy *Policy) (Strategy, error) {
+	if name == "" {
+		name = StrategyPriority
+	}
+
+	strategiesMtx.RLock()
+	newStrategy, ok := strategies[name]
+	strategiesMtx.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown transaction selection strategy "+
+			"%q (registered: %v)", name, StrategyNames())
+	}
+	return newStrategy(policy), nil
+}
+
+// belowMinFee returns whether a candidate paying the passed fee rate is to be
+// skipped as a free transaction, which is the case once the block reaches the
+// minimum block weight of the policy.
+func belowMinFee(c *Candidate, feeRate int64, state *SelectionState) bool {
+	blockPlusTxWeight := state.BlockWeight + c.Weight
+	if feeRate >= int64(state.Policy.TxMinFreeFee) ||
+		blockPlusTxWeight < state.Policy.BlockMinWeight {
+
+		return false
+	}
+
+	log.Tracef("Skipping tx %s with feePerKB %d < TxMinFreeFee %d and "+
+		"block weight %d >= minBlockWeight %d", c.Tx.Hash(), feeRate,
+		state.Policy.TxMinFreeFee, blockPlusTxWeight,
+		state.Policy.BlockMinWeight)
+	return true
+}
+
+// priorityStrategy selects transactions by priority, then fee rate, until the
+// high-priority area of the block is filled or the priority falls below what
+// is considered high-priority.  It selects them by fee rate, then priority,
+// afterwards.  The high-priority area is skipped when the BlockPrioritySize of
+// the policy is zero.  See NewBlockTemplate for details.
+type priorityStrategy struct {
+	policy      *Policy
+	sortedByFee bool
+}
+
+// newPriorityStrategy returns a new priority strategy.  It implements the
+// NewStrategyFunc type.
+func newPriorityStrategy(policy *Policy) Strategy {
+	return &priorityStrategy{
+		policy:      policy,
+		sortedByFee: policy.BlockPrioritySize == 0,
+	}
+}
+
+// Less returns whether candidate a should be considered before candidate b.
+// It is part of the Strategy interface.
+func (s *priorityStrategy) Less(a, b *Candidate) bool {
+	aFeeRate, bFeeRate := a.FeeRate(), b.FeeRate()
+	if s.sortedByFee {
+		if aFeeRate == bFeeRate {
+			return a.Priority > b.Priority
+		}
+		return aFeeRate > bFeeRate
+	}
+
+	if a.Priority == b.Priority {
+		return aFeeRate > bFeeRate
+	}
+	return a.Priority > b.Priority
+}
+
+// Consider decides what to do with the passed candidate.  It is part of the
+// Strategy interface.
+func (s *priorityStrategy) Consider(c *Candidate, state *SelectionState) (Decision, bool) {
+	// Skip free transactions once the block is larger than the minimum
+	// block size.
+	if s.sortedByFee {
+		if belowMinFee(c, c.FeeRate(), state) {
+			return Skip, false
+		}
+		return Include, false
+	}
+
+	// Prioritize by fee per kilobyte once the block is larger than the
+	// priority size or there are no more high-priority transactions.
+	blockPlusTxWeight := state.BlockWeight + c.Weight
+	if blockPlusTxWeight < s.policy.BlockPrioritySize &&
+		c.Priority > MinHighPriority {
+
+		return Include, false
+	}
+
+	log.Tracef("Switching to sort by fees per kilobyte blockSize %d >= "+
+		"BlockPrioritySize %d || priority %.2f <= minHighPriority %.2f",
+		blockPlusTxWeight, s.policy.BlockPrioritySize, c.Priority,
+		MinHighPriority)
+	s.sortedByFee = true
+
+	// Put the transaction back so it is re-prioritized by fees if it won't
+	// fit into the high-priority section or the priority is too low.
+	// Otherwise this transaction will be the final one in the
+	// high-priority section, so it is added now.
+	if blockPlusTxWeight > s.policy.BlockPrioritySize ||
+		c.Priority < MinHighPriority {
+
+		return Requeue, true
+	}
+	return Include, true
+}
+
+// feeRateStrategy greedily selects the transactions paying the highest fee
+// rate of their own, then the highest priority.  Unlike the other strategies,
+// it ignores the fee rate of the package of a transaction, so a parent is only
+// selected early if it pays enough on its own.
+type feeRateStrategy struct{}
+
+// newFeeRateStrategy returns a new fee rate strategy.  It implements the
+// NewStrategyFunc type.
+func newFeeRateStrategy(*Policy) Strategy {
+	return feeRateStrategy{}
+}
+
+// Less returns whether candidate a should be considered before candidate b.
+// It is part of the Strategy interface.
+func (feeRateStrategy) Less(a, b *Candidate) bool {
+	if a.FeePerKB == b.FeePerKB {
+		return a.Priority > b.Priority
+	}
+	return a.FeePerKB > b.FeePerKB
+}
+
+// Consider decides what to do with the passed candidate.  It is part of the
+// Strategy interface.
+func (feeRateStrategy) Consider(c *Candidate, state *SelectionState) (Decision, bool) {
+	if belowMinFee(c, c.FeePerKB, state) {
+		return Skip, false
+	}
+	return Include, false
+}
+
+// packageStrategy selects transactions by the fee rate of their package, so a
+// parent is selected as soon as it's worth mining along with a descendant
+// paying for it.
+//
+// It approximates filling the block as a knapsack: for the same fee rate, the
+// smaller transaction is preferred since it leaves more room for others, and
+// candidates too large for the remaining space are skipped in favor of the
+// smaller ones after them.
+type packageStrategy struct{}
+
+// newPackageStrategy returns a new package strategy.  It implements the
+// NewStrategyFunc type.
+func newPackageStrategy(*Policy) Strategy {
+	return packageStrategy{}
+}
+
+// Less returns whether candidate a should be considered before candidate b.
+// It is part of the Strategy interface.
+func (packageStrategy) Less(a, b *Candidate) bool {
+	aFeeRate, bFeeRate := a.FeeRate(), b.FeeRate()
+	if aFeeRate != bFeeRate {
+		return aFeeRate > bFeeRate
+	}
+	if a.Weight != b.Weight {
+		return a.Weight < b.Weight
+	}
+	return a.Fee > b.Fee
+}
+
+// Consider decides what to do with the passed candidate.  It is part of the
+// Strategy interface.
+func (packageStrategy) Consider(c *Candidate, state *SelectionState) (Decision, bool) {
+	if belowMinFee(c, c.FeeRate(), state) {
+		return Skip, false
+	}
+	return Include, false
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miningdag
+
+import (
+	"sync"
+
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/soterutil"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// templateCache houses the state the block template generator reuses between
+// templates, so a template isn't rebuilt from scratch on every request.
+//
+// The last template is reused as long as neither the tips of the DAG nor the
+// generation of the transaction source changed.  When only the transaction
+// source changed, the transactions are selected again, but the outputs they
+// spend which were already fetched for the same tips are not fetched again, so
+// only the transactions added since the last template are looked up.  All of
+// the state is invalidated when the tips change.
+type templateCache struct {
+	mtx sync.Mutex
+
+	// tipsHash is the hash of the tips the cached state is valid for.
+	tipsHash chainhash.Hash
+
+	// template is the last template generated for the tips, along with
+	// the generation of the transaction source and the key of the payouts
+	// of its coinbase.
+	template   *BlockTemplate
+	generation uint64
+	payouts    string
+
+	// utxos are the outputs referenced by the transactions of the source,
+	// as fetched from the DAG for the tips, by transaction hash.
+	utxos map[chainhash.Hash]*blockdag.UtxoViewpoint
+}
+
+// newTemplateCache returns a new empty template cache.
+func newTemplateCache() *templateCache {
+	return &templateCache{
+		utxos: make(map[chainhash.Hash]*blockdag.UtxoViewpoint),
+	}
+}
+
+// reset invalidates all of the cached state unless it's valid for the passed
+// tips hash.
+//
+// This function MUST be called with the cache lock held (for writes).
+func (c *templateCache) reset(tipsHash *chainhash.Hash) {
+	if c.tipsHash == *tipsHash {
+		return
+	}
+
+	c.tipsHash = *tipsHash
+	c.template = nil
+	c.utxos = make(map[chainhash.Hash]*blockdag.UtxoViewpoint)
+}
+
+// lookupTemplate returns a copy of the cached template if it was generated for
+// the passed tips, generation of the transaction source and payouts, or nil
+// otherwise.
+//
+// This function is safe for concurrent access.
+func (c *templateCache) lookupTemplate(tipsHash *chainhash.Hash, generation uint64, payouts []Payout) *BlockTemplate {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.reset(tipsHash)
+	if c.template == nil || c.generation != generation ||
+		c.payouts != payoutsKey(payouts) {
+
+		return nil
+	}
+	return copyTemplate(c.template)
+}
+
+// storeTemplate caches a copy of the passed template generated for the passed
+// tips, generation of the transaction source and payouts.
+//
+// This function is safe for concurrent access.
+func (c *templateCache) storeTemplate(tipsHash *chainhash.Hash, generation uint64, payouts []Payout, template *BlockTemplate) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.reset(tipsHash)
+	c.template = copyTemplate(template)
+	c.generation = generation
+	c.payouts = payoutsKey(payouts)
+}
+
+// fetchUtxoView returns the outputs referenced by the passed transaction from
+// the point of view of the passed tips, using the outputs cached for the tips
+// when available.  The returned view is a copy the caller may modify.
+//
+// This function is safe for concurrent access.
+func (c *templateCache) fetchUtxoView(chain *blockdag.BlockDAG, tipsHash *chainhash.Hash, tx *soterutil.Tx) (*blockdag.UtxoViewpoint, error) {
+	c.mtx.Lock()
+	c.reset(tipsHash)
+	cached, ok := c.utxos[*tx.Hash()]
+	c.mtx.Unlock()
+
+	if !ok {
+		var err error
+		cached, err = chain.FetchUtxoView(tx)
+		if err != nil {
+			return nil, err
+		}
+
+		c.mtx.Lock()
+		if c.tipsHash == *tipsHash {
+			c.utxos[*tx.Hash()] = cached
+		}
+		c.mtx.Unlock()
+	}
+
+	// The entries of the view are spent while generating the template, so
+	// the cached ones are cloned.
+	view := blockdag.NewUtxoViewpoint()
+	entries := view.Entries()
+	for outpoint, entry := range cached.Entries() {
+		entries[outpoint] = entry.Clone()
+	}
+	return view, nil
+}
+
+// prune removes the cached outputs of the transactions which aren't in the
+// passed transactions of the source anymore.
+//
+// This function is safe for concurrent access.
+func (c *templateCache) prune(sourceTxns []*TxDesc) {
+	keep := make(map[chainhash.Hash]struct{}, len(sourceTxns))
+	for _, txDesc := range sourceTxns {
+		keep[*txDesc.Tx.Hash()] = struct{}{}
+	}
+
+	c.mtx.Lock()
+	for hash := range c.utxos {
+		if _, ok := keep[hash]; !ok {
+			delete(c.utxos, hash)
+		}
+	}
+	c.mtx.Unlock()
+}
+
+// copyTemplate returns a copy of the passed template which can be modified
+// without affecting the original, as is done while solving it.  The
+// transactions other than the coinbase are shared, since they're never
+// modified.
+func copyTemplate(template *BlockTemplate) *BlockTemplate {
+	tmpl := *template
+	src := template.Block
+
+	msgBlock := &wire.MsgBlock{
+		Header:       src.Header,
+		Parents:      src.Parents,
+		Verification: src.Verification,
+		Transactions: make([]*wire.MsgTx, len(src.Transactions)),
+	}
+	copy(msgBlock.Transactions, src.Transactions)
+	if len(msgBlock.Transactions) > 0 {
+		msgBlock.Transactions[0] = src.Transactions[0].Copy()
+	}
+	tmpl.Block = msgBlock
+
+	tmpl.Fees = append([]int64(nil), template.Fees...)
+	tmpl.SigOpCosts = append([]int64(nil), template.SigOpCosts...)
+	tmpl.ParentHashes = append([]chainhash.Hash(nil), template.ParentHashes...)
+	return &tmpl
+}
@@ -7,6 +7,7 @@ package netsync
 
 import (
 	"container/list"
+	"fmt"
 	"math/rand"
 	"net"
 	"sync"
@@ -50,6 +51,10 @@ const (
 	// stallSampleInterval the interval at which we will check to see if our sync has stalled.
 	// It's also used to determine if a request is eligible to retry
 	stallSampleInterval = 30 * time.Second
+
+	// maxAnnouncedHeaders is the maximum number of unrequested headers a
+	// peer may send at once to announce new blocks, see sendheaders.
+	maxAnnouncedHeaders = 8
 )
 
 // zeroHash is the zero value hash (all zeros).  It is defined as a convenience.
@@ -145,6 +150,7 @@ type headerNode struct {
 // about a peer.
 type peerSyncState struct {
 	syncCandidate   bool
+	sentSendHeaders bool
 	requestQueue    []*wire.InvVect
 	requestedTxns   map[chainhash.Hash]*requestExpiry
 	requestedBlocks map[chainhash.Hash]*requestExpiry
@@ -498,11 +504,20 @@ func (sm *SyncManager) handleNewPeerMsg(peer *peerpkg.Peer) {
 
 	// Initialize the peer state
 	isSyncCandidate := sm.isSyncCandidate(peer)
-	sm.peerStates[peer] = &peerSyncState{
+	state := &peerSyncState{
 		syncCandidate:   isSyncCandidate,
 		requestedTxns:   make(map[chainhash.Hash]*requestExpiry),
 		requestedBlocks: make(map[chainhash.Hash]*requestExpiry),
 	}
+	sm.peerStates[peer] = state
+
+	// Ask peers which understand it to announce new blocks via headers
+	// instead of inv messages.  Only these peers may send unrequested
+	// headers.
+	if peer.Features().Has(peerpkg.FeatureSendHeaders) {
+		peer.QueueMessage(wire.NewMsgSendHeaders(), nil)
+		state.sentSendHeaders = true
+	}
 
 	// Start syncing by choosing the best candidate if needed.
 	if isSyncCandidate && sm.syncPeer == nil {
@@ -580,6 +595,7 @@ func (sm *SyncManager) handleDonePeerMsg(peer *peerpkg.Peer) {
 	log.Infof("Lost peer %s", peer)
 
 	sm.clearRequestedState(state)
+	sm.txMemPool.RemovePeerRateLimit(mempool.Tag(peer.ID()))
 
 	if peer == sm.syncPeer {
 		// Update the sync peer. The server has already disconnected the
@@ -630,6 +646,22 @@ func (sm *SyncManager) updateSyncPeer(dcSyncPeer bool) {
 	sm.startSync()
 }
 
+// announceTransactions announces the passed transactions, which were accepted
+// to the memory pool, to the other peers.  Conflicted transactions are kept in
+// the pool in case the block confirming their double spend loses the ordering
+// of the DAG, but they aren't relayed since they may never be confirmed.
+func (sm *SyncManager) announceTransactions(txns []*mempool.TxDesc) {
+	relayed := make([]*mempool.TxDesc, 0, len(txns))
+	for _, txD := range txns {
+		if !sm.txMemPool.IsConflicted(txD.Tx.Hash()) {
+			relayed = append(relayed, txD)
+		}
+	}
+	if len(relayed) > 0 {
+		sm.peerNotifier.AnnounceNewTransactions(relayed)
+	}
+}
+
 // handleTxMsg handles transaction messages from all peers.
 func (sm *SyncManager) handleTxMsg(tmsg *txMsg) {
 	peer := tmsg.peer
@@ -695,7 +727,7 @@ func (sm *SyncManager) handleTxMsg(tmsg *txMsg) {
 		return
 	}
 
-	sm.peerNotifier.AnnounceNewTransactions(acceptedTxs)
+	sm.announceTransactions(acceptedTxs)
 }
 
 // current returns true if we believe we are synced with our peers, false if we
@@ -1075,22 +1107,23 @@ func (sm *SyncManager) filterNeededInv(peer *peerpkg.Peer, inventory []*wire.Inv
 }
 
 // handleHeadersMsg handles block header messages from all peers.  Headers are
-// requested when performing a headers-first sync.
+// requested when performing a headers-first sync, and announce new blocks
+// otherwise.
 func (sm *SyncManager) handleHeadersMsg(hmsg *headersMsg) {
 	peer := hmsg.peer
-	_, exists := sm.peerStates[peer]
+	state, exists := sm.peerStates[peer]
 	if !exists {
 		log.Warnf("Received headers message from unknown peer %s", peer)
 		return
 	}
 
-	// The remote peer is misbehaving if we didn't request headers.
+	// Unrequested headers are announcements of new blocks by peers we
+	// asked to announce blocks via headers.  Only the sync peer is asked
+	// for headers in headers-first mode.
 	msg := hmsg.headers
 	numHeaders := len(msg.Headers)
-	if !sm.headersFirstMode {
-		log.Warnf("Got %d unrequested headers from %s -- "+
-			"disconnecting", numHeaders, peer.Addr())
-		peer.Disconnect()
+	if !sm.headersFirstMode || peer != sm.syncPeer {
+		sm.handleHeadersAnnouncement(peer, state, msg)
 		return
 	}
 
@@ -1270,8 +1303,7 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 	if len(inventory) == 1 && inventory[0].Hash.IsEqual(&zeroHash) {
 		// Peer is indicating that it has more blocks to send us, so ask for blocks
 		// from our current tips to theirs.
-		maxHeight := sm.chain.DAGSnapshot().MaxHeight
-		locator := sm.chain.BlockLocatorFromHeight(maxHeight)
+		locator := sm.chain.LocatorFromTips(sm.chain.TipHashes())
 		err := peer.PushGetBlocksMsg(locator, &zeroHash)
 		if err != nil {
 			log.Warnf("Failed to send getblocks message to peer %s: %s", peer, err)
@@ -1322,6 +1354,62 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 	sm.reqOrphanParents(peer)
 }
 
+// checkHeadersAnnouncement returns an error when a peer with the passed state
+// may not announce the passed number of headers, because we didn't send it a
+// sendheaders message or because an announcement may not contain that many
+// headers.
+func checkHeadersAnnouncement(state *peerSyncState, numHeaders int) error {
+	if !state.sentSendHeaders {
+		return fmt.Errorf("got %d unrequested headers without sending "+
+			"sendheaders", numHeaders)
+	}
+	if numHeaders > maxAnnouncedHeaders {
+		return fmt.Errorf("got %d unrequested headers, more than the "+
+			"max of %d", numHeaders, maxAnnouncedHeaders)
+	}
+	return nil
+}
+
+// handleHeadersAnnouncement handles unrequested headers announcing new blocks,
+// which peers send instead of inv messages once we sent them a sendheaders
+// message.  The announced blocks we don't have are requested the same way as
+// when they are announced via inv messages, along with the missing parents of
+// orphans.  Peers sending unrequested headers without being sent sendheaders,
+// or more headers than an announcement may contain, are misbehaving.
+func (sm *SyncManager) handleHeadersAnnouncement(peer *peerpkg.Peer,
+	state *peerSyncState, msg *wire.MsgHeaders) {
+
+	numHeaders := len(msg.Headers)
+	if err := checkHeadersAnnouncement(state, numHeaders); err != nil {
+		log.Warnf("%v from %s -- disconnecting", err, peer.Addr())
+		peer.Disconnect()
+		return
+	}
+	if numHeaders == 0 {
+		return
+	}
+
+	// If we're in sync mode, don't process announcements from non-sync
+	// peers.
+	if sm.syncPeer != nil && peer != sm.syncPeer {
+		log.Debugf("Ignoring %d announced headers from peer %v, because "+
+			"we are in sync mode", numHeaders, peer)
+		return
+	}
+
+	inventory := make([]*wire.InvVect, 0, numHeaders)
+	for _, blockHeader := range msg.Headers {
+		blockHash := blockHeader.BlockHash()
+		iv := wire.NewInvVect(wire.InvTypeBlock, &blockHash, -1)
+		peer.AddKnownInventory(iv)
+		inventory = append(inventory, iv)
+	}
+	peer.UpdateLastAnnouncedBlock(&inventory[len(inventory)-1].Hash)
+
+	sm.reqBlocks(peer, inventory)
+	sm.reqOrphanParents(peer)
+}
+
 // reqOrphanChildren sends a getblocks message to the peer, for blocks between the height of the orphan parent to
 // each child orphan block.
 func (sm *SyncManager) reqOrphanChildren(peer *peerpkg.Peer, parent *soterutil.Block) {
@@ -1661,19 +1749,27 @@ func (sm *SyncManager) handleBlockchainNotification(notification *blockdag.Notif
 		}
 
 		// Remove all of the transactions (except the coinbase) in the
-		// connected block from the transaction pool.  Secondly, remove any
+		// connected block from the transaction pool.  Secondly, mark any
 		// transactions which are now double spends as a result of these
-		// new transactions.  Finally, remove any transaction that is
-		// no longer an orphan. Transactions which depend on a confirmed
+		// new transactions as conflicted, since the block may still lose
+		// the ordering of the DAG.  Finally, remove any transaction that
+		// is no longer an orphan. Transactions which depend on a confirmed
 		// transaction are NOT removed recursively because they are still
 		// valid.
 		for _, tx := range block.Transactions()[1:] {
-			sm.txMemPool.RemoveTransaction(tx, false)
-			sm.txMemPool.RemoveDoubleSpends(tx)
+			sm.txMemPool.ConfirmTransaction(tx, block.Hash())
 			sm.txMemPool.RemoveOrphan(tx)
 			sm.peerNotifier.TransactionConfirmed(tx)
 			acceptedTxs := sm.txMemPool.ProcessOrphans(tx)
-			sm.peerNotifier.AnnounceNewTransactions(acceptedTxs)
+			sm.announceTransactions(acceptedTxs)
+		}
+
+		// Remove expired transactions, and periodically re-check the
+		// remaining ones against the new state of the DAG since their
+		// inputs may have been spent by blocks on other tips.
+		if err := sm.txMemPool.Maintain(); err != nil {
+			log.Warnf("Unable to maintain the transaction pool: %v",
+				err)
 		}
 
 		// Register block with the fee estimator, if it exists.
@@ -1698,8 +1794,10 @@ func (sm *SyncManager) handleBlockchainNotification(notification *blockdag.Notif
 			break
 		}
 
-		// Reinsert all of the transactions (except the coinbase) into
+		// Forget about the conflicts caused by the block, then
+		// reinsert all of the transactions (except the coinbase) into
 		// the transaction pool.
+		sm.txMemPool.DisconnectBlock(block.Hash())
 		for _, tx := range block.Transactions()[1:] {
 			_, _, err := sm.txMemPool.MaybeAcceptTransaction(tx,
 				false, false)
@@ -0,0 +1,141 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/soteria-dag/soterd/blockdag"
+	"github.com/soteria-dag/soterd/chaincfg"
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/database"
+	_ "github.com/soteria-dag/soterd/database/ffldb"
+	peerpkg "github.com/soteria-dag/soterd/peer"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// TestCheckHeadersAnnouncement ensures unrequested headers are only accepted
+// as announcements from peers we sent sendheaders to, and only up to the max
+// number of headers an announcement may contain.
+func TestCheckHeadersAnnouncement(t *testing.T) {
+	tests := []struct {
+		name            string
+		sentSendHeaders bool
+		numHeaders      int
+		wantErr         bool
+	}{
+		{"no sendheaders", false, 1, true},
+		{"no sendheaders, no headers", false, 0, true},
+		{"no headers", true, 0, false},
+		{"one header", true, 1, false},
+		{"max headers", true, maxAnnouncedHeaders, false},
+		{"above max headers", true, maxAnnouncedHeaders + 1, true},
+	}
+
+	for _, test := range tests {
+		state := &peerSyncState{sentSendHeaders: test.sentSendHeaders}
+		err := checkHeadersAnnouncement(state, test.numHeaders)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.name, err,
+				test.wantErr)
+		}
+	}
+}
+
+// TestHandleHeadersAnnouncement ensures the blocks announced via headers are
+// recorded as announced by the peer, and that rejected announcements are
+// ignored.
+func TestHandleHeadersAnnouncement(t *testing.T) {
+	dbPath, err := ioutil.TempDir("", "netsync")
+	if err != nil {
+		t.Fatalf("unable to create test db dir: %v", err)
+	}
+	defer os.RemoveAll(dbPath)
+	db, err := database.Create("ffldb", filepath.Join(dbPath, "db"),
+		chaincfg.SimNetParams.Net)
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer db.Close()
+
+	params := chaincfg.SimNetParams
+	chain, err := blockdag.New(&blockdag.Config{
+		DB:          db,
+		ChainParams: &params,
+		TimeSource:  blockdag.NewMedianTime(),
+	})
+	if err != nil {
+		t.Fatalf("failed to create chain instance: %v", err)
+	}
+
+	sm := &SyncManager{
+		chain:           chain,
+		chainParams:     &params,
+		rejectedTxns:    make(map[chainhash.Hash]struct{}),
+		requestedTxns:   make(map[chainhash.Hash]*requestExpiry),
+		requestedBlocks: make(map[chainhash.Hash]*requestExpiry),
+		peerStates:      make(map[*peerpkg.Peer]*peerSyncState),
+	}
+
+	// announce returns a headers message announcing the passed number of
+	// blocks, and the hash of the last one.
+	announce := func(numHeaders int) (*wire.MsgHeaders, chainhash.Hash) {
+		msg := wire.NewMsgHeaders()
+		var last chainhash.Hash
+		for i := 0; i < numHeaders; i++ {
+			header := params.GenesisBlock.Header
+			header.Nonce = uint32(i + 1)
+			if err := msg.AddBlockHeader(&header); err != nil {
+				t.Fatalf("AddBlockHeader: %v", err)
+			}
+			last = header.BlockHash()
+		}
+		return msg, last
+	}
+
+	newPeer := func(sentSendHeaders bool) (*peerpkg.Peer, *peerSyncState) {
+		peer, err := peerpkg.NewOutboundPeer(&peerpkg.Config{
+			ChainParams: &params,
+		}, "127.0.0.1:18555")
+		if err != nil {
+			t.Fatalf("NewOutboundPeer: %v", err)
+		}
+		state := &peerSyncState{
+			sentSendHeaders: sentSendHeaders,
+			requestedTxns:   make(map[chainhash.Hash]*requestExpiry),
+			requestedBlocks: make(map[chainhash.Hash]*requestExpiry),
+		}
+		sm.peerStates[peer] = state
+		return peer, state
+	}
+
+	// Announcements from peers we sent sendheaders to are accepted.
+	peer, state := newPeer(true)
+	msg, last := announce(2)
+	sm.handleHeadersAnnouncement(peer, state, msg)
+	if got := peer.LastAnnouncedBlock(); got == nil || *got != last {
+		t.Fatalf("last announced block is %v, want %v", got, last)
+	}
+
+	// Announcements with more headers than allowed are ignored.
+	msg, _ = announce(maxAnnouncedHeaders + 1)
+	sm.handleHeadersAnnouncement(peer, state, msg)
+	if got := peer.LastAnnouncedBlock(); got == nil || *got != last {
+		t.Fatalf("last announced block is %v after an oversized "+
+			"announcement, want %v", got, last)
+	}
+
+	// Announcements from peers we didn't send sendheaders to are ignored.
+	peer, state = newPeer(false)
+	msg, _ = announce(1)
+	sm.handleHeadersAnnouncement(peer, state, msg)
+	if got := peer.LastAnnouncedBlock(); got != nil {
+		t.Fatalf("last announced block is %v for a peer not sent "+
+			"sendheaders, want none", got)
+	}
+}
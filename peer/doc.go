@@ -21,6 +21,8 @@ A quick overview of the major features peer provides are as follows:
  - Full duplex reading and writing of soter protocol messages
  - Automatic handling of the initial handshake process including protocol
    version negotiation
+ - Table of the optional protocol features negotiated with each peer, based on
+   the negotiated protocol version and the services it advertised
  - Asynchronous message queuing of outbound messages with optional channel for
    notification when the message is actually sent
  - Flexible peer configuration
@@ -0,0 +1,129 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// Feature identifies an optional part of the wire protocol which is only used
+// with peers that negotiated a recent enough protocol version or advertised the
+// services it depends on.
+type Feature int
+
+// These constants define the features which may be negotiated with a peer.
+const (
+	// FeaturePong indicates the peer replies to ping messages with pong
+	// messages.
+	FeaturePong Feature = iota
+
+	// FeatureReject indicates the peer understands reject messages.
+	FeatureReject
+
+	// FeatureSendHeaders indicates the peer understands sendheaders
+	// messages.
+	FeatureSendHeaders
+
+	// FeatureFeeFilter indicates the peer understands feefilter messages.
+	FeatureFeeFilter
+
+	// FeatureBloomFilter indicates the peer serves bloom filtered
+	// connections.
+	FeatureBloomFilter
+
+	// FeatureWitness indicates the peer relays witness data along with
+	// transactions and blocks.
+	FeatureWitness
+
+	// FeatureBloomService indicates the peer knows bloom filter requests
+	// are only allowed when the SFNodeBloom service is advertised (BIP0111).
+	FeatureBloomService
+
+	// numFeatures is the number of known features.  It MUST be the last
+	// entry.
+	numFeatures
+)
+
+// featureStrings is a map of features back to their constant names for pretty
+// printing.
+var featureStrings = map[Feature]string{
+	FeaturePong:         "FeaturePong",
+	FeatureReject:       "FeatureReject",
+	FeatureSendHeaders:  "FeatureSendHeaders",
+	FeatureFeeFilter:    "FeatureFeeFilter",
+	FeatureBloomFilter:  "FeatureBloomFilter",
+	FeatureWitness:      "FeatureWitness",
+	FeatureBloomService: "FeatureBloomService",
+}
+
+// String returns the Feature as a human-readable name.
+func (f Feature) String() string {
+	if s, ok := featureStrings[f]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown Feature (%d)", int(f))
+}
+
+// featureRequirement describes what a peer must negotiate for a feature to be
+// used with it.
+type featureRequirement struct {
+	// minVersion is the lowest negotiated protocol version supporting the
+	// feature.
+	minVersion uint32
+
+	// services are the services the peer must advertise for the feature.
+	services wire.ServiceFlag
+}
+
+// featureTable maps each feature to its requirements.  New messages should be
+// gated by adding a feature here rather than by comparing protocol versions
+// in the peer code.
+var featureTable = [numFeatures]featureRequirement{
+	FeaturePong:         {minVersion: wire.BIP0031Version + 1},
+	FeatureReject:       {minVersion: wire.RejectVersion},
+	FeatureSendHeaders:  {minVersion: wire.SendHeadersVersion},
+	FeatureFeeFilter:    {minVersion: wire.FeeFilterVersion},
+	FeatureBloomFilter:  {services: wire.SFNodeBloom},
+	FeatureWitness:      {services: wire.SFNodeWitness},
+	FeatureBloomService: {minVersion: wire.BIP0111Version},
+}
+
+// NegotiatedFeatures describes the features which may be used with a peer,
+// as determined by the negotiated protocol version and the services the peer
+// advertised during the version handshake.
+type NegotiatedFeatures struct {
+	// ProtocolVersion is the negotiated protocol version.
+	ProtocolVersion uint32
+
+	// Services are the services advertised by the peer.
+	Services wire.ServiceFlag
+}
+
+// Has returns whether the passed feature may be used with the peer.  Unknown
+// features are never supported.
+func (f NegotiatedFeatures) Has(feature Feature) bool {
+	if feature < 0 || feature >= numFeatures {
+		return false
+	}
+
+	req := featureTable[feature]
+	return f.ProtocolVersion >= req.minVersion &&
+		f.Services&req.services == req.services
+}
+
+// String returns the supported features in a human-readable form.
+func (f NegotiatedFeatures) String() string {
+	var names []string
+	for feature := Feature(0); feature < numFeatures; feature++ {
+		if f.Has(feature) {
+			names = append(names, feature.String())
+		}
+	}
+	return fmt.Sprintf("version %d, features [%s]", f.ProtocolVersion,
+		strings.Join(names, ", "))
+}
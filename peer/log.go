@@ -111,6 +111,10 @@ func invSummary(invList []*wire.InvVect) string {
 
 // locatorSummary returns a block locator as a human-readable string.
 func locatorSummary(locator []*int32, stopHash *chainhash.Hash) string {
+	if len(locator) > 1 {
+		return fmt.Sprintf("locator %d-%d, stop %s", *locator[0],
+			*locator[len(locator)-1], stopHash)
+	}
 	if len(locator) > 0 {
 		return fmt.Sprintf("locator %d, stop %s", *locator[0], stopHash)
 	}
@@ -387,6 +387,9 @@ type StatsSnap struct {
 	LastPingNonce  uint64
 	LastPingTime   time.Time
 	LastPingMicros int64
+	LastPingRTT    time.Duration
+	MinPingRTT     time.Duration
+	FeeFilter      int64
 }
 
 // HashFunc is a function which returns a block hash, height and error
@@ -453,6 +456,7 @@ type Peer struct {
 	advertisedProtoVer   uint32 // protocol version advertised by remote
 	protocolVersion      uint32 // negotiated protocol version
 	sendHeadersPreferred bool   // peer sent a sendheaders message
+	feeFilter            int64  // min fee rate the peer wants relayed
 	verAckReceived       bool
 	witnessEnabled       bool
 
@@ -474,9 +478,11 @@ type Peer struct {
 	startingHeight     int32
 	maxBlockHeight     int32
 	lastAnnouncedBlock *chainhash.Hash
-	lastPingNonce      uint64    // Set to nonce if we have a pending ping.
-	lastPingTime       time.Time // Time we sent last ping.
-	lastPingMicros     int64     // Time for last ping to return.
+	lastPingNonce      uint64        // Set to nonce if we have a pending ping.
+	lastPingTime       time.Time     // Time we sent last ping.
+	lastPingMicros     int64         // Time for last ping to return.
+	lastPingRTT        time.Duration // Round-trip time of last ping.
+	minPingRTT         time.Duration // Lowest round-trip time seen so far.
 
 	stallControl  chan stallControlMsg
 	outputQueue   chan outMsg
@@ -555,6 +561,7 @@ func (p *Peer) StatsSnapshot() *StatsSnap {
 	userAgent := p.userAgent
 	services := p.services
 	protocolVersion := p.advertisedProtoVer
+	feeFilter := p.feeFilter
 	p.flagsMtx.Unlock()
 
 	// Get a copy of all relevant flags and stats.
@@ -576,6 +583,9 @@ func (p *Peer) StatsSnapshot() *StatsSnap {
 		LastPingNonce:  p.lastPingNonce,
 		LastPingMicros: p.lastPingMicros,
 		LastPingTime:   p.lastPingTime,
+		LastPingRTT:    p.lastPingRTT,
+		MinPingRTT:     p.minPingRTT,
+		FeeFilter:      feeFilter,
 	}
 
 	p.statsMtx.RUnlock()
@@ -686,6 +696,33 @@ func (p *Peer) LastPingMicros() int64 {
 	return lastPingMicros
 }
 
+// LastPingRTT returns the round-trip time of the last ping sent to the remote
+// peer which was answered, at full timer resolution.  It is zero until a ping
+// has been answered.
+//
+// This function is safe for concurrent access.
+func (p *Peer) LastPingRTT() time.Duration {
+	p.statsMtx.RLock()
+	lastPingRTT := p.lastPingRTT
+	p.statsMtx.RUnlock()
+
+	return lastPingRTT
+}
+
+// MinPingRTT returns the lowest round-trip time of the pings sent to the remote
+// peer, which is a better estimate of the network latency to the peer than the
+// last round-trip time as it excludes time spent queueing messages.  It is zero
+// until a ping has been answered.
+//
+// This function is safe for concurrent access.
+func (p *Peer) MinPingRTT() time.Duration {
+	p.statsMtx.RLock()
+	minPingRTT := p.minPingRTT
+	p.statsMtx.RUnlock()
+
+	return minPingRTT
+}
+
 // VersionKnown returns the whether or not the version of a peer is known
 // locally.
 //
@@ -721,6 +758,23 @@ func (p *Peer) ProtocolVersion() uint32 {
 	return protocolVersion
 }
 
+// Features returns the features negotiated with the peer, based on the
+// negotiated protocol version and the services the peer advertised.  Until the
+// version handshake completes, it reflects the local maximum protocol version
+// and no services.
+//
+// This function is safe for concurrent access.
+func (p *Peer) Features() NegotiatedFeatures {
+	p.flagsMtx.Lock()
+	features := NegotiatedFeatures{
+		ProtocolVersion: p.protocolVersion,
+		Services:        p.services,
+	}
+	p.flagsMtx.Unlock()
+
+	return features
+}
+
 // MaxBlockHeight returns the peer's max block height seen so far.
 //
 // This function is safe for concurrent access.
@@ -819,6 +873,28 @@ func (p *Peer) WantsHeaders() bool {
 	return sendHeadersPreferred
 }
 
+// FeeFilter returns the minimum fee rate in nanoSoter/kB of the transactions
+// the peer wants relayed to it, as advertised by its most recent feefilter
+// message.  It is zero when the peer didn't send one.
+//
+// This function is safe for concurrent access.
+func (p *Peer) FeeFilter() int64 {
+	p.flagsMtx.Lock()
+	feeFilter := p.feeFilter
+	p.flagsMtx.Unlock()
+
+	return feeFilter
+}
+
+// WantsFeeRate returns whether the peer wants transactions paying the passed
+// fee rate in nanoSoter/kB relayed to it according to its fee filter, so that
+// announcing transactions it would ignore can be avoided.
+//
+// This function is safe for concurrent access.
+func (p *Peer) WantsFeeRate(feePerKB int64) bool {
+	return feePerKB >= p.FeeFilter()
+}
+
 // IsWitnessEnabled returns true if the peer has signalled that it supports
 // segregated witness.
 //
@@ -1027,9 +1103,9 @@ func (p *Peer) PushGetHeadersMsg(locator blockdag.BlockLocator, stopHash *chainh
 //
 // This function is safe for concurrent access.
 func (p *Peer) PushRejectMsg(command string, code wire.RejectCode, reason string, hash *chainhash.Hash, wait bool) {
-	// Don't bother sending the reject message if the protocol version
-	// is too low.
-	if p.VersionKnown() && p.ProtocolVersion() < wire.RejectVersion {
+	// Don't bother sending the reject message if the peer doesn't
+	// understand it.
+	if p.VersionKnown() && !p.Features().Has(FeatureReject) {
 		return
 	}
 
@@ -1056,13 +1132,34 @@ func (p *Peer) PushRejectMsg(command string, code wire.RejectCode, reason string
 	<-doneChan
 }
 
+// isValidBIP0111 returns whether the bloom filter request with the passed
+// command may be handled, which is only the case when the local peer advertises
+// the SFNodeBloom service.  Peers recent enough to know about this requirement
+// are disconnected for sending such requests anyway, while requests from older
+// peers are just ignored.
+func (p *Peer) isValidBIP0111(cmd string) bool {
+	if p.cfg.Services&wire.SFNodeBloom == wire.SFNodeBloom {
+		return true
+	}
+
+	if p.Features().Has(FeatureBloomService) {
+		log.Debugf("%s sent an unsupported %s request -- "+
+			"disconnecting", p, cmd)
+		p.Disconnect()
+	} else {
+		log.Debugf("Ignoring %s request from %s -- bloom support is "+
+			"disabled", cmd, p)
+	}
+	return false
+}
+
 // handlePingMsg is invoked when a peer receives a ping soter message.  For
 // recent clients (protocol version > BIP0031Version), it replies with a pong
 // message.  For older clients, it does nothing and anything other than failure
 // is considered a successful ping.
 func (p *Peer) handlePingMsg(msg *wire.MsgPing) {
 	// Only reply with pong if the message is from a new enough client.
-	if p.ProtocolVersion() > wire.BIP0031Version {
+	if p.Features().Has(FeaturePong) {
 		// Include nonce from ping so pong can be identified.
 		p.QueueMessage(wire.NewMsgPong(msg.Nonce), nil)
 	}
@@ -1080,10 +1177,14 @@ func (p *Peer) handlePongMsg(msg *wire.MsgPong) {
 	// and overlapping pings will be ignored. It is unlikely to occur
 	// without large usage of the ping rpc call since we ping infrequently
 	// enough that if they overlap we would have timed out the peer.
-	if p.ProtocolVersion() > wire.BIP0031Version {
+	if p.Features().Has(FeaturePong) {
 		p.statsMtx.Lock()
 		if p.lastPingNonce != 0 && msg.Nonce == p.lastPingNonce {
-			p.lastPingMicros = time.Since(p.lastPingTime).Nanoseconds()
+			p.lastPingRTT = time.Since(p.lastPingTime)
+			if p.minPingRTT == 0 || p.lastPingRTT < p.minPingRTT {
+				p.minPingRTT = p.lastPingRTT
+			}
+			p.lastPingMicros = p.lastPingRTT.Nanoseconds()
 			p.lastPingMicros /= 1000 // convert to usec.
 			p.lastPingNonce = 0
 		}
@@ -1587,21 +1688,40 @@ out:
 			}
 
 		case *wire.MsgFeeFilter:
+			// Ignore fee filters which can't be valid fee rates.
+			if msg.MinFee < 0 {
+				log.Debugf("Peer %v sent an invalid feefilter "+
+					"of %d -- ignoring", p, msg.MinFee)
+				break
+			}
+			p.flagsMtx.Lock()
+			p.feeFilter = msg.MinFee
+			p.flagsMtx.Unlock()
+
 			if p.cfg.Listeners.OnFeeFilter != nil {
 				p.cfg.Listeners.OnFeeFilter(p, msg)
 			}
 
 		case *wire.MsgFilterAdd:
+			if !p.isValidBIP0111(msg.Command()) {
+				break
+			}
 			if p.cfg.Listeners.OnFilterAdd != nil {
 				p.cfg.Listeners.OnFilterAdd(p, msg)
 			}
 
 		case *wire.MsgFilterClear:
+			if !p.isValidBIP0111(msg.Command()) {
+				break
+			}
 			if p.cfg.Listeners.OnFilterClear != nil {
 				p.cfg.Listeners.OnFilterClear(p, msg)
 			}
 
 		case *wire.MsgFilterLoad:
+			if !p.isValidBIP0111(msg.Command()) {
+				break
+			}
 			if p.cfg.Listeners.OnFilterLoad != nil {
 				p.cfg.Listeners.OnFilterLoad(p, msg)
 			}
@@ -1816,7 +1936,7 @@ out:
 			case *wire.MsgPing:
 				// Only expects a pong message in later protocol
 				// versions.  Also set up statistics.
-				if p.ProtocolVersion() > wire.BIP0031Version {
+				if p.Features().Has(FeaturePong) {
 					p.statsMtx.Lock()
 					p.lastPingNonce = m.Nonce
 					p.lastPingTime = time.Now()
@@ -2032,9 +2152,12 @@ func (p *Peer) readRemoteVersionMsg() error {
 	p.protocolVersion = minUint32(p.protocolVersion, p.advertisedProtoVer)
 	p.versionKnown = true
 	p.services = msg.Services
+	features := NegotiatedFeatures{
+		ProtocolVersion: p.protocolVersion,
+		Services:        p.services,
+	}
 	p.flagsMtx.Unlock()
-	log.Debugf("Negotiated protocol version %d for peer %s",
-		p.protocolVersion, p)
+	log.Debugf("Negotiated %v for peer %s", features, p)
 
 	// Updating a bunch of stats including block based stats, and the
 	// peer's time offset.
@@ -2051,7 +2174,7 @@ func (p *Peer) readRemoteVersionMsg() error {
 
 	// Determine if the peer would like to receive witness data with
 	// transactions, or not.
-	if p.services&wire.SFNodeWitness == wire.SFNodeWitness {
+	if features.Has(FeatureWitness) {
 		p.witnessEnabled = true
 	}
 	p.flagsMtx.Unlock()
@@ -2061,7 +2184,7 @@ func (p *Peer) readRemoteVersionMsg() error {
 	// protocol. If so, then we'll switch to a decoding mode which is
 	// prepared for the new transaction format introduced as part of
 	// BIP0144.
-	if p.services&wire.SFNodeWitness == wire.SFNodeWitness {
+	if features.Has(FeatureWitness) {
 		p.wireEncoding = wire.WitnessEncoding
 	}
 
@@ -607,6 +607,15 @@ func TestPeerListeners(t *testing.T) {
 			return
 		}
 	}
+
+	// The fee filter sent by the outbound peer is recorded.
+	if got := inPeer.FeeFilter(); got != 15000 {
+		t.Errorf("TestPeerListeners: fee filter is %d, want 15000", got)
+	}
+	if inPeer.WantsFeeRate(14999) || !inPeer.WantsFeeRate(15000) {
+		t.Errorf("TestPeerListeners: fee filter not applied")
+	}
+
 	inPeer.Disconnect()
 	outPeer.Disconnect()
 }
@@ -1156,6 +1165,205 @@ func TestDuplicateVersionMsg(t *testing.T) {
 	}
 }
 
+// TestUnsupportedFilterLoad ensures that a peer sending a bloom filter request
+// when bloom filters aren't supported is disconnected without invoking the
+// related callback.
+func TestUnsupportedFilterLoad(t *testing.T) {
+	// Create a pair of peers that are connected to each other using a fake
+	// connection, neither of which advertises bloom filter support.
+	verack := make(chan struct{})
+	filterLoad := make(chan struct{}, 1)
+	peerCfg := &peer.Config{
+		Listeners: peer.MessageListeners{
+			OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+				verack <- struct{}{}
+			},
+			OnFilterLoad: func(p *peer.Peer, msg *wire.MsgFilterLoad) {
+				filterLoad <- struct{}{}
+			},
+		},
+		UserAgentName:    "peer",
+		UserAgentVersion: semver.Version{Major: 1, Minor: 0, Patch: 0},
+		ChainParams:      &chaincfg.MainNetParams,
+		Services:         0,
+	}
+	inConn, outConn := pipe(
+		&conn{laddr: "10.0.0.1:9108", raddr: "10.0.0.2:9108"},
+		&conn{laddr: "10.0.0.2:9108", raddr: "10.0.0.1:9108"},
+	)
+	outPeer, err := peer.NewOutboundPeer(peerCfg, inConn.laddr)
+	if err != nil {
+		t.Fatalf("NewOutboundPeer: unexpected err: %v\n", err)
+	}
+	outPeer.AssociateConnection(outConn)
+	inPeer := peer.NewInboundPeer(peerCfg)
+	inPeer.AssociateConnection(inConn)
+	// Wait for the veracks from the initial protocol version negotiation.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-verack:
+		case <-time.After(time.Second):
+			t.Fatal("verack timeout")
+		}
+	}
+	// Send a filterload message from the outbound peer and ensure the
+	// recipient disconnects without handling it.
+	outPeer.QueueMessage(wire.NewMsgFilterLoad([]byte{0x01}, 10, 0,
+		wire.BloomUpdateNone), nil)
+	disconnected := make(chan struct{}, 1)
+	go func() {
+		inPeer.WaitForDisconnect()
+		disconnected <- struct{}{}
+	}()
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("peer did not disconnect")
+	}
+	select {
+	case <-filterLoad:
+		t.Fatal("OnFilterLoad invoked for unsupported request")
+	default:
+	}
+}
+
+// TestPingRTT ensures the round-trip time of a ping is recorded once the
+// matching pong is received.
+func TestPingRTT(t *testing.T) {
+	// Create a pair of peers that are connected to each other using a fake
+	// connection.
+	verack := make(chan struct{})
+	pong := make(chan struct{}, 1)
+	peerCfg := &peer.Config{
+		Listeners: peer.MessageListeners{
+			OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+				verack <- struct{}{}
+			},
+			OnPong: func(p *peer.Peer, msg *wire.MsgPong) {
+				pong <- struct{}{}
+			},
+		},
+		UserAgentName:    "peer",
+		UserAgentVersion: semver.Version{Major: 1, Minor: 0, Patch: 0},
+		ChainParams:      &chaincfg.MainNetParams,
+		Services:         0,
+	}
+	inConn, outConn := pipe(
+		&conn{laddr: "10.0.0.1:9108", raddr: "10.0.0.2:9108"},
+		&conn{laddr: "10.0.0.2:9108", raddr: "10.0.0.1:9108"},
+	)
+	outPeer, err := peer.NewOutboundPeer(peerCfg, inConn.laddr)
+	if err != nil {
+		t.Fatalf("NewOutboundPeer: unexpected err: %v\n", err)
+	}
+	outPeer.AssociateConnection(outConn)
+	inPeer := peer.NewInboundPeer(peerCfg)
+	inPeer.AssociateConnection(inConn)
+	// Wait for the veracks from the initial protocol version negotiation.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-verack:
+		case <-time.After(time.Second):
+			t.Fatal("verack timeout")
+		}
+	}
+
+	if rtt := outPeer.LastPingRTT(); rtt != 0 {
+		t.Fatalf("LastPingRTT: got %v before any ping, want 0", rtt)
+	}
+
+	// Ping the inbound peer and wait for its pong.
+	outPeer.QueueMessage(wire.NewMsgPing(42), nil)
+	select {
+	case <-pong:
+	case <-time.After(time.Second):
+		t.Fatal("pong timeout")
+	}
+
+	lastRTT := outPeer.LastPingRTT()
+	if lastRTT <= 0 {
+		t.Fatalf("LastPingRTT: got %v, want positive duration", lastRTT)
+	}
+	if minRTT := outPeer.MinPingRTT(); minRTT != lastRTT {
+		t.Fatalf("MinPingRTT: got %v, want %v", minRTT, lastRTT)
+	}
+	if snap := outPeer.StatsSnapshot(); snap.LastPingRTT != lastRTT {
+		t.Fatalf("StatsSnapshot: got LastPingRTT %v, want %v",
+			snap.LastPingRTT, lastRTT)
+	}
+
+	outPeer.Disconnect()
+	inPeer.Disconnect()
+}
+
+// TestNegotiatedFeatures ensures the features negotiated with a peer depend on
+// both the negotiated protocol version and the advertised services.
+func TestNegotiatedFeatures(t *testing.T) {
+	tests := []struct {
+		name     string
+		features peer.NegotiatedFeatures
+		feature  peer.Feature
+		want     bool
+	}{
+		{
+			name:     "pong at BIP0031Version",
+			features: peer.NegotiatedFeatures{ProtocolVersion: wire.BIP0031Version},
+			feature:  peer.FeaturePong,
+			want:     false,
+		},
+		{
+			name:     "pong after BIP0031Version",
+			features: peer.NegotiatedFeatures{ProtocolVersion: wire.BIP0031Version + 1},
+			feature:  peer.FeaturePong,
+			want:     true,
+		},
+		{
+			name:     "reject before RejectVersion",
+			features: peer.NegotiatedFeatures{ProtocolVersion: wire.RejectVersion - 1},
+			feature:  peer.FeatureReject,
+			want:     false,
+		},
+		{
+			name:     "fee filter at FeeFilterVersion",
+			features: peer.NegotiatedFeatures{ProtocolVersion: wire.FeeFilterVersion},
+			feature:  peer.FeatureFeeFilter,
+			want:     true,
+		},
+		{
+			name: "witness without service",
+			features: peer.NegotiatedFeatures{
+				ProtocolVersion: peer.MaxProtocolVersion,
+				Services:        wire.SFNodeNetwork,
+			},
+			feature: peer.FeatureWitness,
+			want:    false,
+		},
+		{
+			name: "witness with service",
+			features: peer.NegotiatedFeatures{
+				ProtocolVersion: peer.MaxProtocolVersion,
+				Services:        wire.SFNodeNetwork | wire.SFNodeWitness,
+			},
+			feature: peer.FeatureWitness,
+			want:    true,
+		},
+		{
+			name:     "unknown feature",
+			features: peer.NegotiatedFeatures{ProtocolVersion: peer.MaxProtocolVersion},
+			feature:  peer.Feature(-1),
+			want:     false,
+		},
+	}
+
+	for _, test := range tests {
+		got := test.features.Has(test.feature)
+		if got != test.want {
+			t.Errorf("%s: Has(%v) = %v, want %v", test.name,
+				test.feature, got, test.want)
+		}
+	}
+}
+
 func init() {
 	// Allow self connection when running the tests.
 	peer.TstAllowSelfConns()
@@ -0,0 +1,217 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpcclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sync"
+
+	"github.com/soteria-dag/soterd/chaincfg/chainhash"
+	"github.com/soteria-dag/soterd/wire"
+)
+
+// ErrBatchNoResponse is an error to describe the condition where the server
+// replied to a batch without including a response to one of its requests.
+var ErrBatchNoResponse = errors.New("no response to the request in the batch")
+
+// Batch queues the requests issued through it so they can be sent to the
+// server together.  It embeds a client, so the asynchronous form of every RPC
+// is available on it, and the futures those return deliver their results once
+// the batch has been sent and the server replied.
+//
+// The synchronous forms MUST NOT be used on a batch since they wait for the
+// reply to a request which is only sent along with the batch.
+//
+// When the client is running in HTTP POST mode, the requests are sent as a
+// single JSON-RPC batch.  Otherwise they are sent back to back over the
+// websocket connection without waiting for the reply to each of them.
+type Batch struct {
+	*Client
+
+	mtx      sync.Mutex
+	requests []*jsonRequest
+}
+
+// NewBatch returns a new empty batch of requests for the server of the client.
+// Requests issued through the batch are bound to the context of the client, if
+// any.
+func (c *Client) NewBatch() *Batch {
+	b := new(Batch)
+	b.Client = &Client{clientConn: c.clientConn, ctx: c.ctx, batch: b}
+	return b
+}
+
+// queue adds the passed request to the batch.
+//
+// This function is safe for concurrent access.
+func (b *Batch) queue(jReq *jsonRequest) {
+	b.mtx.Lock()
+	b.requests = append(b.requests, jReq)
+	b.mtx.Unlock()
+}
+
+// Len returns the number of requests queued on the batch.
+//
+// This function is safe for concurrent access.
+func (b *Batch) Len() int {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return len(b.requests)
+}
+
+// Send sends all of the requests queued on the batch to the server and empties
+// the batch, so it can be reused.  The results are delivered through the
+// futures returned when the requests were issued.
+//
+// Any error sending the batch is returned as well as delivered to all of the
+// futures of the batch.
+func (b *Batch) Send() error {
+	b.mtx.Lock()
+	requests := b.requests
+	b.requests = nil
+	b.mtx.Unlock()
+
+	if len(requests) == 0 {
+		return nil
+	}
+
+	// Send the requests through a client which doesn't belong to the batch,
+	// since they'd be queued again otherwise.
+	sender := &Client{clientConn: b.clientConn, ctx: b.ctx}
+	if !b.config.HTTPPostMode {
+		for _, jReq := range requests {
+			sender.sendRequest(jReq)
+		}
+		return nil
+	}
+
+	err := sender.sendPostBatch(requests)
+	if err != nil {
+		for _, jReq := range requests {
+			jReq.respond(&response{err: err})
+		}
+	}
+	return err
+}
+
+// sendPostBatch sends the passed requests to the server as a single JSON-RPC
+// batch using an HTTP POST request and delivers the responses to them.  The
+// returned error applies to all of the requests, which are left for the caller
+// to respond to in that case.
+func (c *Client) sendPostBatch(requests []*jsonRequest) error {
+	// Don't send the batch if shutting down.
+	select {
+	case <-c.shutdown:
+		return ErrClientShutdown
+	default:
+	}
+	if c.ctx != nil {
+		if err := c.ctx.Err(); err != nil {
+			return err
+		}
+	}
+
+	// Marshal the requests as a JSON array.
+	batch := make([]json.RawMessage, 0, len(requests))
+	for _, jReq := range requests {
+		batch = append(batch, jReq.marshalledJSON)
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := c.newPostRequest(c.ctx, body)
+	if err != nil {
+		return err
+	}
+
+	log.Tracef("Sending batch of %d commands", len(requests))
+	httpResponse, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+
+	// Read the raw bytes and close the response.
+	respBytes, err := ioutil.ReadAll(httpResponse.Body)
+	httpResponse.Body.Close()
+	if err != nil {
+		return fmt.Errorf("error reading json reply: %v", err)
+	}
+
+	// Try to unmarshal the response as an array of JSON-RPC responses.
+	var resps []struct {
+		ID *float64 `json:"id"`
+		rawResponse
+	}
+	if err := json.Unmarshal(respBytes, &resps); err != nil {
+		// When the response itself isn't a valid JSON-RPC batch response
+		// return an error which includes the HTTP status code and raw
+		// response bytes.
+		return fmt.Errorf("status code: %d, response: %q",
+			httpResponse.StatusCode, string(respBytes))
+	}
+
+	// Deliver each response to the request with the same id.  The
+	// responses may be in any order.
+	pending := make(map[uint64]*jsonRequest, len(requests))
+	for _, jReq := range requests {
+		pending[jReq.id] = jReq
+	}
+	for i := range resps {
+		resp := &resps[i]
+		if resp.ID == nil || *resp.ID < 0 || *resp.ID != math.Trunc(*resp.ID) {
+			log.Warn("Malformed batch response: invalid identifier")
+			continue
+		}
+		id := uint64(*resp.ID)
+		jReq, ok := pending[id]
+		if !ok {
+			log.Warnf("Received unexpected batch reply: %s (id %d)",
+				resp.Result, id)
+			continue
+		}
+		delete(pending, id)
+
+		res, err := resp.result()
+		jReq.respond(&response{result: res, err: err})
+	}
+	for _, jReq := range pending {
+		jReq.respond(&response{err: ErrBatchNoResponse})
+	}
+
+	return nil
+}
+
+// GetBlocks returns the raw blocks with the passed hashes from the server, in
+// the same order.  The blocks are requested in a single batch, which is much
+// faster than requesting them one at a time when fetching many blocks.
+func (c *Client) GetBlocks(blockHashes []*chainhash.Hash) ([]*wire.MsgBlock, error) {
+	batch := c.NewBatch()
+	futures := make([]FutureGetBlockResult, 0, len(blockHashes))
+	for _, hash := range blockHashes {
+		futures = append(futures, batch.GetBlockAsync(hash))
+	}
+	if err := batch.Send(); err != nil {
+		return nil, err
+	}
+
+	blocks := make([]*wire.MsgBlock, 0, len(futures))
+	for i, future := range futures {
+		block, err := future.Receive()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get block %v: %v",
+				blockHashes[i], err)
+		}
+		blocks = append(blocks, block)
+	}
+
+	return blocks, nil
+}
@@ -54,6 +54,31 @@ the Receive method on the returned instance will either return the result
 immediately if it has already arrived, or block until it has.  This is useful
 since it provides the caller with greater control over concurrency.
 
+Batches
+
+Requests can also be queued on a batch created with NewBatch and sent to the
+server together.  A batch provides the asynchronous form of every RPC, and the
+futures deliver their results once the batch has been sent:
+
+  batch := client.NewBatch()
+  countFuture := batch.GetBlockCountAsync()
+  hashFuture := batch.GetBestBlockHashAsync()
+  err := batch.Send()
+
+In HTTP POST mode the requests are sent as a single JSON-RPC batch, saving a
+connection per request.  GetBlocks uses a batch to fetch many blocks at once.
+
+Contexts
+
+Every RPC can be bound to a context.Context by issuing it through the client
+returned by WithContext, which shares the connection of the original client:
+
+  count, err := client.WithContext(ctx).GetBlockCount()
+
+Once the context is done, the requests issued through the bound client are
+abandoned and their futures return the error of the context, which allows
+enforcing deadlines on calls to a node that stopped responding.
+
 Notifications
 
 The first important part of notifications is to realize that they will only
@@ -80,6 +105,15 @@ will cause a deadlock as more server responses won't be read until the callback
 returns, but the callback would be waiting for a response.   Thus, any
 additional RPCs must be issued an a completely decoupled manner.
 
+Notification Replay
+
+Notifications are numbered as they are received, and the client can retain the
+most recent of them by setting the NotificationBufferSize field in the
+connection config.  A consumer which fell behind can record the sequence number
+of the last notification it fully processed, available from LastNotificationSeq,
+and later invoke ReplayNotifications to have the handlers invoked again for the
+notifications received since.
+
 Automatic Reconnection
 
 By default, when running in websockets mode, this client will automatically
@@ -90,6 +124,12 @@ notifications are automatically re-registered and any in-flight commands are
 re-issued.  This means from the caller's perspective, the request simply takes
 longer to complete.
 
+Notifications sent by the server while the client was disconnected are lost,
+however.  The OnClientReconnected notification handler is invoked once all of
+the notifications, including the transaction filter loaded with LoadTxFilter,
+are registered again.  It receives the window during which the client was
+disconnected, so the caller can rescan it.
+
 The caller may invoke the Shutdown method on the client to force the client
 to cease reconnect attempts and return ErrClientShutdown for all outstanding
 commands.
@@ -8,8 +8,8 @@ package rpcclient
 import (
 	"bytes"
 	"container/list"
+	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -102,6 +102,31 @@ type jsonRequest struct {
 	cmd            interface{}
 	marshalledJSON []byte
 	responseChan   chan *response
+
+	// ctx is the context the request was issued with, if any.  The request
+	// is abandoned with the error of the context once it is done.
+	ctx context.Context
+
+	// responded is set atomically once a response has been delivered, and
+	// done is closed at the same time.  Only the first response delivered
+	// for the request is sent on the response channel.
+	responded int32
+	done      chan struct{}
+}
+
+// respond delivers the passed response on the response channel of the request
+// unless a response has already been delivered, such as when the request was
+// abandoned because its context is done.
+//
+// This function is safe for concurrent access.
+func (r *jsonRequest) respond(resp *response) {
+	if !atomic.CompareAndSwapInt32(&r.responded, 0, 1) {
+		return
+	}
+	if r.done != nil {
+		close(r.done)
+	}
+	r.responseChan <- resp
 }
 
 // Client represents a Soter RPC client which allows easy access to the
@@ -116,7 +141,26 @@ type jsonRequest struct {
 // result of the invocation at some future time.  Invoking the Receive method on
 // the returned future will block until the result is available if it's not
 // already.
+//
+// A client bound to a context with WithContext shares the connection of the
+// client it was derived from, and all of the requests issued through it are
+// abandoned once the context is done.
 type Client struct {
+	*clientConn
+
+	// ctx is the context the requests issued through the client are bound
+	// to.  It is nil for clients which weren't derived with WithContext.
+	ctx context.Context
+
+	// batch is the batch the requests issued through the client are queued
+	// on instead of being sent.  It is nil for clients which don't belong to
+	// a batch.
+	batch *Batch
+}
+
+// clientConn houses the connection and request tracking state of a client,
+// which is shared by all of the clients derived from it with WithContext.
+type clientConn struct {
 	id uint64 // atomic, so must stay 64-bit aligned
 
 	// config holds the connection configuration assoiated with this client.
@@ -130,11 +174,17 @@ type Client struct {
 	// POST mode.
 	httpClient *http.Client
 
+	// tlsCerts holds the certificates used for TLS connections to the RPC
+	// server, which can be replaced while the client is running.
+	tlsCerts *tlsCerts
+
 	// mtx is a mutex to protect access to connection related fields.
 	mtx sync.Mutex
 
-	// disconnected indicated whether or not the server is disconnected.
-	disconnected bool
+	// disconnected indicated whether or not the server is disconnected,
+	// and disconnectTime when it was last disconnected.
+	disconnected   bool
+	disconnectTime time.Time
 
 	// retryCount holds the number of times the client has tried to
 	// reconnect to the RPC server.
@@ -149,6 +199,7 @@ type Client struct {
 	ntfnHandlers  *NotificationHandlers
 	ntfnStateLock sync.Mutex
 	ntfnState     *notificationState
+	ntfnBuffer    *ntfnBuffer
 
 	// Networking infrastructure.
 	sendChan        chan []byte
@@ -259,6 +310,19 @@ func (c *Client) trackRegisteredNtfns(cmd interface{}) {
 		for _, addr := range bcmd.Addresses {
 			c.ntfnState.notifyReceived[addr] = struct{}{}
 		}
+
+	case *soterjson.LoadTxFilterCmd:
+		if bcmd.Reload {
+			c.ntfnState.txFilterAddrs = make(map[string]struct{})
+			c.ntfnState.txFilterOutPoints = make(map[soterjson.OutPoint]struct{})
+		}
+		for _, addr := range bcmd.Addresses {
+			c.ntfnState.txFilterAddrs[addr] = struct{}{}
+		}
+		for _, op := range bcmd.OutPoints {
+			c.ntfnState.txFilterOutPoints[op] = struct{}{}
+		}
+		c.ntfnState.txFilterLoaded = true
 	}
 }
 
@@ -336,8 +400,10 @@ func (c *Client) handleMessage(msg []byte) {
 			log.Warn("Malformed notification: missing params")
 			return
 		}
-		// Deliver the notification.
-		log.Tracef("Received notification [%s]", in.Method)
+		// Number and retain the notification so it can be replayed,
+		// then deliver it.
+		seq := c.ntfnBuffer.add(in.rawNotification)
+		log.Tracef("Received notification %d [%s]", seq, in.Method)
 		c.handleNotification(in.rawNotification)
 		return
 	}
@@ -371,7 +437,7 @@ func (c *Client) handleMessage(msg []byte) {
 
 	// Deliver the response.
 	result, err := in.rawResponse.result()
-	request.responseChan <- &response{result: result, err: err}
+	request.respond(&response{result: result, err: err})
 }
 
 // shouldLogReadError returns whether or not the passed error, which is expected
@@ -552,6 +618,26 @@ func (c *Client) reregisterNtfns() error {
 		}
 	}
 
+	// Reload the previously loaded transaction filter as a whole if
+	// needed.
+	if stateCopy.txFilterLoaded {
+		addresses := make([]string, 0, len(stateCopy.txFilterAddrs))
+		for addr := range stateCopy.txFilterAddrs {
+			addresses = append(addresses, addr)
+		}
+		outpoints := make([]soterjson.OutPoint, 0,
+			len(stateCopy.txFilterOutPoints))
+		for op := range stateCopy.txFilterOutPoints {
+			outpoints = append(outpoints, op)
+		}
+		log.Debugf("Reregistering [loadtxfilter] with %d addresses and "+
+			"%d outpoints", len(addresses), len(outpoints))
+		cmd := soterjson.NewLoadTxFilterCmd(true, addresses, outpoints)
+		if _, err := receiveFuture(c.sendCmd(cmd)); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -563,8 +649,8 @@ var ignoreResends = map[string]struct{}{
 
 // resendRequests resends any requests that had not completed when the client
 // disconnected.  It is intended to be called once the client has reconnected as
-// a separate goroutine.
-func (c *Client) resendRequests() {
+// a separate goroutine, with the times the client disconnected and reconnected.
+func (c *Client) resendRequests(disconnected, reconnected time.Time) {
 	// Set the notification state back up.  If anything goes wrong,
 	// disconnect the client.
 	if err := c.reregisterNtfns(); err != nil {
@@ -573,6 +659,12 @@ func (c *Client) resendRequests() {
 		return
 	}
 
+	// Let the caller know about the window in which notifications may have
+	// been missed now that they are registered again.
+	if c.ntfnHandlers != nil && c.ntfnHandlers.OnClientReconnected != nil {
+		c.ntfnHandlers.OnClientReconnected(disconnected, reconnected)
+	}
+
 	// Since it's possible to block on send and more requests might be
 	// added by the caller while resending, make a copy of all of the
 	// requests that need to be resent now and work from the copy.  This
@@ -637,7 +729,7 @@ out:
 			default:
 			}
 
-			wsConn, err := dial(c.config)
+			wsConn, err := dial(c.config, c.tlsCerts)
 			if err != nil {
 				c.retryCount++
 				log.Infof("Failed to connect to %s: %v",
@@ -668,6 +760,7 @@ out:
 			c.mtx.Lock()
 			c.disconnect = make(chan struct{})
 			c.disconnected = false
+			disconnectTime := c.disconnectTime
 			c.mtx.Unlock()
 
 			// Start processing input and output for the
@@ -676,7 +769,7 @@ out:
 
 			// Reissue pending requests in another goroutine since
 			// the send can block.
-			go c.resendRequests()
+			go c.resendRequests(disconnectTime, time.Now())
 
 			// Break out of the reconnect loop back to wait for
 			// disconnect again.
@@ -695,7 +788,7 @@ func (c *Client) handleSendPostMessage(details *sendPostDetails) {
 	log.Tracef("Sending command [%s] with id %d", jReq.method, jReq.id)
 	httpResponse, err := c.httpClient.Do(details.httpRequest)
 	if err != nil {
-		jReq.responseChan <- &response{err: err}
+		jReq.respond(&response{err: err})
 		return
 	}
 
@@ -704,7 +797,7 @@ func (c *Client) handleSendPostMessage(details *sendPostDetails) {
 	httpResponse.Body.Close()
 	if err != nil {
 		err = fmt.Errorf("error reading json reply: %v", err)
-		jReq.responseChan <- &response{err: err}
+		jReq.respond(&response{err: err})
 		return
 	}
 
@@ -717,18 +810,19 @@ func (c *Client) handleSendPostMessage(details *sendPostDetails) {
 		// response bytes.
 		err = fmt.Errorf("status code: %d, response: %q",
 			httpResponse.StatusCode, string(respBytes))
-		jReq.responseChan <- &response{err: err}
+		jReq.respond(&response{err: err})
 		return
 	}
 
 	res, err := resp.result()
-	jReq.responseChan <- &response{result: res, err: err}
+	jReq.respond(&response{result: res, err: err})
 }
 
 // sendPostHandler handles all outgoing messages when the client is running
 // in HTTP POST mode.  It uses a buffered channel to serialize output messages
-// while allowing the sender to continue running asynchronously.  It must be run
-// as a goroutine.
+// while allowing the sender to continue running asynchronously.  One handler is
+// run for each HTTP POST request which may be in flight at the same time.  It
+// must be run as a goroutine.
 func (c *Client) sendPostHandler() {
 out:
 	for {
@@ -749,10 +843,10 @@ cleanup:
 	for {
 		select {
 		case details := <-c.sendPostChan:
-			details.jsonRequest.responseChan <- &response{
+			details.jsonRequest.respond(&response{
 				result: nil,
 				err:    ErrClientShutdown,
-			}
+			})
 
 		default:
 			break cleanup
@@ -770,7 +864,7 @@ func (c *Client) sendPostRequest(httpReq *http.Request, jReq *jsonRequest) {
 	// Don't send the message if shutting down.
 	select {
 	case <-c.shutdown:
-		jReq.responseChan <- &response{result: nil, err: ErrClientShutdown}
+		jReq.respond(&response{result: nil, err: ErrClientShutdown})
 	default:
 	}
 
@@ -799,30 +893,46 @@ func receiveFuture(f chan *response) ([]byte, error) {
 	return r.result, r.err
 }
 
-// sendPost sends the passed request to the server by issuing an HTTP POST
-// request using the provided response channel for the reply.  Typically a new
-// connection is opened and closed for each command when using this method,
-// however, the underlying HTTP client might coalesce multiple commands
-// depending on several factors including the remote server configuration.
-func (c *Client) sendPost(jReq *jsonRequest) {
+// newPostRequest returns an HTTP POST request to the configured RPC server with
+// the passed body, which is bound to the passed context if it's not nil.
+func (c *Client) newPostRequest(ctx context.Context, body []byte) (*http.Request, error) {
 	// Generate a request to the configured RPC server.
 	protocol := "http"
 	if !c.config.DisableTLS {
 		protocol = "https"
 	}
 	url := protocol + "://" + c.config.Host
-	bodyReader := bytes.NewReader(jReq.marshalledJSON)
+	bodyReader := bytes.NewReader(body)
 	httpReq, err := http.NewRequest("POST", url, bodyReader)
 	if err != nil {
-		jReq.responseChan <- &response{result: nil, err: err}
-		return
+		return nil, err
+	}
+	if ctx != nil {
+		httpReq = httpReq.WithContext(ctx)
 	}
-	httpReq.Close = true
+	// Only keep the connection alive for later requests when there is a
+	// pool of them.
+	httpReq.Close = c.config.httpPostConns() == 1
 	httpReq.Header.Set("Content-Type", "application/json")
 
 	// Configure basic access authorization.
 	httpReq.SetBasicAuth(c.config.User, c.config.Pass)
 
+	return httpReq, nil
+}
+
+// sendPost sends the passed request to the server by issuing an HTTP POST
+// request using the provided response channel for the reply.  Typically a new
+// connection is opened and closed for each command when using this method,
+// however, the underlying HTTP client might coalesce multiple commands
+// depending on several factors including the remote server configuration.
+func (c *Client) sendPost(jReq *jsonRequest) {
+	httpReq, err := c.newPostRequest(jReq.ctx, jReq.marshalledJSON)
+	if err != nil {
+		jReq.respond(&response{result: nil, err: err})
+		return
+	}
+
 	log.Tracef("Sending command [%s] with id %d", jReq.method, jReq.id)
 	c.sendPostRequest(httpReq, jReq)
 }
@@ -831,6 +941,25 @@ func (c *Client) sendPost(jReq *jsonRequest) {
 // provided response channel for the reply.  It handles both websocket and HTTP
 // POST mode depending on the configuration of the client.
 func (c *Client) sendRequest(jReq *jsonRequest) {
+	// Queue the request instead when the client belongs to a batch.  It is
+	// sent once the batch is.
+	if c.batch != nil {
+		c.batch.queue(jReq)
+		return
+	}
+
+	// Don't bother sending the request when its context is already done,
+	// and otherwise watch the context so the request is abandoned once it
+	// is done.
+	if jReq.ctx != nil {
+		if err := jReq.ctx.Err(); err != nil {
+			jReq.respond(&response{err: err})
+			return
+		}
+		jReq.done = make(chan struct{})
+		go c.watchRequestContext(jReq)
+	}
+
 	// Choose which marshal and send function to use depending on whether
 	// the client running in HTTP POST mode or not.  When running in HTTP
 	// POST mode, the command is issued via an HTTP client.  Otherwise,
@@ -845,7 +974,7 @@ func (c *Client) sendRequest(jReq *jsonRequest) {
 	select {
 	case <-c.connEstablished:
 	default:
-		jReq.responseChan <- &response{err: ErrClientNotConnected}
+		jReq.respond(&response{err: ErrClientNotConnected})
 		return
 	}
 
@@ -854,13 +983,29 @@ func (c *Client) sendRequest(jReq *jsonRequest) {
 	// channel.  Then send the marshalled request via the websocket
 	// connection.
 	if err := c.addRequest(jReq); err != nil {
-		jReq.responseChan <- &response{err: err}
+		jReq.respond(&response{err: err})
 		return
 	}
 	log.Tracef("Sending command [%s] with id %d", jReq.method, jReq.id)
 	c.sendMessage(jReq.marshalledJSON)
 }
 
+// watchRequestContext abandons the passed request once its context is done by
+// forgetting about it, so it won't be resent on reconnect, and delivering the
+// error of the context as its response.  It returns once the request has been
+// responded to.
+//
+// This function must be run as a goroutine.
+func (c *Client) watchRequestContext(jReq *jsonRequest) {
+	select {
+	case <-jReq.ctx.Done():
+		c.removeRequest(jReq.id)
+		jReq.respond(&response{err: jReq.ctx.Err()})
+
+	case <-jReq.done:
+	}
+}
+
 // sendCmd sends the passed command to the associated server and returns a
 // response channel on which the reply will be delivered at some point in the
 // future.  It handles both websocket and HTTP POST mode depending on the
@@ -887,6 +1032,7 @@ func (c *Client) sendCmd(cmd interface{}) chan *response {
 		cmd:            cmd,
 		marshalledJSON: marshalledJSON,
 		responseChan:   responseChan,
+		ctx:            c.ctx,
 	}
 	c.sendRequest(jReq)
 
@@ -940,6 +1086,7 @@ func (c *Client) doDisconnect() bool {
 		c.wsConn.Close()
 	}
 	c.disconnected = true
+	c.disconnectTime = time.Now()
 	return true
 }
 
@@ -980,10 +1127,10 @@ func (c *Client) Disconnect() {
 	if c.config.DisableAutoReconnect {
 		for e := c.requestList.Front(); e != nil; e = e.Next() {
 			req := e.Value.(*jsonRequest)
-			req.responseChan <- &response{
+			req.respond(&response{
 				result: nil,
 				err:    ErrClientDisconnect,
-			}
+			})
 		}
 		c.removeAllRequests()
 		c.doShutdown()
@@ -1008,10 +1155,10 @@ func (c *Client) Shutdown() {
 	// Send the ErrClientShutdown error to any pending requests.
 	for e := c.requestList.Front(); e != nil; e = e.Next() {
 		req := e.Value.(*jsonRequest)
-		req.responseChan <- &response{
+		req.respond(&response{
 			result: nil,
 			err:    ErrClientShutdown,
-		}
+		})
 	}
 	c.removeAllRequests()
 
@@ -1026,8 +1173,11 @@ func (c *Client) start() {
 	// Start the I/O processing handlers depending on whether the client is
 	// in HTTP POST mode or the default websocket mode.
 	if c.config.HTTPPostMode {
-		c.wg.Add(1)
-		go c.sendPostHandler()
+		conns := c.config.httpPostConns()
+		c.wg.Add(conns)
+		for i := 0; i < conns; i++ {
+			go c.sendPostHandler()
+		}
 	} else {
 		c.wg.Add(3)
 		go func() {
@@ -1074,9 +1224,16 @@ type ConnConfig struct {
 
 	// Certificates are the bytes for a PEM-encoded certificate chain used
 	// for the TLS connection.  It has no effect if the DisableTLS parameter
-	// is true.
+	// is true.  It can be replaced later with UpdateCertificates.
 	Certificates []byte
 
+	// ClientCertificate and ClientKey are the bytes for a PEM-encoded
+	// certificate and private key presented to the RPC server for mutual
+	// TLS.  They have no effect if the DisableTLS parameter is true, and
+	// can be replaced later with UpdateClientCertificate.
+	ClientCertificate []byte
+	ClientKey         []byte
+
 	// Proxy specifies to connect through a SOCKS 5 proxy server.  It may
 	// be an empty string if a proxy is not required.
 	Proxy string
@@ -1112,11 +1269,38 @@ type ConnConfig struct {
 	// EnableBCInfoHacks is an option provided to enable compatibility hacks
 	// when connecting to blockchain.info RPC server
 	EnableBCInfoHacks bool
+
+	// HTTPPostConns is the number of HTTP POST requests which may be in
+	// flight at the same time when running in HTTP POST mode.  When more
+	// than one is allowed, the connections are kept alive and reused for
+	// later requests.  The default of zero issues one request at a time
+	// over a new connection each.
+	HTTPPostConns int
+
+	// HTTPRequestTimeout is the maximum amount of time an HTTP POST request
+	// may take, including reading its response.  The default of zero means
+	// no timeout.
+	HTTPRequestTimeout time.Duration
+
+	// NotificationBufferSize is the number of the most recent notifications
+	// retained by the client for replaying them with ReplayNotifications.
+	// The default of zero retains none.
+	NotificationBufferSize int
+}
+
+// httpPostConns returns the number of HTTP POST requests which may be in flight
+// at the same time.
+func (config *ConnConfig) httpPostConns() int {
+	if config.HTTPPostConns > 1 {
+		return config.HTTPPostConns
+	}
+	return 1
 }
 
 // newHTTPClient returns a new http client that is configured according to the
-// proxy and TLS settings in the associated connection configuration.
-func newHTTPClient(config *ConnConfig) (*http.Client, error) {
+// proxy and TLS settings in the associated connection configuration, using the
+// passed TLS certificates.
+func newHTTPClient(config *ConnConfig, certs *tlsCerts) (*http.Client, error) {
 	// Set proxy function if there is a proxy configured.
 	var proxyFunc func(*http.Request) (*url.URL, error)
 	if config.Proxy != "" {
@@ -1130,40 +1314,29 @@ func newHTTPClient(config *ConnConfig) (*http.Client, error) {
 	// Configure TLS if needed.
 	var tlsConfig *tls.Config
 	if !config.DisableTLS {
-		if len(config.Certificates) > 0 {
-			pool := x509.NewCertPool()
-			pool.AppendCertsFromPEM(config.Certificates)
-			tlsConfig = &tls.Config{
-				RootCAs: pool,
-			}
-		}
+		tlsConfig = newTLSConfig(config, certs, 0)
 	}
 
 	client := http.Client{
 		Transport: &http.Transport{
-			Proxy:           proxyFunc,
-			TLSClientConfig: tlsConfig,
+			Proxy:               proxyFunc,
+			TLSClientConfig:     tlsConfig,
+			MaxIdleConnsPerHost: config.httpPostConns(),
 		},
+		Timeout: config.HTTPRequestTimeout,
 	}
 
 	return &client, nil
 }
 
 // dial opens a websocket connection using the passed connection configuration
-// details.
-func dial(config *ConnConfig) (*websocket.Conn, error) {
+// details and TLS certificates.
+func dial(config *ConnConfig, certs *tlsCerts) (*websocket.Conn, error) {
 	// Setup TLS if not disabled.
 	var tlsConfig *tls.Config
 	var scheme = "ws"
 	if !config.DisableTLS {
-		tlsConfig = &tls.Config{
-			MinVersion: tls.VersionTLS12,
-		}
-		if len(config.Certificates) > 0 {
-			pool := x509.NewCertPool()
-			pool.AppendCertsFromPEM(config.Certificates)
-			tlsConfig.RootCAs = pool
-		}
+		tlsConfig = newTLSConfig(config, certs, tls.VersionTLS12)
 		scheme = "wss"
 	}
 
@@ -1221,6 +1394,12 @@ func dial(config *ConnConfig) (*websocket.Conn, error) {
 // interested in receiving notifications and will be ignored if the
 // configuration is set to run in HTTP POST mode.
 func New(config *ConnConfig, ntfnHandlers *NotificationHandlers) (*Client, error) {
+	// Load the certificates used for TLS connections.
+	certs, err := newTLSCerts(config)
+	if err != nil {
+		return nil, err
+	}
+
 	// Either open a websocket connection or create an HTTP client depending
 	// on the HTTP POST mode.  Also, set the notification handlers to nil
 	// when running in HTTP POST mode.
@@ -1232,15 +1411,13 @@ func New(config *ConnConfig, ntfnHandlers *NotificationHandlers) (*Client, error
 		ntfnHandlers = nil
 		start = true
 
-		var err error
-		httpClient, err = newHTTPClient(config)
+		httpClient, err = newHTTPClient(config, certs)
 		if err != nil {
 			return nil, err
 		}
 	} else {
 		if !config.DisableConnectOnNew {
-			var err error
-			wsConn, err = dial(config)
+			wsConn, err = dial(config, certs)
 			if err != nil {
 				return nil, err
 			}
@@ -1248,20 +1425,22 @@ func New(config *ConnConfig, ntfnHandlers *NotificationHandlers) (*Client, error
 		}
 	}
 
-	client := &Client{
+	client := &Client{clientConn: &clientConn{
 		config:          config,
 		wsConn:          wsConn,
 		httpClient:      httpClient,
+		tlsCerts:        certs,
 		requestMap:      make(map[uint64]*list.Element),
 		requestList:     list.New(),
 		ntfnHandlers:    ntfnHandlers,
 		ntfnState:       newNotificationState(),
+		ntfnBuffer:      newNtfnBuffer(config.NotificationBufferSize),
 		sendChan:        make(chan []byte, sendBufferSize),
 		sendPostChan:    make(chan *sendPostDetails, sendPostBufferSize),
 		connEstablished: connEstablished,
 		disconnect:      make(chan struct{}),
 		shutdown:        make(chan struct{}),
-	}
+	}}
 
 	if start {
 		log.Infof("Established connection to RPC server %s",
@@ -1277,6 +1456,31 @@ func New(config *ConnConfig, ntfnHandlers *NotificationHandlers) (*Client, error
 	return client, nil
 }
 
+// WithContext returns a client bound to the passed context, which shares the
+// connection of c.  Requests issued through the returned client, including
+// ones already queued for sending, are abandoned once the context is done, and
+// their futures return the error of the context.  Any reply the server sends
+// for an abandoned request is ignored.
+//
+// Disconnecting or shutting down the returned client affects c as well, since
+// they share the same connection.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	if ctx == nil {
+		panic("nil context")
+	}
+	return &Client{clientConn: c.clientConn, ctx: ctx}
+}
+
+// Context returns the context the requests issued through the client are
+// bound to.  It is context.Background for clients which weren't returned by
+// WithContext.
+func (c *Client) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
 // Connect establishes the initial websocket connection.  This is necessary when
 // a client was created after setting the DisableConnectOnNew field of the
 // Config struct.
@@ -1305,7 +1509,7 @@ func (c *Client) Connect(tries int) error {
 	var backoff time.Duration
 	for i := 0; tries == 0 || i < tries; i++ {
 		var wsConn *websocket.Conn
-		wsConn, err = dial(c.config)
+		wsConn, err = dial(c.config, c.tlsCerts)
 		if err != nil {
 			backoff = connectionRetryInterval * time.Duration(i+1)
 			if backoff > time.Minute {
@@ -38,6 +38,12 @@ type notificationState struct {
 	notifyNewTxVerbose bool
 	notifyReceived     map[string]struct{}
 	notifySpent        map[soterjson.OutPoint]struct{}
+
+	// The transaction filter loaded with loadtxfilter, which is reloaded
+	// as a whole on reconnect.
+	txFilterLoaded    bool
+	txFilterAddrs     map[string]struct{}
+	txFilterOutPoints map[soterjson.OutPoint]struct{}
 }
 
 // Copy returns a deep copy of the receiver.
@@ -54,6 +60,15 @@ func (s *notificationState) Copy() *notificationState {
 	for op := range s.notifySpent {
 		stateCopy.notifySpent[op] = struct{}{}
 	}
+	stateCopy.txFilterLoaded = s.txFilterLoaded
+	stateCopy.txFilterAddrs = make(map[string]struct{})
+	for addr := range s.txFilterAddrs {
+		stateCopy.txFilterAddrs[addr] = struct{}{}
+	}
+	stateCopy.txFilterOutPoints = make(map[soterjson.OutPoint]struct{})
+	for op := range s.txFilterOutPoints {
+		stateCopy.txFilterOutPoints[op] = struct{}{}
+	}
 
 	return &stateCopy
 }
@@ -61,8 +76,10 @@ func (s *notificationState) Copy() *notificationState {
 // newNotificationState returns a new notification state ready to be populated.
 func newNotificationState() *notificationState {
 	return &notificationState{
-		notifyReceived: make(map[string]struct{}),
-		notifySpent:    make(map[soterjson.OutPoint]struct{}),
+		notifyReceived:    make(map[string]struct{}),
+		notifySpent:       make(map[soterjson.OutPoint]struct{}),
+		txFilterAddrs:     make(map[string]struct{}),
+		txFilterOutPoints: make(map[soterjson.OutPoint]struct{}),
 	}
 }
 
@@ -91,6 +108,15 @@ type NotificationHandlers struct {
 	// notification handlers, and is safe for blocking client requests.
 	OnClientConnected func()
 
+	// OnClientReconnected is invoked once the client has reconnected to
+	// the RPC server and re-registered all previously registered
+	// notifications.  The passed times bound the window during which the
+	// client was disconnected, so notifications sent by the server in
+	// that window were missed and the caller may wish to rescan it.  This
+	// callback is run async with the rest of the notification handlers,
+	// and is safe for blocking client requests.
+	OnClientReconnected func(disconnected, reconnected time.Time)
+
 	// OnBlockConnected is invoked when a block is connected to the longest
 	// (best) chain.  It will only be invoked if a preceding call to
 	// NotifyBlocks has been made to register for the notification and the
@@ -0,0 +1,143 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpcclient
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrNotificationsEvicted is an error to describe the condition where some of
+// the notifications requested to be replayed are no longer retained by the
+// client, since more than NotificationBufferSize notifications have been
+// received since.
+var ErrNotificationsEvicted = errors.New("notifications to replay are no " +
+	"longer retained")
+
+// bufferedNtfn is a notification retained by the notification buffer along
+// with its sequence number.
+type bufferedNtfn struct {
+	seq  uint64
+	ntfn *rawNotification
+}
+
+// ntfnBuffer numbers the notifications received by the client and retains the
+// most recent of them, so they can be replayed to the notification handlers.
+type ntfnBuffer struct {
+	mtx     sync.Mutex
+	size    int
+	lastSeq uint64
+	entries []bufferedNtfn // oldest first
+}
+
+// newNtfnBuffer returns a new notification buffer retaining up to size
+// notifications.  Notifications are still numbered when size is zero.
+func newNtfnBuffer(size int) *ntfnBuffer {
+	if size < 0 {
+		size = 0
+	}
+	return &ntfnBuffer{size: size}
+}
+
+// add numbers the passed notification, retains it if the buffer isn't
+// disabled, evicting the oldest notification when the buffer is full, and
+// returns its sequence number.
+//
+// This function is safe for concurrent access.
+func (b *ntfnBuffer) add(ntfn *rawNotification) uint64 {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.lastSeq++
+	if b.size == 0 {
+		return b.lastSeq
+	}
+
+	if len(b.entries) == b.size {
+		copy(b.entries, b.entries[1:])
+		b.entries = b.entries[:len(b.entries)-1]
+	}
+	b.entries = append(b.entries, bufferedNtfn{seq: b.lastSeq, ntfn: ntfn})
+	return b.lastSeq
+}
+
+// latest returns the sequence number of the most recent notification, or zero
+// if none has been received.
+//
+// This function is safe for concurrent access.
+func (b *ntfnBuffer) latest() uint64 {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return b.lastSeq
+}
+
+// since returns the retained notifications with a sequence number greater than
+// the passed one, oldest first, along with whether all of them are still
+// retained.
+//
+// This function is safe for concurrent access.
+func (b *ntfnBuffer) since(seq uint64) ([]bufferedNtfn, bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if seq >= b.lastSeq {
+		return nil, true
+	}
+
+	// The notifications are retained in order, so the first one to return
+	// is found by its offset from the oldest one retained.
+	if len(b.entries) == 0 {
+		return nil, false
+	}
+	oldest := b.entries[0].seq
+	complete := seq+1 >= oldest
+	start := 0
+	if complete {
+		start = int(seq + 1 - oldest)
+	}
+
+	ntfns := make([]bufferedNtfn, len(b.entries)-start)
+	copy(ntfns, b.entries[start:])
+	return ntfns, complete
+}
+
+// LastNotificationSeq returns the sequence number of the most recent
+// notification received by the client, or zero if none has been received.
+// Notifications are numbered consecutively from one as they are received.
+// Within a notification handler, it is the sequence number of the notification
+// being handled, since notifications are handled one at a time.
+//
+// This function is safe for concurrent access.
+func (c *Client) LastNotificationSeq() uint64 {
+	return c.ntfnBuffer.latest()
+}
+
+// ReplayNotifications invokes the notification handlers again for the
+// notifications received after the one with the passed sequence number, in the
+// order they were received.  It allows a consumer which fell behind, such as
+// one which had to abandon the work of its handlers, to resume from the last
+// notification it fully processed.
+//
+// Only the most recent NotificationBufferSize notifications are retained.  When
+// some of the requested notifications are no longer retained, the retained ones
+// are still replayed and ErrNotificationsEvicted is returned.
+//
+// The handlers are invoked from the calling goroutine, so this MUST NOT be
+// called from a notification handler, and the handlers may run concurrently
+// with the ones invoked for newly received notifications.
+func (c *Client) ReplayNotifications(seq uint64) error {
+	ntfns, complete := c.ntfnBuffer.since(seq)
+	for _, n := range ntfns {
+		log.Tracef("Replaying notification %d [%s]", n.seq,
+			n.ntfn.Method)
+		c.handleNotification(n.ntfn)
+	}
+
+	if !complete {
+		return ErrNotificationsEvicted
+	}
+	return nil
+}
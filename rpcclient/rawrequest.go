@@ -63,6 +63,7 @@ func (c *Client) RawRequestAsync(method string, params []json.RawMessage) Future
 		cmd:            nil,
 		marshalledJSON: marshalledJSON,
 		responseChan:   responseChan,
+		ctx:            c.ctx,
 	}
 	c.sendRequest(jReq)
 
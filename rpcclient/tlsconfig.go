@@ -0,0 +1,174 @@
+// Copyright (c) 2018-2019 The Soteria DAG developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpcclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"sync"
+)
+
+var (
+	// ErrNoCertificates is an error to describe the condition where a
+	// certificate bundle doesn't contain any PEM-encoded certificate.
+	ErrNoCertificates = errors.New("no certificates found in the bundle")
+
+	// ErrNoServerCertificate is an error to describe the condition where
+	// the RPC server didn't present a certificate during the TLS handshake.
+	ErrNoServerCertificate = errors.New("the server did not present a " +
+		"certificate")
+)
+
+// tlsCerts houses the certificates used for the TLS connections of a client.
+// They can be replaced while the client is running, and the new certificates
+// are used for all connections established afterwards.
+type tlsCerts struct {
+	mtx sync.RWMutex
+
+	// roots are the certificates trusted to sign the certificate of the
+	// RPC server.  The roots of the system are used when it is nil.
+	roots *x509.CertPool
+
+	// clientCert is the certificate presented to the RPC server for mutual
+	// TLS, if any.
+	clientCert *tls.Certificate
+}
+
+// newTLSCerts returns the TLS certificates described by the passed connection
+// configuration.
+func newTLSCerts(config *ConnConfig) (*tlsCerts, error) {
+	certs := new(tlsCerts)
+	if len(config.Certificates) > 0 {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(config.Certificates)
+		certs.roots = pool
+	}
+	if len(config.ClientCertificate) > 0 || len(config.ClientKey) > 0 {
+		cert, err := tls.X509KeyPair(config.ClientCertificate,
+			config.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		certs.clientCert = &cert
+	}
+	return certs, nil
+}
+
+// rootPool returns the certificates currently trusted to sign the certificate
+// of the RPC server.
+//
+// This function is safe for concurrent access.
+func (s *tlsCerts) rootPool() *x509.CertPool {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.roots
+}
+
+// getClientCertificate returns the certificate currently presented to the RPC
+// server.  An empty certificate is returned when there is none, which makes the
+// TLS handshake proceed without one.
+//
+// This function is safe for concurrent access.
+func (s *tlsCerts) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	if s.clientCert == nil {
+		return new(tls.Certificate), nil
+	}
+	return s.clientCert, nil
+}
+
+// verifyPeerCertificate returns a function verifying the certificate chain
+// presented by the RPC server against the certificates trusted at the time of
+// the handshake, rather than when the TLS configuration was created.
+func (s *tlsCerts) verifyPeerCertificate(serverName string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return ErrNoServerCertificate
+		}
+
+		certs := make([]*x509.Certificate, 0, len(rawCerts))
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs = append(certs, cert)
+		}
+
+		opts := x509.VerifyOptions{
+			Roots:         s.rootPool(),
+			DNSName:       serverName,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(opts)
+		return err
+	}
+}
+
+// newTLSConfig returns a TLS configuration for connecting to the RPC server of
+// the passed connection configuration, which uses the current certificates
+// for every handshake.
+func newTLSConfig(config *ConnConfig, certs *tlsCerts, minVersion uint16) *tls.Config {
+	serverName := config.Host
+	if host, _, err := net.SplitHostPort(config.Host); err == nil {
+		serverName = host
+	}
+
+	return &tls.Config{
+		MinVersion: minVersion,
+		ServerName: serverName,
+
+		// The certificate of the server is verified against the current
+		// roots by VerifyPeerCertificate instead, since the roots of a
+		// TLS configuration can't be replaced once it's in use.
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: certs.verifyPeerCertificate(serverName),
+		GetClientCertificate:  certs.getClientCertificate,
+	}
+}
+
+// UpdateCertificates replaces the PEM-encoded certificate chain trusted to sign
+// the certificate of the RPC server, such as after the certificate of the
+// server was rotated.  The new certificates are used for all connections
+// established afterwards, including automatic reconnects, so the client
+// doesn't need to be recreated.  Established connections are not affected.
+//
+// This function is safe for concurrent access.
+func (c *Client) UpdateCertificates(certificates []byte) error {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(certificates) {
+		return ErrNoCertificates
+	}
+
+	c.tlsCerts.mtx.Lock()
+	c.tlsCerts.roots = pool
+	c.tlsCerts.mtx.Unlock()
+	return nil
+}
+
+// UpdateClientCertificate replaces the PEM-encoded certificate and private key
+// presented to the RPC server for mutual TLS.  Like UpdateCertificates, it
+// only affects connections established afterwards.
+//
+// This function is safe for concurrent access.
+func (c *Client) UpdateClientCertificate(certificate, key []byte) error {
+	cert, err := tls.X509KeyPair(certificate, key)
+	if err != nil {
+		return err
+	}
+
+	c.tlsCerts.mtx.Lock()
+	c.tlsCerts.clientCert = &cert
+	c.tlsCerts.mtx.Unlock()
+	return nil
+}